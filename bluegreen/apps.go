@@ -0,0 +1,196 @@
+package bluegreen
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
+)
+
+// DeployApps deploys several apps, each like Deploy does, and carries on when one of them fails.
+// The apps are Options.AppNames, or with Options.All every app of the manifest. Apps are deployed
+// after the apps they depend on, in the order they are given otherwise, and Options.Parallel of
+// them at once when they do not depend on each other. An app is skipped when an app it depends
+// on failed. Apps given with a manifest of their own in Options.Manifests are read from it
+// rather than from manifestReader. When apps are deployed in parallel, each of them has its own
+// deployer and every line of its output starts with its name. Through the connection of the cf
+// CLI, their cf commands other than cf push still run one at a time. It returns an *AppsFailedError naming the apps
+// which failed or were skipped.
+func (p *Orchestrator) DeployApps(cfDomains manifest.CfDomains, manifestReader manifest.ManifestReader, opts Options) (Result, error) {
+	result := Result{Strategy: opts.Strategy}
+	if result.Strategy == "" {
+		result.Strategy = StrategyBlueGreen
+	}
+
+	appNames, err := appNamesToDeploy(manifestReader, opts)
+	if err != nil {
+		return result, err
+	}
+	graph, err := p.dependencyGraph(appNames, cfDomains, manifestReader, opts)
+	if err != nil {
+		return result, err
+	}
+
+	// An Orchestrator made by New writes to a lockedWriter already
+	out, locked := p.Out.(*lockedWriter)
+	if !locked {
+		out = &lockedWriter{out: p.Out}
+	}
+	prefixes := appPrefixes(appNames)
+	var mutex, commands sync.Mutex
+	appResults := map[string]Result{}
+	appErrs := graph.Walk(opts.Parallel, func(appName string) error {
+		fmt.Fprintf(out, "Deploying %s, app %d of %d\n", appName, indexOfApp(graph.Order(), appName)+1, len(appNames))
+
+		app := p
+		if opts.Parallel > 1 {
+			appOut := &prefixWriter{prefix: prefixes[appName], out: out}
+			defer appOut.Flush()
+			app = p.forApp(appOut, &commands)
+		}
+		appResult, err := app.Deploy(cfDomains, manifestReaderFor(appName, manifestReader, opts), appOptions(opts, appName))
+		if err != nil {
+			fmt.Fprintf(out, "Deployment of %s failed: %v\n", appName, err)
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		appResults[appName] = appResult
+		return err
+	})
+
+	failed := []string{}
+	for _, appName := range graph.Order() {
+		appResult, deployed := appResults[appName]
+		if !deployed {
+			appResult = Result{AppName: appName, Strategy: result.Strategy}
+		}
+		if err := appErrs[appName]; err != nil {
+			if !deployed {
+				fmt.Fprintf(p.Out, "Deployment of %s was skipped: %v\n", appName, err)
+			}
+			appResult.Err = err
+			failed = append(failed, appName)
+		}
+		result.Apps = append(result.Apps, appResult)
+	}
+
+	if len(failed) > 0 {
+		return result, &AppsFailedError{AppNames: failed}
+	}
+	return result, nil
+}
+
+// appNamesToDeploy are the apps named in the options, or with Options.All the apps of the
+// manifest.
+func appNamesToDeploy(manifestReader manifest.ManifestReader, opts Options) ([]string, error) {
+	if !opts.All {
+		return opts.AppNames, nil
+	}
+	m, err := manifestReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the manifest: %v", err)
+	}
+	return m.AppNames()
+}
+
+// dependencyGraph orders the apps by the dependencies the manifests declare between them.
+func (p *Orchestrator) dependencyGraph(appNames []string, cfDomains manifest.CfDomains, manifestReader manifest.ManifestReader, opts Options) (*DependencyGraph, error) {
+	sharingManifest := []string{}
+	for _, appName := range appNames {
+		if _, ok := opts.Manifests[appName]; !ok {
+			sharingManifest = append(sharingManifest, appName)
+		}
+	}
+	dependsOn, err := p.GetDependencies(sharingManifest, cfDomains, manifestReader)
+	if err != nil {
+		return nil, fmt.Errorf("Could not work out the dependencies between the apps: %v", err)
+	}
+
+	for _, appName := range appNames {
+		if _, ok := opts.Manifests[appName]; !ok {
+			continue
+		}
+		appDependsOn, err := p.GetDependencies([]string{appName}, cfDomains, manifestReaderFor(appName, manifestReader, opts))
+		if err != nil {
+			return nil, fmt.Errorf("Could not work out the dependencies of %s: %v", appName, err)
+		}
+		dependsOn[appName] = appDependsOn[appName]
+	}
+	return NewDependencyGraph(appNames, dependsOn)
+}
+
+// manifestReaderFor reads the manifest of an app given with --app APP=MANIFEST, or else the
+// manifest the apps share.
+func manifestReaderFor(appName string, manifestReader manifest.ManifestReader, opts Options) manifest.ManifestReader {
+	if _, ok := opts.Manifests[appName]; ok {
+		return manifestReaderOf(appOptions(opts, appName))
+	}
+	return manifestReader
+}
+
+// manifestReaderOf reads the manifest of the options, with their variables filled in.
+func manifestReaderOf(opts Options) *manifest.FileManifestReader {
+	return &manifest.FileManifestReader{ManifestPath: opts.ManifestPath, Overlays: opts.Overlays, VarsFiles: opts.VarsFiles, Vars: opts.Vars}
+}
+
+// noManifest is the manifest of deployments with --no-manifest, which have none.
+type noManifest struct{}
+
+func (noManifest) Read() (*manifest.Manifest, error) {
+	return nil, nil
+}
+
+// appOptions are the options for deploying one app of several.
+func appOptions(opts Options, appName string) Options {
+	opts.AppName = appName
+	opts.AppNames = []string{appName}
+	opts.All = false
+	if manifestPath, ok := opts.Manifests[appName]; ok {
+		opts.ManifestPath = manifestPath
+		opts.Overlays = nil
+	}
+	return opts
+}
+
+// WriteSummary writes a table of the apps of a deployment of several apps, saying for each
+// whether it was promoted.
+func (r Result) WriteSummary(out io.Writer) error {
+	table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "APP\tRESULT\tDETAILS")
+	for _, app := range r.Apps {
+		outcome, details := "promoted", ""
+		switch err := app.Err; {
+		case err == nil:
+			routes := []string{}
+			for _, route := range app.Routes {
+				routes = append(routes, RouteURL(route))
+			}
+			details = strings.Join(routes, ", ")
+		case err == ErrGroupFailed:
+			outcome, details = "not promoted", err.Error()
+		default:
+			outcome, details = "failed", err.Error()
+			if _, skipped := err.(*SkippedError); skipped {
+				outcome = "skipped"
+			}
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\n", app.AppName, outcome, details)
+	}
+	return table.Flush()
+}
+
+// lockedWriter lets apps deployed in parallel write to out, one write at a time.
+type lockedWriter struct {
+	mutex sync.Mutex
+	out   io.Writer
+}
+
+func (w *lockedWriter) Write(b []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.out.Write(b)
+}
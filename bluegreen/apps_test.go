@@ -0,0 +1,222 @@
+package bluegreen_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin/models"
+	"code.cloudfoundry.org/cli/plugin/pluginfakes"
+	. "github.com/bluemixgaragelondon/cf-blue-green-deploy/bluegreen"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Deploying several apps", func() {
+	var (
+		b   *BlueGreenDeployFake
+		p   Orchestrator
+		out *bytes.Buffer
+	)
+
+	manifestReader := &fakes.FakeManifestReader{Yaml: `---
+applications:
+- name: frontend
+  host: www
+- name: api
+  host: api
+  instances: 2
+- name: worker
+  no-route: true
+`}
+	cfDomains := manifest.CfDomains{DefaultDomain: "example.com"}
+
+	BeforeEach(func() {
+		b = &BlueGreenDeployFake{passSmokeTest: true}
+		out = &bytes.Buffer{}
+		p = Orchestrator{Deployer: b, Out: out}
+	})
+
+	It("deploys every app of the manifest in order with --all", func() {
+		result, err := p.DeployApps(cfDomains, manifestReader, mustParseArgs(bgdArgs("--all")))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(pushes(b.flow)).To(Equal([]string{"push frontend-new", "push api-new", "push worker-new"}))
+		Expect(result.Apps).To(HaveLen(3))
+		Expect(result.Apps[1].AppName).To(Equal("api"))
+		Expect(result.Apps[1].Routes).To(ConsistOf(plugin_models.GetApp_RouteSummary{Host: "api", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}}))
+		Expect(out.String()).To(ContainSubstring("Deploying api, app 2 of 3"))
+	})
+
+	It("deploys the named apps in the order they are given", func() {
+		_, err := p.DeployApps(cfDomains, manifestReader, mustParseArgs(bgdArgs("worker frontend")))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(pushes(b.flow)).To(Equal([]string{"push worker-new", "push frontend-new"}))
+	})
+
+	It("smoke tests every app on its own temporary route", func() {
+		_, err := p.DeployApps(cfDomains, manifestReader, mustParseArgs(bgdArgs("frontend api --smoke-test script/smoke-test")))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(b.flow).To(ContainElement("script/smoke-test frontend-new.example.com"))
+		Expect(b.flow).To(ContainElement("script/smoke-test api-new.example.com"))
+	})
+
+	It("carries on after an app fails and names the failed apps", func() {
+		b.failingFQDNs = []string{"frontend-new.example.com"}
+
+		result, err := p.DeployApps(cfDomains, manifestReader, mustParseArgs(bgdArgs("frontend api --smoke-test script/smoke-test")))
+		Expect(err).To(MatchError("Deployment failed for frontend"))
+
+		var appsFailed *AppsFailedError
+		Expect(errors.As(err, &appsFailed)).To(BeTrue())
+		Expect(appsFailed.AppNames).To(Equal([]string{"frontend"}))
+
+		Expect(result.Apps[0].Err).To(MatchError(ErrDeploymentFailed))
+		Expect(result.Apps[1].Err).NotTo(HaveOccurred())
+		Expect(b.flow).To(ContainElement("rename api-new to api"))
+	})
+
+	It("fails when an app of the manifest has no name", func() {
+		_, err := p.DeployApps(cfDomains, &fakes.FakeManifestReader{Yaml: "---\napplications:\n- host: www\n"}, mustParseArgs(bgdArgs("--all")))
+		Expect(err).To(MatchError("Application 1 of the manifest has no name"))
+		Expect(b.flow).To(BeEmpty())
+	})
+
+	It("summarises how every app went", func() {
+		b.failingFQDNs = []string{"frontend-new.example.com"}
+
+		result, _ := p.DeployApps(cfDomains, manifestReader, mustParseArgs(bgdArgs("frontend api --smoke-test script/smoke-test")))
+		summary := &bytes.Buffer{}
+		Expect(result.WriteSummary(summary)).To(Succeed())
+
+		Expect(summary.String()).To(Equal(
+			"APP       RESULT    DETAILS\n" +
+				"frontend  failed    Deployment failed, the new version was not promoted\n" +
+				"api       promoted  api.example.com\n"))
+	})
+
+	It("reads apps given with --app from their own manifest", func() {
+		dir, err := ioutil.TempDir("", "bgd-apps")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		workerManifest := filepath.Join(dir, "manifest.yml")
+		Expect(ioutil.WriteFile(workerManifest, []byte("---\napplications:\n- name: worker\n  host: jobs\n"), 0644)).To(Succeed())
+
+		result, err := p.DeployApps(cfDomains, manifestReader, mustParseArgs(bgdArgs("api --app worker="+workerManifest)))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(pushes(b.flow)).To(Equal([]string{"push api-new", "push worker-new"}))
+		Expect(result.Apps[0].Routes).To(ConsistOf(plugin_models.GetApp_RouteSummary{Host: "api", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}}))
+		Expect(result.Apps[1].Routes).To(ConsistOf(plugin_models.GetApp_RouteSummary{Host: "jobs", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}}))
+	})
+
+	Context("in parallel through the connection of the cf CLI", func() {
+		var binDir, path string
+
+		BeforeEach(func() {
+			var err error
+			binDir, err = ioutil.TempDir("", "bgd-bin")
+			Expect(err).NotTo(HaveOccurred())
+			// Only the cf of the test is found, if any
+			path = os.Getenv("PATH")
+			os.Setenv("PATH", binDir)
+		})
+
+		AfterEach(func() {
+			os.Setenv("PATH", path)
+			os.RemoveAll(binDir)
+		})
+
+		It("deploys apps in parallel with every line of their output starting with the app's name", func() {
+			connection := &pluginfakes.FakeCliConnection{}
+			connection.CliCommandWithoutTerminalOutputStub = newFakeCloudController().curl
+			orchestrator := New(connection, out)
+			orchestrator.Deployer.Setup(connection)
+
+			_, err := orchestrator.DeployApps(cfDomains, manifestReader, mustParseArgs(bgdArgs("frontend api --parallel 2 --smoke-test ../test/support/smoke-test-script")))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(out.String()).To(ContainSubstring("frontend | STDOUT\n"))
+			Expect(out.String()).To(ContainSubstring("api      | App FQDN is: api-new.example.com\n"))
+			Expect(connection.CliCommandCallCount()).To(BeNumerically(">", 0))
+		})
+
+		It("runs one cf command at a time through the connection of the cf CLI", func() {
+			var running, mostRunning int32
+			connection := &pluginfakes.FakeCliConnection{}
+			connection.CliCommandStub = func(args ...string) ([]string, error) {
+				now := atomic.AddInt32(&running, 1)
+				defer atomic.AddInt32(&running, -1)
+				if now > atomic.LoadInt32(&mostRunning) {
+					atomic.StoreInt32(&mostRunning, now)
+				}
+				time.Sleep(5 * time.Millisecond)
+				return []string{""}, nil
+			}
+			connection.CliCommandWithoutTerminalOutputStub = newFakeCloudController().curl
+			orchestrator := New(connection, out)
+			orchestrator.Deployer.Setup(connection)
+
+			_, err := orchestrator.DeployApps(cfDomains, manifestReader, mustParseArgs(bgdArgs("frontend api --parallel 2")))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(connection.CliCommandCallCount()).To(BeNumerically(">", 2))
+			Expect(atomic.LoadInt32(&mostRunning)).To(Equal(int32(1)))
+		})
+
+		It("pushes the apps at the same time in cf processes of their own", func() {
+			markers := filepath.Join(binDir, "pushing")
+			Expect(os.Mkdir(markers, 0755)).To(Succeed())
+			// Every push waits for the other one to have started, so they only finish when they run at once
+			Expect(ioutil.WriteFile(filepath.Join(binDir, "cf"), []byte(`#!/bin/sh
+: > "`+markers+`/$2"
+for attempt in 1 2 3 4 5 6 7 8 9 10 11 12 13 14 15 16 17 18 19 20; do
+	if [ -e "`+markers+`/frontend-new" ] && [ -e "`+markers+`/api-new" ]; then
+		echo "pushed $2"
+		exit 0
+	fi
+	/bin/sleep 0.1
+done
+echo FAILED
+exit 1
+`), 0755)).To(Succeed())
+			connection := &pluginfakes.FakeCliConnection{}
+			connection.CliCommandWithoutTerminalOutputStub = newFakeCloudController().curl
+			orchestrator := New(connection, out)
+			orchestrator.Deployer.Setup(connection)
+
+			_, err := orchestrator.DeployApps(cfDomains, manifestReader, mustParseArgs(bgdArgs("frontend api --parallel 2")))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(out.String()).To(ContainSubstring("frontend | pushed frontend-new\n"))
+			Expect(out.String()).To(ContainSubstring("api      | pushed api-new\n"))
+			for i := 0; i < connection.CliCommandCallCount(); i++ {
+				Expect(connection.CliCommandArgsForCall(i)[0]).NotTo(Equal("push"))
+			}
+		})
+	})
+
+	It("does not take app names together with --all", func() {
+		_, err := New(nil, nil).Run(mustParseArgs(bgdArgs("frontend --all")))
+		Expect(err).To(MatchError("Either name the apps or deploy --all of them, not both."))
+	})
+})
+
+func pushes(flow []string) []string {
+	pushed := []string{}
+	for _, step := range flow {
+		if strings.HasPrefix(step, "push ") {
+			pushed = append(pushed, step)
+		}
+	}
+	return pushed
+}
@@ -0,0 +1,467 @@
+package bluegreen
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+	"code.cloudfoundry.org/cli/plugin/models"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/internal/cfapi"
+)
+
+type ErrorHandler func(string, error)
+
+type BlueGreenDeployer interface {
+	Setup(plugin.CliConnection)
+	PushNewApp(string, plugin_models.GetApp_RouteSummary, string, ScaleParameters) error
+	DeleteAllAppsExceptLiveApp(string, ...plugin_models.GetApp_RouteSummary) error
+	DeleteAllAppsExceptLiveAndFailedApp(string, ...plugin_models.GetApp_RouteSummary) error
+	GetScaleParameters(string) (ScaleParameters, error)
+	ScaleApp(string, int) error
+	LiveApp(string) (string, []plugin_models.GetApp_RouteSummary)
+	RunSmokeTests(SmokeTest, string) (bool, error)
+	UnmapRoutesFromApp(string, ...plugin_models.GetApp_RouteSummary) error
+	DeleteRoutes(...plugin_models.GetApp_RouteSummary) error
+	RenameApp(string, string) error
+	MapRoutesToApp(string, ...plugin_models.GetApp_RouteSummary) error
+	SetRouteWeights(plugin_models.GetApp_RouteSummary, ...RouteWeight) error
+	StartRollingDeployment(string, string, ScaleParameters) (string, error)
+	DeploymentStatus(string) (DeploymentStatus, error)
+	NewInstancesRunning(string) (int, error)
+	CancelDeployment(string) error
+	AppRevisions(string) ([]AppRevision, error)
+	LabelCurrentRevision(string) error
+	LabelRolledBackRevisions(...string) error
+	RollbackToRevision(string, string) (string, error)
+	KeepPreviousDroplet(string, string) error
+	PreviousDroplet(string) (string, error)
+	ForgetPreviousDroplet(string) error
+	RollbackToDroplet(string, string) (string, error)
+	CheckSshEnablement(string) (bool, error)
+	SetSshAccess(string, bool) error
+}
+
+type BlueGreenDeploy struct {
+	Connection plugin.CliConnection
+	Out        io.Writer
+
+	// ErrorFunc is told about steps which fail, before the step returns the failure as its error.
+	ErrorFunc ErrorHandler
+	Retry     RetryPolicy
+
+	// VarsFiles and Vars fill in the ((variables)) of the manifest the apps are pushed with.
+	VarsFiles []string
+	Vars      map[string]string
+
+	// NoManifest pushes the apps without a manifest, even when there is one in the directory.
+	NoManifest bool
+
+	// environmentRestores put back the environment of the apps of rolling deployments which
+	// are cancelled, by deployment guid.
+	environmentRestores map[string]func() error
+}
+
+type ScaleParameters struct {
+	InstanceCount int
+	Memory        int64
+	DiskQuota     int64
+}
+
+func (p *BlueGreenDeploy) DeleteAppVersions(apps []plugin_models.GetAppsModel) error {
+	for _, app := range apps {
+		if _, err := p.cliCommand("delete", app.Name, "-f", "-r"); err != nil {
+			return p.fail("Could not delete old app version", err)
+		}
+	}
+	return nil
+}
+
+// DeleteAllAppsExceptLiveApp deletes the versions of the app left by earlier deploys, but like
+// DeleteAllAppsExceptLiveAndFailedApp refuses to delete any version which still has one of the
+// excluded routes mapped to it, since its routes are deleted with it.
+func (p *BlueGreenDeploy) DeleteAllAppsExceptLiveApp(appName string, excludedRoutes ...plugin_models.GetApp_RouteSummary) error {
+	appsInSpace, err := p.getApps()
+	if err != nil {
+		return p.fail("Could not load apps in space, are you logged in?", err)
+	}
+	oldAppVersions, err := p.withoutAppsHoldingRoutes(p.GetOldApps(appName, appsInSpace), excludedRoutes)
+	if err != nil {
+		return err
+	}
+	return p.DeleteAppVersions(oldAppVersions)
+}
+
+// DeleteAllAppsExceptLiveAndFailedApp deletes old versions of the app, but refuses to delete any
+// version which still has one of the excluded routes mapped to it.
+func (p *BlueGreenDeploy) DeleteAllAppsExceptLiveAndFailedApp(appName string, excludedRoutes ...plugin_models.GetApp_RouteSummary) error {
+	appsInSpace, err := p.getApps()
+	if err != nil {
+		return p.fail("Could not load apps in space, are you logged in?", err)
+	}
+	oldAppVersions, err := p.withoutAppsHoldingRoutes(p.GetOldButNotFailedApps(appName, appsInSpace), excludedRoutes)
+	if err != nil {
+		return err
+	}
+	return p.DeleteAppVersions(oldAppVersions)
+}
+
+func (p *BlueGreenDeploy) withoutAppsHoldingRoutes(apps []plugin_models.GetAppsModel, routes []plugin_models.GetApp_RouteSummary) (remainingApps []plugin_models.GetAppsModel, err error) {
+	if len(routes) == 0 {
+		return apps, nil
+	}
+
+	for _, app := range apps {
+		appModel, err := p.getApp(app.Name)
+		if err != nil {
+			return nil, p.fail("Could not load app "+app.Name, err)
+		}
+
+		heldRoutes := []string{}
+		for _, appRoute := range appModel.Routes {
+			for _, route := range routes {
+				if SameRoute(appRoute, route) {
+					heldRoutes = append(heldRoutes, RouteURL(route))
+				}
+			}
+		}
+
+		if len(heldRoutes) > 0 {
+			fmt.Fprintf(p.Out, "Not deleting %s as it still holds excluded routes: %s\n", app.Name, strings.Join(heldRoutes, ", "))
+			continue
+		}
+		remainingApps = append(remainingApps, app)
+	}
+	return remainingApps, nil
+}
+
+func (p *BlueGreenDeploy) GetScaleParameters(appName string) (ScaleParameters, error) {
+	appModel, err := p.getApp(appName)
+	if err != nil {
+		return ScaleParameters{}, fmt.Errorf("Could not get scale parameters")
+	}
+	scaleParameters := ScaleParameters{
+		InstanceCount: appModel.InstanceCount,
+		Memory:        appModel.Memory,
+		DiskQuota:     appModel.DiskQuota,
+	}
+	return scaleParameters, nil
+}
+
+func (p *BlueGreenDeploy) ScaleApp(appName string, instanceCount int) error {
+	if _, err := p.cliCommand("scale", appName, "-i", fmt.Sprintf("%d", instanceCount)); err != nil {
+		return p.fail("Could not scale app", err)
+	}
+	return nil
+}
+
+func mergeScaleParameters(liveScale, manifestScale ScaleParameters) ScaleParameters {
+	scaleParameters := liveScale
+	if manifestScale.Memory != 0 {
+		scaleParameters.Memory = manifestScale.Memory
+	}
+	if manifestScale.InstanceCount != 0 {
+		scaleParameters.InstanceCount = manifestScale.InstanceCount
+	}
+	if manifestScale.DiskQuota != 0 {
+		scaleParameters.DiskQuota = manifestScale.DiskQuota
+	}
+	return scaleParameters
+}
+
+func appendScaleArguments(args []string, scaleParameters ScaleParameters) []string {
+	if scaleParameters.InstanceCount != 0 {
+		instanceCount := fmt.Sprintf("%d", scaleParameters.InstanceCount)
+		args = append(args, "-i", instanceCount)
+	}
+	if scaleParameters.Memory != 0 {
+		memory := fmt.Sprintf("%dM", scaleParameters.Memory)
+		args = append(args, "-m", memory)
+	}
+	if scaleParameters.DiskQuota != 0 {
+		diskQuota := fmt.Sprintf("%dM", scaleParameters.DiskQuota)
+		args = append(args, "-k", diskQuota)
+	}
+	return args
+}
+
+// appendManifestArguments tells cf push which manifest to read, and passes the variables of the
+// manifest on to it in a stable order.
+func (p *BlueGreenDeploy) appendManifestArguments(args []string, manifestPath string) []string {
+	if p.NoManifest {
+		return append(args, "--no-manifest")
+	}
+	if manifestPath != "" {
+		args = append(args, "-f", manifestPath)
+	}
+	for _, varsFile := range p.VarsFiles {
+		args = append(args, "--vars-file", varsFile)
+	}
+	names := []string{}
+	for name := range p.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, "--var", name+"="+p.Vars[name])
+	}
+	return args
+}
+
+func (p *BlueGreenDeploy) PushNewApp(appName string, route plugin_models.GetApp_RouteSummary,
+	manifestPath string, scaleParameters ScaleParameters) error {
+	args := []string{"push", appName, "-n", route.Host, "-d", route.Domain.Name}
+
+	// Remove -new suffix of appname to get live app name
+	newAppSuffix := "-new"
+	liveAppName := strings.TrimSuffix(appName, newAppSuffix)
+	if liveAppName == appName {
+		return p.fail("Could not push new version", fmt.Errorf("%s is not the name of a new version, which ends with %s", appName, newAppSuffix))
+	}
+	liveScaleParameters, _ := p.GetScaleParameters(liveAppName)
+	scaleParameters = mergeScaleParameters(liveScaleParameters, scaleParameters)
+
+	args = appendScaleArguments(args, scaleParameters)
+	args = p.appendManifestArguments(args, manifestPath)
+	if _, err := p.cliCommand(args...); err != nil {
+		return p.fail("Could not push new version", err)
+	}
+	return nil
+}
+
+func (p *BlueGreenDeploy) GetOldApps(appName string, apps []plugin_models.GetAppsModel) (oldApps []plugin_models.GetAppsModel) {
+	r := regexp.MustCompile(fmt.Sprintf("^%s(-old|-failed|-new)?$", appName))
+	for _, app := range apps {
+		if !r.MatchString(app.Name) {
+			continue
+		}
+
+		// TODO (Rufus) - perhaps a change in the regex is needed.
+		// - e.g. `^%s-(old|failed|new)$` (making the capture group not optional). This would mean that the live app, if that is the version
+		// with no prefix, is not matched but others are. Equally, if the live app is the one without a suffix, perhaps it would be sufficient
+		// to check for the existence of a hyphen, in which case we could use something like strings.Count for hyphen instead of the regex.
+		// Then we would not need the if statement below.
+		if strings.HasSuffix(app.Name, "-old") || strings.HasSuffix(app.Name, "-failed") || strings.HasSuffix(app.Name, "-new") {
+			oldApps = append(oldApps, app)
+		}
+	}
+	return
+}
+
+func (p *BlueGreenDeploy) GetOldButNotFailedApps(appName string, apps []plugin_models.GetAppsModel) (oldApps []plugin_models.GetAppsModel) {
+	r := regexp.MustCompile(fmt.Sprintf("^%s(-old|-new)?$", appName))
+	for _, app := range apps {
+		if !r.MatchString(app.Name) {
+			continue
+		}
+
+		// TODO (Rufus) - perhaps a change in the regex is needed.
+		// - e.g. `^%s-(old|failed|new)$` (making the capture group not optional). This would mean that the live app, if that is the version
+		// with no prefix, is not matched but others are. Equally, if the live app is the one without a suffix, perhaps it would be sufficient
+		// to check for the existence of a hyphen, in which case we could use something like strings.Count for hyphen instead of the regex.
+		// Then we would not need the if statement below.
+		if strings.HasSuffix(app.Name, "-old") || strings.HasSuffix(app.Name, "-new") {
+			oldApps = append(oldApps, app)
+		}
+	}
+	return
+}
+
+func (p *BlueGreenDeploy) LiveApp(appName string) (string, []plugin_models.GetApp_RouteSummary) {
+
+	// Don't worry about error handling since earlier calls would have flushed out any errors
+	// except for ones that the app doesn't exist (which isn't an error condition for us)
+	liveApp, _ := p.getApp(appName)
+	return liveApp.Name, liveApp.Routes
+}
+
+func (p *BlueGreenDeploy) Setup(connection plugin.CliConnection) {
+	p.Connection = connection
+}
+
+func (p *BlueGreenDeploy) RunSmokeTests(smokeTest SmokeTest, appFQDN string) (bool, error) {
+	ctx := context.Background()
+	if smokeTest.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, smokeTest.Timeout)
+		defer cancel()
+	}
+
+	out, err := exec.CommandContext(ctx, smokeTest.Script, appFQDN).CombinedOutput()
+	fmt.Fprintln(p.Out, string(out))
+
+	if ctx.Err() == context.DeadlineExceeded {
+		fmt.Fprintf(p.Out, "Smoke tests timed out after %v\n", smokeTest.Timeout)
+		return false, nil
+	}
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return false, nil
+		}
+		return false, p.fail("Smoke tests failed", err)
+	}
+	return true, nil
+}
+
+func (p *BlueGreenDeploy) UnmapRoutesFromApp(oldAppName string, routes ...plugin_models.GetApp_RouteSummary) error {
+	for _, route := range routes {
+		if err := p.unmapRoute(oldAppName, route); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *BlueGreenDeploy) DeleteRoutes(routes ...plugin_models.GetApp_RouteSummary) error {
+	for _, route := range routes {
+		if err := p.deleteRoute(route); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *BlueGreenDeploy) mapRoute(appName string, r plugin_models.GetApp_RouteSummary) error {
+	if err := p.setRouteMapping(appName, r, true); err != nil {
+		return p.fail("Could not map route", err)
+	}
+	return nil
+}
+
+func (p *BlueGreenDeploy) unmapRoute(appName string, r plugin_models.GetApp_RouteSummary) error {
+	if err := p.setRouteMapping(appName, r, false); err != nil {
+		return p.fail("Could not unmap route", err)
+	}
+	return nil
+}
+
+// setRouteMapping maps a route to the web process of an app, creating the route like cf
+// map-route when it does not exist yet, or unmaps it from every process of the app. The route
+// and its destinations are looked at before anything is changed, so a mapping which fails with a
+// transient error is retried as a whole, leaving alone what an earlier attempt already did.
+func (p *BlueGreenDeploy) setRouteMapping(appName string, r plugin_models.GetApp_RouteSummary, mapped bool) error {
+	command := "unmap-route"
+	if mapped {
+		command = "map-route"
+	}
+	_, err := p.Retry.run(p.Out, command, func() ([]string, error) {
+		return nil, p.changeRouteMapping(cfapi.NewClient(p.Connection), appName, r, mapped)
+	})
+	return err
+}
+
+func (p *BlueGreenDeploy) changeRouteMapping(api *cfapi.Client, appName string, r plugin_models.GetApp_RouteSummary, mapped bool) error {
+	appModel, err := p.Connection.GetApp(appName)
+	if err != nil {
+		return err
+	}
+
+	route, err := api.FindRoute(r.Domain.Name, r.Host, r.Path, r.Port)
+	if _, missing := err.(*cfapi.NotFoundError); missing {
+		if !mapped {
+			return nil
+		}
+		route, err = p.createRoute(api, r, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	destinations, err := api.RouteDestinations(route.Guid)
+	if err != nil {
+		return err
+	}
+	for _, destination := range destinations {
+		if destination.App.Guid != appModel.Guid {
+			continue
+		}
+		if mapped && destination.App.Process.Type == "web" {
+			return nil
+		}
+		if !mapped {
+			if err := api.RemoveRouteDestination(route.Guid, destination.Guid); err != nil {
+				return err
+			}
+		}
+	}
+
+	if mapped {
+		return api.InsertRouteDestinations(route.Guid, []cfapi.Destination{cfapi.NewDestination(appModel.Guid, "web")})
+	}
+	return nil
+}
+
+// createRoute creates a missing HTTP route in the targeted space. Routes with a port are
+// reserved differently, so for these the error telling that the route is missing is returned.
+func (p *BlueGreenDeploy) createRoute(api *cfapi.Client, r plugin_models.GetApp_RouteSummary, missing error) (cfapi.Route, error) {
+	if r.Port != 0 {
+		return cfapi.Route{}, missing
+	}
+	space, err := p.Connection.GetCurrentSpace()
+	if err != nil {
+		return cfapi.Route{}, err
+	}
+	domain, err := api.DomainByName(r.Domain.Name)
+	if err != nil {
+		return cfapi.Route{}, err
+	}
+	return api.CreateRoute(space.Guid, domain.Guid, r.Host, r.Path)
+}
+
+func (p *BlueGreenDeploy) deleteRoute(r plugin_models.GetApp_RouteSummary) error {
+	if _, err := p.cliCommand("delete-route", r.Domain.Name, "-n", r.Host, "-f"); err != nil {
+		return p.fail("Could not delete route", err)
+	}
+	return nil
+}
+
+// RenameApp renames an app. An app which only exists under the new name already, for example
+// because an earlier attempt was renamed before it failed, counts as renamed.
+func (p *BlueGreenDeploy) RenameApp(app string, newName string) error {
+	appModel, err := p.getApp(app)
+	if err != nil {
+		if renamedApp, renamedErr := p.getApp(newName); renamedErr == nil && renamedApp.Name == newName {
+			return nil
+		}
+		return p.fail("Could not rename app", err)
+	}
+
+	if err := p.api().RenameApp(appModel.Guid, newName); err != nil {
+		return p.fail("Could not rename app", err)
+	}
+	return nil
+}
+
+func (p *BlueGreenDeploy) MapRoutesToApp(appName string, routes ...plugin_models.GetApp_RouteSummary) error {
+	for _, route := range routes {
+		if err := p.mapRoute(appName, route); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *BlueGreenDeploy) CheckSshEnablement(app string) (bool, error) {
+	result, err := p.cliCommand("ssh-enabled", app)
+	if err != nil {
+		return false, p.fail("Check ssh enabled status failed", err)
+	}
+	return strings.Contains(result[0], "support is enabled"), nil
+}
+
+func (p *BlueGreenDeploy) SetSshAccess(app string, enableSsh bool) error {
+	if enableSsh {
+		if _, err := p.cliCommand("enable-ssh", app); err != nil {
+			return p.fail("Could not enable ssh", err)
+		}
+	} else {
+		if _, err := p.cliCommand("disable-ssh", app); err != nil {
+			return p.fail("Could not disable ssh", err)
+		}
+	}
+	return nil
+}
@@ -1,14 +1,18 @@
-package main_test
+package bluegreen_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
+	"net/url"
 	"strings"
+	"time"
 
 	"code.cloudfoundry.org/cli/plugin/models"
 	"code.cloudfoundry.org/cli/plugin/pluginfakes"
 	"fmt"
-	. "github.com/bluemixgaragelondon/cf-blue-green-deploy"
+	. "github.com/bluemixgaragelondon/cf-blue-green-deploy/bluegreen"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/internal/cfapi"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -35,10 +39,16 @@ var _ = Describe("BlueGreenDeploy", func() {
 
 	Describe("maps routes", func() {
 		var (
+			cc          *fakeCloudController
 			manifestApp plugin_models.GetAppModel
 		)
 
 		BeforeEach(func() {
+			cc = newFakeCloudController("host.example.com")
+			connection.CliCommandWithoutTerminalOutputStub = cc.curl
+			connection.GetAppStub = func(name string) (plugin_models.GetAppModel, error) {
+				return plugin_models.GetAppModel{Name: name, Guid: name}, nil
+			}
 			manifestApp = plugin_models.GetAppModel{
 				Name: "new",
 				Routes: []plugin_models.GetApp_RouteSummary{
@@ -48,24 +58,60 @@ var _ = Describe("BlueGreenDeploy", func() {
 			}
 		})
 
-		It("maps all", func() {
-			p.MapRoutesToApp(manifestApp.Name, manifestApp.Routes...)
-
-			cfCommands := getAllCfCommands(connection)
+		It("maps all, creating the routes which do not exist yet", func() {
+			err := p.MapRoutesToApp(manifestApp.Name, manifestApp.Routes...)
 
-			Expect(cfCommands).To(Equal([]string{
-				"map-route new example.com -n host",
-				"map-route new example.net -n host",
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cc.changes).To(Equal([]string{
+				"POST /v3/routes/host.example.com/destinations",
+				"POST /v3/routes",
+				"POST /v3/routes/host.example.net/destinations",
 			}))
+			Expect(cc.routes).To(Equal(map[string][]string{
+				"host.example.com": {"new"},
+				"host.example.net": {"new"},
+			}))
+			Expect(getAllCfCommands(connection)).To(BeEmpty())
+		})
+
+		It("leaves routes which are already mapped to the app alone", func() {
+			cc.routes["host.example.com"] = []string{"live", "new"}
+
+			err := p.MapRoutesToApp(manifestApp.Name, manifestApp.Routes[0])
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cc.changes).To(BeEmpty())
+		})
+
+		Context("when a route cannot be mapped", func() {
+			It("stops after telling the error callback", func() {
+				cc.failChanges = errors.New("failed to map route")
+
+				err := p.MapRoutesToApp(manifestApp.Name, manifestApp.Routes...)
+
+				Expect(err).To(MatchError("Could not map route - failed to map route"))
+				Expect(bgdExitsWithErrors).To(HaveLen(1))
+				Expect(cc.changes).To(Equal([]string{
+					"POST /v3/routes/host.example.com/destinations",
+				}))
+			})
 		})
 	})
 
 	Describe("remove routes from old app", func() {
 		var (
+			cc     *fakeCloudController
 			oldApp plugin_models.GetAppModel
 		)
 
 		BeforeEach(func() {
+			cc = newFakeCloudController()
+			cc.routes["live.mybluemix.net"] = []string{"old", "live"}
+			cc.routes["live.example.com"] = []string{"old"}
+			connection.CliCommandWithoutTerminalOutputStub = cc.curl
+			connection.GetAppStub = func(name string) (plugin_models.GetAppModel, error) {
+				return plugin_models.GetAppModel{Name: name, Guid: name}, nil
+			}
 			oldApp = plugin_models.GetAppModel{
 				Name: "old",
 				Routes: []plugin_models.GetApp_RouteSummary{
@@ -76,17 +122,22 @@ var _ = Describe("BlueGreenDeploy", func() {
 		})
 
 		It("unmaps all routes from the old app", func() {
-			p.UnmapRoutesFromApp(oldApp.Name, oldApp.Routes...)
-
-			cfCommands := getAllCfCommands(connection)
+			err := p.UnmapRoutesFromApp(oldApp.Name, oldApp.Routes...)
 
-			Expect(cfCommands).To(Equal([]string{
-				"unmap-route old mybluemix.net -n live",
-				"unmap-route old example.com -n live",
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cc.changes).To(Equal([]string{
+				"DELETE /v3/routes/live.mybluemix.net/destinations/old-destination",
+				"DELETE /v3/routes/live.example.com/destinations/old-destination",
+			}))
+			Expect(cc.routes).To(Equal(map[string][]string{
+				"live.mybluemix.net": {"live"},
+				"live.example.com":   {},
 			}))
 		})
 
 		It("unmaps routes from old app with paths", func() {
+			cc.routes["live.mybluemix.net:my:context:path1"] = []string{"old"}
+			cc.routes["live.example.com:my:context:path2"] = []string{"old"}
 			oldApp = plugin_models.GetAppModel{
 				Name: "old",
 				Routes: []plugin_models.GetApp_RouteSummary{
@@ -94,15 +145,24 @@ var _ = Describe("BlueGreenDeploy", func() {
 					{Host: "live", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}, Path: "my/context/path2"},
 				},
 			}
-			p.UnmapRoutesFromApp(oldApp.Name, oldApp.Routes...)
 
-			cfCommands := getAllCfCommands(connection)
+			err := p.UnmapRoutesFromApp(oldApp.Name, oldApp.Routes...)
 
-			Expect(cfCommands).To(Equal([]string{
-				"unmap-route old mybluemix.net -n live --path my/context/path1",
-				"unmap-route old example.com -n live --path my/context/path2",
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cc.changes).To(Equal([]string{
+				"DELETE /v3/routes/live.mybluemix.net:my:context:path1/destinations/old-destination",
+				"DELETE /v3/routes/live.example.com:my:context:path2/destinations/old-destination",
 			}))
 		})
+
+		It("leaves routes which are not mapped to the app alone", func() {
+			err := p.UnmapRoutesFromApp("live", oldApp.Routes[1], plugin_models.GetApp_RouteSummary{
+				Host: "gone", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"},
+			})
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cc.changes).To(BeEmpty())
+		})
 	})
 
 	Describe("checks ssh enablement", func() {
@@ -114,7 +174,7 @@ var _ = Describe("BlueGreenDeploy", func() {
 			})
 
 			It("returns false", func() {
-				result := p.CheckSshEnablement("test-app")
+				result, _ := p.CheckSshEnablement("test-app")
 
 				Expect(result).To(BeFalse())
 				cfCommands := getAllCfCommands(connection)
@@ -133,7 +193,7 @@ var _ = Describe("BlueGreenDeploy", func() {
 			})
 
 			It("returns true", func() {
-				result := p.CheckSshEnablement("test-app")
+				result, _ := p.CheckSshEnablement("test-app")
 
 				Expect(result).To(BeTrue())
 				cfCommands := getAllCfCommands(connection)
@@ -206,30 +266,63 @@ var _ = Describe("BlueGreenDeploy", func() {
 	})
 
 	Describe("renaming an app", func() {
-		var app string
+		var cc *fakeCloudController
 
 		BeforeEach(func() {
-			app = "foo"
+			cc = newFakeCloudController()
+			connection.CliCommandWithoutTerminalOutputStub = cc.curl
+			connection.GetAppStub = func(name string) (plugin_models.GetAppModel, error) {
+				if name != "foo" {
+					return plugin_models.GetAppModel{}, errors.New("App " + name + " not found")
+				}
+				return plugin_models.GetAppModel{Name: name, Guid: "foo-guid"}, nil
+			}
 		})
 
 		It("renames the app", func() {
-			p.RenameApp(app, "bar")
-			cfCommands := getAllCfCommands(connection)
+			err := p.RenameApp("foo", "bar")
 
-			Expect(cfCommands).To(ContainElement(
-				"rename foo bar",
-			))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cc.changes).To(Equal([]string{"PATCH /v3/apps/foo-guid"}))
+			Expect(connection.CliCommandWithoutTerminalOutputArgsForCall(0)).To(Equal([]string{
+				"curl", "/v3/apps/foo-guid", "-X", "PATCH", "-d", `{"name":"bar"}`,
+			}))
+		})
+
+		It("counts an app which already has the new name as renamed", func() {
+			err := p.RenameApp("bar", "foo")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cc.changes).To(BeEmpty())
 		})
 
 		Context("when renaming the app fails", func() {
+			BeforeEach(func() {
+				cc.failChanges = errors.New("failed to rename app")
+			})
+
 			It("calls the error callback", func() {
-				connection.CliCommandStub = func(args ...string) ([]string, error) {
-					return nil, errors.New("failed to rename app")
-				}
-				p.RenameApp(app, "bar")
+				p.RenameApp("foo", "bar")
 
 				Expect(bgdExitsWithErrors[0]).To(MatchError("failed to rename app"))
 			})
+
+			It("returns the error without an error callback", func() {
+				p.ErrorFunc = nil
+
+				err := p.RenameApp("foo", "bar")
+
+				Expect(err).To(MatchError("Could not rename app - failed to rename app"))
+			})
+		})
+
+		Context("when neither the app nor its new name exist", func() {
+			It("returns the error", func() {
+				err := p.RenameApp("bar", "baz")
+
+				Expect(err).To(MatchError("Could not rename app - App bar not found"))
+				Expect(cc.changes).To(BeEmpty())
+			})
 		})
 	})
 
@@ -424,6 +517,64 @@ var _ = Describe("BlueGreenDeploy", func() {
 		})
 	})
 
+	Describe("delete old apps (but not ones holding excluded routes)", func() {
+		excludedRoute := plugin_models.GetApp_RouteSummary{Host: "admin", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}}
+
+		BeforeEach(func() {
+			connection.GetAppsReturns([]plugin_models.GetAppsModel{
+				{Name: "app-name-old"},
+				{Name: "app-name"},
+			}, nil)
+		})
+
+		Context("when the old app still holds an excluded route", func() {
+			BeforeEach(func() {
+				connection.GetAppReturns(plugin_models.GetAppModel{
+					Name: "app-name-old",
+					Routes: []plugin_models.GetApp_RouteSummary{
+						{Guid: "route-guid", Host: "admin", Domain: plugin_models.GetApp_DomainFields{Guid: "domain-guid", Name: "example.com"}},
+					},
+				}, nil)
+			})
+
+			It("does not delete it", func() {
+				p.DeleteAllAppsExceptLiveAndFailedApp("app-name", excludedRoute)
+				Expect(connection.CliCommandCallCount()).To(Equal(0))
+				Expect(bgdOut.String()).To(ContainSubstring("Not deleting app-name-old"))
+			})
+		})
+
+		Context("when cleaning up before the next deploy", func() {
+			BeforeEach(func() {
+				connection.GetAppReturns(plugin_models.GetAppModel{
+					Name: "app-name-old",
+					Routes: []plugin_models.GetApp_RouteSummary{
+						{Guid: "route-guid", Host: "admin", Domain: plugin_models.GetApp_DomainFields{Guid: "domain-guid", Name: "example.com"}},
+					},
+				}, nil)
+			})
+
+			It("does not delete the old app holding it either", func() {
+				p.DeleteAllAppsExceptLiveApp("app-name", excludedRoute)
+				Expect(connection.CliCommandCallCount()).To(Equal(0))
+				Expect(bgdOut.String()).To(ContainSubstring("Not deleting app-name-old"))
+			})
+		})
+
+		Context("when the old app no longer holds an excluded route", func() {
+			BeforeEach(func() {
+				connection.GetAppReturns(plugin_models.GetAppModel{Name: "app-name-old"}, nil)
+			})
+
+			It("deletes it", func() {
+				p.DeleteAllAppsExceptLiveAndFailedApp("app-name", excludedRoute)
+				Expect(getAllCfCommands(connection)).To(Equal([]string{
+					"delete app-name-old -f -r",
+				}))
+			})
+		})
+	})
+
 	Describe("deleting apps", func() {
 		Context("when there is an old version deployed", func() {
 			apps := []plugin_models.GetAppsModel{
@@ -507,6 +658,27 @@ var _ = Describe("BlueGreenDeploy", func() {
 		})
 	})
 
+	Describe("scaling an app", func() {
+		It("sets the instance count", func() {
+			p.ScaleApp("app-name-new", 3)
+
+			Expect(getAllCfCommands(connection)).To(Equal([]string{
+				"scale app-name-new -i 3",
+			}))
+		})
+
+		Context("when scaling fails", func() {
+			It("calls the error callback", func() {
+				connection.CliCommandStub = func(args ...string) ([]string, error) {
+					return nil, errors.New("failed to scale app")
+				}
+				p.ScaleApp("app-name-new", 3)
+
+				Expect(bgdExitsWithErrors[0]).To(MatchError("failed to scale app"))
+			})
+		})
+	})
+
 	Describe("pushing a new app", func() {
 		newApp := "app-name-new"
 		newRoute := plugin_models.GetApp_RouteSummary{Host: newApp, Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}}
@@ -548,6 +720,23 @@ var _ = Describe("BlueGreenDeploy", func() {
 				To(Not(MatchRegexp(`-f `)))
 		})
 
+		It("tells cf push not to read a manifest when deploying without one", func() {
+			p.NoManifest = true
+			p.PushNewApp(newApp, newRoute, "", scaleParameters)
+
+			Expect(strings.Join(connection.CliCommandArgsForCall(0), " ")).
+				To(HaveSuffix("--no-manifest"))
+		})
+
+		It("passes the variables of the manifest on", func() {
+			p.VarsFiles = []string{"vars/prod.yml"}
+			p.Vars = map[string]string{"memory": "1G", "host": "www"}
+			p.PushNewApp(newApp, newRoute, "manifest.yml", scaleParameters)
+
+			Expect(strings.Join(connection.CliCommandArgsForCall(0), " ")).
+				To(HaveSuffix("-f manifest.yml --vars-file vars/prod.yml --var host=www --var memory=1G"))
+		})
+
 		It("pushes using the scale values of the old app", func() {
 			liveAppModel := plugin_models.GetAppModel{
 				Memory:        int64(32),
@@ -610,6 +799,13 @@ var _ = Describe("BlueGreenDeploy", func() {
 				Expect(bgdExitsWithErrors[0]).To(MatchError("failed to push app"))
 			})
 		})
+
+		It("does not push an app whose name does not end with -new", func() {
+			err := p.PushNewApp("app-name", newRoute, "", scaleParameters)
+
+			Expect(err).To(MatchError("Could not push new version - app-name is not the name of a new version, which ends with -new"))
+			Expect(connection.CliCommandCallCount()).To(Equal(0))
+		})
 	})
 
 	Describe("live app", func() {
@@ -675,30 +871,30 @@ var _ = Describe("BlueGreenDeploy", func() {
 
 	Describe("smoke test runner", func() {
 		It("returns stdout", func() {
-			_ = p.RunSmokeTests("test/support/smoke-test-script", "app.mybluemix.net")
+			_, _ = p.RunSmokeTests(SmokeTest{Script: "../test/support/smoke-test-script"}, "app.mybluemix.net")
 			Expect(bgdOut.String()).To(ContainSubstring("STDOUT"))
 		})
 
 		It("returns stderr", func() {
-			_ = p.RunSmokeTests("test/support/smoke-test-script", "app.mybluemix.net")
+			_, _ = p.RunSmokeTests(SmokeTest{Script: "../test/support/smoke-test-script"}, "app.mybluemix.net")
 			Expect(bgdOut.String()).To(ContainSubstring("STDERR"))
 		})
 
 		It("passes app FQDN as first argument", func() {
-			_ = p.RunSmokeTests("test/support/smoke-test-script", "app.mybluemix.net")
+			_, _ = p.RunSmokeTests(SmokeTest{Script: "../test/support/smoke-test-script"}, "app.mybluemix.net")
 			Expect(bgdOut.String()).To(ContainSubstring("App FQDN is: app.mybluemix.net"))
 		})
 
 		Context("when script doesn't exist", func() {
 			It("fails with useful error", func() {
-				_ = p.RunSmokeTests("inexistent-smoke-test-script", "app.mybluemix.net")
+				_, _ = p.RunSmokeTests(SmokeTest{Script: "inexistent-smoke-test-script"}, "app.mybluemix.net")
 				Expect(bgdExitsWithErrors[0].Error()).To(ContainSubstring("executable file not found"))
 			})
 		})
 
 		Context("when script isn't executable", func() {
 			It("fails with useful error", func() {
-				_ = p.RunSmokeTests("test/support/nonexec-smoke-test-script", "app.mybluemix.net")
+				_, _ = p.RunSmokeTests(SmokeTest{Script: "../test/support/nonexec-smoke-test-script"}, "app.mybluemix.net")
 				Expect(bgdExitsWithErrors[0].Error()).To(ContainSubstring("permission denied"))
 			})
 		})
@@ -707,7 +903,7 @@ var _ = Describe("BlueGreenDeploy", func() {
 			var passSmokeTest bool
 
 			BeforeEach(func() {
-				passSmokeTest = p.RunSmokeTests("test/support/smoke-test-script", "FORCE-SMOKE-TEST-FAILURE")
+				passSmokeTest, _ = p.RunSmokeTests(SmokeTest{Script: "../test/support/smoke-test-script"}, "FORCE-SMOKE-TEST-FAILURE")
 			})
 
 			It("returns false", func() {
@@ -718,6 +914,18 @@ var _ = Describe("BlueGreenDeploy", func() {
 				Expect(bgdExitsWithErrors).To(HaveLen(0))
 			})
 		})
+
+		Context("when script takes longer than the timeout", func() {
+			It("stops it and returns false", func() {
+				started := time.Now()
+				smokeTest := SmokeTest{Script: "../test/support/smoke-test-script", Timeout: 100 * time.Millisecond}
+
+				Expect(p.RunSmokeTests(smokeTest, "FORCE-SMOKE-TEST-TIMEOUT")).To(BeFalse())
+				Expect(time.Since(started)).To(BeNumerically("<", 5*time.Second))
+				Expect(bgdOut.String()).To(ContainSubstring("Smoke tests timed out after 100ms"))
+				Expect(bgdExitsWithErrors).To(HaveLen(0))
+			})
+		})
 	})
 
 })
@@ -730,3 +938,89 @@ func getAllCfCommands(connection *pluginfakes.FakeCliConnection) (commands []str
 	}
 	return
 }
+
+// fakeCloudController answers the cf curl requests which map routes and rename apps. Routes are
+// keyed by their guid, which is made of their host, domain and path with colons for slashes,
+// every domain has its name as its guid and the requests which change anything are recorded as
+// "METHOD path".
+type fakeCloudController struct {
+	routes      map[string][]string
+	changes     []string
+	failChanges error
+}
+
+func newFakeCloudController(routes ...string) *fakeCloudController {
+	cc := &fakeCloudController{routes: map[string][]string{}, changes: []string{}}
+	for _, route := range routes {
+		cc.routes[route] = []string{}
+	}
+	return cc
+}
+
+func (cc *fakeCloudController) curl(args ...string) ([]string, error) {
+	method, body := "GET", ""
+	for i := 2; i+1 < len(args); i += 2 {
+		switch args[i] {
+		case "-X":
+			method = args[i+1]
+		case "-d":
+			body = args[i+1]
+		}
+	}
+	requestURL, _ := url.Parse(args[1])
+	query := requestURL.Query()
+	segments := strings.Split(strings.TrimPrefix(requestURL.Path, "/v3/"), "/")
+
+	if method != "GET" {
+		cc.changes = append(cc.changes, method+" "+requestURL.Path)
+		if cc.failChanges != nil {
+			return nil, cc.failChanges
+		}
+	}
+
+	switch {
+	case segments[0] == "domains":
+		return []string{fmt.Sprintf(`{"resources": [{"guid": %q, "name": %q}]}`, query.Get("names"), query.Get("names"))}, nil
+	case segments[0] == "routes" && len(segments) == 1 && method == "GET":
+		guid := routeGuid(query.Get("hosts"), query.Get("domain_guids"), query.Get("paths"))
+		if _, ok := cc.routes[guid]; !ok {
+			return []string{`{"resources": []}`}, nil
+		}
+		return []string{fmt.Sprintf(`{"resources": [{"guid": %q, "host": %q, "path": %q}]}`, guid, query.Get("hosts"), query.Get("paths"))}, nil
+	case segments[0] == "routes" && len(segments) == 1:
+		route := struct {
+			Host          string
+			Path          string
+			Relationships struct{ Domain cfapi.Relationship }
+		}{}
+		json.Unmarshal([]byte(body), &route)
+		guid := routeGuid(route.Host, route.Relationships.Domain.Data.Guid, route.Path)
+		cc.routes[guid] = []string{}
+		return []string{fmt.Sprintf(`{"guid": %q, "host": %q, "path": %q}`, guid, route.Host, route.Path)}, nil
+	case segments[0] == "routes" && method == "GET":
+		destinations := []string{}
+		for _, app := range cc.routes[segments[1]] {
+			destinations = append(destinations, fmt.Sprintf(`{"guid": %q, "app": {"guid": %q, "process": {"type": "web"}}}`, app+"-destination", app))
+		}
+		return []string{`{"destinations": [` + strings.Join(destinations, ", ") + `]}`}, nil
+	case segments[0] == "routes" && method == "POST":
+		request := struct{ Destinations []cfapi.Destination }{}
+		json.Unmarshal([]byte(body), &request)
+		for _, destination := range request.Destinations {
+			cc.routes[segments[1]] = append(cc.routes[segments[1]], destination.App.Guid)
+		}
+	case segments[0] == "routes" && method == "DELETE":
+		apps := []string{}
+		for _, app := range cc.routes[segments[1]] {
+			if app+"-destination" != segments[3] {
+				apps = append(apps, app)
+			}
+		}
+		cc.routes[segments[1]] = apps
+	}
+	return []string{"{}"}, nil
+}
+
+func routeGuid(host string, domainGuid string, path string) string {
+	return strings.Replace(host+"."+domainGuid+path, "/", ":", -1)
+}
@@ -1,4 +1,4 @@
-package main_test
+package bluegreen_test
 
 import (
 	. "github.com/onsi/ginkgo"
@@ -8,8 +8,8 @@ import (
 	"testing"
 )
 
-func TestCfGreenBlueDeploy(t *testing.T) {
+func TestBluegreen(t *testing.T) {
 	RegisterFailHandler(Fail)
 	junitReporter := reporters.NewJUnitReporter("junit.xml")
-	RunSpecsWithDefaultAndCustomReporters(t, "CfGreenBlueDeploy Suite", []Reporter{junitReporter})
+	RunSpecsWithDefaultAndCustomReporters(t, "Bluegreen Suite", []Reporter{junitReporter})
 }
@@ -0,0 +1,121 @@
+package bluegreen
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin/models"
+)
+
+const (
+	StrategyBlueGreen = "blue-green"
+	StrategyCanary    = "canary"
+)
+
+// CanaryStrategy moves the traffic of the live routes to the new version in weighted steps.
+type CanaryStrategy struct{}
+
+func (CanaryStrategy) Deploy(p *Orchestrator, deployment *Deployment) (bool, error) {
+	if deployment.LiveAppName == "" {
+		return BlueGreenStrategy{}.Deploy(p, deployment)
+	}
+
+	passed, err := p.PushAndSmokeTest(deployment, deployment.ManifestScale)
+	if err != nil {
+		return false, err
+	}
+	if !passed {
+		return false, p.FailDeployment(deployment)
+	}
+
+	// Only routes the live app already serves can be shared between both versions
+	weightedRoutes := p.intersectRouteLists(deployment.NewAppRoutes, deployment.PromotedRoutes)
+	promoted, weighted, err := p.promoteByWeight(deployment.LiveAppName, deployment.NewAppName, weightedRoutes,
+		deployment.Options.CanarySteps, deployment.SmokeTest, deployment.Options.CanaryPause)
+	if err != nil {
+		return false, err
+	}
+	if !weighted {
+		if err := p.mapRoutes(deployment.NewAppName, deployment.NewAppRoutes...); err != nil {
+			return false, err
+		}
+		return true, p.CompletePromotion(deployment, deployment.PromotedRoutes)
+	}
+	if !promoted {
+		return false, p.FailDeployment(deployment)
+	}
+
+	if err := p.mapRoutes(deployment.NewAppName, p.SubtractRouteList(deployment.NewAppRoutes, weightedRoutes)...); err != nil {
+		return false, err
+	}
+	return true, p.CompletePromotion(deployment, p.SubtractRouteList(deployment.PromotedRoutes, weightedRoutes))
+}
+
+// promoteByWeight shifts the traffic of routes from the live app to the new app in steps, using
+// weighted route destinations, and verifies the routes after every step. It reports whether the
+// new app was promoted, and whether weighted routing could be used at all. When the Cloud
+// Controller does not support weights, nothing is left changed so that the caller can promote
+// all routes at once. Any other error of the first step is returned, once the weights are restored.
+func (p *Orchestrator) promoteByWeight(liveAppName string, newAppName string, routes []plugin_models.GetApp_RouteSummary,
+	steps []int, smokeTest SmokeTest, pause time.Duration) (promoted bool, weighted bool, err error) {
+
+	for i, step := range steps {
+		if step >= 100 {
+			break
+		}
+
+		fmt.Fprintf(p.Out, "Sending %d%% of the traffic to %s\n", step, newAppName)
+		for j, route := range routes {
+			err := p.Deployer.SetRouteWeights(route, RouteWeight{AppName: newAppName, Weight: step}, RouteWeight{AppName: liveAppName, Weight: 100 - step})
+			var unsupported *WeightedRoutingUnsupportedError
+			if err != nil && i == 0 && errors.As(err, &unsupported) {
+				fmt.Fprintf(p.Out, "Weighted routing is not available (%v), promoting all routes at once\n", err)
+				p.restoreRouteWeights(liveAppName, newAppName, routes[:j])
+				return false, false, nil
+			} else if err != nil && i == 0 {
+				p.restoreRouteWeights(liveAppName, newAppName, routes[:j])
+				return false, true, &Error{Message: fmt.Sprintf("Could not change the weight of %s", RouteURL(route)), Err: err}
+			} else if err != nil {
+				fmt.Fprintf(p.Out, "Could not change the weight of %s: %v\n", RouteURL(route), err)
+				p.restoreRouteWeights(liveAppName, newAppName, routes)
+				return false, true, nil
+			}
+		}
+
+		passed, err := p.verifyWave(newAppName, smokeTest, routes)
+		if err != nil {
+			p.restoreRouteWeights(liveAppName, newAppName, routes)
+			return false, true, err
+		}
+		if !passed {
+			fmt.Fprintf(p.Out, "Canary step of %d%% failed verification, sending all traffic back to %s\n", step, liveAppName)
+			p.restoreRouteWeights(liveAppName, newAppName, routes)
+			p.emit(Event{Type: EventRolledBack, App: liveAppName, Message: fmt.Sprintf("canary step of %d%% failed verification", step)})
+			return false, true, nil
+		}
+
+		if pause > 0 {
+			fmt.Fprintf(p.Out, "Waiting %v before the next step\n", pause)
+			time.Sleep(pause)
+		}
+	}
+
+	fmt.Fprintf(p.Out, "Sending all of the traffic to %s\n", newAppName)
+	for _, route := range routes {
+		if err := p.Deployer.SetRouteWeights(route, RouteWeight{AppName: newAppName, Weight: 100}, RouteWeight{AppName: liveAppName, Weight: 0}); err != nil {
+			fmt.Fprintf(p.Out, "Could not change the weight of %s: %v\n", RouteURL(route), err)
+			p.restoreRouteWeights(liveAppName, newAppName, routes)
+			return false, true, nil
+		}
+	}
+	return true, true, nil
+}
+
+func (p *Orchestrator) restoreRouteWeights(liveAppName string, newAppName string, routes []plugin_models.GetApp_RouteSummary) {
+	for _, route := range routes {
+		if err := p.Deployer.SetRouteWeights(route, RouteWeight{AppName: liveAppName, Weight: 100}, RouteWeight{AppName: newAppName, Weight: 0}); err != nil {
+			fmt.Fprintf(p.Out, "Could not send the traffic of %s back to %s: %v\n", RouteURL(route), liveAppName, err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package bluegreen_test
+
+import (
+	"bytes"
+	"errors"
+
+	"code.cloudfoundry.org/cli/plugin/models"
+	. "github.com/bluemixgaragelondon/cf-blue-green-deploy/bluegreen"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Canary strategy", func() {
+	var (
+		b *BlueGreenDeployFake
+		p Orchestrator
+	)
+
+	liveRoute := plugin_models.GetApp_RouteSummary{Host: "www", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}}
+
+	BeforeEach(func() {
+		b = &BlueGreenDeployFake{
+			liveApp: &plugin_models.GetAppModel{Name: "app-name",
+				Routes: []plugin_models.GetApp_RouteSummary{liveRoute}},
+			passSmokeTest: true,
+		}
+		p = Orchestrator{
+			Deployer: b,
+			Out:      &bytes.Buffer{},
+		}
+	})
+
+	deploy := func(extraArgs ...string) bool {
+		args := append([]string{"bgd", "app-name", "--strategy", "canary", "--canary-steps", "5,50,100"}, extraArgs...)
+		_, err := p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{}, mustParseArgs(args))
+		return err == nil
+	}
+
+	It("raises the weight of the new app in steps", func() {
+		Expect(deploy("--smoke-test", "smoke")).To(BeTrue())
+
+		Expect(b.flow[8:]).To(Equal([]string{
+			"weight www.example.com app-name-new=5 app-name=95",
+			"smoke www.example.com",
+			"weight www.example.com app-name-new=50 app-name=50",
+			"smoke www.example.com",
+			"weight www.example.com app-name-new=100 app-name=0",
+			"mapped 0 routes",
+			"rename app-name to app-name-old",
+			"rename app-name-new to app-name",
+			"unmap 0 routes from app-name-old",
+		}))
+	})
+
+	It("sends all traffic back to the live app when a step fails", func() {
+		b.failingFQDNs = []string{"www.example.com"}
+
+		Expect(deploy("--smoke-test", "smoke")).To(BeFalse())
+
+		Expect(b.flow[8:]).To(Equal([]string{
+			"weight www.example.com app-name-new=5 app-name=95",
+			"smoke www.example.com",
+			"weight www.example.com app-name=100 app-name-new=0",
+			"rename app-name-new to app-name-failed",
+		}))
+	})
+
+	Context("when weighted routing is not supported", func() {
+		It("promotes all routes at once", func() {
+			b.noWeights = true
+
+			Expect(deploy()).To(BeTrue())
+
+			Expect(b.flow[7:]).To(Equal([]string{
+				"mapped 1 routes",
+				"rename app-name to app-name-old",
+				"rename app-name-new to app-name",
+				"unmap 1 routes from app-name-old",
+			}))
+			Expect(b.mappedRoutes).To(ConsistOf(liveRoute))
+		})
+	})
+
+	Context("when the weights cannot be changed for another reason", func() {
+		It("stops the deployment with the error", func() {
+			b.weightsError = errors.New("CF-NotAuthenticated: Authentication error")
+
+			args := []string{"bgd", "app-name", "--strategy", "canary", "--canary-steps", "5,50,100"}
+			_, err := p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{}, mustParseArgs(args))
+
+			Expect(err).To(MatchError("Could not change the weight of www.example.com - CF-NotAuthenticated: Authentication error"))
+			Expect(b.flow).ToNot(ContainElement("mapped 1 routes"))
+			Expect(b.flow).ToNot(ContainElement("rename app-name to app-name-old"))
+		})
+	})
+})
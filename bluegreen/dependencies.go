@@ -0,0 +1,189 @@
+package bluegreen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
+)
+
+// DependencyGraph says which of the apps being deployed have to be promoted before others, as
+// declared with x-bgd-depends-on in the manifest.
+type DependencyGraph struct {
+	order     []string
+	dependsOn map[string][]string
+}
+
+// NewDependencyGraph orders appNames so that every app comes after the apps it depends on, and
+// otherwise keeps their order. Dependencies on apps which are not being deployed are left out,
+// since those apps are live already. It returns an error when apps depend on each other in a
+// cycle.
+func NewDependencyGraph(appNames []string, dependsOn map[string][]string) (*DependencyGraph, error) {
+	graph := &DependencyGraph{dependsOn: map[string][]string{}}
+
+	deployed := map[string]bool{}
+	for _, appName := range appNames {
+		deployed[appName] = true
+	}
+	for _, appName := range appNames {
+		for _, dependency := range dependsOn[appName] {
+			if dependency == appName {
+				return nil, fmt.Errorf("App %s depends on itself", appName)
+			}
+			if deployed[dependency] {
+				graph.dependsOn[appName] = append(graph.dependsOn[appName], dependency)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[string]int{}
+	path := []string{}
+
+	var visit func(appName string) error
+	visit = func(appName string) error {
+		switch state[appName] {
+		case visited:
+			return nil
+		case visiting:
+			cycle := append(path[indexOfApp(path, appName):], appName)
+			return fmt.Errorf("Apps depend on each other in a cycle: %s", strings.Join(cycle, " -> "))
+		}
+
+		state[appName] = visiting
+		path = append(path, appName)
+		for _, dependency := range graph.dependsOn[appName] {
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[appName] = visited
+		graph.order = append(graph.order, appName)
+		return nil
+	}
+
+	for _, appName := range appNames {
+		if err := visit(appName); err != nil {
+			return nil, err
+		}
+	}
+	return graph, nil
+}
+
+func indexOfApp(appNames []string, appName string) int {
+	for i, name := range appNames {
+		if name == appName {
+			return i
+		}
+	}
+	return -1
+}
+
+// Order lists the apps so that every app comes after the apps it depends on.
+func (g *DependencyGraph) Order() []string {
+	return g.order
+}
+
+// DependsOn lists the apps being deployed which appName depends on.
+func (g *DependencyGraph) DependsOn(appName string) []string {
+	return g.dependsOn[appName]
+}
+
+// SkippedError is the error of an app which was not deployed because an app it depends on failed.
+type SkippedError struct {
+	Dependency string
+}
+
+func (e *SkippedError) Error() string {
+	return fmt.Sprintf("Skipped, since %s failed", e.Dependency)
+}
+
+// Walk deploys every app with deploy once the apps it depends on have been deployed, up to
+// parallel apps at once. An app is skipped when an app it depends on failed. It returns the
+// errors of the apps which failed or were skipped.
+func (g *DependencyGraph) Walk(parallel int, deploy func(appName string) error) map[string]error {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	type outcome struct {
+		appName string
+		err     error
+	}
+	finished := make(chan outcome)
+	done := map[string]bool{}
+	errs := map[string]error{}
+	running := 0
+
+	pending := g.order
+	for len(pending) > 0 || running > 0 {
+		waiting := []string{}
+		for _, appName := range pending {
+			ready, failedDependency := g.ready(appName, done, errs)
+			switch {
+			case failedDependency != "":
+				errs[appName] = &SkippedError{Dependency: failedDependency}
+				done[appName] = true
+			case ready && running < parallel:
+				running++
+				go func(appName string) {
+					finished <- outcome{appName, deploy(appName)}
+				}(appName)
+			default:
+				waiting = append(waiting, appName)
+			}
+		}
+		pending = waiting
+
+		if running > 0 {
+			result := <-finished
+			running--
+			done[result.appName] = true
+			if result.err != nil {
+				errs[result.appName] = result.err
+			}
+		}
+	}
+	return errs
+}
+
+// ready tells whether every app appName depends on has been deployed, or else which of them
+// failed.
+func (g *DependencyGraph) ready(appName string, done map[string]bool, errs map[string]error) (bool, string) {
+	for _, dependency := range g.dependsOn[appName] {
+		if errs[dependency] != nil {
+			return false, dependency
+		}
+		if !done[dependency] {
+			return false, ""
+		}
+	}
+	return true, ""
+}
+
+// GetDependencies reads which apps each of appNames depends on from the manifest.
+func (p *Orchestrator) GetDependencies(appNames []string, cfDomains manifest.CfDomains, manifestReader manifest.ManifestReader) (map[string][]string, error) {
+	dependsOn := map[string][]string{}
+
+	parsedManifest, err := manifestReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the manifest: %v", err)
+	}
+	if parsedManifest == nil {
+		return dependsOn, nil
+	}
+
+	for _, appName := range appNames {
+		pluginParams, err := parsedManifest.GetPluginParams(appName, cfDomains)
+		if err != nil {
+			return nil, err
+		}
+		dependsOn[appName] = pluginParams.DependsOn
+	}
+	return dependsOn, nil
+}
@@ -0,0 +1,174 @@
+package bluegreen_test
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/bluemixgaragelondon/cf-blue-green-deploy/bluegreen"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Dependencies between apps", func() {
+	Describe("NewDependencyGraph", func() {
+		It("orders apps after the apps they depend on and keeps the order otherwise", func() {
+			graph, err := NewDependencyGraph([]string{"frontend", "worker", "api", "auth"}, map[string][]string{
+				"frontend": {"api", "auth"},
+				"api":      {"auth"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(graph.Order()).To(Equal([]string{"auth", "api", "frontend", "worker"}))
+		})
+
+		It("leaves out dependencies on apps which are not deployed", func() {
+			graph, err := NewDependencyGraph([]string{"frontend"}, map[string][]string{"frontend": {"api"}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(graph.Order()).To(Equal([]string{"frontend"}))
+			Expect(graph.DependsOn("frontend")).To(BeEmpty())
+		})
+
+		It("finds cycles", func() {
+			_, err := NewDependencyGraph([]string{"frontend", "api", "auth"}, map[string][]string{
+				"frontend": {"api"},
+				"api":      {"auth"},
+				"auth":     {"api"},
+			})
+			Expect(err).To(MatchError("Apps depend on each other in a cycle: api -> auth -> api"))
+		})
+
+		It("finds apps which depend on themselves", func() {
+			_, err := NewDependencyGraph([]string{"api"}, map[string][]string{"api": {"api"}})
+			Expect(err).To(MatchError("App api depends on itself"))
+		})
+	})
+
+	Describe("Walk", func() {
+		It("deploys apps one at a time in order", func() {
+			graph, _ := NewDependencyGraph([]string{"frontend", "api"}, map[string][]string{"frontend": {"api"}})
+
+			deployed := []string{}
+			errs := graph.Walk(1, func(appName string) error {
+				deployed = append(deployed, appName)
+				return nil
+			})
+			Expect(errs).To(BeEmpty())
+			Expect(deployed).To(Equal([]string{"api", "frontend"}))
+		})
+
+		It("skips the apps which depend on a failed app", func() {
+			graph, _ := NewDependencyGraph([]string{"frontend", "admin", "api", "worker"}, map[string][]string{
+				"frontend": {"api"},
+				"admin":    {"frontend"},
+			})
+
+			deployed := []string{}
+			errs := graph.Walk(1, func(appName string) error {
+				deployed = append(deployed, appName)
+				if appName == "api" {
+					return errors.New("push failed")
+				}
+				return nil
+			})
+			Expect(deployed).To(Equal([]string{"api", "worker"}))
+			Expect(errs).To(HaveLen(3))
+			Expect(errs["api"]).To(MatchError("push failed"))
+			Expect(errs["frontend"]).To(MatchError("Skipped, since api failed"))
+			Expect(errs["admin"]).To(MatchError("Skipped, since frontend failed"))
+		})
+
+		It("deploys apps which do not depend on each other at the same time", func() {
+			graph, _ := NewDependencyGraph([]string{"frontend", "api", "worker"}, map[string][]string{"frontend": {"api", "worker"}})
+
+			var mutex sync.Mutex
+			started := map[string]bool{}
+			bothStarted := make(chan struct{})
+			order := []string{}
+
+			walked := make(chan map[string]error)
+			go func() {
+				walked <- graph.Walk(2, func(appName string) error {
+					mutex.Lock()
+					started[appName] = true
+					order = append(order, appName)
+					if started["api"] && started["worker"] && appName != "frontend" {
+						close(bothStarted)
+					}
+					mutex.Unlock()
+
+					if appName != "frontend" {
+						<-bothStarted
+					}
+					return nil
+				})
+			}()
+
+			var errs map[string]error
+			Eventually(walked, 5*time.Second).Should(Receive(&errs))
+			Expect(errs).To(BeEmpty())
+			Expect(order[2]).To(Equal("frontend"))
+		})
+	})
+
+	Describe("deploying the apps of a manifest", func() {
+		var (
+			b *BlueGreenDeployFake
+			p Orchestrator
+		)
+
+		cfDomains := manifest.CfDomains{DefaultDomain: "example.com"}
+
+		BeforeEach(func() {
+			b = &BlueGreenDeployFake{passSmokeTest: true}
+			p = Orchestrator{Deployer: b, Out: &bytes.Buffer{}}
+		})
+
+		It("deploys apps after the apps they depend on", func() {
+			manifestReader := &fakes.FakeManifestReader{Yaml: `---
+applications:
+- name: frontend
+  x-bgd-depends-on: [api]
+- name: api
+`}
+			result, err := p.DeployApps(cfDomains, manifestReader, mustParseArgs(bgdArgs("--all")))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pushes(b.flow)).To(Equal([]string{"push api-new", "push frontend-new"}))
+			Expect(result.Apps[0].AppName).To(Equal("api"))
+		})
+
+		It("skips the apps whose dependencies failed", func() {
+			manifestReader := &fakes.FakeManifestReader{Yaml: `---
+applications:
+- name: frontend
+  x-bgd-depends-on: [api]
+- name: api
+  x-bgd-smoke-test: smoke/api.sh
+- name: worker
+`}
+			b.smokeTestFails = 1
+
+			result, err := p.DeployApps(cfDomains, manifestReader, mustParseArgs(bgdArgs("--all")))
+			Expect(err).To(MatchError("Deployment failed for api, frontend"))
+
+			Expect(pushes(b.flow)).To(Equal([]string{"push api-new", "push worker-new"}))
+			Expect(result.Apps[1].AppName).To(Equal("frontend"))
+			Expect(result.Apps[1].Err).To(MatchError("Skipped, since api failed"))
+		})
+
+		It("does not deploy apps which depend on each other in a cycle", func() {
+			manifestReader := &fakes.FakeManifestReader{Yaml: `---
+applications:
+- name: frontend
+  x-bgd-depends-on: [api]
+- name: api
+  x-bgd-depends-on: [frontend]
+`}
+			_, err := p.DeployApps(cfDomains, manifestReader, mustParseArgs(bgdArgs("--all")))
+			Expect(err).To(MatchError("Apps depend on each other in a cycle: frontend -> api -> frontend"))
+			Expect(b.flow).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,93 @@
+package bluegreen
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/internal/cfapi"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
+)
+
+// DiscoverDomains finds the domains available to the targeted org and its default domain through
+// the CF v3 API. Foundations without the v3 API fall back to the v2 domain listings, where the
+// first shared domain is taken as the default. Any other error is returned, since guessing the
+// default domain could put the routes of the apps on the wrong one.
+func (p *Orchestrator) DiscoverDomains() (manifest.CfDomains, error) {
+	cfDomains, err := p.v3Domains()
+	if err == nil {
+		return cfDomains, nil
+	}
+	if !cfapi.IsNotFound(err) {
+		return cfDomains, fmt.Errorf("Failed to get domains: %v", err)
+	}
+
+	cfDomains = manifest.CfDomains{}
+	cfDomains.SharedDomains, err = p.SharedDomains()
+	if err != nil {
+		return cfDomains, fmt.Errorf("Failed to get shared domains: %v", err)
+	}
+	if len(cfDomains.SharedDomains) < 1 {
+		return cfDomains, errors.New("Failed to get default shared domain (no shared domains defined)")
+	}
+	cfDomains.DefaultDomain = cfDomains.SharedDomains[0]
+
+	cfDomains.PrivateDomains, err = p.PrivateDomains()
+	if err != nil {
+		return cfDomains, fmt.Errorf("Failed to get private domains: %v", err)
+	}
+	return cfDomains, nil
+}
+
+func (p *Orchestrator) v3Domains() (manifest.CfDomains, error) {
+	cfDomains := manifest.CfDomains{}
+	api := retryingClient(p.Connection, p.Retry, p.Out)
+
+	org, err := p.Connection.GetCurrentOrg()
+	if err != nil {
+		return cfDomains, err
+	}
+
+	domains, err := api.Domains(org.Guid)
+	if err != nil {
+		return cfDomains, err
+	}
+
+	for _, resource := range domains {
+		domain := manifest.Domain{
+			Name:     resource.Name,
+			Internal: resource.Internal,
+			Shared:   resource.Shared(),
+		}
+		if resource.RouterGroup != nil {
+			domain.RouterGroup = resource.RouterGroup.Guid
+		}
+
+		cfDomains.Domains = append(cfDomains.Domains, domain)
+		if domain.Shared {
+			cfDomains.SharedDomains = append(cfDomains.SharedDomains, domain.Name)
+		} else {
+			cfDomains.PrivateDomains = append(cfDomains.PrivateDomains, domain.Name)
+		}
+	}
+
+	if org.Guid != "" {
+		defaultDomain, err := api.DefaultDomain(org.Guid)
+		if err != nil {
+			return cfDomains, err
+		}
+		cfDomains.DefaultDomain = defaultDomain.Name
+	} else {
+		// Without an org, the first shared domain apps can be reached on is what cf push would use
+		for _, domain := range cfDomains.Domains {
+			if domain.Shared && cfDomains.IsExternalHttp(domain.Name) {
+				cfDomains.DefaultDomain = domain.Name
+				break
+			}
+		}
+	}
+
+	if cfDomains.DefaultDomain == "" {
+		return cfDomains, errors.New("Failed to get default domain")
+	}
+	return cfDomains, nil
+}
@@ -0,0 +1,138 @@
+package bluegreen_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin/models"
+	"code.cloudfoundry.org/cli/plugin/pluginfakes"
+	. "github.com/bluemixgaragelondon/cf-blue-green-deploy/bluegreen"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Domain discovery", func() {
+	var (
+		connection *pluginfakes.FakeCliConnection
+		p          Orchestrator
+		paths      []string
+		responses  map[string]string
+	)
+
+	BeforeEach(func() {
+		paths = []string{}
+		responses = map[string]string{
+			"/v3/organizations/org-guid/domains": `{
+				"pagination": {"next": {"href": "https://api.example.com/v3/organizations/org-guid/domains?page=2&per_page=2"}},
+				"resources": [
+					{"name": "apps.internal", "internal": true, "router_group": null, "relationships": {"organization": {"data": null}}},
+					{"name": "tcp.example.com", "internal": false, "router_group": {"guid": "tcp-group"}, "relationships": {"organization": {"data": null}}}
+				]}`,
+			"/v3/organizations/org-guid/domains?page=2&per_page=2": `{
+				"pagination": {"next": null},
+				"resources": [
+					{"name": "example.com", "internal": false, "router_group": null, "relationships": {"organization": {"data": null}}},
+					{"name": "mine.com", "internal": false, "router_group": null, "relationships": {"organization": {"data": {"guid": "org-guid"}}}}
+				]}`,
+			"/v3/organizations/org-guid/domains/default": `{"name": "example.com"}`,
+		}
+
+		connection = &pluginfakes.FakeCliConnection{}
+		connection.GetCurrentOrgReturns(plugin_models.Organization{OrganizationFields: plugin_models.OrganizationFields{Guid: "org-guid"}}, nil)
+		connection.CliCommandWithoutTerminalOutputStub = func(args ...string) ([]string, error) {
+			paths = append(paths, args[1])
+			if response, ok := responses[args[1]]; ok {
+				return []string{response}, nil
+			}
+			return []string{`{"errors": [{"title": "CF-NotFound", "detail": "Unknown request"}]}`}, nil
+		}
+		p = Orchestrator{Connection: connection}
+	})
+
+	It("reads every page of the org's domains and its default domain", func() {
+		cfDomains, err := p.DiscoverDomains()
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cfDomains.DefaultDomain).To(Equal("example.com"))
+		Expect(cfDomains.SharedDomains).To(Equal([]string{"apps.internal", "tcp.example.com", "example.com"}))
+		Expect(cfDomains.PrivateDomains).To(Equal([]string{"mine.com"}))
+		Expect(cfDomains.Domains).To(ContainElement(manifest.Domain{Name: "apps.internal", Internal: true, Shared: true}))
+		Expect(cfDomains.Domains).To(ContainElement(manifest.Domain{Name: "tcp.example.com", Shared: true, RouterGroup: "tcp-group"}))
+		Expect(cfDomains.Domains).To(ContainElement(manifest.Domain{Name: "mine.com"}))
+	})
+
+	It("picks the first external HTTP shared domain when no org is targeted", func() {
+		connection.GetCurrentOrgReturns(plugin_models.Organization{}, nil)
+		responses["/v3/domains"] = responses["/v3/organizations/org-guid/domains"]
+		responses["/v3/organizations/org-guid/domains?page=2&per_page=2"] = strings.Replace(
+			responses["/v3/organizations/org-guid/domains?page=2&per_page=2"], `"example.com"`, `"other.com"`, 1)
+
+		cfDomains, err := p.DiscoverDomains()
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cfDomains.DefaultDomain).To(Equal("other.com"))
+		Expect(paths[0]).To(Equal("/v3/domains"))
+	})
+
+	It("falls back to the v2 API when the v3 API is not available", func() {
+		responses = map[string]string{
+			"/v2/shared_domains":                         `{"resources": [{"entity": {"name": "example.com"}}]}`,
+			"/v2/organizations/org-guid/private_domains": `{"resources": [{"entity": {"name": "mine.com"}}]}`,
+		}
+
+		cfDomains, err := p.DiscoverDomains()
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cfDomains).To(Equal(manifest.CfDomains{
+			DefaultDomain:  "example.com",
+			SharedDomains:  []string{"example.com"},
+			PrivateDomains: []string{"mine.com"},
+		}))
+	})
+
+	It("reports when the org cannot be found", func() {
+		connection.GetCurrentOrgReturns(plugin_models.Organization{}, errors.New("not logged in"))
+		responses = map[string]string{}
+		connection.CliCommandWithoutTerminalOutputStub = func(args ...string) ([]string, error) {
+			return nil, errors.New("not logged in")
+		}
+
+		_, err := p.DiscoverDomains()
+		Expect(err).To(MatchError("Failed to get domains: not logged in"))
+	})
+
+	It("does not fall back to the v2 API when the v3 API fails", func() {
+		responses["/v3/organizations/org-guid/domains/default"] = `{"errors": [{"code": 10001, "title": "UnknownError", "detail": "An unknown error occurred."}]}`
+
+		_, err := p.DiscoverDomains()
+		Expect(err).To(MatchError("Failed to get domains: UnknownError: An unknown error occurred."))
+		Expect(paths).ToNot(ContainElement("/v2/shared_domains"))
+	})
+})
+
+var _ = Describe("Temporary route", func() {
+	It("is created on a domain the smoke tests can reach", func() {
+		b := &BlueGreenDeployFake{passSmokeTest: true}
+		p := Orchestrator{Deployer: b, Out: &bytes.Buffer{}}
+
+		manifestReader := &fakes.FakeManifestReader{Yaml: `---
+applications:
+- name: app-name
+  routes:
+  - route: app-name.apps.internal
+  - route: www.example.com
+`}
+		cfDomains := manifest.CfDomains{
+			DefaultDomain: "example.com",
+			SharedDomains: []string{"apps.internal", "example.com"},
+			Domains:       []manifest.Domain{{Name: "apps.internal", Internal: true, Shared: true}, {Name: "example.com", Shared: true}},
+		}
+
+		_, err := p.Deploy(cfDomains, manifestReader, mustParseArgs([]string{"bgd", "app-name", "--smoke-test", "smoke"}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b.flow).To(ContainElement("smoke app-name-new.example.com"))
+	})
+})
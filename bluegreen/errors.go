@@ -0,0 +1,53 @@
+package bluegreen
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrDeploymentFailed is returned when the new version of an app was not promoted, for example
+// because its smoke tests failed. The live version keeps serving the app's routes.
+var ErrDeploymentFailed = errors.New("Deployment failed, the new version was not promoted")
+
+// ErrGroupFailed is the error of the apps of a group which were not promoted because another app
+// of the group failed.
+var ErrGroupFailed = errors.New("Not promoted, since another app of the group failed")
+
+// ErrRollbackFailed is returned when a rollback did not finish. Its cause has been reported to
+// the output.
+var ErrRollbackFailed = errors.New("Rollback failed")
+
+// AppsFailedError is returned when some of the apps of a deployment of several apps failed. The
+// Err of their results says why.
+type AppsFailedError struct {
+	AppNames []string
+}
+
+func (e *AppsFailedError) Error() string {
+	return fmt.Sprintf("Deployment failed for %s", strings.Join(e.AppNames, ", "))
+}
+
+// Error is a step of a deployment which failed, such as a cf command, and stopped the
+// deployment part way through.
+type Error struct {
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v - %v", e.Message, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// fail reports a failed step to the ErrorFunc, if there is one, and returns it as an *Error, which
+// stops the step.
+func (p *BlueGreenDeploy) fail(message string, err error) error {
+	if p.ErrorFunc != nil {
+		p.ErrorFunc(message, err)
+	}
+	return &Error{Message: message, Err: err}
+}
@@ -0,0 +1,162 @@
+package bluegreen
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin/models"
+)
+
+type EventType string
+
+const (
+	EventCleanup          EventType = "cleanup"
+	EventPushStarted      EventType = "push-started"
+	EventPushFinished     EventType = "push-finished"
+	EventSmokeTestStarted EventType = "smoke-test-started"
+	EventSmokeTestPassed  EventType = "smoke-test-passed"
+	EventSmokeTestFailed  EventType = "smoke-test-failed"
+	EventRouteMapped      EventType = "route-mapped"
+	EventRouteUnmapped    EventType = "route-unmapped"
+	EventRenamed          EventType = "renamed"
+	EventPromoted         EventType = "promoted"
+	EventRolledBack       EventType = "rolled-back"
+	EventFailed           EventType = "failed"
+)
+
+// Event is a step a deployment has reached. App is the app the step concerns, which is the
+// new version of the app, such as APP-new, while it is being pushed and tested.
+type Event struct {
+	Type    EventType `json:"type"`
+	Time    time.Time `json:"time"`
+	App     string    `json:"app"`
+	Route   string    `json:"route,omitempty"`
+	NewName string    `json:"new_name,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+// String describes the event for people following the deployment.
+func (e Event) String() string {
+	var description string
+	switch e.Type {
+	case EventCleanup:
+		description = fmt.Sprintf("Deleting old versions of %s", e.App)
+	case EventPushStarted:
+		description = fmt.Sprintf("Pushing %s", e.App)
+	case EventPushFinished:
+		description = fmt.Sprintf("Pushed %s", e.App)
+	case EventSmokeTestStarted:
+		description = fmt.Sprintf("Running the smoke tests of %s against %s", e.App, e.Route)
+	case EventSmokeTestPassed:
+		description = fmt.Sprintf("Smoke tests of %s passed against %s", e.App, e.Route)
+	case EventSmokeTestFailed:
+		description = fmt.Sprintf("Smoke tests of %s failed against %s", e.App, e.Route)
+	case EventRouteMapped:
+		description = fmt.Sprintf("Mapped %s to %s", e.Route, e.App)
+	case EventRouteUnmapped:
+		description = fmt.Sprintf("Unmapped %s from %s", e.Route, e.App)
+	case EventRenamed:
+		description = fmt.Sprintf("Renamed %s to %s", e.App, e.NewName)
+	case EventPromoted:
+		description = fmt.Sprintf("Promoted the new version of %s", e.App)
+	case EventRolledBack:
+		description = fmt.Sprintf("Rolled back %s", e.App)
+	case EventFailed:
+		description = fmt.Sprintf("Deployment of %s failed", e.App)
+	default:
+		description = fmt.Sprintf("%s %s", e.Type, e.App)
+	}
+
+	if e.Message != "" {
+		description = description + ": " + e.Message
+	}
+	return description
+}
+
+// Observer is told about every event of the deployments of an Orchestrator it is added to.
+type Observer interface {
+	Observe(Event)
+}
+
+// ObserverFunc lets a function observe events.
+type ObserverFunc func(Event)
+
+func (f ObserverFunc) Observe(event Event) {
+	f(event)
+}
+
+// ProgressObserver writes events as lines for people following the deployment.
+type ProgressObserver struct {
+	Out io.Writer
+}
+
+func (o ProgressObserver) Observe(event Event) {
+	fmt.Fprintf(o.Out, "==> %s\n", event)
+}
+
+// JSONObserver writes events as newline-delimited JSON, for CI systems and wrappers to react to.
+type JSONObserver struct {
+	Out io.Writer
+}
+
+func (o JSONObserver) Observe(event Event) {
+	json.NewEncoder(o.Out).Encode(event)
+}
+
+func (p *Orchestrator) AddObserver(observer Observer) {
+	p.Observers = append(p.Observers, observer)
+}
+
+func (p *Orchestrator) emit(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	p.emitting.Lock()
+	defer p.emitting.Unlock()
+	for _, observer := range p.Observers {
+		observer.Observe(event)
+	}
+}
+
+// The steps below run a Deployer step and report it to the observers once it succeeded.
+
+func (p *Orchestrator) deleteOldVersions(appName string, excludedRoutes ...plugin_models.GetApp_RouteSummary) error {
+	p.emit(Event{Type: EventCleanup, App: appName})
+	return p.Deployer.DeleteAllAppsExceptLiveApp(appName, excludedRoutes...)
+}
+
+func (p *Orchestrator) deleteOldButNotFailedVersions(appName string, excludedRoutes ...plugin_models.GetApp_RouteSummary) error {
+	p.emit(Event{Type: EventCleanup, App: appName})
+	return p.Deployer.DeleteAllAppsExceptLiveAndFailedApp(appName, excludedRoutes...)
+}
+
+func (p *Orchestrator) mapRoutes(appName string, routes ...plugin_models.GetApp_RouteSummary) error {
+	if err := p.Deployer.MapRoutesToApp(appName, routes...); err != nil {
+		return err
+	}
+	for _, route := range routes {
+		p.emit(Event{Type: EventRouteMapped, App: appName, Route: RouteURL(route)})
+	}
+	return nil
+}
+
+func (p *Orchestrator) unmapRoutes(appName string, routes ...plugin_models.GetApp_RouteSummary) error {
+	if err := p.Deployer.UnmapRoutesFromApp(appName, routes...); err != nil {
+		return err
+	}
+	for _, route := range routes {
+		p.emit(Event{Type: EventRouteUnmapped, App: appName, Route: RouteURL(route)})
+	}
+	return nil
+}
+
+func (p *Orchestrator) renameApp(appName string, newName string) error {
+	if err := p.Deployer.RenameApp(appName, newName); err != nil {
+		return err
+	}
+	p.emit(Event{Type: EventRenamed, App: appName, NewName: newName})
+	return nil
+}
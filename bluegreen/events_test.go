@@ -0,0 +1,140 @@
+package bluegreen_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin/models"
+	. "github.com/bluemixgaragelondon/cf-blue-green-deploy/bluegreen"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Events", func() {
+	var (
+		b      *BlueGreenDeployFake
+		p      Orchestrator
+		events []Event
+	)
+
+	liveRoute := plugin_models.GetApp_RouteSummary{Host: "www", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}}
+
+	BeforeEach(func() {
+		b = &BlueGreenDeployFake{
+			liveApp: &plugin_models.GetAppModel{Name: "app-name",
+				Routes: []plugin_models.GetApp_RouteSummary{liveRoute}},
+			passSmokeTest: true,
+		}
+		p = Orchestrator{Deployer: b, Out: &bytes.Buffer{}}
+
+		events = nil
+		p.AddObserver(ObserverFunc(func(event Event) {
+			events = append(events, event)
+		}))
+	})
+
+	deploy := func(argString string) error {
+		_, err := p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{}, mustParseArgs(bgdArgs(argString)))
+		return err
+	}
+
+	eventTypes := func() []EventType {
+		types := []EventType{}
+		for _, event := range events {
+			types = append(types, event.Type)
+		}
+		return types
+	}
+
+	It("tells the observers about every step of a blue-green deployment", func() {
+		Expect(deploy("app-name --smoke-test script/smoke-test")).To(Succeed())
+
+		Expect(eventTypes()).To(Equal([]EventType{
+			EventCleanup,
+			EventPushStarted,
+			EventPushFinished,
+			EventSmokeTestStarted,
+			EventSmokeTestPassed,
+			EventRouteUnmapped,
+			EventRouteMapped,
+			EventRenamed,
+			EventRenamed,
+			EventRouteUnmapped,
+			EventPromoted,
+		}))
+	})
+
+	It("describes the app and route of each step", func() {
+		Expect(deploy("app-name --smoke-test script/smoke-test")).To(Succeed())
+
+		Expect(events[3].App).To(Equal("app-name-new"))
+		Expect(events[3].Route).To(Equal("app-name-new.example.com"))
+		Expect(events[6].Route).To(Equal("www.example.com"))
+		Expect(events[8].App).To(Equal("app-name-new"))
+		Expect(events[8].NewName).To(Equal("app-name"))
+		for _, event := range events {
+			Expect(event.Time).NotTo(BeZero())
+		}
+	})
+
+	It("reports failed smoke tests and the failed deployment", func() {
+		b.passSmokeTest = false
+		Expect(deploy("app-name --smoke-test script/smoke-test")).To(MatchError(ErrDeploymentFailed))
+
+		Expect(eventTypes()).To(Equal([]EventType{
+			EventCleanup,
+			EventPushStarted,
+			EventPushFinished,
+			EventSmokeTestStarted,
+			EventSmokeTestFailed,
+			EventRouteUnmapped,
+			EventRenamed,
+			EventFailed,
+		}))
+		Expect(events[6].NewName).To(Equal("app-name-failed"))
+	})
+
+	It("reports the routes which are moved back when a wave fails", func() {
+		b.failingFQDNs = []string{"www.example.com"}
+		Expect(deploy("app-name --smoke-test script/smoke-test --wave example.com")).To(MatchError(ErrDeploymentFailed))
+
+		Expect(eventTypes()).To(ContainElement(EventRolledBack))
+		Expect(eventTypes()[len(events)-1]).To(Equal(EventFailed))
+	})
+
+	Describe("JSONObserver", func() {
+		It("writes an event per line", func() {
+			out := &bytes.Buffer{}
+			observer := JSONObserver{Out: out}
+			eventTime := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+
+			observer.Observe(Event{Type: EventRouteMapped, Time: eventTime, App: "app-name-new", Route: "www.example.com"})
+			observer.Observe(Event{Type: EventRenamed, Time: eventTime, App: "app-name-new", NewName: "app-name"})
+
+			Expect(out.String()).To(Equal(
+				`{"type":"route-mapped","time":"2020-05-01T12:00:00Z","app":"app-name-new","route":"www.example.com"}` + "\n" +
+					`{"type":"renamed","time":"2020-05-01T12:00:00Z","app":"app-name-new","new_name":"app-name"}` + "\n"))
+
+			var event Event
+			Expect(json.Unmarshal(bytes.Split(out.Bytes(), []byte("\n"))[0], &event)).To(Succeed())
+			Expect(event.Type).To(Equal(EventRouteMapped))
+		})
+	})
+
+	Describe("ProgressObserver", func() {
+		It("describes the events for people", func() {
+			out := &bytes.Buffer{}
+			observer := ProgressObserver{Out: out}
+
+			observer.Observe(Event{Type: EventSmokeTestFailed, App: "app-name-new", Route: "app-name-new.example.com"})
+			observer.Observe(Event{Type: EventRolledBack, App: "app-name", Message: "wave 1 failed verification"})
+
+			Expect(out.String()).To(Equal(
+				"==> Smoke tests of app-name-new failed against app-name-new.example.com\n" +
+					"==> Rolled back app-name: wave 1 failed verification\n"))
+		})
+	})
+})
@@ -0,0 +1,171 @@
+package bluegreen
+
+import (
+	"fmt"
+
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
+)
+
+// DeployGroup deploys several apps which only work together, such as a frontend and the API it
+// calls, so that either all of them are promoted or none is. Every new version is pushed and
+// smoke tested first, and only once all of them have passed are the routes of every app switched
+// over, back to back, and the apps renamed. If a switch or a rename fails, the renames are undone
+// and the routes of every app go back to its live version.
+//
+// The routes are switched the blue-green way, so the group cannot use another strategy or waves.
+func (p *Orchestrator) DeployGroup(cfDomains manifest.CfDomains, manifestReader manifest.ManifestReader, opts Options) (Result, error) {
+	result := Result{Strategy: StrategyBlueGreen}
+	if (opts.Strategy != "" && opts.Strategy != StrategyBlueGreen) || len(opts.Waves) > 0 {
+		return result, fmt.Errorf("Apps can only be promoted together with the %s strategy and without waves", StrategyBlueGreen)
+	}
+
+	appNames, err := appNamesToDeploy(manifestReader, opts)
+	if err != nil {
+		return result, err
+	}
+	graph, err := p.dependencyGraph(appNames, cfDomains, manifestReader, opts)
+	if err != nil {
+		return result, err
+	}
+	// Routes are switched in the order of the dependencies, so an app is served by its new
+	// version no later than the apps which depend on it.
+	appNames = graph.Order()
+
+	deployments := []*Deployment{}
+	for _, appName := range appNames {
+		deployment, err := p.PrepareDeployment(cfDomains, manifestReaderFor(appName, manifestReader, opts), appOptions(opts, appName))
+		if err != nil {
+			return result, fmt.Errorf("Could not prepare the deployment of %s: %v", appName, err)
+		}
+		deployments = append(deployments, deployment)
+		result.Apps = append(result.Apps, Result{
+			AppName:         appName,
+			Strategy:        StrategyBlueGreen,
+			Routes:          deployment.NewAppRoutes,
+			ReplacedLiveApp: deployment.LiveAppName != "",
+		})
+	}
+
+	failed := []string{}
+	for i, deployment := range deployments {
+		fmt.Fprintf(p.Out, "Pushing %s, app %d of %d\n", deployment.AppName, i+1, len(deployments))
+		if passed, err := p.PushAndSmokeTest(deployment, deployment.ManifestScale); err != nil {
+			fmt.Fprintf(p.Out, "Could not push %s: %v\n", deployment.AppName, err)
+			result.Apps[i].Err = err
+		} else if !passed {
+			result.Apps[i].Err = ErrDeploymentFailed
+		}
+		if result.Apps[i].Err != nil {
+			failed = append(failed, deployment.AppName)
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(p.Out, "Not promoting any app of the group, since %v failed\n", failed)
+		return result, p.failGroup(deployments, result.Apps, failed)
+	}
+
+	if err := p.switchGroupRoutes(deployments); err != nil {
+		fmt.Fprintf(p.Out, "Switching the routes of the group failed, moving them back to the live apps: %v\n", err)
+		p.restoreGroupRoutes(deployments)
+		for i := range result.Apps {
+			result.Apps[i].Err = err
+		}
+		return result, p.failGroup(deployments, result.Apps, appNames)
+	}
+
+	if err := p.renameGroup(deployments); err != nil {
+		fmt.Fprintf(p.Out, "Renaming the apps of the group failed, moving the routes back to the live apps: %v\n", err)
+		p.restoreGroupRoutes(deployments)
+		for i := range result.Apps {
+			result.Apps[i].Err = err
+		}
+		return result, p.failGroup(deployments, result.Apps, appNames)
+	}
+
+	for _, deployment := range deployments {
+		// The routes were unmapped from the live apps while they were switched
+		if err := p.finishPromotion(deployment, nil); err != nil {
+			return result, err
+		}
+		p.recordDeployedRevision(deployment.AppName)
+		p.emit(Event{Type: EventPromoted, App: deployment.AppName})
+	}
+	return result, nil
+}
+
+// renameGroup gives the new version of every app of the group the app's name, keeping the live
+// versions as APP-old. If a rename fails, the renames done so far are undone, last first, so that
+// no app of the group is left promoted while another is not.
+func (p *Orchestrator) renameGroup(deployments []*Deployment) error {
+	type rename struct{ from, to string }
+	renames := []rename{}
+	for _, deployment := range deployments {
+		p.preparePromotionRenames(deployment)
+		if deployment.LiveAppName != "" {
+			renames = append(renames, rename{deployment.LiveAppName, deployment.AppName + "-old"})
+		}
+		renames = append(renames, rename{deployment.NewAppName, deployment.AppName})
+	}
+
+	for i, r := range renames {
+		if err := p.renameApp(r.from, r.to); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				if undoErr := p.renameApp(renames[j].to, renames[j].from); undoErr != nil {
+					fmt.Fprintf(p.Out, "Could not rename %s back to %s: %v\n", renames[j].to, renames[j].from, undoErr)
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// switchGroupRoutes maps the routes of every new version before unmapping any of them from the
+// live versions, so that no app of the group is only served by its new version while another
+// still is only served by its old one.
+func (p *Orchestrator) switchGroupRoutes(deployments []*Deployment) error {
+	for _, deployment := range deployments {
+		if err := p.mapRoutes(deployment.NewAppName, deployment.NewAppRoutes...); err != nil {
+			return err
+		}
+	}
+	for _, deployment := range deployments {
+		if deployment.LiveAppName != "" {
+			if err := p.unmapRoutes(deployment.LiveAppName, deployment.PromotedRoutes...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// restoreGroupRoutes gives the live versions their routes back and takes them off the new
+// versions. It carries on when a step fails, so that as much of the group as possible is restored.
+func (p *Orchestrator) restoreGroupRoutes(deployments []*Deployment) {
+	for _, deployment := range deployments {
+		if deployment.LiveAppName != "" {
+			if err := p.mapRoutes(deployment.LiveAppName, deployment.PromotedRoutes...); err != nil {
+				fmt.Fprintf(p.Out, "Could not move the routes of %s back: %v\n", deployment.LiveAppName, err)
+			}
+		}
+		if err := p.unmapRoutes(deployment.NewAppName, deployment.NewAppRoutes...); err != nil {
+			fmt.Fprintf(p.Out, "Could not unmap the routes of %s: %v\n", deployment.NewAppName, err)
+		}
+		p.emit(Event{Type: EventRolledBack, App: deployment.AppName, Message: "the routes of the group could not be switched"})
+	}
+}
+
+// failGroup marks the new version of every app of the group as failed, since none of them is
+// promoted, and returns the error naming the apps which failed.
+func (p *Orchestrator) failGroup(deployments []*Deployment, results []Result, failed []string) error {
+	for i, deployment := range deployments {
+		if err := p.FailDeployment(deployment); err != nil {
+			fmt.Fprintf(p.Out, "Could not mark %s as failed: %v\n", deployment.NewAppName, err)
+		}
+		if results[i].Err == nil {
+			results[i].Err = ErrGroupFailed
+		}
+		p.emit(Event{Type: EventFailed, App: deployment.AppName})
+	}
+	return &AppsFailedError{AppNames: failed}
+}
@@ -0,0 +1,150 @@
+package bluegreen_test
+
+import (
+	"bytes"
+
+	"code.cloudfoundry.org/cli/plugin/models"
+	. "github.com/bluemixgaragelondon/cf-blue-green-deploy/bluegreen"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Deploying a group of apps", func() {
+	var (
+		b      *BlueGreenDeployFake
+		p      Orchestrator
+		events []Event
+	)
+
+	manifestReader := &fakes.FakeManifestReader{Yaml: `---
+applications:
+- name: frontend
+  host: www
+- name: api
+  host: api
+`}
+	cfDomains := manifest.CfDomains{DefaultDomain: "example.com"}
+	liveRoute := plugin_models.GetApp_RouteSummary{Host: "live", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}}
+
+	BeforeEach(func() {
+		b = &BlueGreenDeployFake{
+			liveApp:       &plugin_models.GetAppModel{Name: "live-app", Routes: []plugin_models.GetApp_RouteSummary{liveRoute}},
+			passSmokeTest: true,
+		}
+		p = Orchestrator{Deployer: b, Out: &bytes.Buffer{}}
+
+		events = nil
+		p.AddObserver(ObserverFunc(func(event Event) {
+			events = append(events, event)
+		}))
+	})
+
+	deployGroup := func(argString string) (Result, error) {
+		return p.DeployGroup(cfDomains, manifestReader, mustParseArgs(bgdArgs(argString)))
+	}
+
+	It("pushes and smoke tests every app before switching any routes", func() {
+		result, err := deployGroup("frontend api --atomic --smoke-test script/smoke-test")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(b.flow).To(ContainElement("script/smoke-test api-new.example.com"))
+		Expect(indexOf(b.flow, "push api-new")).To(BeNumerically("<", indexOf(b.flow, "mapped 2 routes")))
+		Expect(indexOf(b.flow, "script/smoke-test api-new.example.com")).To(BeNumerically("<", indexOf(b.flow, "mapped 2 routes")))
+		Expect(b.flow).To(ContainElement("rename frontend-new to frontend"))
+		Expect(b.flow).To(ContainElement("rename api-new to api"))
+
+		Expect(result.Apps).To(HaveLen(2))
+		Expect(result.Apps[0].Err).NotTo(HaveOccurred())
+		Expect(eventTypesOf(events)).To(ContainElement(EventPromoted))
+	})
+
+	It("switches the routes of every app back to back", func() {
+		_, err := deployGroup("frontend api --atomic")
+		Expect(err).NotTo(HaveOccurred())
+
+		switched := b.flow[indexOf(b.flow, "mapped 2 routes"):]
+		Expect(switched[:4]).To(Equal([]string{
+			"mapped 2 routes",
+			"mapped 2 routes",
+			"unmap 1 routes from live-app",
+			"unmap 1 routes from live-app",
+		}))
+	})
+
+	It("promotes none of the apps when one fails its smoke tests", func() {
+		b.failingFQDNs = []string{"frontend-new.example.com"}
+
+		result, err := deployGroup("frontend api --atomic --smoke-test script/smoke-test")
+		Expect(err).To(MatchError("Deployment failed for frontend"))
+
+		Expect(b.flow).To(ContainElement("script/smoke-test api-new.example.com"))
+		Expect(b.flow).NotTo(ContainElement("mapped 2 routes"))
+		Expect(b.flow).To(ContainElement("rename frontend-new to frontend-failed"))
+		Expect(b.flow).To(ContainElement("rename api-new to api-failed"))
+		Expect(result.Apps[0].Err).To(MatchError(ErrDeploymentFailed))
+		Expect(result.Apps[1].Err).To(MatchError(ErrGroupFailed))
+	})
+
+	It("moves the routes of every app back when a switch fails", func() {
+		b.failingMapApp = "api-new"
+
+		result, err := deployGroup("frontend api --atomic")
+		Expect(err).To(MatchError("Deployment failed for frontend, api"))
+
+		Expect(b.flow).To(ContainElement("unmap 2 routes from frontend-new"))
+		Expect(b.flow).To(ContainElement("rename frontend-new to frontend-failed"))
+		Expect(b.flow).To(ContainElement("rename api-new to api-failed"))
+		Expect(b.flow).NotTo(ContainElement("rename frontend-new to frontend"))
+		Expect(b.mappedRoutes).To(ConsistOf(liveRoute))
+		Expect(result.Apps[0].Err).To(HaveOccurred())
+		Expect(eventTypesOf(events)).To(ContainElement(EventRolledBack))
+	})
+
+	It("undoes the renames and moves the routes of every app back when a rename fails", func() {
+		b.failingRenameTo = "api"
+
+		result, err := deployGroup("frontend api --atomic")
+		Expect(err).To(MatchError("Deployment failed for frontend, api"))
+
+		renamed := b.flow[indexOf(b.flow, "rename live-app to frontend-old"):]
+		Expect(renamed[:6]).To(Equal([]string{
+			"rename live-app to frontend-old",
+			"rename frontend-new to frontend",
+			"rename live-app to api-old",
+			"rename api-old to live-app",
+			"rename frontend to frontend-new",
+			"rename frontend-old to live-app",
+		}))
+		Expect(b.flow).To(ContainElement("unmap 2 routes from frontend-new"))
+		Expect(b.flow).To(ContainElement("rename frontend-new to frontend-failed"))
+		Expect(b.flow).To(ContainElement("rename api-new to api-failed"))
+		Expect(b.mappedRoutes).To(ConsistOf(liveRoute))
+		Expect(result.Apps[1].Err).To(MatchError("Could not rename app - failed"))
+		Expect(eventTypesOf(events)).NotTo(ContainElement(EventPromoted))
+	})
+
+	It("only switches routes the blue-green way", func() {
+		_, err := deployGroup("frontend api --atomic --strategy canary")
+		Expect(err).To(MatchError("Apps can only be promoted together with the blue-green strategy and without waves"))
+		Expect(b.flow).To(BeEmpty())
+	})
+})
+
+func indexOf(flow []string, step string) int {
+	for i, s := range flow {
+		if s == step {
+			return i
+		}
+	}
+	return -1
+}
+
+func eventTypesOf(events []Event) []EventType {
+	types := []EventType{}
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	return types
+}
@@ -0,0 +1,128 @@
+package bluegreen
+
+import (
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin/models"
+)
+
+const StrategyInstanceCanary = "instance-canary"
+
+// InstanceCanaryStrategy moves the traffic of the live routes to the new version by moving instances.
+type InstanceCanaryStrategy struct{}
+
+func (InstanceCanaryStrategy) Deploy(p *Orchestrator, deployment *Deployment) (bool, error) {
+	if deployment.LiveAppName == "" {
+		return BlueGreenStrategy{}.Deploy(p, deployment)
+	}
+
+	// The new app starts with a single instance next to the live app
+	pushScaleParameters := deployment.ManifestScale
+	pushScaleParameters.InstanceCount = 1
+	passed, err := p.PushAndSmokeTest(deployment, pushScaleParameters)
+	if err != nil {
+		return false, err
+	}
+	if !passed {
+		return false, p.FailDeployment(deployment)
+	}
+
+	promoted, err := p.promoteByInstances(deployment.LiveAppName, deployment.NewAppName, deployment.NewAppRoutes, deployment.ManifestScale,
+		deployment.Options.CanarySteps, deployment.SmokeTest, deployment.Options.CanaryPause)
+	if err != nil {
+		return false, err
+	}
+	if !promoted {
+		return false, p.FailDeployment(deployment)
+	}
+	return true, p.CompletePromotion(deployment, deployment.PromotedRoutes)
+}
+
+// promoteByInstances shares the routes between the live app and the new app, which starts with a
+// single instance, and moves the share of instances to the new app in steps. The split of the
+// traffic follows the split of the instances, so this works on foundations without weighted
+// routing. If a step cannot be scaled or fails verification, the live app is scaled back to its
+// original instance count, the routes are unmapped from the new app and false is returned.
+func (p *Orchestrator) promoteByInstances(liveAppName string, newAppName string, routes []plugin_models.GetApp_RouteSummary,
+	manifestScale ScaleParameters, steps []int, smokeTest SmokeTest, pause time.Duration) (bool, error) {
+
+	liveScale, err := p.Deployer.GetScaleParameters(liveAppName)
+	if err != nil {
+		fmt.Fprintf(p.Out, "Could not get the scale of %s: %v\n", liveAppName, err)
+		return false, p.unmapRoutes(newAppName, routes...)
+	}
+	liveInstances := instanceCountAtLeastOne(liveScale.InstanceCount)
+	targetInstances := instanceCountAtLeastOne(mergeScaleParameters(liveScale, manifestScale).InstanceCount)
+
+	if err := p.mapRoutes(newAppName, routes...); err != nil {
+		return false, err
+	}
+
+	for _, step := range steps {
+		if step >= 100 {
+			break
+		}
+
+		newInstances := instanceCountAtLeastOne((targetInstances*step + 99) / 100)
+		oldInstances := instanceCountAtLeastOne(liveInstances - liveInstances*step/100)
+		fmt.Fprintf(p.Out, "Scaling %s to %d and %s to %d instances\n", newAppName, newInstances, liveAppName, oldInstances)
+		err := p.Deployer.ScaleApp(newAppName, newInstances)
+		if err == nil {
+			err = p.Deployer.ScaleApp(liveAppName, oldInstances)
+		}
+		if err != nil {
+			fmt.Fprintf(p.Out, "Canary step of %d%% could not be scaled (%v), restoring %s to %d instances\n", step, err, liveAppName, liveInstances)
+			return false, p.restoreLiveInstances(liveAppName, newAppName, liveInstances, routes, fmt.Sprintf("canary step of %d%% could not be scaled", step))
+		}
+
+		passed, err := p.verifyWave(newAppName, smokeTest, routes)
+		if err != nil {
+			fmt.Fprintf(p.Out, "Canary step of %d%% could not be verified (%v), restoring %s to %d instances\n", step, err, liveAppName, liveInstances)
+			if restoreErr := p.restoreLiveInstances(liveAppName, newAppName, liveInstances, routes, fmt.Sprintf("canary step of %d%% could not be verified", step)); restoreErr != nil {
+				fmt.Fprintf(p.Out, "Could not unmap the routes of %s: %v\n", newAppName, restoreErr)
+			}
+			return false, err
+		}
+		if !passed {
+			fmt.Fprintf(p.Out, "Canary step of %d%% failed verification, restoring %s to %d instances\n", step, liveAppName, liveInstances)
+			return false, p.restoreLiveInstances(liveAppName, newAppName, liveInstances, routes, fmt.Sprintf("canary step of %d%% failed verification", step))
+		}
+
+		if pause > 0 {
+			fmt.Fprintf(p.Out, "Waiting %v before the next step\n", pause)
+			time.Sleep(pause)
+		}
+	}
+
+	fmt.Fprintf(p.Out, "Scaling %s to %d instances\n", newAppName, targetInstances)
+	if err := p.Deployer.ScaleApp(newAppName, targetInstances); err != nil {
+		fmt.Fprintf(p.Out, "Could not scale %s (%v), restoring %s to %d instances\n", newAppName, err, liveAppName, liveInstances)
+		return false, p.restoreLiveInstances(liveAppName, newAppName, liveInstances, routes, "the new app could not be scaled")
+	}
+	return true, nil
+}
+
+// restoreLiveInstances scales the live app back to the instance count it had before the first
+// step and sends all of the traffic of the routes back to it. A live app which cannot be scaled
+// back is only reported, since it still serves the routes; it returns an error when the routes
+// cannot be taken off the new app.
+func (p *Orchestrator) restoreLiveInstances(liveAppName string, newAppName string, liveInstances int,
+	routes []plugin_models.GetApp_RouteSummary, reason string) error {
+
+	if err := p.Deployer.ScaleApp(liveAppName, liveInstances); err != nil {
+		fmt.Fprintf(p.Out, "Could not restore %s to %d instances: %v\n", liveAppName, liveInstances, err)
+	}
+	if err := p.unmapRoutes(newAppName, routes...); err != nil {
+		return err
+	}
+	p.emit(Event{Type: EventRolledBack, App: liveAppName, Message: reason})
+	return nil
+}
+
+func instanceCountAtLeastOne(instanceCount int) int {
+	if instanceCount < 1 {
+		return 1
+	}
+	return instanceCount
+}
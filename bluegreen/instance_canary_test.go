@@ -0,0 +1,94 @@
+package bluegreen_test
+
+import (
+	"bytes"
+
+	"code.cloudfoundry.org/cli/plugin/models"
+	. "github.com/bluemixgaragelondon/cf-blue-green-deploy/bluegreen"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Instance canary strategy", func() {
+	var (
+		b *BlueGreenDeployFake
+		p Orchestrator
+	)
+
+	liveRoute := plugin_models.GetApp_RouteSummary{Host: "www", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}}
+
+	BeforeEach(func() {
+		b = &BlueGreenDeployFake{
+			liveApp: &plugin_models.GetAppModel{Name: "app-name",
+				Routes: []plugin_models.GetApp_RouteSummary{liveRoute}},
+			passSmokeTest: true,
+			scale:         &ScaleParameters{InstanceCount: 4},
+		}
+		p = Orchestrator{
+			Deployer: b,
+			Out:      &bytes.Buffer{},
+		}
+	})
+
+	deploy := func() bool {
+		args := []string{"bgd", "app-name", "--strategy", "instance-canary", "--canary-steps", "25,50,100", "--smoke-test", "smoke"}
+		_, err := p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{}, mustParseArgs(args))
+		return err == nil
+	}
+
+	It("pushes the new app with a single instance", func() {
+		deploy()
+
+		Expect(b.usedScale.InstanceCount).To(Equal(1))
+	})
+
+	It("moves instances from the live app to the new app in steps", func() {
+		Expect(deploy()).To(BeTrue())
+
+		Expect(b.flow[8:]).To(Equal([]string{
+			"mapped 1 routes",
+			"scale app-name-new to 1",
+			"scale app-name to 3",
+			"smoke www.example.com",
+			"scale app-name-new to 2",
+			"scale app-name to 2",
+			"smoke www.example.com",
+			"scale app-name-new to 4",
+			"rename app-name to app-name-old",
+			"rename app-name-new to app-name",
+			"unmap 1 routes from app-name-old",
+		}))
+	})
+
+	It("restores the live app when a step fails", func() {
+		b.failingFQDNs = []string{"www.example.com"}
+
+		Expect(deploy()).To(BeFalse())
+
+		Expect(b.flow[8:]).To(Equal([]string{
+			"mapped 1 routes",
+			"scale app-name-new to 1",
+			"scale app-name to 3",
+			"smoke www.example.com",
+			"scale app-name to 4",
+			"unmap 1 routes from app-name-new",
+			"rename app-name-new to app-name-failed",
+		}))
+	})
+
+	It("restores the live app when a step cannot be scaled", func() {
+		b.failingScale = 2
+
+		Expect(deploy()).To(BeFalse())
+
+		Expect(b.flow[8:]).To(Equal([]string{
+			"mapped 1 routes",
+			"scale app-name-new to 1",
+			"scale app-name to 4",
+			"unmap 1 routes from app-name-new",
+			"rename app-name-new to app-name-failed",
+		}))
+	})
+})
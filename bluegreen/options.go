@@ -0,0 +1,327 @@
+package bluegreen
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
+)
+
+// Options say what to deploy and how. DefaultOptions holds the defaults of the plugin's flags.
+type Options struct {
+	AppName        string
+	SmokeTestPath  string
+	ManifestPath   string
+	DeleteOldApps  bool
+	PruneRoutes    bool
+	ExcludedRoutes []string
+	Waves          []string
+	WavePause      time.Duration
+	Strategy       string
+	CanarySteps    []int
+	CanaryPause    time.Duration
+	RollingTimeout time.Duration
+	Rollback       bool
+	Revision       string
+	Retries        int
+	RetryDelay     time.Duration
+
+	// EventLog is a file the events of the deployment are written to as newline-delimited JSON.
+	EventLog string
+
+	// AppNames are all the apps named on the command line, of which AppName is the first. With
+	// several of them, or with All, every app of the manifest is deployed in turn.
+	AppNames []string
+	All      bool
+
+	// Atomic promotes several apps together, or none of them, see DeployGroup.
+	Atomic bool
+
+	// Parallel is how many apps which do not depend on each other are deployed at once.
+	Parallel int
+
+	// Manifests are the manifests of the apps given with --app APP=MANIFEST, by app name. The
+	// other apps are read from ManifestPath.
+	Manifests map[string]string
+
+	// Overlays are merged into the manifest in turn, such as the settings of an environment. They
+	// are given with --overlay, or with -f after the first.
+	Overlays []string
+
+	// VarsFiles and Vars fill in the ((variables)) of the manifests, and are passed on to cf push.
+	VarsFiles []string
+	Vars      map[string]string
+
+	// NoManifest deploys without reading a manifest. Otherwise a manifest which cannot be read
+	// stops the deployment before anything changes.
+	NoManifest bool
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Strategy:       StrategyBlueGreen,
+		CanarySteps:    []int{10, 50, 100},
+		RollingTimeout: 15 * time.Minute,
+		Retries:        3,
+		RetryDelay:     2 * time.Second,
+		Parallel:       1,
+	}
+}
+
+// ParseArgs reads the options from the command line of the plugin, such as
+// blue-green-deploy APP_NAME --smoke-test script.
+func ParseArgs(osArgs []string) (Options, error) {
+	opts := DefaultOptions()
+	opts.AppNames = extractAppNames(osArgs)
+	if len(opts.AppNames) > 0 {
+		opts.AppName = opts.AppNames[0]
+	}
+
+	// Only use FlagSet so that we can pass string slice to Parse
+	f := flag.NewFlagSet("blue-green-deploy", flag.ContinueOnError)
+	f.SetOutput(ioutil.Discard)
+
+	f.StringVar(&opts.SmokeTestPath, "smoke-test", opts.SmokeTestPath, "")
+	f.Var(&manifestPaths{opts: &opts}, "f", "")
+	f.Var((*stringSlice)(&opts.Overlays), "overlay", "")
+	f.BoolVar(&opts.DeleteOldApps, "delete-old-apps", opts.DeleteOldApps, "")
+	f.BoolVar(&opts.PruneRoutes, "prune-routes", opts.PruneRoutes, "")
+	f.Var((*stringSlice)(&opts.ExcludedRoutes), "exclude-route", "")
+	f.Var((*stringSlice)(&opts.Waves), "wave", "")
+	f.DurationVar(&opts.WavePause, "wave-pause", opts.WavePause, "")
+	f.StringVar(&opts.Strategy, "strategy", opts.Strategy, "")
+	f.Var((*percentageSteps)(&opts.CanarySteps), "canary-steps", "")
+	f.DurationVar(&opts.CanaryPause, "canary-pause", opts.CanaryPause, "")
+	f.DurationVar(&opts.RollingTimeout, "rolling-timeout", opts.RollingTimeout, "")
+	f.BoolVar(&opts.Rollback, "rollback", opts.Rollback, "")
+	f.StringVar(&opts.Revision, "revision", opts.Revision, "")
+	f.IntVar(&opts.Retries, "retries", opts.Retries, "")
+	f.DurationVar(&opts.RetryDelay, "retry-delay", opts.RetryDelay, "")
+	f.StringVar(&opts.EventLog, "event-log", opts.EventLog, "")
+	f.BoolVar(&opts.All, "all", opts.All, "")
+	f.BoolVar(&opts.Atomic, "atomic", opts.Atomic, "")
+	f.IntVar(&opts.Parallel, "parallel", opts.Parallel, "")
+	f.Var(&appManifests{opts: &opts}, "app", "")
+	f.Var((*stringSlice)(&opts.VarsFiles), "vars-file", "")
+	f.Var((*manifestVars)(&opts.Vars), "var", "")
+	f.BoolVar(&opts.NoManifest, "no-manifest", opts.NoManifest, "")
+
+	if err := f.Parse(extractBgdArgs(osArgs)); err != nil {
+		return opts, err
+	}
+	if opts.AppName == "" && len(opts.AppNames) > 0 {
+		opts.AppName = opts.AppNames[0]
+	}
+	if opts.Parallel < 1 {
+		return opts, fmt.Errorf("--parallel must be at least 1, not %d", opts.Parallel)
+	}
+	if opts.NoManifest && (opts.ManifestPath != "" || len(opts.Overlays) > 0 || len(opts.Manifests) > 0 || opts.All) {
+		return opts, errors.New("--no-manifest cannot be combined with -f, --overlay, --app or --all")
+	}
+	if opts.Strategy == StrategyRolling && (len(opts.Waves) > 0 || opts.PruneRoutes || len(opts.ExcludedRoutes) > 0) {
+		// A rolling deployment updates the live app in place, so its routes never move
+		return opts, errors.New("--wave, --prune-routes and --exclude-route cannot be used with --strategy rolling")
+	}
+	return opts, nil
+}
+
+// ParseValidateArgs reads the options from the command line of bgd-validate, which takes the
+// flags saying which manifests to read, such as bgd-validate -f manifest.yml --vars-file prod.yml.
+func ParseValidateArgs(osArgs []string) (Options, error) {
+	opts := DefaultOptions()
+
+	f := flag.NewFlagSet("bgd-validate", flag.ContinueOnError)
+	f.SetOutput(ioutil.Discard)
+
+	f.Var(&manifestPaths{opts: &opts}, "f", "")
+	f.Var((*stringSlice)(&opts.Overlays), "overlay", "")
+	f.Var(&appManifests{opts: &opts}, "app", "")
+	f.Var((*stringSlice)(&opts.VarsFiles), "vars-file", "")
+	f.Var((*manifestVars)(&opts.Vars), "var", "")
+
+	args := osArgs
+	for i, arg := range osArgs {
+		if arg == "bgd-validate" {
+			args = osArgs[i+1:]
+			break
+		}
+	}
+	if err := f.Parse(args); err != nil {
+		return opts, err
+	}
+	if f.NArg() > 0 {
+		return opts, fmt.Errorf("Unexpected argument %s", f.Arg(0))
+	}
+	return opts, nil
+}
+
+func indexOfAppName(osArgs []string) int {
+	index := 0
+	for i, arg := range osArgs {
+		if arg == "blue-green-deploy" || arg == "bgd" {
+			index = i + 1
+			break
+		}
+	}
+	if len(osArgs) > index {
+		return index
+	}
+	return -1
+}
+
+// extractAppNames returns the app names, which come before the flags.
+func extractAppNames(osArgs []string) []string {
+	appNames := []string{}
+	index := indexOfAppName(osArgs)
+	if index < 0 {
+		return appNames
+	}
+	for _, arg := range osArgs[index:] {
+		if strings.HasPrefix(arg, "-") {
+			break
+		}
+		appNames = append(appNames, arg)
+	}
+	return appNames
+}
+
+func extractBgdArgs(osArgs []string) []string {
+	index := indexOfAppName(osArgs)
+	if index < 0 {
+		return []string{}
+	}
+	return osArgs[index+len(extractAppNames(osArgs)):]
+}
+
+// stringSlice is a flag value which can be given multiple times.
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// appManifests is a flag value naming an app to deploy together with its manifest, as
+// APP=MANIFEST. It can be given multiple times.
+type appManifests struct {
+	opts *Options
+}
+
+func (a *appManifests) String() string {
+	if a.opts == nil {
+		return ""
+	}
+	pairs := []string{}
+	for _, appName := range a.opts.AppNames {
+		if manifestPath, ok := a.opts.Manifests[appName]; ok {
+			pairs = append(pairs, appName+"="+manifestPath)
+		}
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (a *appManifests) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("%q is not of the form APP=MANIFEST", value)
+	}
+	appName, manifestPath := parts[0], parts[1]
+	for _, name := range a.opts.AppNames {
+		if name == appName {
+			return fmt.Errorf("app %s is given more than once", appName)
+		}
+	}
+	if a.opts.Manifests == nil {
+		a.opts.Manifests = map[string]string{}
+	}
+	a.opts.AppNames = append(a.opts.AppNames, appName)
+	a.opts.Manifests[appName] = manifestPath
+	return nil
+}
+
+// manifestPaths is a flag value naming the manifest the first time it is given, and an overlay
+// every time after that.
+type manifestPaths struct {
+	opts *Options
+}
+
+func (m *manifestPaths) String() string {
+	if m.opts == nil {
+		return ""
+	}
+	return strings.Join(append([]string{m.opts.ManifestPath}, m.opts.Overlays...), ",")
+}
+
+func (m *manifestPaths) Set(value string) error {
+	if m.opts.ManifestPath == "" {
+		m.opts.ManifestPath = value
+	} else {
+		m.opts.Overlays = append(m.opts.Overlays, value)
+	}
+	return nil
+}
+
+// manifestVars is a flag value setting a variable of the manifest as NAME=VALUE. It can be given
+// multiple times.
+type manifestVars map[string]string
+
+func (v *manifestVars) String() string {
+	assignments := []string{}
+	for name, value := range *v {
+		assignments = append(assignments, name+"="+value)
+	}
+	sort.Strings(assignments)
+	return strings.Join(assignments, ",")
+}
+
+func (v *manifestVars) Set(value string) error {
+	name, varValue, err := manifest.ParseVar(value)
+	if err != nil {
+		return err
+	}
+	if *v == nil {
+		*v = manifestVars{}
+	}
+	(*v)[name] = varValue
+	return nil
+}
+
+// percentageSteps is a flag value holding a comma separated, increasing list of percentages.
+type percentageSteps []int
+
+func (s *percentageSteps) String() string {
+	steps := []string{}
+	for _, step := range *s {
+		steps = append(steps, strconv.Itoa(step))
+	}
+	return strings.Join(steps, ",")
+}
+
+func (s *percentageSteps) Set(value string) error {
+	steps := []int{}
+	for _, stepString := range strings.Split(value, ",") {
+		step, err := strconv.Atoi(strings.TrimSpace(stepString))
+		if err != nil {
+			return err
+		}
+		if step < 1 || step > 100 {
+			return fmt.Errorf("%d is not a percentage between 1 and 100", step)
+		}
+		if len(steps) > 0 && step <= steps[len(steps)-1] {
+			return fmt.Errorf("steps must increase, but %d follows %d", step, steps[len(steps)-1])
+		}
+		steps = append(steps, step)
+	}
+	*s = steps
+	return nil
+}
@@ -0,0 +1,337 @@
+package bluegreen_test
+
+import (
+	. "github.com/bluemixgaragelondon/cf-blue-green-deploy/bluegreen"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"strings"
+	"time"
+)
+
+var _ = Describe("Options", func() {
+	Context("With an appname only", func() {
+		args := mustParseArgs(bgdArgs("appname"))
+
+		It("sets the app name", func() {
+			Expect(args.AppName).To(Equal("appname"))
+		})
+
+		It("does not set the smoke test file", func() {
+			Expect(args.SmokeTestPath).To(BeZero())
+		})
+
+		It("does not set a manifest", func() {
+			Expect(args.ManifestPath).To(BeZero())
+		})
+
+		It("does not delete old app instances", func() {
+			Expect(args.DeleteOldApps).To(BeFalse())
+		})
+	})
+
+	Context("With a smoke test and an appname", func() {
+		args := mustParseArgs(bgdArgs("appname --smoke-test script/smoke-test"))
+
+		It("sets the smoke test file", func() {
+			Expect(args.SmokeTestPath).To(Equal("script/smoke-test"))
+		})
+
+		It("sets the app name", func() {
+			Expect(args.AppName).To(Equal("appname"))
+		})
+
+		It("does not set a manifest", func() {
+			Expect(args.ManifestPath).To(BeZero())
+		})
+
+		It("does not delete old app instances", func() {
+			Expect(args.DeleteOldApps).To(BeFalse())
+		})
+	})
+
+	Context("With an appname smoke test and a manifest", func() {
+		args := mustParseArgs(bgdArgs("appname --smoke-test smokey -f custommanifest.yml"))
+
+		It("sets the smoke test file", func() {
+			Expect(args.SmokeTestPath).To(Equal("smokey"))
+		})
+
+		It("sets the app name", func() {
+			Expect(args.AppName).To(Equal("appname"))
+		})
+
+		It("sets a manifest", func() {
+			Expect(args.ManifestPath).To(Equal("custommanifest.yml"))
+		})
+
+		It("does not delete old app instances", func() {
+			Expect(args.DeleteOldApps).To(BeFalse())
+		})
+	})
+
+	Context("With an appname and a manifest", func() {
+		args := mustParseArgs(bgdArgs("appname -f custommanifest.yml"))
+
+		It("sets the app name", func() {
+			Expect(args.AppName).To(Equal("appname"))
+		})
+
+		It("sets a manifest", func() {
+			Expect(args.ManifestPath).To(Equal("custommanifest.yml"))
+		})
+
+		It("does not delete old app instances", func() {
+			Expect(args.DeleteOldApps).To(BeFalse())
+		})
+	})
+
+	Context("When a global cf flag is set with an app name", func() {
+		args := mustParseArgs([]string{"cf", "-v", "blue-green-deploy", "app"})
+
+		It("sets the app name", func() {
+			Expect(args.AppName).To(Equal("app"))
+		})
+	})
+
+	Context("When the bgd abbreviation is used", func() {
+		args := mustParseArgs([]string{"cf", "bgd", "app"})
+
+		It("sets the app name", func() {
+			Expect(args.AppName).To(Equal("app"))
+		})
+	})
+
+	Context("With an appname and a manifest and the delete-old-apps flag", func() {
+		args := mustParseArgs(bgdArgs("appname -f custommanifest.yml --delete-old-apps"))
+
+		It("sets the app name", func() {
+			Expect(args.AppName).To(Equal("appname"))
+		})
+
+		It("sets a manifest", func() {
+			Expect(args.ManifestPath).To(Equal("custommanifest.yml"))
+		})
+
+		It("deletes old app instances", func() {
+			Expect(args.DeleteOldApps).To(BeTrue())
+		})
+
+		It("does not prune routes", func() {
+			Expect(args.PruneRoutes).To(BeFalse())
+		})
+	})
+
+	Context("With an appname and several excluded routes", func() {
+		args := mustParseArgs(bgdArgs("appname --exclude-route admin.example.com --exclude-route www.example.com/pinned"))
+
+		It("sets the app name", func() {
+			Expect(args.AppName).To(Equal("appname"))
+		})
+
+		It("keeps every excluded route", func() {
+			Expect(args.ExcludedRoutes).To(Equal([]string{"admin.example.com", "www.example.com/pinned"}))
+		})
+	})
+
+	Context("With an appname and waves", func() {
+		args := mustParseArgs(bgdArgs("appname --wave apps.internal --wave example.com,example.org --wave-pause 30s"))
+
+		It("keeps the waves in order", func() {
+			Expect(args.Waves).To(Equal([]string{"apps.internal", "example.com,example.org"}))
+		})
+
+		It("sets the pause between waves", func() {
+			Expect(args.WavePause).To(Equal(30 * time.Second))
+		})
+	})
+
+	Context("With an appname and the canary strategy", func() {
+		args := mustParseArgs(bgdArgs("appname --strategy canary --canary-steps 5,25,50,100 --canary-pause 1m"))
+
+		It("sets the strategy", func() {
+			Expect(args.Strategy).To(Equal(StrategyCanary))
+		})
+
+		It("sets the canary steps", func() {
+			Expect(args.CanarySteps).To(Equal([]int{5, 25, 50, 100}))
+		})
+
+		It("sets the pause between steps", func() {
+			Expect(args.CanaryPause).To(Equal(time.Minute))
+		})
+	})
+
+	Context("With an appname only, the deployment strategy", func() {
+		args := mustParseArgs(bgdArgs("appname"))
+
+		It("is blue-green", func() {
+			Expect(args.Strategy).To(Equal(StrategyBlueGreen))
+		})
+
+		It("has default canary steps", func() {
+			Expect(args.CanarySteps).To(Equal([]int{10, 50, 100}))
+		})
+	})
+
+	Context("With an appname and the prune-routes flag", func() {
+		args := mustParseArgs(bgdArgs("appname --prune-routes"))
+
+		It("sets the app name", func() {
+			Expect(args.AppName).To(Equal("appname"))
+		})
+
+		It("prunes routes", func() {
+			Expect(args.PruneRoutes).To(BeTrue())
+		})
+	})
+
+	Context("With an appname only, retries", func() {
+		args := mustParseArgs(bgdArgs("appname"))
+
+		It("are made three times, starting after two seconds", func() {
+			Expect(args.Retries).To(Equal(3))
+			Expect(args.RetryDelay).To(Equal(2 * time.Second))
+		})
+	})
+
+	Context("With an appname and the retry flags", func() {
+		args := mustParseArgs(bgdArgs("appname --retries 5 --retry-delay 500ms"))
+
+		It("sets the retries", func() {
+			Expect(args.Retries).To(Equal(5))
+			Expect(args.RetryDelay).To(Equal(500 * time.Millisecond))
+		})
+	})
+
+	Context("With an appname and an event log", func() {
+		args := mustParseArgs(bgdArgs("appname --event-log events.json"))
+
+		It("sets the event log", func() {
+			Expect(args.EventLog).To(Equal("events.json"))
+		})
+	})
+
+	Context("With several app names", func() {
+		args := mustParseArgs(bgdArgs("frontend api --smoke-test script/smoke-test"))
+
+		It("sets all of the app names", func() {
+			Expect(args.AppName).To(Equal("frontend"))
+			Expect(args.AppNames).To(Equal([]string{"frontend", "api"}))
+		})
+
+		It("parses the flags after the app names", func() {
+			Expect(args.SmokeTestPath).To(Equal("script/smoke-test"))
+		})
+	})
+
+	Context("With --all", func() {
+		args := mustParseArgs(bgdArgs("--all --delete-old-apps"))
+
+		It("deploys all apps without an app name", func() {
+			Expect(args.All).To(BeTrue())
+			Expect(args.AppName).To(BeEmpty())
+			Expect(args.AppNames).To(BeEmpty())
+			Expect(args.DeleteOldApps).To(BeTrue())
+		})
+	})
+
+	Context("With overlays", func() {
+		args := mustParseArgs(bgdArgs("appname -f manifest.yml -f prod.yml --overlay eu.yml"))
+
+		It("takes the first -f as the manifest and the others as overlays, in order", func() {
+			Expect(args.ManifestPath).To(Equal("manifest.yml"))
+			Expect(args.Overlays).To(Equal([]string{"prod.yml", "eu.yml"}))
+		})
+	})
+
+	Context("With variables for the manifest", func() {
+		args := mustParseArgs(bgdArgs("appname --vars-file vars/common.yml --vars-file vars/prod.yml --var host=www --var memory=1G"))
+
+		It("sets the vars files in order and the variables", func() {
+			Expect(args.VarsFiles).To(Equal([]string{"vars/common.yml", "vars/prod.yml"}))
+			Expect(args.Vars).To(Equal(map[string]string{"host": "www", "memory": "1G"}))
+		})
+	})
+
+	Context("Without a manifest", func() {
+		args := mustParseArgs(bgdArgs("appname --no-manifest"))
+
+		It("deploys without reading one", func() {
+			Expect(args.NoManifest).To(BeTrue())
+		})
+	})
+
+	Context("With apps given with their manifests", func() {
+		args := mustParseArgs(bgdArgs("--app api=api/manifest.yml --app worker=worker/manifest.yml --parallel 4"))
+
+		It("deploys the apps with their own manifests", func() {
+			Expect(args.AppName).To(Equal("api"))
+			Expect(args.AppNames).To(Equal([]string{"api", "worker"}))
+			Expect(args.Manifests).To(Equal(map[string]string{"api": "api/manifest.yml", "worker": "worker/manifest.yml"}))
+			Expect(args.Parallel).To(Equal(4))
+		})
+	})
+})
+
+var _ = Describe("ParseArgs", func() {
+	It("returns invalid flags as an error", func() {
+		_, err := ParseArgs(bgdArgs("appname --canary-steps 50,10"))
+		Expect(err).To(MatchError(ContainSubstring("steps must increase, but 10 follows 50")))
+	})
+
+	It("needs apps to be given with their manifest as APP=MANIFEST", func() {
+		_, err := ParseArgs(bgdArgs("--app api"))
+		Expect(err).To(MatchError(ContainSubstring(`"api" is not of the form APP=MANIFEST`)))
+	})
+
+	It("needs variables to be given as NAME=VALUE", func() {
+		_, err := ParseArgs(bgdArgs("appname --var host"))
+		Expect(err).To(MatchError(ContainSubstring(`"host" is not of the form NAME=VALUE`)))
+	})
+
+	It("does not read a manifest with --no-manifest while naming one", func() {
+		_, err := ParseArgs(bgdArgs("appname --no-manifest -f manifest.yml"))
+		Expect(err).To(MatchError("--no-manifest cannot be combined with -f, --overlay, --app or --all"))
+	})
+
+	It("does not move routes with the rolling strategy", func() {
+		for _, flags := range []string{"--wave example.com", "--prune-routes", "--exclude-route admin.example.com"} {
+			_, err := ParseArgs(bgdArgs("appname --strategy rolling " + flags))
+			Expect(err).To(MatchError("--wave, --prune-routes and --exclude-route cannot be used with --strategy rolling"))
+		}
+	})
+
+	It("does not take an app twice", func() {
+		_, err := ParseArgs(bgdArgs("api --app api=api/manifest.yml"))
+		Expect(err).To(MatchError(ContainSubstring("app api is given more than once")))
+	})
+
+	It("returns unknown flags as an error", func() {
+		_, err := ParseArgs(bgdArgs("appname --no-such-flag"))
+		Expect(err).To(MatchError(ContainSubstring("no-such-flag")))
+	})
+
+	It("starts from the default options", func() {
+		opts, err := ParseArgs(bgdArgs("appname"))
+		Expect(err).NotTo(HaveOccurred())
+
+		expected := DefaultOptions()
+		expected.AppName = "appname"
+		expected.AppNames = []string{"appname"}
+		Expect(opts).To(Equal(expected))
+	})
+})
+
+// mustParseArgs parses the arguments of a test, which are known to be valid.
+func mustParseArgs(osArgs []string) Options {
+	opts, err := ParseArgs(osArgs)
+	if err != nil {
+		panic(err)
+	}
+	return opts
+}
+
+func bgdArgs(argString string) []string {
+	args := strings.Split(argString, " ")
+	return append([]string{"blue-green-deploy"}, args...)
+}
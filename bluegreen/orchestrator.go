@@ -0,0 +1,445 @@
+package bluegreen
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/cli/plugin"
+	"code.cloudfoundry.org/cli/plugin/models"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
+)
+
+// Orchestrator runs deployments and rollbacks of apps, built from the steps of its Deployer.
+type Orchestrator struct {
+	Connection plugin.CliConnection
+	Deployer   BlueGreenDeployer
+	Out        io.Writer
+
+	// Retry says how often the requests for the domains of the org are retried, like the
+	// BlueGreenDeploy does for the cf commands.
+	Retry RetryPolicy
+
+	// Observers are told about every step of the deployments, see AddObserver.
+	Observers []Observer
+
+	// emitting keeps apps deployed in parallel from telling the observers at the same time.
+	emitting sync.Mutex
+}
+
+// New returns an Orchestrator which deploys through connection and reports its progress to out.
+func New(connection plugin.CliConnection, out io.Writer) *Orchestrator {
+	if out == nil {
+		out = ioutil.Discard
+	}
+	// Apps deployed in parallel report their progress at the same time
+	out = &lockedWriter{out: out}
+	return &Orchestrator{
+		Connection: connection,
+		Deployer:   &BlueGreenDeploy{Connection: connection, Out: out},
+		Out:        out,
+	}
+}
+
+// Result describes what a deployment or rollback did.
+type Result struct {
+	AppName  string
+	Strategy string
+
+	// Routes are the routes of the new version.
+	Routes []plugin_models.GetApp_RouteSummary
+
+	// ReplacedLiveApp is set when the app was live before, and not deployed for the first time.
+	ReplacedLiveApp bool
+
+	// RevisionGuid is the revision a rollback moved the app to, or DropletGuid the droplet when
+	// it went back to the version a blue-green deploy replaced.
+	RevisionGuid string
+	DropletGuid  string
+
+	// Apps are the results of every app of a deployment of several apps, in the order they were
+	// deployed. Err says why an app failed.
+	Apps []Result
+	Err  error
+}
+
+// Run deploys the app, or rolls it back with Options.Rollback, in the org and space targeted by
+// the connection.
+func (p *Orchestrator) Run(opts Options) (result Result, err error) {
+	if opts.All && len(opts.AppNames) > 0 {
+		return Result{}, errors.New("Either name the apps or deploy --all of them, not both.")
+	}
+	if opts.AppName == "" && !opts.All {
+		return Result{}, errors.New("App name was empty, must be provided.")
+	}
+	deployApps := opts.All || len(opts.AppNames) > 1
+	if deployApps && opts.Rollback {
+		return Result{}, errors.New("Only one app can be rolled back at a time.")
+	}
+
+	p.Retry = RetryPolicy{Retries: opts.Retries, InitialDelay: opts.RetryDelay}
+	cfDomains, err := p.DiscoverDomains()
+	if err != nil {
+		return Result{AppName: opts.AppName}, err
+	}
+
+	p.Deployer.Setup(p.Connection)
+	if deployer, ok := p.Deployer.(*BlueGreenDeploy); ok {
+		deployer.Retry = p.Retry
+		deployer.VarsFiles = opts.VarsFiles
+		deployer.Vars = opts.Vars
+		deployer.NoManifest = opts.NoManifest
+	}
+
+	if opts.EventLog != "" {
+		eventLog, err := os.Create(opts.EventLog)
+		if err != nil {
+			return Result{AppName: opts.AppName}, fmt.Errorf("Could not create the event log: %v", err)
+		}
+		defer eventLog.Close()
+
+		observers := p.Observers
+		defer func() { p.Observers = observers }()
+		p.Observers = append(append([]Observer{}, observers...), JSONObserver{Out: eventLog})
+	}
+
+	if opts.Rollback {
+		return p.Rollback(opts)
+	}
+
+	// The manifests are read in full before anything changes, so that a manifest which cannot be
+	// read or has problems stops the deployment
+	if !opts.NoManifest {
+		problems, err := validateManifests(cfDomains, opts)
+		if err != nil {
+			return Result{AppName: opts.AppName}, fmt.Errorf("Could not read the manifest: %v", err)
+		}
+		if len(problems) > 0 {
+			return Result{AppName: opts.AppName}, &manifest.ValidationError{Problems: problems}
+		}
+	}
+
+	if len(opts.Overlays) > 0 {
+		// cf push is given the merged manifest, which the deployment reads as well
+		mergedPath, err := manifestReaderOf(opts).Generate()
+		if err != nil {
+			return Result{AppName: opts.AppName}, fmt.Errorf("Could not merge the manifests: %v", err)
+		}
+		defer os.Remove(mergedPath)
+		opts.ManifestPath, opts.Overlays = mergedPath, nil
+	}
+
+	if !deployApps {
+		opts = appOptions(opts, opts.AppName)
+		return p.Deploy(cfDomains, manifestReaderOf(opts), opts)
+	}
+
+	var manifestReader manifest.ManifestReader = manifestReaderOf(opts)
+	if opts.NoManifest {
+		manifestReader = noManifest{}
+	}
+	if opts.Atomic {
+		result, err = p.DeployGroup(cfDomains, manifestReader, opts)
+	} else {
+		result, err = p.DeployApps(cfDomains, manifestReader, opts)
+	}
+	if len(result.Apps) > 0 {
+		fmt.Fprintln(p.Out)
+		result.WriteSummary(p.Out)
+	}
+	return result, err
+}
+
+// Deploy pushes a new version of the app and moves its traffic over with the chosen strategy.
+// It returns ErrDeploymentFailed when the new version was not promoted.
+func (p *Orchestrator) Deploy(cfDomains manifest.CfDomains, manifestReader manifest.ManifestReader, opts Options) (Result, error) {
+	result := Result{AppName: opts.AppName, Strategy: opts.Strategy}
+	if result.Strategy == "" {
+		result.Strategy = StrategyBlueGreen
+	}
+
+	strategy, err := LookupStrategy(opts.Strategy)
+	if err != nil {
+		return result, err
+	}
+
+	deployment, err := p.PrepareDeployment(cfDomains, manifestReader, opts)
+	if err != nil {
+		return result, err
+	}
+	result.Routes = deployment.NewAppRoutes
+	result.ReplacedLiveApp = deployment.LiveAppName != ""
+
+	promoted, err := strategy.Deploy(p, deployment)
+	if err != nil {
+		return result, err
+	}
+	if !promoted {
+		p.emit(Event{Type: EventFailed, App: opts.AppName})
+		return result, ErrDeploymentFailed
+	}
+
+	p.recordDeployedRevision(opts.AppName)
+	p.emit(Event{Type: EventPromoted, App: opts.AppName})
+	return result, nil
+}
+
+// PrepareDeployment clears away versions left by earlier deploys and works out what the new
+// version of the app should look like, before any strategy starts pushing it.
+func (p *Orchestrator) PrepareDeployment(cfDomains manifest.CfDomains, manifestReader manifest.ManifestReader, opts Options) (*Deployment, error) {
+	appName := opts.AppName
+
+	if opts.NoManifest {
+		manifestReader = noManifest{}
+	}
+
+	// Everything the manifest says is worked out before the first change to the space, so that a
+	// manifest which cannot be read stops the deployment
+	excludedRoutes, err := p.GetExcludedRoutes(appName, cfDomains, manifestReader, opts.ExcludedRoutes)
+	if err != nil {
+		return nil, fmt.Errorf("Could not work out the excluded routes: %v", err)
+	}
+
+	smokeTest, err := p.GetSmokeTest(appName, cfDomains, manifestReader, opts)
+	if err != nil {
+		return nil, fmt.Errorf("Could not work out the smoke test: %v", err)
+	}
+
+	manifestScaleParameters, err := p.GetScaleFromManifest(appName, cfDomains, manifestReader)
+	if err != nil {
+		return nil, fmt.Errorf("Could not work out the scale of the app: %v", err)
+	}
+
+	if err := p.deleteOldVersions(appName, excludedRoutes...); err != nil {
+		return nil, err
+	}
+	liveAppName, liveAppRoutes := p.Deployer.LiveApp(appName)
+
+	// Excluded routes stay with the old version, so they are neither moved to the new app nor unmapped from the old one
+	promotedRoutes := p.SubtractRouteList(liveAppRoutes, excludedRoutes)
+
+	// TODO We're overloading 'new' here for both the staging app and the 'finished' app, which is confusing
+	newAppRoutes, err := p.GetNewAppRoutes(opts.AppName, cfDomains, manifestReader, promotedRoutes, opts.PruneRoutes)
+	if err != nil {
+		return nil, fmt.Errorf("Could not work out the routes of the app: %v", err)
+	}
+	newAppRoutes = p.SubtractRouteList(newAppRoutes, excludedRoutes)
+
+	return &Deployment{
+		AppName:        appName,
+		NewAppName:     appName + "-new",
+		LiveAppName:    liveAppName,
+		LiveAppRoutes:  liveAppRoutes,
+		PromotedRoutes: promotedRoutes,
+		ExcludedRoutes: excludedRoutes,
+		NewAppRoutes:   newAppRoutes,
+		ManifestScale:  manifestScaleParameters,
+		SmokeTest:      smokeTest,
+		CfDomains:      cfDomains,
+		Options:        opts,
+	}, nil
+}
+
+// GetExcludedRoutes returns the routes given with --exclude-route together with those listed under
+// x-bgd-exclude-routes in the manifest. These routes are left on the old version of the app.
+func (p *Orchestrator) GetExcludedRoutes(appName string, cfDomains manifest.CfDomains, manifestReader manifest.ManifestReader, excludedRouteArgs []string) ([]plugin_models.GetApp_RouteSummary, error) {
+	excludedRoutes := []plugin_models.GetApp_RouteSummary{}
+	for _, routeArg := range excludedRouteArgs {
+		route, err := manifest.ParseRoute(cfDomains, routeArg)
+		if err != nil {
+			return nil, err
+		}
+		excludedRoutes = append(excludedRoutes, route)
+	}
+
+	parsedManifest, err := manifestReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the manifest: %v", err)
+	}
+	if parsedManifest == nil {
+		return excludedRoutes, nil
+	}
+
+	pluginParams, err := parsedManifest.GetPluginParams(appName, cfDomains)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.UnionRouteLists(excludedRoutes, pluginParams.ExcludedRoutes), nil
+}
+
+func (p *Orchestrator) reportExcludedRoutes(oldAppName string, excludedRoutes []plugin_models.GetApp_RouteSummary) {
+	if len(excludedRoutes) == 0 {
+		return
+	}
+
+	fmt.Fprintf(p.Out, "The following routes are excluded and stay mapped to %s:\n", oldAppName)
+	for _, route := range excludedRoutes {
+		fmt.Fprintf(p.Out, "  %s\n", RouteURL(route))
+	}
+}
+
+// GetNewAppRoutes works out the routes of the new version of the app: those of the manifest
+// together with the live ones, or with pruneRoutes only those of the manifest. Without any, the
+// app gets a route on the default domain.
+func (p *Orchestrator) GetNewAppRoutes(appName string, cfDomains manifest.CfDomains, manifestReader manifest.ManifestReader, liveAppRoutes []plugin_models.GetApp_RouteSummary, pruneRoutes bool) ([]plugin_models.GetApp_RouteSummary, error) {
+	newAppRoutes := []plugin_models.GetApp_RouteSummary{}
+
+	parsedManifest, err := manifestReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the manifest: %v", err)
+	}
+
+	if parsedManifest != nil {
+		appParams, err := parsedManifest.AppParams(appName, cfDomains)
+		if err != nil {
+			return nil, err
+		}
+		if appParams != nil && appParams.Routes != nil {
+			newAppRoutes = appParams.Routes
+		}
+	}
+
+	defaultRoute := plugin_models.GetApp_RouteSummary{Host: appName, Domain: plugin_models.GetApp_DomainFields{Name: cfDomains.DefaultDomain}}
+
+	// Only prune when we actually have a manifest to treat as the source of truth,
+	// otherwise deploying with --no-manifest would drop every live route.
+	if pruneRoutes && parsedManifest != nil {
+		if len(newAppRoutes) == 0 {
+			newAppRoutes = append(newAppRoutes, defaultRoute)
+		}
+		p.reportPrunedRoutes(appName, p.SubtractRouteList(liveAppRoutes, newAppRoutes))
+		return p.UnionRouteLists(newAppRoutes, nil), nil
+	}
+
+	uniqueRoutes := p.UnionRouteLists(newAppRoutes, liveAppRoutes)
+
+	if len(uniqueRoutes) == 0 {
+		uniqueRoutes = append(uniqueRoutes, defaultRoute)
+	}
+	return uniqueRoutes, nil
+}
+
+func (p *Orchestrator) reportPrunedRoutes(appName string, prunedRoutes []plugin_models.GetApp_RouteSummary) {
+	if len(prunedRoutes) == 0 {
+		return
+	}
+
+	fmt.Fprintf(p.Out, "The following routes of %s are not in the manifest and will not be mapped to the new version:\n", appName)
+	for _, route := range prunedRoutes {
+		fmt.Fprintf(p.Out, "  %s\n", RouteURL(route))
+	}
+}
+
+// GetScaleFromManifest reads the memory, disk quota and instances of the app from the manifest.
+// Those it does not set are zero, and are taken from the live app when pushing.
+func (p *Orchestrator) GetScaleFromManifest(appName string, cfDomains manifest.CfDomains,
+	manifestReader manifest.ManifestReader) (ScaleParameters, error) {
+	parsedManifest, err := manifestReader.Read()
+	if err != nil {
+		return ScaleParameters{}, fmt.Errorf("Could not read the manifest: %v", err)
+	}
+	if parsedManifest == nil {
+		return ScaleParameters{}, nil
+	}
+
+	manifestScaleParameters, err := parsedManifest.AppParams(appName, cfDomains)
+	if err != nil || manifestScaleParameters == nil {
+		return ScaleParameters{}, err
+	}
+	return ScaleParameters{
+		Memory:        manifestScaleParameters.Memory,
+		InstanceCount: manifestScaleParameters.InstanceCount,
+		DiskQuota:     manifestScaleParameters.DiskQuota,
+	}, nil
+}
+
+
+func (p *Orchestrator) contains(list []plugin_models.GetApp_RouteSummary, value plugin_models.GetApp_RouteSummary) bool {
+	for _, v := range list {
+		if SameRoute(v, value) {
+			return true;
+		}
+	}
+	return false;
+}
+
+func (p *Orchestrator) UnionRouteLists(listA []plugin_models.GetApp_RouteSummary, listB []plugin_models.GetApp_RouteSummary) []plugin_models.GetApp_RouteSummary {
+	duplicateList := append(listA, listB...)
+
+	uniqueRoutes := []plugin_models.GetApp_RouteSummary{}
+	for _, route := range duplicateList {
+		if (! p.contains(uniqueRoutes, route)) {
+			uniqueRoutes = append(uniqueRoutes, route)
+		}
+	}
+
+	return uniqueRoutes
+}
+
+// SubtractRouteList returns the routes in listA which are not in listB.
+func (p *Orchestrator) SubtractRouteList(listA []plugin_models.GetApp_RouteSummary, listB []plugin_models.GetApp_RouteSummary) []plugin_models.GetApp_RouteSummary {
+	remainingRoutes := []plugin_models.GetApp_RouteSummary{}
+	for _, route := range listA {
+		if !p.contains(listB, route) {
+			remainingRoutes = append(remainingRoutes, route)
+		}
+	}
+
+	return remainingRoutes
+}
+
+// PrivateDomains lists the private domains the targeted org owns or has been shared with.
+// Without a targeted org, all private domains visible to the user are listed.
+func (p *Orchestrator) PrivateDomains() (domains []string, apiErr error) {
+	path := "/v2/private_domains"
+
+	org, err := p.Connection.GetCurrentOrg()
+	if err != nil {
+		return nil, err
+	}
+	if org.Guid != "" {
+		path = fmt.Sprintf("/v2/organizations/%s/private_domains", org.Guid)
+	}
+	return p.listCfDomains(path)
+}
+
+func (p *Orchestrator) SharedDomains() (domains []string, apiErr error) {
+	path := "/v2/shared_domains"
+	return p.listCfDomains(path)
+}
+
+// listCfDomains reads the names of the domains in a v2 listing, following next_url through
+// every page of results.
+func (p *Orchestrator) listCfDomains(cfPath string) (domains []string, err error) {
+	return retryingClient(p.Connection, p.Retry, p.Out).V2DomainNames(cfPath)
+}
+
+func FQDN(r plugin_models.GetApp_RouteSummary) string {
+	return fmt.Sprintf("%v.%v", r.Host, r.Domain.Name)
+}
+
+// SameRoute compares routes by what they address, ignoring GUIDs which are only
+// known for routes read from CF, and the leading slash of the path.
+func SameRoute(a, b plugin_models.GetApp_RouteSummary) bool {
+	return a.Host == b.Host &&
+		a.Domain.Name == b.Domain.Name &&
+		a.Port == b.Port &&
+		strings.TrimPrefix(a.Path, "/") == strings.TrimPrefix(b.Path, "/")
+}
+
+// RouteURL describes a route as HOST.DOMAIN[/PATH], the same way routes are written in a manifest.
+func RouteURL(r plugin_models.GetApp_RouteSummary) string {
+	url := r.Domain.Name
+	if r.Host != "" {
+		url = FQDN(r)
+	}
+	if r.Path != "" {
+		url = url + "/" + strings.TrimPrefix(r.Path, "/")
+	}
+	return url
+}
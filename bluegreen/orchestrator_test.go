@@ -1,13 +1,15 @@
-package main_test
+package bluegreen_test
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 
 	"code.cloudfoundry.org/cli/plugin"
 	"code.cloudfoundry.org/cli/plugin/models"
 	"code.cloudfoundry.org/cli/plugin/pluginfakes"
-	. "github.com/bluemixgaragelondon/cf-blue-green-deploy"
+	. "github.com/bluemixgaragelondon/cf-blue-green-deploy/bluegreen"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/internal/cfapi"
 	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
 	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest/fakes"
 	. "github.com/onsi/ginkgo"
@@ -21,11 +23,11 @@ var _ = Describe("BGD Plugin", func() {
 		Context("when there is a previous live app", func() {
 			It("calls methods in correct order", func() {
 				b := &BlueGreenDeployFake{liveApp: &plugin_models.GetAppModel{Name: "app-name-live"}, appSshEnabled: false}
-				p := CfPlugin{
+				p := Orchestrator{
 					Deployer: b,
 				}
 
-				p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{}, NewArgs([]string{"bgd", "app-name"}))
+				p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{}, mustParseArgs([]string{"bgd", "app-name"}))
 
 				Expect(b.flow).To(Equal([]string{
 					"delete old apps",
@@ -45,11 +47,11 @@ var _ = Describe("BGD Plugin", func() {
 			Context("and we want to delete the old app instances", func() {
 				It("calls 'delete old apps'", func() {
 					b := &BlueGreenDeployFake{liveApp: &plugin_models.GetAppModel{Name: "app-name-live"}, appSshEnabled: false}
-					p := CfPlugin{
+					p := Orchestrator{
 						Deployer: b,
 					}
 
-					p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{}, NewArgs([]string{"bgd", "app-name", "--delete-old-apps"}))
+					p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{}, mustParseArgs([]string{"bgd", "app-name", "--delete-old-apps"}))
 
 					Expect(b.flow).To(Equal([]string{
 						"delete old apps",
@@ -80,11 +82,11 @@ var _ = Describe("BGD Plugin", func() {
 						liveApp: &plugin_models.GetAppModel{Name: "app-name-live",
 							Routes: liveAppRoutes},
 					}
-					p := CfPlugin{
+					p := Orchestrator{
 						Deployer: b,
 					}
 
-					p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{}, NewArgs([]string{"bgd", "app-name"}))
+					p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{}, mustParseArgs([]string{"bgd", "app-name"}))
 
 					deletedTempRoute := plugin_models.GetApp_RouteSummary{Host: "app-name-new", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}}
 					Expect(b.deletedRoutes).To(ConsistOf(deletedTempRoute))
@@ -103,7 +105,7 @@ var _ = Describe("BGD Plugin", func() {
 						liveApp: &plugin_models.GetAppModel{Name: "app-name-live",
 							Routes: liveAppRoutes},
 					}
-					p := CfPlugin{
+					p := Orchestrator{
 						Deployer: b,
 					}
 					repo := &fakes.FakeManifestReader{Yaml: `---
@@ -114,7 +116,7 @@ var _ = Describe("BGD Plugin", func() {
            - example.com
         `}
 
-					p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, repo, NewArgs([]string{"bgd", "app-name"}))
+					p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, repo, mustParseArgs([]string{"bgd", "app-name"}))
 
 					expectedAppRoutes := append(liveAppRoutes, plugin_models.GetApp_RouteSummary{Host: "man1", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}})
 
@@ -131,7 +133,7 @@ var _ = Describe("BGD Plugin", func() {
 						liveApp: &plugin_models.GetAppModel{Name: "app-name-live",
 							Routes: liveAppRoutes},
 					}
-					p := CfPlugin{
+					p := Orchestrator{
 						Deployer: b,
 					}
 					repo := &fakes.FakeManifestReader{Yaml: `---
@@ -144,7 +146,7 @@ var _ = Describe("BGD Plugin", func() {
            - example.com
         `}
 
-					p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, repo, NewArgs([]string{"bgd", "app-name"}))
+					p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, repo, mustParseArgs([]string{"bgd", "app-name"}))
 
 					expectedAppRoutes := append(liveAppRoutes, plugin_models.GetApp_RouteSummary{Host: "man1", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}})
 
@@ -152,16 +154,177 @@ var _ = Describe("BGD Plugin", func() {
 
 				})
 			})
+
+			Context("with an existing live route and manifest and the prune-routes flag", func() {
+				var (
+					b   *BlueGreenDeployFake
+					p   Orchestrator
+					out *bytes.Buffer
+				)
+
+				BeforeEach(func() {
+					liveAppRoutes := []plugin_models.GetApp_RouteSummary{
+						{Host: "host1", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}},
+						{Host: "host2", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}},
+					}
+
+					b = &BlueGreenDeployFake{
+						liveApp: &plugin_models.GetAppModel{Name: "app-name-live",
+							Routes: liveAppRoutes},
+					}
+					out = &bytes.Buffer{}
+					p = Orchestrator{
+						Deployer: b,
+						Out:      out,
+					}
+				})
+
+				It("only maps the manifest routes", func() {
+					repo := &fakes.FakeManifestReader{Yaml: `---
+          name: app-name
+          hosts:
+           - man1
+           - host1
+          domains:
+           - example.com
+        `}
+
+					p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, repo, mustParseArgs([]string{"bgd", "app-name", "--prune-routes"}))
+
+					Expect(b.mappedRoutes).To(ConsistOf(
+						plugin_models.GetApp_RouteSummary{Host: "man1", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}},
+						plugin_models.GetApp_RouteSummary{Host: "host1", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}},
+					))
+				})
+
+				It("lists the routes which are dropped", func() {
+					repo := &fakes.FakeManifestReader{Yaml: `---
+          name: app-name
+          hosts:
+           - host1
+          domains:
+           - example.com
+        `}
+
+					p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, repo, mustParseArgs([]string{"bgd", "app-name", "--prune-routes"}))
+
+					Expect(out.String()).To(ContainSubstring("host2.example.com"))
+					Expect(out.String()).ToNot(ContainSubstring("host1.example.com"))
+				})
+
+				It("maps the default route when the manifest has no routes", func() {
+					repo := &fakes.FakeManifestReader{Yaml: `---
+          name: app-name
+        `}
+
+					p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, repo, mustParseArgs([]string{"bgd", "app-name", "--prune-routes"}))
+
+					Expect(b.mappedRoutes).To(Equal([]plugin_models.GetApp_RouteSummary{
+						{Host: "app-name", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}},
+					}))
+				})
+
+				It("stops before changing anything when the manifest cannot be read", func() {
+					repo := &fakes.FakeManifestReader{Err: errors.New("no manifest")}
+
+					_, err := p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, repo, mustParseArgs([]string{"bgd", "app-name", "--prune-routes"}))
+
+					Expect(err).To(MatchError(ContainSubstring("Could not read the manifest: no manifest")))
+					Expect(b.flow).To(BeEmpty())
+					Expect(b.mappedRoutes).To(BeEmpty())
+				})
+
+				It("does not prune anything when deploying without a manifest", func() {
+					repo := &fakes.FakeManifestReader{Err: errors.New("no manifest")}
+
+					p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, repo, mustParseArgs([]string{"bgd", "app-name", "--prune-routes", "--no-manifest"}))
+
+					Expect(b.mappedRoutes).To(HaveLen(2))
+					Expect(out.String()).To(BeEmpty())
+				})
+			})
+		})
+
+		Context("when there is a previous live app with excluded routes", func() {
+			var (
+				b         *BlueGreenDeployFake
+				p         Orchestrator
+				out       *bytes.Buffer
+				cfDomains manifest.CfDomains
+			)
+
+			movedRoute := plugin_models.GetApp_RouteSummary{Host: "host1", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}}
+			pinnedRoute := plugin_models.GetApp_RouteSummary{Host: "admin", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}}
+
+			BeforeEach(func() {
+				b = &BlueGreenDeployFake{
+					liveApp: &plugin_models.GetAppModel{Name: "app-name-live",
+						Routes: []plugin_models.GetApp_RouteSummary{movedRoute, pinnedRoute}},
+				}
+				out = &bytes.Buffer{}
+				p = Orchestrator{
+					Deployer: b,
+					Out:      out,
+				}
+				cfDomains = manifest.CfDomains{DefaultDomain: "example.com", SharedDomains: []string{"example.com"}}
+			})
+
+			Context("given on the command line", func() {
+				It("leaves the excluded routes on the old app", func() {
+					p.Deploy(cfDomains, &fakes.FakeManifestReader{}, mustParseArgs([]string{"bgd", "app-name", "--exclude-route", "admin.example.com"}))
+
+					Expect(b.mappedRoutes).To(ConsistOf(movedRoute))
+					Expect(b.unmappedRoutes["app-name-old"]).To(ConsistOf(movedRoute))
+					Expect(out.String()).To(ContainSubstring("admin.example.com"))
+				})
+
+				It("tells the clean up which routes to keep", func() {
+					p.Deploy(cfDomains, &fakes.FakeManifestReader{}, mustParseArgs([]string{"bgd", "app-name", "--exclude-route", "admin.example.com", "--delete-old-apps"}))
+
+					Expect(b.keptRoutes).To(ConsistOf(pinnedRoute))
+				})
+
+				It("keeps the old app holding them when deploying again", func() {
+					opts := mustParseArgs([]string{"bgd", "app-name", "--exclude-route", "admin.example.com"})
+					p.Deploy(cfDomains, &fakes.FakeManifestReader{}, opts)
+					b.liveApp = &plugin_models.GetAppModel{Name: "app-name", Routes: []plugin_models.GetApp_RouteSummary{movedRoute}}
+					p.Deploy(cfDomains, &fakes.FakeManifestReader{}, opts)
+
+					Expect(b.cleanupKeptRoutes).To(ConsistOf(pinnedRoute))
+				})
+
+				It("fails before changing anything when the route does not match a domain", func() {
+					_, err := p.Deploy(cfDomains, &fakes.FakeManifestReader{}, mustParseArgs([]string{"bgd", "app-name", "--exclude-route", "admin.unknown.org"}))
+
+					Expect(err).To(MatchError(ContainSubstring("Could not work out the excluded routes")))
+					Expect(b.flow).To(BeEmpty())
+				})
+			})
+
+			Context("given in the manifest", func() {
+				It("leaves the excluded routes on the old app", func() {
+					repo := &fakes.FakeManifestReader{Yaml: `---
+name: app-name
+x-bgd-exclude-routes:
+  - route: admin.example.com
+`}
+
+					p.Deploy(cfDomains, repo, mustParseArgs([]string{"bgd", "app-name"}))
+
+					Expect(b.mappedRoutes).To(ConsistOf(movedRoute))
+					Expect(b.unmappedRoutes["app-name-old"]).To(ConsistOf(movedRoute))
+				})
+			})
 		})
 
 		Context("when there is no previous live app", func() {
 			It("calls methods in correct order", func() {
 				b := &BlueGreenDeployFake{liveApp: nil}
-				p := CfPlugin{
+				p := Orchestrator{
 					Deployer: b,
 				}
 
-				p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{}, NewArgs([]string{"bgd", "app-name"}))
+				p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{}, mustParseArgs([]string{"bgd", "app-name"}))
 
 				Expect(b.flow).To(Equal([]string{
 					"delete old apps",
@@ -179,7 +342,7 @@ var _ = Describe("BGD Plugin", func() {
 			Context("when manifest uses hosts and domains", func() {
 				It("maps manifest routes", func() {
 					b := &BlueGreenDeployFake{liveApp: nil}
-					p := CfPlugin{
+					p := Orchestrator{
 						Deployer: b,
 					}
 					repo := &fakes.FakeManifestReader{Yaml: `---
@@ -192,7 +355,7 @@ var _ = Describe("BGD Plugin", func() {
            - specific.net
         `}
 
-					p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, repo, NewArgs([]string{"bgd", "app-name"}))
+					p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, repo, mustParseArgs([]string{"bgd", "app-name"}))
 					Expect(b.flow).To(Equal([]string{
 						"delete old apps",
 						"get current live app",
@@ -221,7 +384,7 @@ var _ = Describe("BGD Plugin", func() {
 			Context("when manifest uses routes", func() {
 				It("maps manifest routes", func() {
 					b := &BlueGreenDeployFake{liveApp: nil}
-					p := CfPlugin{
+					p := Orchestrator{
 						Deployer: b,
 					}
 					repo := &fakes.FakeManifestReader{Yaml: `---
@@ -232,7 +395,7 @@ routes:
   - route: host3.common.com
 `}
 
-					p.Deploy(manifest.CfDomains{DefaultDomain: "example.com", SharedDomains: []string{"common.com"}, PrivateDomains: []string{"mine.com", "something.com"}}, repo, NewArgs([]string{"bgd", "app-name"}))
+					p.Deploy(manifest.CfDomains{DefaultDomain: "example.com", SharedDomains: []string{"common.com"}, PrivateDomains: []string{"mine.com", "something.com"}}, repo, mustParseArgs([]string{"bgd", "app-name"}))
 
 					Expect(b.flow).To(Equal([]string{
 						"delete old apps",
@@ -257,7 +420,7 @@ routes:
 			Context("when scale parameters are defined", func() {
 				It("Uses the scale values", func() {
 					b := &BlueGreenDeployFake{liveApp: nil}
-					p := CfPlugin{
+					p := Orchestrator{
 						Deployer: b,
 					}
 					repo := &fakes.FakeManifestReader{Yaml: `---
@@ -268,7 +431,7 @@ routes:
             hosts:
             - host1
             `}
-					p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, repo, NewArgs([]string{"bgd", "app-name"}))
+					p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, repo, mustParseArgs([]string{"bgd", "app-name"}))
 					Expect(b.flow).To(Equal([]string{
 						"delete old apps",
 						"get current live app",
@@ -289,7 +452,7 @@ routes:
 			Context("when no routes are specified in the manifest", func() {
 				It("maps the app name as the only route", func() {
 					b := &BlueGreenDeployFake{liveApp: nil}
-					p := CfPlugin{
+					p := Orchestrator{
 						Deployer: b,
 					}
 					repo := &fakes.FakeManifestReader{Yaml: `---
@@ -298,7 +461,7 @@ routes:
 							- host1
 					`}
 
-					p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, repo, NewArgs([]string{"bgd", "app-name"}))
+					p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, repo, mustParseArgs([]string{"bgd", "app-name"}))
 
 					Expect(b.mappedRoutes).To(Equal([]plugin_models.GetApp_RouteSummary{
 						{Host: "app-name", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}},
@@ -311,18 +474,18 @@ routes:
 			Context("when it succeeds", func() {
 				var (
 					b *BlueGreenDeployFake
-					p CfPlugin
+					p Orchestrator
 				)
 
 				BeforeEach(func() {
 					b = &BlueGreenDeployFake{liveApp: nil, passSmokeTest: true}
-					p = CfPlugin{
+					p = Orchestrator{
 						Deployer: b,
 					}
 				})
 
 				It("calls methods in correct order", func() {
-					p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{}, NewArgs([]string{"bgd", "app-name", "--smoke-test", "script/smoke-test"}))
+					p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{}, mustParseArgs([]string{"bgd", "app-name", "--smoke-test", "script/smoke-test"}))
 
 					Expect(b.flow).To(Equal([]string{
 						"delete old apps",
@@ -336,28 +499,31 @@ routes:
 					}))
 				})
 
-				It("returns true", func() {
-					result := p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{}, NewArgs([]string{"bgd", "app-name", "--smoke-test", "script/smoke-test"}))
+				It("returns the result of the deployment", func() {
+					result, err := p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{}, mustParseArgs([]string{"bgd", "app-name", "--smoke-test", "script/smoke-test"}))
 
-					Expect(result).To(Equal(true))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(result.AppName).To(Equal("app-name"))
+					Expect(result.Strategy).To(Equal(StrategyBlueGreen))
+					Expect(result.ReplacedLiveApp).To(BeFalse())
 				})
 			})
 
 			Context("when it fails", func() {
 				var (
 					b *BlueGreenDeployFake
-					p CfPlugin
+					p Orchestrator
 				)
 
 				BeforeEach(func() {
 					b = &BlueGreenDeployFake{liveApp: nil, passSmokeTest: false}
-					p = CfPlugin{
+					p = Orchestrator{
 						Deployer: b,
 					}
 				})
 
 				It("calls methods in correct order", func() {
-					p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{}, NewArgs([]string{"bgd", "app-name", "--smoke-test", "script/smoke-test"}))
+					p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{}, mustParseArgs([]string{"bgd", "app-name", "--smoke-test", "script/smoke-test"}))
 
 					Expect(b.flow).To(Equal([]string{
 						"delete old apps",
@@ -370,16 +536,16 @@ routes:
 					}))
 				})
 
-				It("returns false", func() {
-					result := p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{}, NewArgs([]string{"bgd", "app-name", "--smoke-test", "script/smoke-test"}))
+				It("returns ErrDeploymentFailed", func() {
+					_, err := p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{}, mustParseArgs([]string{"bgd", "app-name", "--smoke-test", "script/smoke-test"}))
 
-					Expect(result).To(Equal(false))
+					Expect(err).To(Equal(ErrDeploymentFailed))
 				})
 			})
 		})
 
 		Describe("GetScaleFromManifest", func() {
-			p := CfPlugin{}
+			p := Orchestrator{}
 			Context("when the manifest is valid", func() {
 				It("returns the scale parameters", func() {
 					fakeManifestReader := &fakes.FakeManifestReader{Yaml: `---
@@ -390,25 +556,71 @@ routes:
             - man1
             `,
 					}
-					actualScale := p.GetScaleFromManifest("app-name", manifest.CfDomains{DefaultDomain: "example.com"}, fakeManifestReader)
+					actualScale, err := p.GetScaleFromManifest("app-name", manifest.CfDomains{DefaultDomain: "example.com"}, fakeManifestReader)
 					expectedScale := ScaleParameters{Memory: int64(16), DiskQuota: int64(500)}
+					Expect(err).ToNot(HaveOccurred())
 					Expect(actualScale).To(Equal(expectedScale))
 				})
 			})
 			Context("the manifest is invalid", func() {
-				It("returns an empty manifest", func() {
-					failingFakeManifestReader := &fakes.FakeManifestReader{Err: errors.New("")}
-					actualScale := p.GetScaleFromManifest("app-name", manifest.CfDomains{DefaultDomain: "example.com"}, failingFakeManifestReader)
-					expectedScale := ScaleParameters{}
-					Expect(actualScale).To(Equal(expectedScale))
+				It("returns an error", func() {
+					failingFakeManifestReader := &fakes.FakeManifestReader{Err: errors.New("bad manifest")}
+					_, err := p.GetScaleFromManifest("app-name", manifest.CfDomains{DefaultDomain: "example.com"}, failingFakeManifestReader)
+					Expect(err).To(MatchError("Could not read the manifest: bad manifest"))
 				})
 			})
 		})
 	})
 
+	Describe("New", func() {
+		var (
+			connection *pluginfakes.FakeCliConnection
+			out        *bytes.Buffer
+		)
+
+		BeforeEach(func() {
+			connection = &pluginfakes.FakeCliConnection{}
+			out = &bytes.Buffer{}
+		})
+
+		It("returns a failed step as an error instead of exiting", func() {
+			connection.GetAppsReturns(nil, errors.New("not logged in"))
+
+			_, err := New(connection, out).Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{}, mustParseArgs([]string{"bgd", "app-name"}))
+
+			Expect(err).To(MatchError("Could not load apps in space, are you logged in? - not logged in"))
+			Expect(err.(*Error).Err).To(MatchError("not logged in"))
+		})
+
+		It("returns a failed step of a deployment phase as an error", func() {
+			connection.GetAppsReturns(nil, errors.New("not logged in"))
+
+			_, err := New(connection, out).PrepareDeployment(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{}, mustParseArgs([]string{"bgd", "app-name"}))
+
+			Expect(err).To(MatchError("Could not load apps in space, are you logged in? - not logged in"))
+		})
+
+		It("returns a push which fails as the error of PushAndSmokeTest", func() {
+			connection.CliCommandReturns(nil, errors.New("staging failed"))
+			deployment := &Deployment{AppName: "app-name", NewAppName: "app-name-new", CfDomains: manifest.CfDomains{DefaultDomain: "example.com"}}
+
+			passed, err := New(connection, out).PushAndSmokeTest(deployment, ScaleParameters{})
+
+			Expect(passed).To(BeFalse())
+			Expect(err).To(MatchError("Could not push new version - staging failed"))
+		})
+
+		It("needs an app name", func() {
+			_, err := New(connection, out).Run(DefaultOptions())
+
+			Expect(err).To(MatchError("App name was empty, must be provided."))
+			Expect(connection.CliCommandWithoutTerminalOutputCallCount()).To(Equal(0))
+		})
+	})
+
 	Describe("SharedDomains", func() {
 		connection := &pluginfakes.FakeCliConnection{}
-		p := CfPlugin{Connection: connection}
+		p := Orchestrator{Connection: connection}
 
 		Context("when CF command succeeds", func() {
 			It("returns all CF shared domains", func() {
@@ -467,11 +679,39 @@ routes:
 				Expect(err).To(HaveOccurred())
 			})
 		})
+
+		Context("when the domains span several pages", func() {
+			It("follows next_url to the last page", func() {
+				paths := []string{}
+				connection.CliCommandWithoutTerminalOutputStub = func(args ...string) ([]string, error) {
+					paths = append(paths, args[1])
+					if args[1] == "/v2/shared_domains" {
+						return []string{`{"next_url": "/v2/shared_domains?page=2", "resources": [{"entity": {"name": "first.com"}}]}`}, nil
+					}
+					return []string{`{"next_url": null, "resources": [{"entity": {"name": "second.com"}}]}`}, nil
+				}
+				domains, err := p.SharedDomains()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(domains).To(Equal([]string{"first.com", "second.com"}))
+				Expect(paths).To(Equal([]string{"/v2/shared_domains", "/v2/shared_domains?page=2"}))
+			})
+		})
+
+		Context("when the Cloud Controller returns an error document", func() {
+			It("returns a CcError", func() {
+				connection.CliCommandWithoutTerminalOutputStub = func(args ...string) ([]string, error) {
+					return []string{`{"code": 10002, "description": "Authentication error", "error_code": "CF-NotAuthenticated"}`}, nil
+				}
+				_, err := p.SharedDomains()
+				Expect(err).To(Equal(&cfapi.CcError{Code: 10002, Description: "Authentication error", ErrorCode: "CF-NotAuthenticated"}))
+				Expect(err).To(MatchError("CF-NotAuthenticated: Authentication error (code 10002)"))
+			})
+		})
 	})
 
 	Describe("PrivateDomains", func() {
 		connection := &pluginfakes.FakeCliConnection{}
-		p := CfPlugin{Connection: connection}
+		p := Orchestrator{Connection: connection}
 
 		Context("when CF command succeeds", func() {
 			It("returns all private domains", func() {
@@ -530,10 +770,24 @@ routes:
 				Expect(err).To(HaveOccurred())
 			})
 		})
+
+		Context("when an org is targeted", func() {
+			It("lists the private domains of the org", func() {
+				orgConnection := &pluginfakes.FakeCliConnection{}
+				orgConnection.GetCurrentOrgReturns(plugin_models.Organization{OrganizationFields: plugin_models.OrganizationFields{Guid: "org-guid"}}, nil)
+				orgConnection.CliCommandWithoutTerminalOutputReturns([]string{`{"resources": [{"entity": {"name": "mine.com"}}]}`}, nil)
+				p := Orchestrator{Connection: orgConnection}
+
+				domains, err := p.PrivateDomains()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(domains).To(Equal([]string{"mine.com"}))
+				Expect(orgConnection.CliCommandWithoutTerminalOutputArgsForCall(0)).To(Equal([]string{"curl", "/v2/organizations/org-guid/private_domains"}))
+			})
+		})
 	})
 
 	Describe("Unique list of routes", func() {
-		p := CfPlugin{}
+		p := Orchestrator{}
 
 		Context("when listA and ListB are empty", func() {
 			It("returns an empty list", func() {
@@ -622,6 +876,38 @@ routes:
 		})
 	})
 
+	Describe("Subtracting route lists", func() {
+		p := Orchestrator{}
+
+		Context("when listB contains some of the routes in listA", func() {
+			It("returns the routes only in listA", func() {
+				listA := []plugin_models.GetApp_RouteSummary{{Host: "foo"}, {Host: "bar"}}
+				listB := []plugin_models.GetApp_RouteSummary{{Host: "foo"}, {Host: "baz"}}
+
+				Expect(p.SubtractRouteList(listA, listB)).To(Equal([]plugin_models.GetApp_RouteSummary{{Host: "bar"}}))
+			})
+		})
+		Context("when list A is nil", func() {
+			It("returns an empty list", func() {
+				listB := []plugin_models.GetApp_RouteSummary{{Host: "foo"}}
+
+				Expect(p.SubtractRouteList(nil, listB)).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("RouteURL", func() {
+		It("includes the path of the route", func() {
+			route := plugin_models.GetApp_RouteSummary{Host: "testroute", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}, Path: "/api"}
+			Expect(RouteURL(route)).To(Equal("testroute.example.com/api"))
+		})
+
+		It("omits an empty host", func() {
+			route := plugin_models.GetApp_RouteSummary{Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}}
+			Expect(RouteURL(route)).To(Equal("example.com"))
+		})
+	})
+
 	Describe("FQDN", func() {
 		It("returns the fqdn of the route", func() {
 			route := plugin_models.GetApp_RouteSummary{Host: "testroute", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}}
@@ -631,14 +917,39 @@ routes:
 })
 
 type BlueGreenDeployFake struct {
-	flow          []string
-	liveApp       *plugin_models.GetAppModel
-	appSshEnabled bool
-	passSmokeTest bool
-	mappedRoutes  []plugin_models.GetApp_RouteSummary
-	deletedRoutes []plugin_models.GetApp_RouteSummary
-	scale         *ScaleParameters
-	usedScale     *ScaleParameters
+	flow           []string
+	liveApp        *plugin_models.GetAppModel
+	appSshEnabled  bool
+	passSmokeTest  bool
+	mappedRoutes   []plugin_models.GetApp_RouteSummary
+	unmappedRoutes map[string][]plugin_models.GetApp_RouteSummary
+	deletedRoutes  []plugin_models.GetApp_RouteSummary
+	keptRoutes     []plugin_models.GetApp_RouteSummary
+	scale          *ScaleParameters
+	usedScale      *ScaleParameters
+	failingFQDNs   []string
+	noWeights      bool
+	weightsError   error
+	failingMapApp  string
+	smokeTestFails int
+	// failingMapCall is the call of MapRoutesToApp which fails, counting from 1
+	failingMapCall int
+	mapCalls       int
+	// failingRenameTo is the name which renaming an app to fails
+	failingRenameTo string
+	// failingScale is the call of ScaleApp which fails, counting from 1
+	failingScale int
+	scaleCalls   int
+
+	// cleanupKeptRoutes are the routes the clean up before a deploy was told to keep
+	cleanupKeptRoutes []plugin_models.GetApp_RouteSummary
+
+	rollingPushError   error
+	deploymentStatuses []DeploymentStatus
+	newInstances       []int
+	revisions          []AppRevision
+	labelledRevisions  []string
+	previousDroplet    string
 }
 
 func (p *BlueGreenDeployFake) Setup(connection plugin.CliConnection) {
@@ -646,21 +957,38 @@ func (p *BlueGreenDeployFake) Setup(connection plugin.CliConnection) {
 }
 
 func (p *BlueGreenDeployFake) GetScaleParameters(appName string) (ScaleParameters, error) {
+	if p.scale != nil {
+		return *p.scale, nil
+	}
 	return ScaleParameters{}, nil
 }
 
+func (p *BlueGreenDeployFake) ScaleApp(appName string, instanceCount int) error {
+	p.scaleCalls++
+	if p.scaleCalls == p.failingScale {
+		return &Error{Message: "Could not scale " + appName, Err: errors.New("failed")}
+	}
+	p.flow = append(p.flow, fmt.Sprintf("scale %s to %d", appName, instanceCount))
+	return nil
+}
+
 func (p *BlueGreenDeployFake) PushNewApp(appName string, route plugin_models.GetApp_RouteSummary,
-	manifestPath string, scaleParameters ScaleParameters) {
+	manifestPath string, scaleParameters ScaleParameters) error {
 	p.usedScale = &scaleParameters
 	p.flow = append(p.flow, fmt.Sprintf("push %s", appName))
+	return nil
 }
 
-func (p *BlueGreenDeployFake) DeleteAllAppsExceptLiveApp(string) {
+func (p *BlueGreenDeployFake) DeleteAllAppsExceptLiveApp(appName string, excludedRoutes ...plugin_models.GetApp_RouteSummary) error {
+	p.cleanupKeptRoutes = excludedRoutes
 	p.flow = append(p.flow, "delete old apps")
+	return nil
 }
 
-func (p *BlueGreenDeployFake) DeleteAllAppsExceptLiveAndFailedApp(string) {
+func (p *BlueGreenDeployFake) DeleteAllAppsExceptLiveAndFailedApp(appName string, excludedRoutes ...plugin_models.GetApp_RouteSummary) error {
+	p.keptRoutes = excludedRoutes
 	p.flow = append(p.flow, "delete old apps except failed ones")
+	return nil
 }
 
 func (p *BlueGreenDeployFake) LiveApp(string) (string, []plugin_models.GetApp_RouteSummary) {
@@ -671,38 +999,152 @@ func (p *BlueGreenDeployFake) LiveApp(string) (string, []plugin_models.GetApp_Ro
 		return p.liveApp.Name, p.liveApp.Routes
 	}
 }
-func (p *BlueGreenDeployFake) RunSmokeTests(script string, fqdn string) bool {
-	p.flow = append(p.flow, fmt.Sprintf("%s %s", script, fqdn))
-	return p.passSmokeTest
+func (p *BlueGreenDeployFake) RunSmokeTests(smokeTest SmokeTest, fqdn string) (bool, error) {
+	p.flow = append(p.flow, fmt.Sprintf("%s %s", smokeTest.Script, fqdn))
+	if p.smokeTestFails > 0 {
+		p.smokeTestFails--
+		return false, nil
+	}
+	for _, failingFQDN := range p.failingFQDNs {
+		if fqdn == failingFQDN {
+			return false, nil
+		}
+	}
+	return p.passSmokeTest, nil
 }
 
 func (p *BlueGreenDeployFake) RemapRoutesFromLiveAppToNewApp(liveApp plugin_models.GetAppModel, newApp plugin_models.GetAppModel) {
 	p.flow = append(p.flow, fmt.Sprintf("remap routes from %s to %s", liveApp.Name, newApp.Name))
 }
 
-func (p *BlueGreenDeployFake) RenameApp(app string, newName string) {
+func (p *BlueGreenDeployFake) RenameApp(app string, newName string) error {
+	if newName == p.failingRenameTo {
+		return &Error{Message: "Could not rename app", Err: errors.New("failed")}
+	}
 	p.flow = append(p.flow, fmt.Sprintf("rename %s to %s", app, newName))
+	return nil
 }
 
-func (p *BlueGreenDeployFake) MapRoutesToApp(appName string, routes ...plugin_models.GetApp_RouteSummary) {
+func (p *BlueGreenDeployFake) MapRoutesToApp(appName string, routes ...plugin_models.GetApp_RouteSummary) error {
+	p.mapCalls++
+	if appName == p.failingMapApp || p.mapCalls == p.failingMapCall {
+		return &Error{Message: "Could not map routes to " + appName, Err: errors.New("failed")}
+	}
 	p.mappedRoutes = routes
 	p.flow = append(p.flow, fmt.Sprintf("mapped %d routes", len(routes)))
+	return nil
+}
+
+func (p *BlueGreenDeployFake) SetRouteWeights(route plugin_models.GetApp_RouteSummary, weights ...RouteWeight) error {
+	if p.noWeights {
+		return &WeightedRoutingUnsupportedError{Err: errors.New("CF-NotFound: Unknown request")}
+	}
+	if p.weightsError != nil {
+		return p.weightsError
+	}
+	destinations := []string{}
+	for _, weight := range weights {
+		destinations = append(destinations, fmt.Sprintf("%s=%d", weight.AppName, weight.Weight))
+	}
+	p.flow = append(p.flow, fmt.Sprintf("weight %s %s", RouteURL(route), strings.Join(destinations, " ")))
+	return nil
+}
+
+func (p *BlueGreenDeployFake) StartRollingDeployment(appName string, manifestPath string, scaleParameters ScaleParameters) (string, error) {
+	p.usedScale = &scaleParameters
+	p.flow = append(p.flow, fmt.Sprintf("rolling push %s", appName))
+	if p.rollingPushError != nil {
+		return "", p.rollingPushError
+	}
+	return "deployment-guid", nil
+}
+
+func (p *BlueGreenDeployFake) DeploymentStatus(deploymentGuid string) (DeploymentStatus, error) {
+	p.flow = append(p.flow, fmt.Sprintf("status of %s", deploymentGuid))
+	if len(p.deploymentStatuses) == 0 {
+		return DeploymentStatus{Value: "FINALIZED", Reason: "DEPLOYED"}, nil
+	}
+	status := p.deploymentStatuses[0]
+	p.deploymentStatuses = p.deploymentStatuses[1:]
+	return status, nil
 }
 
-func (p *BlueGreenDeployFake) UnmapRoutesFromApp(oldAppName string, routes ...plugin_models.GetApp_RouteSummary) {
+func (p *BlueGreenDeployFake) NewInstancesRunning(deploymentGuid string) (int, error) {
+	p.flow = append(p.flow, fmt.Sprintf("new instances of %s", deploymentGuid))
+	if len(p.newInstances) == 0 {
+		return 1, nil
+	}
+	running := p.newInstances[0]
+	p.newInstances = p.newInstances[1:]
+	return running, nil
+}
+
+func (p *BlueGreenDeployFake) CancelDeployment(deploymentGuid string) error {
+	p.flow = append(p.flow, fmt.Sprintf("cancel %s", deploymentGuid))
+	return nil
+}
+
+func (p *BlueGreenDeployFake) AppRevisions(appName string) ([]AppRevision, error) {
+	p.flow = append(p.flow, fmt.Sprintf("list revisions of %s", appName))
+	return p.revisions, nil
+}
+
+func (p *BlueGreenDeployFake) LabelCurrentRevision(appName string) error {
+	p.labelledRevisions = append(p.labelledRevisions, appName)
+	return nil
+}
+
+func (p *BlueGreenDeployFake) LabelRolledBackRevisions(revisionGuids ...string) error {
+	p.flow = append(p.flow, fmt.Sprintf("label rolled back revisions %s", strings.Join(revisionGuids, ", ")))
+	return nil
+}
+
+func (p *BlueGreenDeployFake) RollbackToRevision(appName string, revisionGuid string) (string, error) {
+	p.flow = append(p.flow, fmt.Sprintf("roll back %s to %s", appName, revisionGuid))
+	return "rollback-guid", nil
+}
+
+func (p *BlueGreenDeployFake) KeepPreviousDroplet(newAppName string, liveAppName string) error {
+	p.previousDroplet = liveAppName + "-droplet"
+	return nil
+}
+
+func (p *BlueGreenDeployFake) PreviousDroplet(appName string) (string, error) {
+	return p.previousDroplet, nil
+}
+
+func (p *BlueGreenDeployFake) ForgetPreviousDroplet(appName string) error {
+	p.flow = append(p.flow, fmt.Sprintf("forget previous droplet of %s", appName))
+	p.previousDroplet = ""
+	return nil
+}
+
+func (p *BlueGreenDeployFake) RollbackToDroplet(appName string, dropletGuid string) (string, error) {
+	p.flow = append(p.flow, fmt.Sprintf("roll back %s to droplet %s", appName, dropletGuid))
+	return "rollback-guid", nil
+}
+
+func (p *BlueGreenDeployFake) UnmapRoutesFromApp(oldAppName string, routes ...plugin_models.GetApp_RouteSummary) error {
+	if p.unmappedRoutes == nil {
+		p.unmappedRoutes = map[string][]plugin_models.GetApp_RouteSummary{}
+	}
+	p.unmappedRoutes[oldAppName] = routes
 	p.flow = append(p.flow, fmt.Sprintf("unmap %d routes from %s", len(routes), oldAppName))
+	return nil
 }
 
-func (p *BlueGreenDeployFake) DeleteRoutes(routes ...plugin_models.GetApp_RouteSummary) {
+func (p *BlueGreenDeployFake) DeleteRoutes(routes ...plugin_models.GetApp_RouteSummary) error {
 	p.deletedRoutes = routes
 	p.flow = append(p.flow, fmt.Sprintf("delete %d routes", len(routes)))
+	return nil
 }
 
-func (p *BlueGreenDeployFake) CheckSshEnablement(app string) bool {
+func (p *BlueGreenDeployFake) CheckSshEnablement(app string) (bool, error) {
 	p.flow = append(p.flow, fmt.Sprintf("check ssh enablement for '%s'", app))
-	return strings.Contains(app, "ssh-enabled-app")
+	return strings.Contains(app, "ssh-enabled-app"), nil
 }
 
-func (p *BlueGreenDeployFake) SetSshAccess(app string, enableSsh bool) {
+func (p *BlueGreenDeployFake) SetSshAccess(app string, enableSsh bool) error {
 	p.flow = append(p.flow, fmt.Sprintf("set ssh enablement for '%s' to '%v'", app, enableSsh))
+	return nil
 }
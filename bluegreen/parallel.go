@@ -0,0 +1,151 @@
+package bluegreen
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/cli/plugin"
+	"code.cloudfoundry.org/cli/plugin/models"
+)
+
+// outputConnection is a connection which can send the output of the cf commands elsewhere, such
+// as the standalone connection of cf-bgd. The connection of the cf CLI always writes to the
+// terminal.
+type outputConnection interface {
+	WithOutput(out io.Writer) plugin.CliConnection
+}
+
+// serialConnection runs the cf commands of apps deployed in parallel one at a time. The cf CLI
+// serves the commands of a plugin from a single RPC server, which captures the output of one
+// command at a time, so commands run at once would get each other's output. Pushes, which take
+// longest since they stage the app, rather run in a cf process of their own when there is a cf
+// on the PATH, writing their output to out, so that the apps stage at the same time.
+type serialConnection struct {
+	plugin.CliConnection
+	commands *sync.Mutex
+	out      io.Writer
+}
+
+func (c serialConnection) CliCommand(args ...string) ([]string, error) {
+	if len(args) > 0 && args[0] == "push" {
+		if cf, err := exec.LookPath("cf"); err == nil {
+			return runCf(cf, c.out, args...)
+		}
+	}
+	c.commands.Lock()
+	defer c.commands.Unlock()
+	return c.CliConnection.CliCommand(args...)
+}
+
+func (c serialConnection) CliCommandWithoutTerminalOutput(args ...string) ([]string, error) {
+	c.commands.Lock()
+	defer c.commands.Unlock()
+	return c.CliConnection.CliCommandWithoutTerminalOutput(args...)
+}
+
+func (c serialConnection) GetApp(appName string) (plugin_models.GetAppModel, error) {
+	c.commands.Lock()
+	defer c.commands.Unlock()
+	return c.CliConnection.GetApp(appName)
+}
+
+func (c serialConnection) GetApps() ([]plugin_models.GetAppsModel, error) {
+	c.commands.Lock()
+	defer c.commands.Unlock()
+	return c.CliConnection.GetApps()
+}
+
+// runCf runs a cf command in a process of its own, which uses the target and tokens of the cf CLI
+// the plugin runs in through the same CF_HOME. Like CliCommand, it returns the lines of the
+// command's output.
+func runCf(cf string, out io.Writer, args ...string) ([]string, error) {
+	output := &bytes.Buffer{}
+	command := exec.Command(cf, args...)
+	command.Stdout = io.MultiWriter(out, output)
+	command.Stderr = command.Stdout
+	err := command.Run()
+	return strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n"), err
+}
+
+// forApp returns an Orchestrator for deploying one app while others are deployed in parallel.
+// It has its own deployer, which writes to out, and tells the observers of p about its events.
+// A connection which cannot send the output of its cf commands to out is shared with the other
+// apps through commands, which lets one cf command other than cf push run at a time.
+func (p *Orchestrator) forApp(out io.Writer, commands *sync.Mutex) *Orchestrator {
+	app := &Orchestrator{
+		Connection: p.Connection,
+		Deployer:   p.Deployer,
+		Out:        out,
+		Observers:  []Observer{ObserverFunc(p.emit)},
+	}
+	if _, ok := p.Connection.(outputConnection); !ok {
+		app.Connection = serialConnection{CliConnection: p.Connection, commands: commands, out: out}
+	}
+	if deployer, ok := p.Deployer.(*BlueGreenDeploy); ok {
+		appDeployer := *deployer
+		appDeployer.Out = out
+		if connection, ok := deployer.Connection.(outputConnection); ok {
+			appDeployer.Connection = connection.WithOutput(out)
+		} else {
+			appDeployer.Connection = serialConnection{CliConnection: deployer.Connection, commands: commands, out: out}
+		}
+		app.Deployer = &appDeployer
+	}
+	return app
+}
+
+// appPrefixes are the prefixes of the output of apps deployed in parallel, padded to the same
+// width so that the output lines up.
+func appPrefixes(appNames []string) map[string]string {
+	width := 0
+	for _, appName := range appNames {
+		if len(appName) > width {
+			width = len(appName)
+		}
+	}
+	prefixes := map[string]string{}
+	for _, appName := range appNames {
+		prefixes[appName] = fmt.Sprintf("%-*s | ", width, appName)
+	}
+	return prefixes
+}
+
+// prefixWriter starts every line written to out with prefix. It only writes whole lines, so that
+// the lines of apps deployed in parallel do not run into each other.
+type prefixWriter struct {
+	prefix  string
+	out     io.Writer
+	partial []byte
+}
+
+func (w *prefixWriter) Write(b []byte) (int, error) {
+	w.partial = append(w.partial, b...)
+	lines := []byte{}
+	for {
+		end := bytes.IndexByte(w.partial, '\n')
+		if end < 0 {
+			break
+		}
+		lines = append(append(lines, w.prefix...), w.partial[:end+1]...)
+		w.partial = w.partial[end+1:]
+	}
+	if len(lines) > 0 {
+		if _, err := w.out.Write(lines); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Flush writes what is left of a line which was not finished.
+func (w *prefixWriter) Flush() error {
+	if len(w.partial) == 0 {
+		return nil
+	}
+	_, err := w.Write([]byte("\n"))
+	return err
+}
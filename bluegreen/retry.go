@@ -0,0 +1,151 @@
+package bluegreen
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin"
+	"code.cloudfoundry.org/cli/plugin/models"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/internal/cfapi"
+)
+
+// maxRetryDelay caps the exponential backoff between retries.
+const maxRetryDelay = 30 * time.Second
+
+// RetryPolicy says how often cf commands which fail with a transient error are retried. The zero
+// value runs every command once.
+type RetryPolicy struct {
+	Retries      int
+	InitialDelay time.Duration
+}
+
+// Delay is how long to wait before the given retry, starting at 1, doubling the initial delay
+// for every retry up to maxRetryDelay.
+func (r RetryPolicy) Delay(retry int) time.Duration {
+	delay := r.InitialDelay
+	for i := 1; i < retry && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+var transientFailures = []*regexp.Regexp{
+	// Cloud Controller and gorouter errors
+	regexp.MustCompile(`(?i)status code:? 5\d\d`),
+	regexp.MustCompile(`\b50[234] (Bad Gateway|Service Unavailable|Gateway Time-?out)`),
+	regexp.MustCompile(`CF-AsyncServiceInstanceOperationInProgress`),
+	regexp.MustCompile(`(?i)status code:? 429|Too Many Requests|rate limit`),
+	// Network errors
+	regexp.MustCompile(`(?i)connection (refused|reset)|i/o timeout|TLS handshake timeout|no such host|unexpected EOF|broken pipe`),
+}
+
+// IsTransientFailure tells from the output and error of a failed cf command whether it failed
+// for a reason which may go away when the command is retried. Only the error and what the cf CLI
+// reports after its FAILED line are looked at, not the staging and app logs before it, which may
+// well tell of network errors of the app itself.
+func IsTransientFailure(output []string, err error) bool {
+	text := ""
+	for i := len(output) - 1; i >= 0; i-- {
+		if strings.TrimSpace(output[i]) == "FAILED" {
+			text = strings.Join(output[i+1:], "\n")
+			break
+		}
+	}
+	if err != nil {
+		text = text + "\n" + err.Error()
+	}
+
+	for _, pattern := range transientFailures {
+		if pattern.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// run sends a request, such as a cf command, and sends it again while it fails with a transient
+// error, reporting every retry of the command to out.
+func (r RetryPolicy) run(out io.Writer, command string, request func() ([]string, error)) ([]string, error) {
+	for retry := 1; ; retry++ {
+		output, err := request()
+		if err == nil || retry > r.Retries || !IsTransientFailure(output, err) {
+			return output, err
+		}
+
+		delay := r.Delay(retry)
+		fmt.Fprintf(out, "cf %s failed with a transient error, retrying in %v (retry %d of %d)\n",
+			command, delay, retry, r.Retries)
+		time.Sleep(delay)
+	}
+}
+
+// idempotentCommands are the cf commands which leave the same state behind however often they
+// run, so that running one again is safe even when its failure came after it took effect.
+var idempotentCommands = map[string]bool{
+	"push":         true,
+	"scale":        true,
+	"delete":       true,
+	"delete-route": true,
+	"ssh-enabled":  true,
+	"enable-ssh":   true,
+	"disable-ssh":  true,
+}
+
+// cliCommand runs a cf command, retrying it according to the retry policy while it fails with
+// a transient error. Commands which are not idempotent are run once.
+func (p *BlueGreenDeploy) cliCommand(args ...string) ([]string, error) {
+	command := func() ([]string, error) {
+		return p.Connection.CliCommand(args...)
+	}
+	if !idempotentCommands[args[0]] {
+		return command()
+	}
+	return p.Retry.run(p.Out, args[0], command)
+}
+
+// api is a Cloud Controller client whose requests are retried like the cf commands, except for
+// the POST requests, which create things and would create them twice when a request which failed
+// had taken effect.
+func (p *BlueGreenDeploy) api() *cfapi.Client {
+	return retryingClient(p.Connection, p.Retry, p.Out)
+}
+
+// getApp looks an app up through the connection, retrying like the cf commands. The rest of the
+// deployment relies on the answer, so an app which only seems to be missing while the Cloud
+// Controller is unavailable must not be taken for one which does not exist.
+func (p *BlueGreenDeploy) getApp(appName string) (plugin_models.GetAppModel, error) {
+	var appModel plugin_models.GetAppModel
+	_, err := p.Retry.run(p.Out, "app", func() ([]string, error) {
+		var err error
+		appModel, err = p.Connection.GetApp(appName)
+		return nil, err
+	})
+	return appModel, err
+}
+
+func (p *BlueGreenDeploy) getApps() ([]plugin_models.GetAppsModel, error) {
+	var apps []plugin_models.GetAppsModel
+	_, err := p.Retry.run(p.Out, "apps", func() ([]string, error) {
+		var err error
+		apps, err = p.Connection.GetApps()
+		return nil, err
+	})
+	return apps, err
+}
+
+func retryingClient(connection plugin.CliConnection, retry RetryPolicy, out io.Writer) *cfapi.Client {
+	client := cfapi.NewClient(connection)
+	client.Retry = func(method string, request func() ([]string, error)) ([]string, error) {
+		if method == "POST" {
+			return request()
+		}
+		return retry.run(out, "curl", request)
+	}
+	return client
+}
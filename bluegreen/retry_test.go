@@ -0,0 +1,194 @@
+package bluegreen_test
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin/models"
+	"code.cloudfoundry.org/cli/plugin/pluginfakes"
+	. "github.com/bluemixgaragelondon/cf-blue-green-deploy/bluegreen"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Retries", func() {
+	DescribeTable("classifying failures",
+		func(output string, transient bool) {
+			Expect(IsTransientFailure([]string{"FAILED", output}, errors.New("Error executing cli core command"))).To(Equal(transient))
+		},
+		Entry("gateway errors", "Server error, status code: 502, error code: 0, message: ", true),
+		Entry("unavailable Cloud Controller", "503 Service Unavailable", true),
+		Entry("service operations in progress", "CF-AsyncServiceInstanceOperationInProgress", true),
+		Entry("rate limiting", "Server error, status code: 429, error code: 10013", true),
+		Entry("network errors", "dial tcp 10.0.0.1:443: connect: connection refused", true),
+		Entry("timeouts", "net/http: TLS handshake timeout", true),
+		Entry("missing apps", "App my-app not found", false),
+		Entry("client errors", "Server error, status code: 400, error code: 210003, message: The host is taken", false),
+	)
+
+	It("does not classify the logs which the cf CLI shows before it fails", func() {
+		output := []string{
+			"[APP/PROC/WEB/0] ERR dial tcp db.internal:5432: connect: connection refused",
+			"FAILED",
+			"Start unsuccessful",
+		}
+		Expect(IsTransientFailure(output, errors.New("Error executing cli core command"))).To(BeFalse())
+	})
+
+	It("doubles the delay up to a maximum", func() {
+		policy := RetryPolicy{Retries: 10, InitialDelay: 2 * time.Second}
+
+		Expect(policy.Delay(1)).To(Equal(2 * time.Second))
+		Expect(policy.Delay(2)).To(Equal(4 * time.Second))
+		Expect(policy.Delay(4)).To(Equal(16 * time.Second))
+		Expect(policy.Delay(9)).To(Equal(30 * time.Second))
+	})
+
+	Context("when running cf commands", func() {
+		var (
+			connection *pluginfakes.FakeCliConnection
+			p          BlueGreenDeploy
+			errs       []error
+			failures   []string
+		)
+
+		BeforeEach(func() {
+			errs = []error{}
+			failures = []string{}
+			connection = &pluginfakes.FakeCliConnection{}
+			connection.CliCommandStub = func(args ...string) ([]string, error) {
+				if len(failures) == 0 {
+					return []string{"OK"}, nil
+				}
+				failure := failures[0]
+				failures = failures[1:]
+				return []string{"FAILED", failure}, errors.New("Error executing cli core command")
+			}
+			connection.GetAppReturns(plugin_models.GetAppModel{}, errors.New("App not found"))
+			p = BlueGreenDeploy{
+				Connection: connection,
+				Out:        &bytes.Buffer{},
+				ErrorFunc:  func(message string, err error) { errs = append(errs, err) },
+				Retry:      RetryPolicy{Retries: 2, InitialDelay: time.Millisecond},
+			}
+		})
+
+		It("retries transient failures", func() {
+			failures = []string{"Server error, status code: 502", "Server error, status code: 504"}
+
+			p.ScaleApp("app-old", 2)
+
+			Expect(connection.CliCommandCallCount()).To(Equal(3))
+			Expect(errs).To(BeEmpty())
+		})
+
+		It("gives up after the configured number of retries", func() {
+			failures = []string{"status code: 502", "status code: 502", "status code: 502"}
+
+			p.ScaleApp("app-old", 2)
+
+			Expect(connection.CliCommandCallCount()).To(Equal(3))
+			Expect(errs).To(HaveLen(1))
+		})
+
+		It("does not retry other failures", func() {
+			failures = []string{"App app-old not found"}
+
+			p.ScaleApp("app-old", 2)
+
+			Expect(connection.CliCommandCallCount()).To(Equal(1))
+			Expect(errs).To(HaveLen(1))
+		})
+
+		Context("when a route mapping fails after it took effect", func() {
+			It("retries it without mapping the route twice", func() {
+				cc := newFakeCloudController("www.example.com")
+				failed := false
+				connection.CliCommandWithoutTerminalOutputStub = func(args ...string) ([]string, error) {
+					output, err := cc.curl(args...)
+					if !failed && len(args) > 3 && args[3] == "POST" {
+						failed = true
+						return []string{"502 Bad Gateway: Registered endpoint failed to handle the request."}, nil
+					}
+					return output, err
+				}
+				connection.GetAppReturns(plugin_models.GetAppModel{Name: "app-new", Guid: "app-new"}, nil)
+
+				err := p.MapRoutesToApp("app-new", plugin_models.GetApp_RouteSummary{Host: "www", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}})
+
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cc.changes).To(Equal([]string{"POST /v3/routes/www.example.com/destinations"}))
+				Expect(cc.routes["www.example.com"]).To(Equal([]string{"app-new"}))
+			})
+		})
+	})
+
+	Context("when talking to the Cloud Controller", func() {
+		var (
+			connection *pluginfakes.FakeCliConnection
+			p          BlueGreenDeploy
+		)
+
+		BeforeEach(func() {
+			connection = &pluginfakes.FakeCliConnection{}
+			p = BlueGreenDeploy{
+				Connection: connection,
+				Out:        &bytes.Buffer{},
+				Retry:      RetryPolicy{Retries: 2, InitialDelay: time.Millisecond},
+			}
+		})
+
+		It("retries requests which the router fails with a gateway error", func() {
+			responses := []string{
+				"502 Bad Gateway: Registered endpoint failed to handle the request.",
+				`{"guid": "deployment-guid", "status": {"value": "FINALIZED", "reason": "DEPLOYED"}}`,
+			}
+			connection.CliCommandWithoutTerminalOutputStub = func(args ...string) ([]string, error) {
+				response := responses[0]
+				responses = responses[1:]
+				return []string{response}, nil
+			}
+
+			status, err := p.DeploymentStatus("deployment-guid")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(status.Deployed()).To(BeTrue())
+			Expect(connection.CliCommandWithoutTerminalOutputCallCount()).To(Equal(2))
+		})
+
+		It("does not retry Cloud Controller errors which will not go away", func() {
+			connection.CliCommandWithoutTerminalOutputReturns([]string{`{"errors": [{"code": 10010, "title": "CF-ResourceNotFound", "detail": "Deployment not found"}]}`}, nil)
+
+			_, err := p.DeploymentStatus("deployment-guid")
+
+			Expect(err).To(MatchError("CF-ResourceNotFound: Deployment not found"))
+			Expect(connection.CliCommandWithoutTerminalOutputCallCount()).To(Equal(1))
+		})
+
+		It("sends requests which create things once", func() {
+			connection.CliCommandWithoutTerminalOutputReturns([]string{"502 Bad Gateway: Registered endpoint failed to handle the request."}, nil)
+
+			err := p.CancelDeployment("deployment-guid")
+
+			Expect(err).To(HaveOccurred())
+			Expect(connection.CliCommandWithoutTerminalOutputCallCount()).To(Equal(1))
+		})
+
+		It("retries looking up apps", func() {
+			connection.GetAppStub = func(appName string) (plugin_models.GetAppModel, error) {
+				if connection.GetAppCallCount() == 1 {
+					return plugin_models.GetAppModel{}, errors.New("Server error, status code: 502")
+				}
+				return plugin_models.GetAppModel{Name: appName, Guid: "app-guid"}, nil
+			}
+			connection.CliCommandWithoutTerminalOutputReturns([]string{`{"resources": []}`}, nil)
+
+			_, err := p.AppRevisions("app-name")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(connection.GetAppCallCount()).To(Equal(2))
+		})
+	})
+})
@@ -0,0 +1,311 @@
+package bluegreen
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/internal/cfapi"
+)
+
+// deployedRevisionLabel marks the app revisions which cf bgd deployed successfully as deployed,
+// and those a rollback then moved the app away from as rolled-back.
+const deployedRevisionLabel = "blue-green-deploy"
+
+// previousDropletLabel holds the droplet of the version a blue-green deploy replaced, copied to
+// the new version of the app.
+const previousDropletLabel = "blue-green-deploy-previous-droplet"
+
+// AppRevision is a CF v3 app revision: a droplet together with the environment and process
+// configuration the app ran with.
+type AppRevision struct {
+	Guid        string
+	Version     int
+	Description string
+	CreatedAt   string
+	Deployable  bool
+
+	// Deployed is set for the revisions which were labelled by a successful cf bgd deploy
+	Deployed bool
+
+	// Current is set for the revisions of the droplet the app runs
+	Current bool
+}
+
+// AppRevisions lists the revisions of an app, newest first.
+func (p *BlueGreenDeploy) AppRevisions(appName string) ([]AppRevision, error) {
+	appModel, err := p.getApp(appName)
+	if err != nil {
+		return nil, fmt.Errorf("Could not find app %s: %v", appName, err)
+	}
+	api := p.api()
+
+	revisions, err := api.Revisions(appModel.Guid)
+	if err != nil {
+		return nil, err
+	}
+	droplet, err := api.CurrentDroplet(appModel.Guid)
+	if err != nil {
+		return nil, fmt.Errorf("Could not find the current droplet of %s: %v", appName, err)
+	}
+	// The versions of the revisions of an app only go up, whatever order they were listed in
+	sort.SliceStable(revisions, func(i, j int) bool {
+		return revisions[i].Version > revisions[j].Version
+	})
+
+	appRevisions := []AppRevision{}
+	for _, revision := range revisions {
+		appRevisions = append(appRevisions, AppRevision{
+			Guid:        revision.Guid,
+			Version:     revision.Version,
+			Description: revision.Description,
+			CreatedAt:   revision.CreatedAt,
+			Deployable:  revision.Deployable,
+			Deployed:    revision.Metadata.Labels[deployedRevisionLabel] == "deployed",
+			Current:     revision.Droplet.Guid == droplet.Guid,
+		})
+	}
+	return appRevisions, nil
+}
+
+// LabelCurrentRevision labels the newest revision of an app as deployed, so that a later
+// rollback can tell the revisions cf bgd deployed from intermediate ones.
+func (p *BlueGreenDeploy) LabelCurrentRevision(appName string) error {
+	revisions, err := p.AppRevisions(appName)
+	if err != nil {
+		return err
+	}
+	if len(revisions) == 0 {
+		return fmt.Errorf("App %s has no revisions", appName)
+	}
+
+	labels := map[string]string{deployedRevisionLabel: "deployed"}
+	return p.api().SetLabels("/v3/revisions/"+revisions[0].Guid, labels)
+}
+
+// LabelRolledBackRevisions labels revisions a rollback moved an app away from, so that a later
+// rollback goes further back instead of returning to them.
+func (p *BlueGreenDeploy) LabelRolledBackRevisions(revisionGuids ...string) error {
+	labels := map[string]string{deployedRevisionLabel: "rolled-back"}
+	for _, revisionGuid := range revisionGuids {
+		if err := p.api().SetLabels("/v3/revisions/"+revisionGuid, labels); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RollbackToRevision starts a rolling deployment of an earlier revision of an app and
+// returns the deployment guid.
+func (p *BlueGreenDeploy) RollbackToRevision(appName string, revisionGuid string) (string, error) {
+	appModel, err := p.getApp(appName)
+	if err != nil {
+		return "", fmt.Errorf("Could not find app %s: %v", appName, err)
+	}
+
+	deployment, err := p.api().CreateDeployment(appModel.Guid, revisionGuid)
+	if err != nil {
+		return "", err
+	}
+	return deployment.Guid, nil
+}
+
+// KeepPreviousDroplet copies the current droplet of the live app to the new version of the app
+// and labels the new version with it. Revisions belong to a single app, so this is what a
+// rollback of an app deployed blue-green goes back to once APP-old has been deleted.
+func (p *BlueGreenDeploy) KeepPreviousDroplet(newAppName string, liveAppName string) error {
+	api := p.api()
+
+	liveApp, err := p.getApp(liveAppName)
+	if err != nil {
+		return fmt.Errorf("Could not find app %s: %v", liveAppName, err)
+	}
+	newApp, err := p.getApp(newAppName)
+	if err != nil {
+		return fmt.Errorf("Could not find app %s: %v", newAppName, err)
+	}
+
+	droplet, err := api.CurrentDroplet(liveApp.Guid)
+	if err != nil {
+		return err
+	}
+	copied, err := api.CopyDroplet(droplet.Guid, newApp.Guid)
+	if err != nil {
+		return err
+	}
+	return api.SetLabels("/v3/apps/"+newApp.Guid, map[string]string{previousDropletLabel: copied.Guid})
+}
+
+// PreviousDroplet is the droplet of the version a blue-green deploy of the app replaced, or ""
+// when there is none.
+func (p *BlueGreenDeploy) PreviousDroplet(appName string) (string, error) {
+	appModel, err := p.getApp(appName)
+	if err != nil {
+		return "", fmt.Errorf("Could not find app %s: %v", appName, err)
+	}
+
+	app, err := p.api().App(appModel.Guid)
+	if err != nil {
+		return "", err
+	}
+	return app.Metadata.Labels[previousDropletLabel], nil
+}
+
+// ForgetPreviousDroplet removes the label with the previous droplet from an app once it has been
+// rolled back to it, so that a later rollback does not deploy it again.
+func (p *BlueGreenDeploy) ForgetPreviousDroplet(appName string) error {
+	appModel, err := p.getApp(appName)
+	if err != nil {
+		return fmt.Errorf("Could not find app %s: %v", appName, err)
+	}
+	return p.api().SetLabels("/v3/apps/"+appModel.Guid, map[string]string{previousDropletLabel: ""})
+}
+
+// RollbackToDroplet starts a rolling deployment of a droplet of an app and returns the
+// deployment guid.
+func (p *BlueGreenDeploy) RollbackToDroplet(appName string, dropletGuid string) (string, error) {
+	appModel, err := p.getApp(appName)
+	if err != nil {
+		return "", fmt.Errorf("Could not find app %s: %v", appName, err)
+	}
+
+	deployment, err := p.api().CreateDropletDeployment(appModel.Guid, dropletGuid, cfapi.DeploymentScale{})
+	if err != nil {
+		return "", err
+	}
+	return deployment.Guid, nil
+}
+
+// RollbackTarget picks the revision to roll back to from the revisions of an app, newest first:
+// the given revision, or else the newest revision deployed by cf bgd before the first revision of
+// the droplet the app runs. Revisions after that were either rolled back from or, after an
+// earlier rollback, run the same droplet again.
+func RollbackTarget(revisions []AppRevision, revisionGuid string) (AppRevision, error) {
+	if revisionGuid != "" {
+		for _, revision := range revisions {
+			if revision.Guid == revisionGuid {
+				return revision, nil
+			}
+		}
+		return AppRevision{}, fmt.Errorf("Could not find revision %s", revisionGuid)
+	}
+
+	earlier := revisions
+	for i, revision := range revisions {
+		if revision.Current {
+			earlier = revisions[i+1:]
+		}
+	}
+	for _, revision := range earlier {
+		if revision.Deployed && revision.Deployable {
+			return revision, nil
+		}
+	}
+	return AppRevision{}, errors.New("There is no earlier deployed revision to roll back to")
+}
+
+// Rollback moves the live app back to an earlier revision through a v3 deployment. An app deployed
+// blue-green has only the revisions of its own version, so without an earlier deployed revision
+// it goes back to the droplet of the version its last deploy replaced.
+func (p *Orchestrator) Rollback(opts Options) (Result, error) {
+	appName := opts.AppName
+	result := Result{AppName: appName, ReplacedLiveApp: true}
+
+	revisions, err := p.Deployer.AppRevisions(appName)
+	if err != nil {
+		return result, fmt.Errorf("Could not list the revisions of %s: %v", appName, err)
+	}
+
+	fmt.Fprintf(p.Out, "Revisions of %s:\n", appName)
+	for _, revision := range revisions {
+		marker := ""
+		if revision.Deployed {
+			marker = " (deployed)"
+		}
+		fmt.Fprintf(p.Out, "  %d  %s  %s  %s%s\n", revision.Version, revision.Guid, revision.CreatedAt, revision.Description, marker)
+	}
+
+	var deploymentGuid, rolledBackTo string
+	target, err := RollbackTarget(revisions, opts.Revision)
+	if err != nil && opts.Revision == "" {
+		dropletGuid, dropletErr := p.Deployer.PreviousDroplet(appName)
+		if dropletErr != nil {
+			return result, fmt.Errorf("Could not find the previous droplet of %s: %v", appName, dropletErr)
+		}
+		if dropletGuid == "" {
+			return result, err
+		}
+
+		fmt.Fprintf(p.Out, "Rolling back %s to the droplet of its previous version (%s)\n", appName, dropletGuid)
+		if deploymentGuid, err = p.Deployer.RollbackToDroplet(appName, dropletGuid); err != nil {
+			return result, fmt.Errorf("Could not roll back %s: %v", appName, err)
+		}
+		result.DropletGuid = dropletGuid
+		rolledBackTo = "the previous droplet"
+	} else if err != nil {
+		return result, err
+	} else {
+		fmt.Fprintf(p.Out, "Rolling back %s to revision %d (%s)\n", appName, target.Version, target.Guid)
+		if deploymentGuid, err = p.Deployer.RollbackToRevision(appName, target.Guid); err != nil {
+			return result, fmt.Errorf("Could not roll back %s: %v", appName, err)
+		}
+		result.RevisionGuid = target.Guid
+		rolledBackTo = fmt.Sprintf("revision %d", target.Version)
+	}
+
+	status, err := p.waitForDeployment(deploymentGuid, opts.RollingTimeout)
+	if err != nil {
+		fmt.Fprintf(p.Out, "%v, cancelling the rollback of %s\n", err, appName)
+		p.cancelDeployment(deploymentGuid)
+		return result, ErrRollbackFailed
+	}
+	if !status.Deployed() {
+		fmt.Fprintf(p.Out, "The rollback of %s did not finish: %s\n", appName, status.Reason)
+		return result, ErrRollbackFailed
+	}
+
+	p.recordDeployedRevision(appName)
+	p.recordRollback(appName, revisions, result)
+	p.emit(Event{Type: EventRolledBack, App: appName, Message: rolledBackTo})
+	return result, nil
+}
+
+// recordRollback labels the deployed revisions newer than the one a rollback went back to, and
+// forgets the previous droplet once the app runs it, so that the next rollback goes further
+// back. Like recordDeployedRevision, a failure is only reported.
+func (p *Orchestrator) recordRollback(appName string, revisions []AppRevision, result Result) {
+	rolledBack := []string{}
+	for _, revision := range revisions {
+		if revision.Guid == result.RevisionGuid {
+			break
+		}
+		if revision.Deployed {
+			rolledBack = append(rolledBack, revision.Guid)
+		}
+	}
+	if err := p.Deployer.LabelRolledBackRevisions(rolledBack...); err != nil {
+		fmt.Fprintf(p.Out, "Could not label the revisions %s was rolled back from: %v\n", appName, err)
+	}
+
+	if result.DropletGuid != "" {
+		if err := p.Deployer.ForgetPreviousDroplet(appName); err != nil {
+			fmt.Fprintf(p.Out, "Could not remove the previous droplet label of %s: %v\n", appName, err)
+		}
+	}
+}
+
+// recordDeployedRevision labels the revision a successful deploy left running. Foundations
+// without app revisions still deploy fine, so a failure is only reported.
+func (p *Orchestrator) recordDeployedRevision(appName string) {
+	if err := p.Deployer.LabelCurrentRevision(appName); err != nil {
+		fmt.Fprintf(p.Out, "Could not record the deployed revision of %s: %v\n", appName, err)
+	}
+}
+
+// keepPreviousDroplet hands the droplet of the live app over to the new version before the live
+// app is renamed, and possibly deleted. Like recordDeployedRevision, a failure is only reported.
+func (p *Orchestrator) keepPreviousDroplet(newAppName string, liveAppName string) {
+	if err := p.Deployer.KeepPreviousDroplet(newAppName, liveAppName); err != nil {
+		fmt.Fprintf(p.Out, "Could not keep the droplet of %s for a rollback: %v\n", liveAppName, err)
+	}
+}
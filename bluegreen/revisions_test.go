@@ -0,0 +1,262 @@
+package bluegreen_test
+
+import (
+	"bytes"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin/models"
+	"code.cloudfoundry.org/cli/plugin/pluginfakes"
+	. "github.com/bluemixgaragelondon/cf-blue-green-deploy/bluegreen"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Revisions", func() {
+	revisions := []AppRevision{
+		{Guid: "current", Version: 4, Deployable: true, Deployed: true, Current: true},
+		{Guid: "intermediate", Version: 3, Deployable: true},
+		{Guid: "previous", Version: 2, Deployable: true, Deployed: true},
+		{Guid: "first", Version: 1, Deployable: true, Deployed: true},
+	}
+
+	Describe("RollbackTarget", func() {
+		It("picks the newest deployed revision before the current one", func() {
+			target, err := RollbackTarget(revisions, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(target.Guid).To(Equal("previous"))
+		})
+
+		It("skips the revisions newer than the one the app runs", func() {
+			target, err := RollbackTarget(append([]AppRevision{{Guid: "rolled-forward", Version: 5, Deployable: true, Deployed: true}}, revisions...), "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(target.Guid).To(Equal("previous"))
+		})
+
+		It("goes further back after a rollback, which runs an earlier droplet again", func() {
+			rolledBack := []AppRevision{
+				{Guid: "rollback", Version: 4, Deployable: true, Deployed: true, Current: true},
+				{Guid: "rolled-back", Version: 3, Deployable: true},
+				{Guid: "previous", Version: 2, Deployable: true, Deployed: true, Current: true},
+				{Guid: "first", Version: 1, Deployable: true, Deployed: true},
+			}
+
+			target, err := RollbackTarget(rolledBack, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(target.Guid).To(Equal("first"))
+		})
+
+		It("picks the given revision", func() {
+			target, err := RollbackTarget(revisions, "intermediate")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(target.Version).To(Equal(3))
+		})
+
+		It("fails for an unknown revision", func() {
+			_, err := RollbackTarget(revisions, "unknown")
+			Expect(err).To(MatchError("Could not find revision unknown"))
+		})
+
+		It("fails when nothing was deployed before", func() {
+			_, err := RollbackTarget(revisions[:2], "")
+			Expect(err).To(MatchError("There is no earlier deployed revision to roll back to"))
+		})
+	})
+
+	Context("when rolling back", func() {
+		var (
+			b   *BlueGreenDeployFake
+			p   Orchestrator
+			out *bytes.Buffer
+		)
+
+		BeforeEach(func() {
+			b = &BlueGreenDeployFake{revisions: revisions}
+			out = &bytes.Buffer{}
+			p = Orchestrator{Deployer: b, Out: out}
+		})
+
+		It("deploys the previous revision and waits for it", func() {
+			result, err := p.Rollback(mustParseArgs([]string{"bgd", "app-name", "--rollback"}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RevisionGuid).To(Equal("previous"))
+
+			Expect(b.flow).To(Equal([]string{
+				"list revisions of app-name",
+				"roll back app-name to previous",
+				"status of rollback-guid",
+				"label rolled back revisions current",
+			}))
+			Expect(b.labelledRevisions).To(Equal([]string{"app-name"}))
+			Expect(out.String()).To(ContainSubstring("  2  previous"))
+		})
+
+		It("cancels the rollback when it does not finish in time", func() {
+			b.deploymentStatuses = []DeploymentStatus{{Value: "ACTIVE", Reason: "DEPLOYING"}}
+
+			_, err := p.Rollback(mustParseArgs([]string{"bgd", "app-name", "--rollback", "--revision", "first", "--rolling-timeout", "1ns"}))
+			Expect(err).To(Equal(ErrRollbackFailed))
+			Expect(b.flow[1:]).To(Equal([]string{
+				"roll back app-name to first",
+				"status of rollback-guid",
+				"cancel rollback-guid",
+			}))
+		})
+	})
+
+	Context("when the app was deployed blue-green", func() {
+		It("rolls back to the droplet of the version the deploy replaced", func() {
+			b := &BlueGreenDeployFake{
+				liveApp:       &plugin_models.GetAppModel{Name: "app-name"},
+				passSmokeTest: true,
+				// The new app only has the revision of its own version
+				revisions: []AppRevision{{Guid: "first", Version: 1, Deployable: true, Deployed: true, Current: true}},
+			}
+			p := Orchestrator{Deployer: b, Out: &bytes.Buffer{}}
+
+			_, err := p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{},
+				mustParseArgs([]string{"bgd", "app-name", "--delete-old-apps"}))
+			Expect(err).NotTo(HaveOccurred())
+
+			b.flow = nil
+			result, err := p.Rollback(mustParseArgs([]string{"bgd", "app-name", "--rollback"}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.DropletGuid).To(Equal("app-name-droplet"))
+			Expect(b.flow).To(Equal([]string{
+				"list revisions of app-name",
+				"roll back app-name to droplet app-name-droplet",
+				"status of rollback-guid",
+				"label rolled back revisions first",
+				"forget previous droplet of app-name",
+			}))
+
+			// The droplet is not deployed again by a second rollback
+			_, err = p.Rollback(mustParseArgs([]string{"bgd", "app-name", "--rollback"}))
+			Expect(err).To(MatchError("There is no earlier deployed revision to roll back to"))
+		})
+
+		It("fails when the app has never replaced a version", func() {
+			b := &BlueGreenDeployFake{revisions: []AppRevision{{Guid: "first", Version: 1, Deployable: true, Deployed: true, Current: true}}}
+			p := Orchestrator{Deployer: b, Out: &bytes.Buffer{}}
+
+			_, err := p.Rollback(mustParseArgs([]string{"bgd", "app-name", "--rollback"}))
+			Expect(err).To(MatchError("There is no earlier deployed revision to roll back to"))
+		})
+	})
+
+	It("records the revision of a successful deploy", func() {
+		b := &BlueGreenDeployFake{passSmokeTest: true}
+		p := Orchestrator{Deployer: b, Out: &bytes.Buffer{}}
+
+		_, err := p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{}, mustParseArgs([]string{"bgd", "app-name"}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b.labelledRevisions).To(Equal([]string{"app-name"}))
+	})
+
+	Describe("on Cloud Foundry", func() {
+		var (
+			connection *pluginfakes.FakeCliConnection
+			p          BlueGreenDeploy
+			curlCalls  []string
+		)
+
+		BeforeEach(func() {
+			curlCalls = []string{}
+			connection = &pluginfakes.FakeCliConnection{}
+			connection.GetAppStub = func(name string) (plugin_models.GetAppModel, error) {
+				return plugin_models.GetAppModel{Name: name, Guid: name + "-guid"}, nil
+			}
+			connection.CliCommandWithoutTerminalOutputStub = func(args ...string) ([]string, error) {
+				curlCalls = append(curlCalls, strings.Join(args, " "))
+				switch {
+				case strings.Contains(args[1], "/revisions?"):
+					return []string{`{"resources": [
+						{"guid": "rev-1", "version": 1, "deployable": true, "droplet": {"guid": "old-droplet"}, "metadata": {"labels": {"blue-green-deploy": "deployed"}}},
+						{"guid": "rev-2", "version": 2, "deployable": true, "droplet": {"guid": "live-droplet"}, "metadata": {"labels": {}}}
+					]}`}, nil
+				case args[1] == "/v3/deployments":
+					return []string{`{"guid": "deployment-guid"}`}, nil
+				case strings.HasSuffix(args[1], "/droplets/current"):
+					return []string{`{"guid": "live-droplet"}`}, nil
+				case strings.HasPrefix(args[1], "/v3/droplets?"):
+					return []string{`{"guid": "copied-droplet", "state": "COPYING"}`}, nil
+				case args[1] == "/v3/apps/app-name-guid":
+					return []string{`{"guid": "app-name-guid", "metadata": {"labels": {"blue-green-deploy-previous-droplet": "copied-droplet"}}}`}, nil
+				default:
+					return []string{`{}`}, nil
+				}
+			}
+			p = BlueGreenDeploy{Connection: connection, Out: &bytes.Buffer{}}
+		})
+
+		It("lists the revisions newest first with their deployed label and whether the app runs their droplet", func() {
+			appRevisions, err := p.AppRevisions("app-name")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(appRevisions).To(Equal([]AppRevision{
+				{Guid: "rev-2", Version: 2, Deployable: true, Current: true},
+				{Guid: "rev-1", Version: 1, Deployable: true, Deployed: true},
+			}))
+			Expect(curlCalls).To(Equal([]string{
+				"curl /v3/apps/app-name-guid/revisions?order_by=-created_at&per_page=5000",
+				"curl /v3/apps/app-name-guid/droplets/current",
+			}))
+		})
+
+		It("labels the newest revision", func() {
+			Expect(p.LabelCurrentRevision("app-name")).To(Succeed())
+
+			Expect(curlCalls[2]).To(Equal(`curl /v3/revisions/rev-2 -X PATCH -d {"metadata":{"labels":{"blue-green-deploy":"deployed"}}}`))
+		})
+
+		It("labels the revisions a rollback moved away from", func() {
+			Expect(p.LabelRolledBackRevisions("rev-2")).To(Succeed())
+
+			Expect(curlCalls).To(Equal([]string{
+				`curl /v3/revisions/rev-2 -X PATCH -d {"metadata":{"labels":{"blue-green-deploy":"rolled-back"}}}`,
+			}))
+		})
+
+		It("removes the previous droplet label", func() {
+			Expect(p.ForgetPreviousDroplet("app-name")).To(Succeed())
+
+			Expect(curlCalls).To(Equal([]string{
+				`curl /v3/apps/app-name-guid -X PATCH -d {"metadata":{"labels":{"blue-green-deploy-previous-droplet":null}}}`,
+			}))
+		})
+
+		It("deploys a revision", func() {
+			guid, err := p.RollbackToRevision("app-name", "rev-1")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(guid).To(Equal("deployment-guid"))
+			Expect(curlCalls).To(Equal([]string{
+				`curl /v3/deployments -X POST -d {"relationships":{"app":{"data":{"guid":"app-name-guid"}}},"revision":{"guid":"rev-1"}}`,
+			}))
+		})
+
+		It("copies the droplet of the live app to the new version and labels it", func() {
+			Expect(p.KeepPreviousDroplet("app-name-new", "app-name")).To(Succeed())
+
+			Expect(curlCalls).To(Equal([]string{
+				"curl /v3/apps/app-name-guid/droplets/current",
+				`curl /v3/droplets?source_guid=live-droplet -X POST -d {"relationships":{"app":{"data":{"guid":"app-name-new-guid"}}}}`,
+				`curl /v3/apps/app-name-new-guid -X PATCH -d {"metadata":{"labels":{"blue-green-deploy-previous-droplet":"copied-droplet"}}}`,
+			}))
+		})
+
+		It("finds the previous droplet and deploys it", func() {
+			dropletGuid, err := p.PreviousDroplet("app-name")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dropletGuid).To(Equal("copied-droplet"))
+
+			guid, err := p.RollbackToDroplet("app-name", dropletGuid)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(guid).To(Equal("deployment-guid"))
+			Expect(curlCalls[1]).To(Equal(
+				`curl /v3/deployments -X POST -d {"droplet":{"guid":"copied-droplet"},"strategy":"rolling","relationships":{"app":{"data":{"guid":"app-name-guid"}}}}`,
+			))
+		})
+	})
+})
@@ -0,0 +1,338 @@
+package bluegreen
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/internal/cfapi"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
+)
+
+const StrategyRolling = "rolling"
+
+// rollingPollInterval is how often the status of a rolling deployment is checked.
+var rollingPollInterval = 5 * time.Second
+
+// DeploymentStatus is the status of a CF v3 deployment. A deployment is ACTIVE while instances
+// are being replaced and FINALIZED once it is done, with the reason telling how it ended.
+type DeploymentStatus struct {
+	Value  string `json:"value"`
+	Reason string `json:"reason"`
+}
+
+func (s DeploymentStatus) Finalized() bool {
+	return s.Value == "FINALIZED"
+}
+
+func (s DeploymentStatus) Deployed() bool {
+	return s.Finalized() && s.Reason == "DEPLOYED"
+}
+
+// rollingManifestKeys are the app properties of a manifest a rolling deployment can honour. The
+// routes are worked out like those of any deployment, and the rest is applied to the new
+// version. Properties starting with x- are extensions cf push does not read either.
+var rollingManifestKeys = map[string]bool{
+	"name": true, "path": true, "env": true, "instances": true, "memory": true, "disk_quota": true,
+	"routes": true, "host": true, "hosts": true, "domain": true, "domains": true,
+	"no-hostname": true, "random-route": true, "no-route": true,
+}
+
+// StartRollingDeployment uploads and stages a new version of appName and starts a rolling
+// deployment of its droplet, returning the deployment guid as soon as Cloud Foundry has started
+// it. The files and environment of the app are taken from the manifest as cf push would, and the
+// scale is given to the new instances only. Everything goes through cf curl, so this works with
+// cf CLIs which cannot push with a strategy.
+func (p *BlueGreenDeploy) StartRollingDeployment(appName string, manifestPath string, scaleParameters ScaleParameters) (string, error) {
+	appPath, environment, err := p.rollingAppData(appName, manifestPath)
+	if err != nil {
+		return "", fmt.Errorf("Could not read the manifest: %v", err)
+	}
+
+	appModel, err := p.getApp(appName)
+	if err != nil {
+		return "", fmt.Errorf("Could not find app %s: %v", appName, err)
+	}
+	api := p.api()
+
+	// The new revision takes its environment from the app. The running instances keep theirs
+	// until they are replaced, and the app gets its old environment back when the new version
+	// is not deployed.
+	restoreEnvironment, err := setEnvironment(api, appModel.Guid, environment)
+	if err != nil {
+		return "", fmt.Errorf("Could not set the environment of %s: %v", appName, err)
+	}
+	scale := cfapi.DeploymentScale{Instances: scaleParameters.InstanceCount, MemoryInMb: scaleParameters.Memory, DiskInMb: scaleParameters.DiskQuota}
+	deploymentGuid, err := p.deployNewDroplet(api, appModel.Guid, appName, appPath, scale)
+	if err != nil {
+		if restoreErr := restoreEnvironment(); restoreErr != nil {
+			fmt.Fprintf(p.Out, "Could not restore the environment of %s: %v\n", appName, restoreErr)
+		}
+		return "", err
+	}
+
+	if p.environmentRestores == nil {
+		p.environmentRestores = map[string]func() error{}
+	}
+	p.environmentRestores[deploymentGuid] = restoreEnvironment
+	return deploymentGuid, nil
+}
+
+func (p *BlueGreenDeploy) deployNewDroplet(api *cfapi.Client, appGuid string, appName string, appPath string, scale cfapi.DeploymentScale) (string, error) {
+	fmt.Fprintf(p.Out, "Uploading %s from %s\n", appName, appPath)
+	pkg, err := api.UploadPackage(appGuid, appPath)
+	if err != nil {
+		return "", fmt.Errorf("Could not upload new version: %v", err)
+	}
+	fmt.Fprintf(p.Out, "Staging %s\n", appName)
+	dropletGuid, err := api.StagePackage(pkg.Guid)
+	if err != nil {
+		return "", err
+	}
+
+	deployment, err := api.CreateDropletDeployment(appGuid, dropletGuid, scale)
+	if err != nil {
+		return "", err
+	}
+	return deployment.Guid, nil
+}
+
+// setEnvironment adds environment variables to an app and returns how to put back the ones it
+// had before.
+func setEnvironment(api *cfapi.Client, appGuid string, environment map[string]interface{}) (func() error, error) {
+	if len(environment) == 0 {
+		return func() error { return nil }, nil
+	}
+	previous, err := api.EnvironmentVariables(appGuid)
+	if err != nil {
+		return nil, err
+	}
+	restored := map[string]interface{}{}
+	for name := range environment {
+		// Variables the app did not have are removed by setting them to null
+		restored[name] = previous[name]
+	}
+
+	if err := api.SetEnvironmentVariables(appGuid, environment); err != nil {
+		return nil, err
+	}
+	return func() error {
+		return api.SetEnvironmentVariables(appGuid, restored)
+	}, nil
+}
+
+// rollingAppData reads the path of the files of an app and its environment from the manifest.
+// Like cf push, it uploads the current directory when there is no manifest. A manifest with
+// properties a rolling deployment cannot apply to the new version, such as buildpacks or
+// services, is rejected rather than having them ignored.
+func (p *BlueGreenDeploy) rollingAppData(appName string, manifestPath string) (string, map[string]interface{}, error) {
+	if p.NoManifest {
+		return ".", nil, nil
+	}
+	m, err := manifest.FileManifestReader{ManifestPath: manifestPath, VarsFiles: p.VarsFiles, Vars: p.Vars}.Read()
+	if err != nil && manifestPath == "" {
+		return ".", nil, nil
+	} else if err != nil {
+		return "", nil, err
+	}
+
+	appPath, err := m.AppPath(appName)
+	if err != nil {
+		return "", nil, err
+	}
+	appData, err := m.AppData(appName)
+	if err != nil {
+		return "", nil, err
+	}
+
+	unsupported := []string{}
+	for key := range appData {
+		if !rollingManifestKeys[key] && !strings.HasPrefix(key, "x-") {
+			unsupported = append(unsupported, key)
+		}
+	}
+	if len(unsupported) > 0 {
+		sort.Strings(unsupported)
+		return "", nil, fmt.Errorf("A rolling deployment cannot apply %s from the manifest, deploy %s with the blue-green strategy instead",
+			strings.Join(unsupported, ", "), appName)
+	}
+
+	// The Cloud Controller only takes strings, where a manifest may have numbers or booleans
+	environment := map[string]interface{}{}
+	switch env := appData["env"].(type) {
+	case map[interface{}]interface{}:
+		for name, value := range env {
+			environment[fmt.Sprint(name)] = fmt.Sprint(value)
+		}
+	case map[string]interface{}:
+		for name, value := range env {
+			environment[name] = fmt.Sprint(value)
+		}
+	}
+	return appPath, environment, nil
+}
+
+func (p *BlueGreenDeploy) DeploymentStatus(deploymentGuid string) (DeploymentStatus, error) {
+	deployment, err := p.api().Deployment(deploymentGuid)
+	return DeploymentStatus(deployment.Status), err
+}
+
+// NewInstancesRunning counts the instances of the new version a deployment has started which
+// are running.
+func (p *BlueGreenDeploy) NewInstancesRunning(deploymentGuid string) (int, error) {
+	api := p.api()
+	deployment, err := api.Deployment(deploymentGuid)
+	if err != nil {
+		return 0, err
+	}
+
+	running := 0
+	for _, process := range deployment.NewProcesses {
+		if process.Type != "web" {
+			continue
+		}
+		instances, err := api.ProcessInstances(process.Guid)
+		if err != nil {
+			return 0, err
+		}
+		for _, instance := range instances {
+			if instance.State == "RUNNING" {
+				running++
+			}
+		}
+	}
+	return running, nil
+}
+
+// CancelDeployment stops a deployment which is still active. Cloud Foundry then moves the app
+// back to the droplet it ran before the deployment started, and the environment the deployment
+// was started with is taken back off the app.
+func (p *BlueGreenDeploy) CancelDeployment(deploymentGuid string) error {
+	if err := p.api().CancelDeployment(deploymentGuid); err != nil {
+		return err
+	}
+	if restoreEnvironment, ok := p.environmentRestores[deploymentGuid]; ok {
+		delete(p.environmentRestores, deploymentGuid)
+		if err := restoreEnvironment(); err != nil {
+			return fmt.Errorf("Could not restore the environment of the app: %v", err)
+		}
+	}
+	return nil
+}
+
+// RollingStrategy replaces the instances of the live app one after another through the CF v3
+// deployments API, without a second app. Once the first new instance is running, the smoke
+// tests run against the app's routes, which then reach old and new instances, and the
+// deployment is cancelled when they fail.
+type RollingStrategy struct{}
+
+func (RollingStrategy) Deploy(p *Orchestrator, deployment *Deployment) (bool, error) {
+	if deployment.LiveAppName == "" {
+		// There is nothing to roll over, so push the first version the usual way
+		return BlueGreenStrategy{}.Deploy(p, deployment)
+	}
+
+	appName := deployment.LiveAppName
+	fmt.Fprintf(p.Out, "Starting a rolling deployment of %s\n", appName)
+	p.emit(Event{Type: EventPushStarted, App: appName})
+	deploymentGuid, err := p.Deployer.StartRollingDeployment(appName, deployment.Options.ManifestPath, deployment.ManifestScale)
+	if err != nil {
+		fmt.Fprintf(p.Out, "Could not start a rolling deployment of %s: %v\n", appName, err)
+		return false, nil
+	}
+	p.emit(Event{Type: EventPushFinished, App: appName})
+
+	if deployment.SmokeTest.Script != "" {
+		status, err := p.waitForNewInstance(deploymentGuid, deployment.Options.RollingTimeout)
+		if err != nil {
+			fmt.Fprintf(p.Out, "%v, cancelling the deployment of %s\n", err, appName)
+			p.cancelDeployment(deploymentGuid)
+			p.emit(Event{Type: EventRolledBack, App: appName, Message: err.Error()})
+			return false, nil
+		}
+		if status.Finalized() && !status.Deployed() {
+			fmt.Fprintf(p.Out, "The deployment of %s did not finish: %s\n", appName, status.Reason)
+			return false, nil
+		}
+	}
+
+	passed, err := p.verifyWave(appName, deployment.SmokeTest, deployment.NewAppRoutes)
+	if err != nil {
+		p.cancelDeployment(deploymentGuid)
+		return false, err
+	}
+	if !passed {
+		fmt.Fprintf(p.Out, "Smoke tests failed, cancelling the deployment of %s\n", appName)
+		p.cancelDeployment(deploymentGuid)
+		p.emit(Event{Type: EventRolledBack, App: appName, Message: "smoke tests failed"})
+		return false, nil
+	}
+
+	status, err := p.waitForDeployment(deploymentGuid, deployment.Options.RollingTimeout)
+	if err != nil {
+		fmt.Fprintf(p.Out, "%v, cancelling the deployment of %s\n", err, appName)
+		p.cancelDeployment(deploymentGuid)
+		p.emit(Event{Type: EventRolledBack, App: appName, Message: err.Error()})
+		return false, nil
+	}
+	if !status.Deployed() {
+		fmt.Fprintf(p.Out, "The deployment of %s did not finish: %s\n", appName, status.Reason)
+		return false, nil
+	}
+
+	if deployment.Options.DeleteOldApps {
+		return true, p.deleteOldButNotFailedVersions(deployment.AppName, deployment.ExcludedRoutes...)
+	}
+	return true, nil
+}
+
+func (p *Orchestrator) waitForDeployment(deploymentGuid string, timeout time.Duration) (DeploymentStatus, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := p.Deployer.DeploymentStatus(deploymentGuid)
+		if err != nil {
+			return status, fmt.Errorf("Could not get the status of the deployment: %v", err)
+		}
+		if status.Finalized() {
+			return status, nil
+		}
+		if timeout > 0 && time.Now().After(deadline) {
+			return status, fmt.Errorf("The deployment did not finish within %v", timeout)
+		}
+		time.Sleep(rollingPollInterval)
+	}
+}
+
+// waitForNewInstance waits until a deployment has a running instance of the new version, so that
+// the smoke tests do not only reach the old instances. A deployment which finishes meanwhile has
+// replaced all of them.
+func (p *Orchestrator) waitForNewInstance(deploymentGuid string, timeout time.Duration) (DeploymentStatus, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := p.Deployer.DeploymentStatus(deploymentGuid)
+		if err != nil {
+			return status, fmt.Errorf("Could not get the status of the deployment: %v", err)
+		}
+		if status.Finalized() {
+			return status, nil
+		}
+		running, err := p.Deployer.NewInstancesRunning(deploymentGuid)
+		if err != nil {
+			return status, fmt.Errorf("Could not get the new instances of the deployment: %v", err)
+		}
+		if running > 0 {
+			return status, nil
+		}
+		if timeout > 0 && time.Now().After(deadline) {
+			return status, fmt.Errorf("No new instance was running within %v", timeout)
+		}
+		time.Sleep(rollingPollInterval)
+	}
+}
+
+func (p *Orchestrator) cancelDeployment(deploymentGuid string) {
+	if err := p.Deployer.CancelDeployment(deploymentGuid); err != nil {
+		fmt.Fprintf(p.Out, "Could not cancel the deployment: %v\n", err)
+	}
+}
@@ -0,0 +1,318 @@
+package bluegreen_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin/models"
+	"code.cloudfoundry.org/cli/plugin/pluginfakes"
+	. "github.com/bluemixgaragelondon/cf-blue-green-deploy/bluegreen"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rolling strategy", func() {
+	var (
+		b   *BlueGreenDeployFake
+		p   Orchestrator
+		out *bytes.Buffer
+	)
+
+	liveRoute := plugin_models.GetApp_RouteSummary{Host: "www", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}}
+
+	BeforeEach(func() {
+		b = &BlueGreenDeployFake{
+			liveApp: &plugin_models.GetAppModel{Name: "app-name",
+				Routes: []plugin_models.GetApp_RouteSummary{liveRoute}},
+			passSmokeTest: true,
+		}
+		out = &bytes.Buffer{}
+		p = Orchestrator{
+			Deployer: b,
+			Out:      out,
+		}
+	})
+
+	deploy := func(extraArgs ...string) bool {
+		args := append([]string{"bgd", "app-name", "--strategy", "rolling"}, extraArgs...)
+		_, err := p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{}, mustParseArgs(args))
+		return err == nil
+	}
+
+	It("replaces the live app in place and smoke tests its routes once a new instance runs", func() {
+		b.deploymentStatuses = []DeploymentStatus{{Value: "ACTIVE", Reason: "DEPLOYING"}}
+
+		Expect(deploy("--smoke-test", "smoke", "--delete-old-apps")).To(BeTrue())
+
+		Expect(b.flow).To(Equal([]string{
+			"delete old apps",
+			"get current live app",
+			"rolling push app-name",
+			"status of deployment-guid",
+			"new instances of deployment-guid",
+			"smoke www.example.com",
+			"status of deployment-guid",
+			"delete old apps except failed ones",
+		}))
+	})
+
+	It("cancels the deployment when the smoke tests fail", func() {
+		b.passSmokeTest = false
+		b.deploymentStatuses = []DeploymentStatus{{Value: "ACTIVE", Reason: "DEPLOYING"}}
+
+		Expect(deploy("--smoke-test", "smoke")).To(BeFalse())
+
+		Expect(b.flow[2:]).To(Equal([]string{
+			"rolling push app-name",
+			"status of deployment-guid",
+			"new instances of deployment-guid",
+			"smoke www.example.com",
+			"cancel deployment-guid",
+		}))
+	})
+
+	It("cancels the deployment without smoke testing when no new instance runs in time", func() {
+		b.deploymentStatuses = []DeploymentStatus{{Value: "ACTIVE", Reason: "DEPLOYING"}}
+		b.newInstances = []int{0}
+
+		Expect(deploy("--smoke-test", "smoke", "--rolling-timeout", "1ns")).To(BeFalse())
+
+		Expect(b.flow[2:]).To(Equal([]string{
+			"rolling push app-name",
+			"status of deployment-guid",
+			"new instances of deployment-guid",
+			"cancel deployment-guid",
+		}))
+		Expect(out.String()).To(ContainSubstring("No new instance was running within 1ns"))
+	})
+
+	It("smoke tests a deployment which has already replaced every instance", func() {
+		Expect(deploy("--smoke-test", "smoke")).To(BeTrue())
+
+		Expect(b.flow[2:5]).To(Equal([]string{
+			"rolling push app-name",
+			"status of deployment-guid",
+			"smoke www.example.com",
+		}))
+	})
+
+	It("cancels the deployment when it does not finish in time", func() {
+		b.deploymentStatuses = []DeploymentStatus{{Value: "ACTIVE", Reason: "DEPLOYING"}}
+
+		Expect(deploy("--rolling-timeout", "1ns")).To(BeFalse())
+
+		Expect(b.flow[2:]).To(Equal([]string{
+			"rolling push app-name",
+			"status of deployment-guid",
+			"cancel deployment-guid",
+		}))
+		Expect(out.String()).To(ContainSubstring("The deployment did not finish within 1ns"))
+	})
+
+	It("fails when the deployment ends without being deployed", func() {
+		b.deploymentStatuses = []DeploymentStatus{{Value: "FINALIZED", Reason: "CANCELED"}}
+
+		Expect(deploy()).To(BeFalse())
+		Expect(out.String()).To(ContainSubstring("The deployment of app-name did not finish: CANCELED"))
+	})
+
+	It("fails when the deployment cannot be started", func() {
+		b.rollingPushError = errors.New("rolling deployments are not supported")
+
+		Expect(deploy()).To(BeFalse())
+		Expect(b.flow[2:]).To(Equal([]string{"rolling push app-name"}))
+	})
+
+	It("pushes the first version the blue-green way", func() {
+		b.liveApp = nil
+
+		Expect(deploy()).To(BeTrue())
+		Expect(b.flow).To(ContainElement("push app-name-new"))
+		Expect(b.flow).ToNot(ContainElement("rolling push app-name"))
+	})
+})
+
+var _ = Describe("Rolling deployments", func() {
+	var (
+		connection *pluginfakes.FakeCliConnection
+		p          BlueGreenDeploy
+		curlCalls  []string
+		requests   []string
+		bodies     map[string]string
+		responses  map[string]string
+		appDir     string
+	)
+
+	BeforeEach(func() {
+		curlCalls = []string{}
+		requests = []string{}
+		bodies = map[string]string{}
+		responses = map[string]string{
+			"POST /v3/packages":                                   `{"guid": "package-guid", "state": "AWAITING_UPLOAD"}`,
+			"POST /v3/packages/package-guid/upload":               `{"guid": "package-guid", "state": "PROCESSING_UPLOAD"}`,
+			"GET /v3/packages/package-guid":                       `{"guid": "package-guid", "state": "READY"}`,
+			"POST /v3/builds":                                     `{"guid": "build-guid", "state": "STAGING"}`,
+			"GET /v3/builds/build-guid":                           `{"guid": "build-guid", "state": "STAGED", "droplet": {"guid": "droplet-guid"}}`,
+			"GET /v3/apps/app-name-guid/environment_variables":    `{"var": {"GREETING": "hi", "OTHER": "kept"}}`,
+			"POST /v3/deployments/deployment-guid/actions/cancel": `{"errors": [{"code": 10008, "title": "CF-UnprocessableEntity", "detail": "Cannot cancel a DEPLOYED deployment"}]}`,
+		}
+		connection = &pluginfakes.FakeCliConnection{}
+		connection.GetAppStub = func(name string) (plugin_models.GetAppModel, error) {
+			return plugin_models.GetAppModel{Name: name, Guid: name + "-guid"}, nil
+		}
+		connection.CliCommandWithoutTerminalOutputStub = func(args ...string) ([]string, error) {
+			curlCalls = append(curlCalls, strings.Join(args, " "))
+			method := "GET"
+			for i := 2; i < len(args)-1; i++ {
+				switch args[i] {
+				case "-X":
+					method = args[i+1]
+				case "-d":
+					bodies[args[1]] = args[i+1]
+				}
+			}
+			request := method + " " + args[1]
+			requests = append(requests, request)
+			if response, ok := responses[request]; ok {
+				return []string{response}, nil
+			}
+			return []string{`{"guid": "deployment-guid", "status": {"value": "FINALIZED", "reason": "DEPLOYED"}}`}, nil
+		}
+		p = BlueGreenDeploy{Connection: connection, Out: &bytes.Buffer{}}
+
+		var err error
+		appDir, err = ioutil.TempDir("", "rolling")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.Mkdir(filepath.Join(appDir, "dist"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(appDir, "dist", "index.html"), []byte("hello"), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(appDir, "manifest.yml"), []byte(`applications:
+- name: app-name
+  path: dist
+  env:
+    GREETING: hello
+    WORKERS: 4
+`), 0644)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(appDir)
+	})
+
+	It("uploads, stages and deploys the new version through the v3 API without cf push", func() {
+		guid, err := p.StartRollingDeployment("app-name", filepath.Join(appDir, "manifest.yml"), ScaleParameters{InstanceCount: 3, Memory: 512})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(guid).To(Equal("deployment-guid"))
+		Expect(connection.CliCommandCallCount()).To(Equal(0))
+		Expect(requests).To(Equal([]string{
+			"GET /v3/apps/app-name-guid/environment_variables",
+			"PATCH /v3/apps/app-name-guid/environment_variables",
+			"POST /v3/packages",
+			"POST /v3/packages/package-guid/upload",
+			"GET /v3/packages/package-guid",
+			"POST /v3/builds",
+			"GET /v3/builds/build-guid",
+			"POST /v3/deployments",
+		}))
+		Expect(bodies["/v3/apps/app-name-guid/environment_variables"]).To(MatchJSON(`{"var": {"GREETING": "hello", "WORKERS": "4"}}`))
+		Expect(bodies["/v3/packages/package-guid/upload"]).To(HavePrefix("@"))
+		Expect(bodies["/v3/deployments"]).To(MatchJSON(`{
+			"droplet": {"guid": "droplet-guid"},
+			"strategy": "rolling",
+			"options": {"web_instances": 3, "memory_in_mb": 512},
+			"relationships": {"app": {"data": {"guid": "app-name-guid"}}}
+		}`))
+	})
+
+	It("leaves the scale of the running instances to the deployment without scaling", func() {
+		_, err := p.StartRollingDeployment("app-name", filepath.Join(appDir, "manifest.yml"), ScaleParameters{})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bodies["/v3/deployments"]).ToNot(ContainSubstring("options"))
+		for _, request := range requests {
+			Expect(request).ToNot(ContainSubstring("scale"))
+		}
+	})
+
+	It("rejects manifest properties it cannot apply to the new version before changing anything", func() {
+		Expect(ioutil.WriteFile(filepath.Join(appDir, "manifest.yml"), []byte(`applications:
+- name: app-name
+  path: dist
+  buildpacks: [nodejs_buildpack]
+  command: node server.js
+  x-team: payments
+`), 0644)).To(Succeed())
+
+		_, err := p.StartRollingDeployment("app-name", filepath.Join(appDir, "manifest.yml"), ScaleParameters{})
+
+		Expect(err).To(MatchError("Could not read the manifest: A rolling deployment cannot apply buildpacks, command from the manifest, deploy app-name with the blue-green strategy instead"))
+		Expect(requests).To(BeEmpty())
+	})
+
+	It("uploads the current directory without a manifest", func() {
+		p.NoManifest = true
+
+		_, err := p.StartRollingDeployment("app-name", "", ScaleParameters{})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(requests[0]).To(Equal("POST /v3/packages"))
+	})
+
+	It("reports a failed staging", func() {
+		responses["GET /v3/builds/build-guid"] = `{"guid": "build-guid", "state": "FAILED", "error": "no buildpack"}`
+
+		_, err := p.StartRollingDeployment("app-name", filepath.Join(appDir, "manifest.yml"), ScaleParameters{})
+		Expect(err).To(MatchError("Staging failed: no buildpack"))
+		Expect(requests).ToNot(ContainElement("POST /v3/deployments"))
+		Expect(requests[len(requests)-1]).To(Equal("PATCH /v3/apps/app-name-guid/environment_variables"))
+		Expect(bodies["/v3/apps/app-name-guid/environment_variables"]).To(MatchJSON(`{"var": {"GREETING": "hi", "WORKERS": null}}`))
+	})
+
+	It("restores the environment of the app when the deployment is cancelled", func() {
+		responses["POST /v3/deployments/deployment-guid/actions/cancel"] = `{}`
+		_, err := p.StartRollingDeployment("app-name", filepath.Join(appDir, "manifest.yml"), ScaleParameters{})
+		Expect(err).ToNot(HaveOccurred())
+		requests = []string{}
+
+		Expect(p.CancelDeployment("deployment-guid")).To(Succeed())
+
+		Expect(requests).To(Equal([]string{
+			"POST /v3/deployments/deployment-guid/actions/cancel",
+			"PATCH /v3/apps/app-name-guid/environment_variables",
+		}))
+		Expect(bodies["/v3/apps/app-name-guid/environment_variables"]).To(MatchJSON(`{"var": {"GREETING": "hi", "WORKERS": null}}`))
+	})
+
+	It("counts the running instances of the new web process of the deployment", func() {
+		responses["GET /v3/deployments/deployment-guid"] = `{"guid": "deployment-guid", "status": {"value": "ACTIVE", "reason": "DEPLOYING"},
+			"new_processes": [{"guid": "new-web-guid", "type": "web"}, {"guid": "new-worker-guid", "type": "worker"}]}`
+		responses["GET /v3/processes/new-web-guid/stats"] = `{"resources": [{"index": 0, "state": "RUNNING"}, {"index": 1, "state": "STARTING"}]}`
+
+		running, err := p.NewInstancesRunning("deployment-guid")
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(running).To(Equal(1))
+		Expect(requests).To(Equal([]string{"GET /v3/deployments/deployment-guid", "GET /v3/processes/new-web-guid/stats"}))
+	})
+
+	It("reads the status of the deployment", func() {
+		status, err := p.DeploymentStatus("deployment-guid")
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(status.Deployed()).To(BeTrue())
+		Expect(curlCalls).To(Equal([]string{"curl /v3/deployments/deployment-guid"}))
+	})
+
+	It("cancels the deployment and reports Cloud Controller errors", func() {
+		err := p.CancelDeployment("deployment-guid")
+
+		Expect(err).To(MatchError("CF-UnprocessableEntity: Cannot cancel a DEPLOYED deployment"))
+		Expect(curlCalls).To(Equal([]string{"curl /v3/deployments/deployment-guid/actions/cancel -X POST"}))
+	})
+})
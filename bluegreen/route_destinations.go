@@ -0,0 +1,96 @@
+package bluegreen
+
+import (
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin/models"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/internal/cfapi"
+)
+
+// RouteWeight is the share of a route's traffic, in percent, which goes to an app. An app with
+// a weight of 0 no longer gets any traffic from the route.
+type RouteWeight struct {
+	AppName string
+	Weight  int
+}
+
+// WeightedRoutingUnsupportedError is returned by SetRouteWeights when the Cloud Controller does
+// not have weighted route destinations, so the traffic of a route can only be moved at once.
+type WeightedRoutingUnsupportedError struct {
+	Err error
+}
+
+func (e *WeightedRoutingUnsupportedError) Error() string {
+	return fmt.Sprintf("weighted routing is not supported: %v", e.Err)
+}
+
+func (e *WeightedRoutingUnsupportedError) Unwrap() error {
+	return e.Err
+}
+
+// SetRouteWeights shares the traffic of a route between the given apps, using the CF v3
+// weighted routing API. Only the web processes of these apps are changed, every other
+// destination of the route is kept. A single app taking all of the traffic is mapped without a
+// weight, which turns the route back into a plain mapping.
+func (p *BlueGreenDeploy) SetRouteWeights(route plugin_models.GetApp_RouteSummary, weights ...RouteWeight) error {
+	api := p.api()
+
+	cfRoute, err := api.FindRoute(route.Domain.Name, route.Host, route.Path, route.Port)
+	if err != nil {
+		return err
+	}
+	existing, err := api.RouteDestinations(cfRoute.Guid)
+	if cfapi.IsNotFound(err) {
+		// The route was found, so it is the destinations endpoint which the Cloud Controller lacks
+		return &WeightedRoutingUnsupportedError{Err: err}
+	}
+	if err != nil {
+		return err
+	}
+
+	appGuids := map[string]bool{}
+	weighted := []cfapi.Destination{}
+	for _, weight := range weights {
+		appModel, err := p.getApp(weight.AppName)
+		if err != nil {
+			return fmt.Errorf("Could not find app %s: %v", weight.AppName, err)
+		}
+		appGuids[appModel.Guid] = true
+
+		if weight.Weight > 0 {
+			destination := cfapi.NewDestination(appModel.Guid, "web")
+			destinationWeight := weight.Weight
+			destination.Weight = &destinationWeight
+			weighted = append(weighted, destination)
+		}
+	}
+	if len(weighted) == 1 {
+		weighted[0].Weight = nil
+	}
+
+	destinations := []cfapi.Destination{}
+	for _, destination := range existing {
+		if !appGuids[destination.App.Guid] || destination.App.Process.Type != "web" {
+			// The guid is given to a destination by the Cloud Controller, not sent to it
+			destination.Guid = ""
+			destinations = append(destinations, destination)
+		}
+	}
+	destinations = append(destinations, weighted...)
+
+	return weightsError(api.ReplaceRouteDestinations(cfRoute.Guid, destinations))
+}
+
+// weightsError tells the error of a Cloud Controller which has route destinations but rejects
+// their weights apart from any other error.
+func weightsError(err error) error {
+	if ccErrors, ok := err.(cfapi.Errors); ok {
+		for _, ccError := range ccErrors {
+			if ccError.Title == "CF-UnprocessableEntity" && strings.Contains(strings.ToLower(ccError.Detail), "weight") {
+				return &WeightedRoutingUnsupportedError{Err: err}
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,158 @@
+package bluegreen_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin/models"
+	"code.cloudfoundry.org/cli/plugin/pluginfakes"
+	. "github.com/bluemixgaragelondon/cf-blue-green-deploy/bluegreen"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Route destinations", func() {
+	var (
+		connection    *pluginfakes.FakeCliConnection
+		p             BlueGreenDeploy
+		curlCalls     []string
+		patchBody     string
+		destinations  string
+		patchResponse string
+	)
+
+	route := plugin_models.GetApp_RouteSummary{Host: "www", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}}
+
+	BeforeEach(func() {
+		curlCalls = []string{}
+		patchBody = ""
+		destinations = `{"destinations": []}`
+		patchResponse = `{"destinations": []}`
+		connection = &pluginfakes.FakeCliConnection{}
+		connection.GetAppStub = func(name string) (plugin_models.GetAppModel, error) {
+			return plugin_models.GetAppModel{Name: name, Guid: name + "-guid"}, nil
+		}
+		connection.CliCommandWithoutTerminalOutputStub = func(args ...string) ([]string, error) {
+			curlCalls = append(curlCalls, strings.Join(args[:2], " "))
+			switch {
+			case strings.HasPrefix(args[1], "/v3/domains"):
+				return []string{`{"resources": [{"guid": "domain-guid", "name": "example.com"}]}`}, nil
+			case strings.HasPrefix(args[1], "/v3/routes?"):
+				return []string{`{"resources": [
+					{"guid": "path-route-guid", "host": "www", "path": "/api"},
+					{"guid": "route-guid", "host": "www", "path": ""}
+				]}`}, nil
+			case len(args) == 2:
+				return []string{destinations}, nil
+			default:
+				patchBody = args[len(args)-1]
+				return []string{patchResponse}, nil
+			}
+		}
+		p = BlueGreenDeploy{Connection: connection, Out: &bytes.Buffer{}}
+	})
+
+	It("replaces the destinations of the route with weighted apps", func() {
+		err := p.SetRouteWeights(route, RouteWeight{AppName: "new", Weight: 10}, RouteWeight{AppName: "live", Weight: 90})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(curlCalls).To(Equal([]string{
+			"curl /v3/domains?names=example.com",
+			"curl /v3/routes?domain_guids=domain-guid&hosts=www",
+			"curl /v3/routes/route-guid/destinations",
+			"curl /v3/routes/route-guid/destinations",
+		}))
+		Expect(patchBody).To(MatchJSON(`{"destinations": [
+			{"app": {"guid": "new-guid", "process": {"type": "web"}}, "weight": 10},
+			{"app": {"guid": "live-guid", "process": {"type": "web"}}, "weight": 90}
+		]}`))
+	})
+
+	It("maps a single app without a weight", func() {
+		err := p.SetRouteWeights(route, RouteWeight{AppName: "new", Weight: 100})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(patchBody).To(MatchJSON(`{"destinations": [
+			{"app": {"guid": "new-guid", "process": {"type": "web"}}}
+		]}`))
+	})
+
+	It("keeps the destinations of other apps and processes", func() {
+		destinations = `{"destinations": [
+			{"guid": "other-app", "app": {"guid": "other-guid", "process": {"type": "web"}}, "port": 8081},
+			{"guid": "live-worker", "app": {"guid": "live-guid", "process": {"type": "worker"}}},
+			{"guid": "live-web", "app": {"guid": "live-guid", "process": {"type": "web"}}}
+		]}`
+
+		err := p.SetRouteWeights(route, RouteWeight{AppName: "new", Weight: 100}, RouteWeight{AppName: "live", Weight: 0})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(patchBody).To(MatchJSON(`{"destinations": [
+			{"app": {"guid": "other-guid", "process": {"type": "web"}}, "port": 8081},
+			{"app": {"guid": "live-guid", "process": {"type": "worker"}}},
+			{"app": {"guid": "new-guid", "process": {"type": "web"}}}
+		]}`))
+	})
+
+	Context("when the cloud controller has no route destinations", func() {
+		It("reports that weighted routing is not supported", func() {
+			destinations = `{"errors": [{"code": 10000, "title": "CF-NotFound", "detail": "Unknown request"}]}`
+
+			err := p.SetRouteWeights(route, RouteWeight{AppName: "new", Weight: 100})
+			Expect(err).To(BeAssignableToTypeOf(&WeightedRoutingUnsupportedError{}))
+		})
+	})
+
+	Context("when the cloud controller has no v3 routes at all", func() {
+		It("returns the error of looking the route up", func() {
+			connection.CliCommandWithoutTerminalOutputStub = func(args ...string) ([]string, error) {
+				return []string{`{"errors": [{"code": 10000, "title": "CF-NotFound", "detail": "Unknown request"}]}`}, nil
+			}
+
+			err := p.SetRouteWeights(route, RouteWeight{AppName: "new", Weight: 100})
+			Expect(err).To(MatchError("CF-NotFound: Unknown request"))
+			Expect(err).ToNot(BeAssignableToTypeOf(&WeightedRoutingUnsupportedError{}))
+		})
+	})
+
+	Context("when the cloud controller returns an error document", func() {
+		It("returns the error", func() {
+			patchResponse = `{"errors": [{"code": 10008, "title": "CF-UnprocessableEntity", "detail": "Weighted routing is not supported"}]}`
+
+			err := p.SetRouteWeights(route, RouteWeight{AppName: "new", Weight: 100})
+			Expect(err).To(MatchError("weighted routing is not supported: CF-UnprocessableEntity: Weighted routing is not supported"))
+			Expect(err).To(BeAssignableToTypeOf(&WeightedRoutingUnsupportedError{}))
+		})
+
+		It("returns any other error as it is", func() {
+			connection.CliCommandWithoutTerminalOutputStub = func(args ...string) ([]string, error) {
+				return []string{`{"errors": [{"code": 10002, "title": "CF-NotAuthenticated", "detail": "Authentication error"}]}`}, nil
+			}
+
+			err := p.SetRouteWeights(route, RouteWeight{AppName: "new", Weight: 100})
+			Expect(err).To(MatchError("CF-NotAuthenticated: Authentication error"))
+			Expect(err).ToNot(BeAssignableToTypeOf(&WeightedRoutingUnsupportedError{}))
+		})
+	})
+
+	Context("when cf curl fails", func() {
+		It("returns the error", func() {
+			connection.CliCommandWithoutTerminalOutputStub = func(args ...string) ([]string, error) {
+				return nil, errors.New("cf curl failed")
+			}
+
+			err := p.SetRouteWeights(route, RouteWeight{AppName: "new", Weight: 100})
+			Expect(err).To(MatchError("cf curl failed"))
+		})
+	})
+
+	Context("when the route does not exist", func() {
+		It("returns an error", func() {
+			err := p.SetRouteWeights(plugin_models.GetApp_RouteSummary{Host: "other", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}},
+				RouteWeight{AppName: "new", Weight: 100})
+			Expect(err).To(MatchError("Could not find route other.example.com"))
+			Expect(err).ToNot(BeAssignableToTypeOf(&WeightedRoutingUnsupportedError{}))
+		})
+	})
+})
@@ -0,0 +1,77 @@
+package bluegreen
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
+)
+
+// SmokeTest is how the new version of an app is tested: by running Script with the FQDN of a
+// route of the new version as its only argument.
+type SmokeTest struct {
+	Script string
+
+	// Timeout fails a script which runs for longer, when it is set.
+	Timeout time.Duration
+
+	// Attempts is how often the script is run until it passes, waiting Interval in between, for
+	// new versions which take a while to serve their routes.
+	Attempts int
+	Interval time.Duration
+}
+
+// GetSmokeTest works out how an app is smoke tested: with the x-bgd-smoke-test settings of the
+// app in the manifest, or else the script given with --smoke-test. Without either, the app is not
+// smoke tested and the Script is empty.
+func (p *Orchestrator) GetSmokeTest(appName string, cfDomains manifest.CfDomains, manifestReader manifest.ManifestReader, opts Options) (SmokeTest, error) {
+	smokeTest := SmokeTest{Script: opts.SmokeTestPath, Attempts: 1}
+
+	parsedManifest, err := manifestReader.Read()
+	if err != nil {
+		return smokeTest, fmt.Errorf("Could not read the manifest: %v", err)
+	}
+	if parsedManifest == nil {
+		return smokeTest, nil
+	}
+
+	pluginParams, err := parsedManifest.GetPluginParams(appName, cfDomains)
+	if err != nil {
+		return smokeTest, err
+	}
+
+	if pluginParams.SmokeTest != "" {
+		smokeTest.Script = pluginParams.SmokeTest
+	}
+	if pluginParams.SmokeTestAttempts > 0 {
+		smokeTest.Attempts = pluginParams.SmokeTestAttempts
+	}
+	smokeTest.Timeout = pluginParams.SmokeTestTimeout
+	smokeTest.Interval = pluginParams.SmokeTestInterval
+	return smokeTest, nil
+}
+
+// runSmokeTests runs the smoke test of an app against a route until it passes or runs out of
+// attempts, and reports it to the observers. It returns an error when the smoke test could not
+// be run at all.
+func (p *Orchestrator) runSmokeTests(appName string, smokeTest SmokeTest, route string) (bool, error) {
+	p.emit(Event{Type: EventSmokeTestStarted, App: appName, Route: route})
+	for attempt := 1; ; attempt++ {
+		passed, err := p.Deployer.RunSmokeTests(smokeTest, route)
+		if err != nil {
+			return false, err
+		}
+		if passed {
+			p.emit(Event{Type: EventSmokeTestPassed, App: appName, Route: route})
+			return true, nil
+		}
+		if attempt >= smokeTest.Attempts {
+			break
+		}
+
+		fmt.Fprintf(p.Out, "Smoke tests of %s failed, attempt %d of %d, trying again in %v\n", appName, attempt, smokeTest.Attempts, smokeTest.Interval)
+		time.Sleep(smokeTest.Interval)
+	}
+	p.emit(Event{Type: EventSmokeTestFailed, App: appName, Route: route})
+	return false, nil
+}
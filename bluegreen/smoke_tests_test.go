@@ -0,0 +1,90 @@
+package bluegreen_test
+
+import (
+	"bytes"
+	"time"
+
+	. "github.com/bluemixgaragelondon/cf-blue-green-deploy/bluegreen"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Smoke tests", func() {
+	var (
+		b   *BlueGreenDeployFake
+		p   Orchestrator
+		out *bytes.Buffer
+	)
+
+	manifestReader := &fakes.FakeManifestReader{Yaml: `---
+applications:
+- name: frontend
+  x-bgd-smoke-test: smoke/frontend.sh
+- name: api
+  x-bgd-smoke-test: smoke/api.sh
+  x-bgd-smoke-test-timeout: 2m
+  x-bgd-smoke-test-attempts: 3
+  x-bgd-smoke-test-interval: 1ms
+- name: worker
+`}
+	cfDomains := manifest.CfDomains{DefaultDomain: "example.com"}
+
+	BeforeEach(func() {
+		b = &BlueGreenDeployFake{passSmokeTest: true}
+		out = &bytes.Buffer{}
+		p = Orchestrator{Deployer: b, Out: out}
+	})
+
+	Describe("GetSmokeTest", func() {
+		It("uses the settings of the app in the manifest", func() {
+			smokeTest, err := p.GetSmokeTest("api", cfDomains, manifestReader, mustParseArgs(bgdArgs("api --smoke-test smoke/default.sh")))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(smokeTest).To(Equal(SmokeTest{Script: "smoke/api.sh", Timeout: 2 * time.Minute, Attempts: 3, Interval: time.Millisecond}))
+		})
+
+		It("falls back to the script given on the command line", func() {
+			smokeTest, err := p.GetSmokeTest("worker", cfDomains, manifestReader, mustParseArgs(bgdArgs("worker --smoke-test smoke/default.sh")))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(smokeTest).To(Equal(SmokeTest{Script: "smoke/default.sh", Attempts: 1}))
+		})
+	})
+
+	It("tests every app of a manifest with its own script", func() {
+		_, err := p.DeployApps(cfDomains, manifestReader, mustParseArgs(bgdArgs("--all --smoke-test smoke/default.sh")))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(b.flow).To(ContainElement("smoke/frontend.sh frontend-new.example.com"))
+		Expect(b.flow).To(ContainElement("smoke/api.sh api-new.example.com"))
+		Expect(b.flow).To(ContainElement("smoke/default.sh worker-new.example.com"))
+	})
+
+	It("runs the script again until it passes", func() {
+		b.smokeTestFails = 2
+
+		_, err := p.Deploy(cfDomains, manifestReader, mustParseArgs(bgdArgs("api")))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(countOf(b.flow, "smoke/api.sh api-new.example.com")).To(Equal(3))
+		Expect(out.String()).To(ContainSubstring("Smoke tests of api-new failed, attempt 2 of 3"))
+	})
+
+	It("fails once the script ran out of attempts", func() {
+		b.smokeTestFails = 3
+
+		_, err := p.Deploy(cfDomains, manifestReader, mustParseArgs(bgdArgs("api")))
+		Expect(err).To(MatchError(ErrDeploymentFailed))
+		Expect(countOf(b.flow, "smoke/api.sh api-new.example.com")).To(Equal(3))
+	})
+})
+
+func countOf(flow []string, step string) int {
+	count := 0
+	for _, s := range flow {
+		if s == step {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,187 @@
+package bluegreen
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin/models"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
+)
+
+// DeploymentStrategy decides how a new version of an app is pushed and how traffic is moved
+// to it. Strategies are built from the BlueGreenDeployer primitives and the shared phases on
+// Orchestrator, and report whether the new version was promoted, or the error of a step which
+// stopped the deployment part way through.
+type DeploymentStrategy interface {
+	Deploy(p *Orchestrator, deployment *Deployment) (bool, error)
+}
+
+// Strategies holds the strategies which can be chosen with --strategy.
+var Strategies = map[string]DeploymentStrategy{
+	StrategyBlueGreen:      BlueGreenStrategy{},
+	StrategyCanary:         CanaryStrategy{},
+	StrategyInstanceCanary: InstanceCanaryStrategy{},
+	StrategyRolling:        RollingStrategy{},
+}
+
+func LookupStrategy(name string) (DeploymentStrategy, error) {
+	if name == "" {
+		name = StrategyBlueGreen
+	}
+
+	strategy, ok := Strategies[name]
+	if !ok {
+		names := []string{}
+		for strategyName := range Strategies {
+			names = append(names, strategyName)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("Unknown strategy %q, must be one of %s.", name, strings.Join(names, ", "))
+	}
+	return strategy, nil
+}
+
+// Deployment is everything a strategy needs to know about the app being deployed.
+type Deployment struct {
+	AppName     string
+	NewAppName  string
+	LiveAppName string
+
+	// LiveAppRoutes are all routes of the live app, and PromotedRoutes those which should move
+	// to the new version. The rest are ExcludedRoutes, which stay with the old version.
+	LiveAppRoutes  []plugin_models.GetApp_RouteSummary
+	PromotedRoutes []plugin_models.GetApp_RouteSummary
+	ExcludedRoutes []plugin_models.GetApp_RouteSummary
+
+	NewAppRoutes  []plugin_models.GetApp_RouteSummary
+	ManifestScale ScaleParameters
+	SmokeTest     SmokeTest
+	CfDomains     manifest.CfDomains
+	Options       Options
+}
+
+// PushAndSmokeTest pushes the new version with a temporary route and runs the smoke tests
+// against it. The temporary route is removed again whatever the outcome of the smoke tests.
+func (p *Orchestrator) PushAndSmokeTest(deployment *Deployment, scaleParameters ScaleParameters) (bool, error) {
+	// Add route so that we can run the smoke tests, on a domain the smoke tests can reach over HTTP
+	tempRouteDomain := plugin_models.GetApp_DomainFields{Name: deployment.CfDomains.DefaultDomain}
+	for _, route := range deployment.NewAppRoutes {
+		if deployment.CfDomains.IsExternalHttp(route.Domain.Name) {
+			tempRouteDomain = route.Domain
+			break
+		}
+	}
+	tempRoute := plugin_models.GetApp_RouteSummary{Host: deployment.NewAppName, Domain: tempRouteDomain}
+
+	// If the push is unsuccessful, the deployment stops here.
+	p.emit(Event{Type: EventPushStarted, App: deployment.NewAppName})
+	if err := p.Deployer.PushNewApp(deployment.NewAppName, tempRoute, deployment.Options.ManifestPath, scaleParameters); err != nil {
+		return false, err
+	}
+	p.emit(Event{Type: EventPushFinished, App: deployment.NewAppName})
+
+	if deployment.LiveAppName != "" {
+		sshEnabled, err := p.Deployer.CheckSshEnablement(deployment.AppName)
+		if err != nil {
+			return false, err
+		}
+		if err := p.Deployer.SetSshAccess(deployment.NewAppName, sshEnabled); err != nil {
+			return false, err
+		}
+	}
+	passedSmokeTests := true
+	if deployment.SmokeTest.Script != "" {
+		passed, err := p.runSmokeTests(deployment.NewAppName, deployment.SmokeTest, FQDN(tempRoute))
+		if err != nil {
+			return false, err
+		}
+		passedSmokeTests = passed
+	}
+
+	if err := p.unmapRoutes(deployment.NewAppName, tempRoute); err != nil {
+		return false, err
+	}
+	if err := p.Deployer.DeleteRoutes(tempRoute); err != nil {
+		return false, err
+	}
+	return passedSmokeTests, nil
+}
+
+// CompletePromotion is called once the new version serves its routes. It gives the new version
+// the app's name, keeping the live app as APP-old, and unmaps routesToUnmap from the old version.
+func (p *Orchestrator) CompletePromotion(deployment *Deployment, routesToUnmap []plugin_models.GetApp_RouteSummary) error {
+	p.preparePromotionRenames(deployment)
+	if deployment.LiveAppName != "" {
+		if err := p.renameApp(deployment.LiveAppName, deployment.AppName+"-old"); err != nil {
+			return err
+		}
+	}
+	if err := p.renameApp(deployment.NewAppName, deployment.AppName); err != nil {
+		return err
+	}
+	return p.finishPromotion(deployment, routesToUnmap)
+}
+
+// preparePromotionRenames keeps what the live version knows, which is looked up by its name, before
+// the apps are renamed.
+func (p *Orchestrator) preparePromotionRenames(deployment *Deployment) {
+	if deployment.LiveAppName != "" {
+		p.keepPreviousDroplet(deployment.NewAppName, deployment.LiveAppName)
+		p.reportExcludedRoutes(deployment.AppName+"-old", p.SubtractRouteList(deployment.LiveAppRoutes, deployment.PromotedRoutes))
+	}
+}
+
+// finishPromotion unmaps routesToUnmap from the old version once the apps have been renamed, and
+// deletes the old versions if asked to.
+func (p *Orchestrator) finishPromotion(deployment *Deployment, routesToUnmap []plugin_models.GetApp_RouteSummary) error {
+	if deployment.LiveAppName != "" {
+		if err := p.unmapRoutes(deployment.AppName+"-old", routesToUnmap...); err != nil {
+			return err
+		}
+	}
+
+	if deployment.Options.DeleteOldApps {
+		return p.deleteOldButNotFailedVersions(deployment.AppName, deployment.ExcludedRoutes...)
+	}
+	return nil
+}
+
+// FailDeployment marks the new version as failed, leaving it around for investigation.
+func (p *Orchestrator) FailDeployment(deployment *Deployment) error {
+	return p.renameApp(deployment.NewAppName, deployment.AppName+"-failed")
+}
+
+// BlueGreenStrategy moves all routes to the new version at once, or wave by wave when waves are given.
+type BlueGreenStrategy struct{}
+
+func (BlueGreenStrategy) Deploy(p *Orchestrator, deployment *Deployment) (bool, error) {
+	passed, err := p.PushAndSmokeTest(deployment, deployment.ManifestScale)
+	if err != nil {
+		return false, err
+	}
+	if !passed {
+		return false, p.FailDeployment(deployment)
+	}
+
+	// If there is no live app, we only need to add our new routes.
+	if deployment.LiveAppName == "" || len(deployment.Options.Waves) == 0 {
+		if err := p.mapRoutes(deployment.NewAppName, deployment.NewAppRoutes...); err != nil {
+			return false, err
+		}
+		return true, p.CompletePromotion(deployment, deployment.PromotedRoutes)
+	}
+
+	waves := GroupRoutesIntoWaves(deployment.NewAppRoutes, deployment.Options.Waves)
+	promoted, err := p.promoteInWaves(deployment.LiveAppName, deployment.NewAppName, waves, deployment.PromotedRoutes,
+		deployment.SmokeTest, deployment.Options.WavePause)
+	if err != nil {
+		return false, err
+	}
+	if !promoted {
+		return false, p.FailDeployment(deployment)
+	}
+
+	// The waves have already unmapped the routes which moved to the new app
+	return true, p.CompletePromotion(deployment, p.SubtractRouteList(deployment.PromotedRoutes, deployment.NewAppRoutes))
+}
@@ -0,0 +1,92 @@
+package bluegreen_test
+
+import (
+	"bytes"
+
+	"code.cloudfoundry.org/cli/plugin/models"
+	. "github.com/bluemixgaragelondon/cf-blue-green-deploy/bluegreen"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type recordingStrategy struct {
+	deployments []*Deployment
+}
+
+func (s *recordingStrategy) Deploy(p *Orchestrator, deployment *Deployment) (bool, error) {
+	s.deployments = append(s.deployments, deployment)
+	return true, nil
+}
+
+var _ = Describe("Deployment strategies", func() {
+	Describe("LookupStrategy", func() {
+		It("uses blue-green when no strategy is given", func() {
+			strategy, err := LookupStrategy("")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(strategy).To(Equal(BlueGreenStrategy{}))
+		})
+
+		It("finds the registered strategies", func() {
+			strategy, err := LookupStrategy("instance-canary")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(strategy).To(Equal(InstanceCanaryStrategy{}))
+		})
+
+		It("lists the known strategies for an unknown one", func() {
+			_, err := LookupStrategy("big-bang")
+			Expect(err).To(MatchError(`Unknown strategy "big-bang", must be one of blue-green, canary, instance-canary, rolling.`))
+		})
+	})
+
+	Context("when a strategy is registered", func() {
+		var (
+			b        *BlueGreenDeployFake
+			p        Orchestrator
+			out      *bytes.Buffer
+			strategy *recordingStrategy
+		)
+
+		liveRoute := plugin_models.GetApp_RouteSummary{Host: "www", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}}
+
+		BeforeEach(func() {
+			b = &BlueGreenDeployFake{
+				liveApp: &plugin_models.GetAppModel{Name: "app-name",
+					Routes: []plugin_models.GetApp_RouteSummary{liveRoute}},
+				passSmokeTest: true,
+			}
+			out = &bytes.Buffer{}
+			p = Orchestrator{Deployer: b, Out: out}
+
+			strategy = &recordingStrategy{}
+			Strategies["recording"] = strategy
+		})
+
+		AfterEach(func() {
+			delete(Strategies, "recording")
+		})
+
+		It("hands the prepared deployment to the strategy", func() {
+			args := mustParseArgs([]string{"bgd", "app-name", "--strategy", "recording"})
+			_, err := p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{}, args)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(strategy.deployments).To(HaveLen(1))
+			deployment := strategy.deployments[0]
+			Expect(deployment.AppName).To(Equal("app-name"))
+			Expect(deployment.NewAppName).To(Equal("app-name-new"))
+			Expect(deployment.LiveAppName).To(Equal("app-name"))
+			Expect(deployment.PromotedRoutes).To(Equal([]plugin_models.GetApp_RouteSummary{liveRoute}))
+		})
+
+		It("does not deploy with an unknown strategy", func() {
+			args := mustParseArgs([]string{"bgd", "app-name", "--strategy", "recording"})
+			args.Strategy = "big-bang"
+
+			_, err := p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{}, args)
+			Expect(err).To(MatchError(ContainSubstring(`Unknown strategy "big-bang"`)))
+			Expect(b.flow).To(BeEmpty())
+		})
+	})
+})
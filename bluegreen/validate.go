@@ -0,0 +1,44 @@
+package bluegreen
+
+import (
+	"sort"
+
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
+)
+
+// Validate checks the manifests of the options against the domains of the targeted org, and
+// returns the problems found in them, each at the file and line it was written on.
+func (p *Orchestrator) Validate(opts Options) ([]manifest.Problem, error) {
+	cfDomains, err := p.DiscoverDomains()
+	if err != nil {
+		return nil, err
+	}
+	return validateManifests(cfDomains, opts)
+}
+
+// validateManifests checks the manifest the apps share, unless every app has its own, and the
+// manifests of the apps given with --app APP=MANIFEST.
+func validateManifests(cfDomains manifest.CfDomains, opts Options) ([]manifest.Problem, error) {
+	readers := []*manifest.FileManifestReader{}
+	if opts.All || len(opts.Manifests) < len(opts.AppNames) || len(opts.AppNames) == 0 {
+		readers = append(readers, manifestReaderOf(opts))
+	}
+	appNames := []string{}
+	for appName := range opts.Manifests {
+		appNames = append(appNames, appName)
+	}
+	sort.Strings(appNames)
+	for _, appName := range appNames {
+		readers = append(readers, manifestReaderOf(appOptions(opts, appName)))
+	}
+
+	problems := []manifest.Problem{}
+	for _, reader := range readers {
+		readerProblems, err := reader.Validate(cfDomains)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, readerProblems...)
+	}
+	return problems, nil
+}
@@ -0,0 +1,99 @@
+package bluegreen_test
+
+import (
+	"bytes"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin/models"
+	"code.cloudfoundry.org/cli/plugin/pluginfakes"
+	. "github.com/bluemixgaragelondon/cf-blue-green-deploy/bluegreen"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Validation", func() {
+	var (
+		connection *pluginfakes.FakeCliConnection
+		p          *Orchestrator
+	)
+
+	BeforeEach(func() {
+		responses := map[string]string{
+			"/v3/organizations/org-guid/domains": `{
+				"pagination": {"next": null},
+				"resources": [
+					{"name": "example.com", "internal": false, "router_group": null, "relationships": {"organization": {"data": null}}}
+				]}`,
+			"/v3/organizations/org-guid/domains/default": `{"name": "example.com"}`,
+		}
+		connection = &pluginfakes.FakeCliConnection{}
+		connection.GetCurrentOrgReturns(plugin_models.Organization{OrganizationFields: plugin_models.OrganizationFields{Guid: "org-guid"}}, nil)
+		connection.CliCommandWithoutTerminalOutputStub = func(args ...string) ([]string, error) {
+			if response, ok := responses[args[1]]; ok {
+				return []string{response}, nil
+			}
+			return []string{`{"errors": [{"title": "CF-NotFound", "detail": "Unknown request"}]}`}, nil
+		}
+		p = New(connection, &bytes.Buffer{})
+	})
+
+	It("reads the manifests to validate from the command line", func() {
+		opts, err := ParseValidateArgs([]string{"bgd-validate", "-f", "manifest.yml", "-f", "prod.yml", "--vars-file", "vars.yml", "--var", "host=www"})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(opts.ManifestPath).To(Equal("manifest.yml"))
+		Expect(opts.Overlays).To(Equal([]string{"prod.yml"}))
+		Expect(opts.VarsFiles).To(Equal([]string{"vars.yml"}))
+		Expect(opts.Vars).To(Equal(map[string]string{"host": "www"}))
+	})
+
+	It("does not take app names", func() {
+		_, err := ParseValidateArgs([]string{"bgd-validate", "app-name"})
+
+		Expect(err).To(MatchError("Unexpected argument app-name"))
+	})
+
+	It("reports the problems of the manifest against the domains of the org", func() {
+		problems, err := p.Validate(mustParseValidateArgs("bgd-validate -f ../fixtures/invalid/manifest.yml"))
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(problems).To(ContainElement(manifest.Problem{
+			File:    "../fixtures/invalid/manifest.yml",
+			Line:    11,
+			Message: "worker: The domain nowhere.com does not exist",
+		}))
+	})
+
+	It("reports the problems of the manifests of apps given with --app", func() {
+		problems, err := p.Validate(mustParseValidateArgs("bgd-validate --app web=../fixtures/invalid/manifest.yml"))
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(problems).To(HaveLen(6))
+	})
+
+	It("stops a deployment with an invalid manifest before changing anything", func() {
+		_, err := p.Run(mustParseArgs(bgdArgs("web -f ../fixtures/invalid/manifest.yml")))
+
+		Expect(err).To(BeAssignableToTypeOf(&manifest.ValidationError{}))
+		Expect(err.Error()).To(ContainSubstring("../fixtures/invalid/manifest.yml:6: web: Invalid value for 'instances': two, expected a whole number"))
+		Expect(connection.GetAppsCallCount()).To(Equal(0))
+		Expect(connection.CliCommandCallCount()).To(Equal(0))
+	})
+
+	It("stops a deployment whose manifest cannot be found before changing anything", func() {
+		_, err := p.Run(mustParseArgs(bgdArgs("web -f ../fixtures/no-such-manifest.yml")))
+
+		Expect(err).To(MatchError(ContainSubstring("Could not read the manifest: Error finding manifest")))
+		Expect(connection.GetAppsCallCount()).To(Equal(0))
+		Expect(connection.CliCommandCallCount()).To(Equal(0))
+	})
+})
+
+func mustParseValidateArgs(argString string) Options {
+	opts, err := ParseValidateArgs(strings.Split(argString, " "))
+	if err != nil {
+		panic(err)
+	}
+	return opts
+}
@@ -0,0 +1,134 @@
+package bluegreen
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin/models"
+)
+
+// GroupRoutesIntoWaves splits routes into the waves given with --wave. Each wave is a comma separated
+// list of domains or routes; a route belongs to the first wave naming its domain or the route itself.
+// Routes which no wave names are promoted together in a final wave.
+func GroupRoutesIntoWaves(routes []plugin_models.GetApp_RouteSummary, waveSpecs []string) [][]plugin_models.GetApp_RouteSummary {
+	waves := make([][]plugin_models.GetApp_RouteSummary, len(waveSpecs)+1)
+
+	for _, route := range routes {
+		wave := len(waveSpecs)
+		for i, waveSpec := range waveSpecs {
+			if waveMatchesRoute(waveSpec, route) {
+				wave = i
+				break
+			}
+		}
+		waves[wave] = append(waves[wave], route)
+	}
+
+	nonEmptyWaves := [][]plugin_models.GetApp_RouteSummary{}
+	for _, wave := range waves {
+		if len(wave) > 0 {
+			nonEmptyWaves = append(nonEmptyWaves, wave)
+		}
+	}
+	return nonEmptyWaves
+}
+
+func waveMatchesRoute(waveSpec string, route plugin_models.GetApp_RouteSummary) bool {
+	for _, name := range strings.Split(waveSpec, ",") {
+		name = strings.TrimSpace(name)
+		if name == route.Domain.Name || name == RouteURL(route) {
+			return true
+		}
+	}
+	return false
+}
+
+// promoteInWaves moves the routes from the live app to the new app one wave at a time, verifying
+// each wave before moving on. If a wave fails, because its routes could not be moved or it failed
+// verification, every wave moved so far is moved back to the live app and false is returned,
+// together with the error of the wave if it did not get as far as verification. Without a smoke
+// test the waves are not verified.
+func (p *Orchestrator) promoteInWaves(liveAppName string, newAppName string, waves [][]plugin_models.GetApp_RouteSummary,
+	liveAppRoutes []plugin_models.GetApp_RouteSummary, smokeTest SmokeTest, pause time.Duration) (bool, error) {
+
+	for i, wave := range waves {
+		fmt.Fprintf(p.Out, "Promoting wave %d of %d to %s:\n", i+1, len(waves), newAppName)
+		for _, route := range wave {
+			fmt.Fprintf(p.Out, "  %s\n", RouteURL(route))
+		}
+
+		passed, err := p.promoteWave(liveAppName, newAppName, wave, liveAppRoutes, smokeTest)
+		if err != nil || !passed {
+			failure := "failed verification"
+			if err != nil {
+				failure = fmt.Sprintf("failed: %v", err)
+			}
+			fmt.Fprintf(p.Out, "Wave %d %s, moving the promoted routes back to %s\n", i+1, failure, liveAppName)
+			if !p.moveWavesBack(liveAppName, newAppName, waves[:i+1], liveAppRoutes) && err == nil {
+				err = ErrRollbackFailed
+			}
+			p.emit(Event{Type: EventRolledBack, App: liveAppName, Message: fmt.Sprintf("wave %d %s", i+1, failure)})
+			return false, err
+		}
+
+		if i < len(waves)-1 && pause > 0 {
+			fmt.Fprintf(p.Out, "Waiting %v before the next wave\n", pause)
+			time.Sleep(pause)
+		}
+	}
+	return true, nil
+}
+
+func (p *Orchestrator) promoteWave(liveAppName string, newAppName string, wave []plugin_models.GetApp_RouteSummary,
+	liveAppRoutes []plugin_models.GetApp_RouteSummary, smokeTest SmokeTest) (bool, error) {
+
+	if err := p.mapRoutes(newAppName, wave...); err != nil {
+		return false, err
+	}
+	if err := p.unmapRoutes(liveAppName, p.intersectRouteLists(wave, liveAppRoutes)...); err != nil {
+		return false, err
+	}
+	return p.verifyWave(newAppName, smokeTest, wave)
+}
+
+// moveWavesBack gives the live app back the routes of the given waves, the last one first, and
+// takes them off the new app. Since the last wave may only have been moved in part, it carries on
+// when a step fails, so that as many routes as possible are moved back, and reports whether all
+// of them were.
+func (p *Orchestrator) moveWavesBack(liveAppName string, newAppName string, waves [][]plugin_models.GetApp_RouteSummary,
+	liveAppRoutes []plugin_models.GetApp_RouteSummary) bool {
+
+	movedBack := true
+	for j := len(waves) - 1; j >= 0; j-- {
+		if err := p.mapRoutes(liveAppName, p.intersectRouteLists(waves[j], liveAppRoutes)...); err != nil {
+			fmt.Fprintf(p.Out, "Could not move the routes of wave %d back to %s: %v\n", j+1, liveAppName, err)
+			movedBack = false
+		}
+		if err := p.unmapRoutes(newAppName, waves[j]...); err != nil {
+			fmt.Fprintf(p.Out, "Could not unmap the routes of wave %d from %s: %v\n", j+1, newAppName, err)
+			movedBack = false
+		}
+	}
+	return movedBack
+}
+
+// verifyWave runs the smoke test against every route of a wave. Without a smoke test there is
+// nothing to verify a wave with, so it passes.
+func (p *Orchestrator) verifyWave(appName string, smokeTest SmokeTest, wave []plugin_models.GetApp_RouteSummary) (bool, error) {
+	if smokeTest.Script == "" {
+		return true, nil
+	}
+
+	for _, route := range wave {
+		passed, err := p.runSmokeTests(appName, smokeTest, RouteURL(route))
+		if err != nil || !passed {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (p *Orchestrator) intersectRouteLists(listA []plugin_models.GetApp_RouteSummary, listB []plugin_models.GetApp_RouteSummary) []plugin_models.GetApp_RouteSummary {
+	return p.SubtractRouteList(listA, p.SubtractRouteList(listA, listB))
+}
@@ -0,0 +1,154 @@
+package bluegreen_test
+
+import (
+	"bytes"
+
+	"code.cloudfoundry.org/cli/plugin/models"
+	. "github.com/bluemixgaragelondon/cf-blue-green-deploy/bluegreen"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Waves", func() {
+	internalRoute := plugin_models.GetApp_RouteSummary{Host: "app", Domain: plugin_models.GetApp_DomainFields{Name: "apps.internal"}}
+	betaRoute := plugin_models.GetApp_RouteSummary{Host: "beta", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}}
+	wwwRoute := plugin_models.GetApp_RouteSummary{Host: "www", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}}
+	customRoute := plugin_models.GetApp_RouteSummary{Host: "shop", Domain: plugin_models.GetApp_DomainFields{Name: "example.org"}}
+
+	Describe("grouping routes into waves", func() {
+		It("groups routes by domain or route, in the order of the waves", func() {
+			waves := GroupRoutesIntoWaves(
+				[]plugin_models.GetApp_RouteSummary{wwwRoute, customRoute, betaRoute, internalRoute},
+				[]string{"apps.internal", "beta.example.com", "example.com,example.org"},
+			)
+
+			Expect(waves).To(Equal([][]plugin_models.GetApp_RouteSummary{
+				{internalRoute},
+				{betaRoute},
+				{wwwRoute, customRoute},
+			}))
+		})
+
+		It("puts routes not named by any wave into a final wave", func() {
+			waves := GroupRoutesIntoWaves(
+				[]plugin_models.GetApp_RouteSummary{wwwRoute, internalRoute},
+				[]string{"apps.internal"},
+			)
+
+			Expect(waves).To(Equal([][]plugin_models.GetApp_RouteSummary{
+				{internalRoute},
+				{wwwRoute},
+			}))
+		})
+
+		It("skips waves which match no routes", func() {
+			waves := GroupRoutesIntoWaves(
+				[]plugin_models.GetApp_RouteSummary{wwwRoute},
+				[]string{"apps.internal", "example.com"},
+			)
+
+			Expect(waves).To(Equal([][]plugin_models.GetApp_RouteSummary{
+				{wwwRoute},
+			}))
+		})
+	})
+
+	Describe("deploying in waves", func() {
+		var (
+			b *BlueGreenDeployFake
+			p Orchestrator
+		)
+
+		BeforeEach(func() {
+			b = &BlueGreenDeployFake{
+				liveApp: &plugin_models.GetAppModel{Name: "app-name",
+					Routes: []plugin_models.GetApp_RouteSummary{internalRoute, betaRoute, wwwRoute}},
+				passSmokeTest: true,
+			}
+			p = Orchestrator{
+				Deployer: b,
+				Out:      &bytes.Buffer{},
+			}
+		})
+
+		It("moves each wave and verifies it before moving on", func() {
+			_, err := p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{},
+				mustParseArgs([]string{"bgd", "app-name", "--smoke-test", "smoke", "--wave", "apps.internal", "--wave", "beta.example.com"}))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(b.flow).To(Equal([]string{
+				"delete old apps",
+				"get current live app",
+				"push app-name-new",
+				"check ssh enablement for 'app-name'",
+				"set ssh enablement for 'app-name-new' to 'false'",
+				"smoke app-name-new.apps.internal",
+				"unmap 1 routes from app-name-new",
+				"delete 1 routes",
+				"mapped 1 routes",
+				"unmap 1 routes from app-name",
+				"smoke app.apps.internal",
+				"mapped 1 routes",
+				"unmap 1 routes from app-name",
+				"smoke beta.example.com",
+				"mapped 1 routes",
+				"unmap 1 routes from app-name",
+				"smoke www.example.com",
+				"rename app-name to app-name-old",
+				"rename app-name-new to app-name",
+				"unmap 0 routes from app-name-old",
+			}))
+		})
+
+		It("moves every promoted wave back when a wave fails", func() {
+			b.failingFQDNs = []string{"beta.example.com"}
+
+			_, err := p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{},
+				mustParseArgs([]string{"bgd", "app-name", "--smoke-test", "smoke", "--wave", "apps.internal", "--wave", "beta.example.com"}))
+
+			Expect(err).To(Equal(ErrDeploymentFailed))
+			Expect(b.flow[len(b.flow)-6:]).To(Equal([]string{
+				"smoke beta.example.com",
+				"mapped 1 routes",
+				"unmap 1 routes from app-name-new",
+				"mapped 1 routes",
+				"unmap 1 routes from app-name-new",
+				"rename app-name-new to app-name-failed",
+			}))
+		})
+
+		It("moves every promoted wave back when the routes of a wave cannot be moved", func() {
+			b.failingMapCall = 2
+
+			_, err := p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{},
+				mustParseArgs([]string{"bgd", "app-name", "--smoke-test", "smoke", "--wave", "apps.internal", "--wave", "beta.example.com"}))
+
+			Expect(err).To(MatchError("Could not map routes to app-name-new - failed"))
+			Expect(b.flow[len(b.flow)-5:]).To(Equal([]string{
+				"smoke app.apps.internal",
+				"mapped 1 routes",
+				"unmap 1 routes from app-name-new",
+				"mapped 1 routes",
+				"unmap 1 routes from app-name-new",
+			}))
+		})
+
+		It("fails the deployment when the waves cannot be moved back", func() {
+			b.failingFQDNs = []string{"beta.example.com"}
+			b.failingMapCall = 3
+
+			_, err := p.Deploy(manifest.CfDomains{DefaultDomain: "example.com"}, &fakes.FakeManifestReader{},
+				mustParseArgs([]string{"bgd", "app-name", "--smoke-test", "smoke", "--wave", "apps.internal", "--wave", "beta.example.com"}))
+
+			Expect(err).To(Equal(ErrRollbackFailed))
+			Expect(b.flow[len(b.flow)-4:]).To(Equal([]string{
+				"smoke beta.example.com",
+				"unmap 1 routes from app-name-new",
+				"mapped 1 routes",
+				"unmap 1 routes from app-name-new",
+			}))
+		})
+	})
+})
@@ -0,0 +1,106 @@
+// Command cf-bgd runs a blue-green deployment without the cf CLI. It uses the target and tokens
+// the cf CLI saved on login, or the ones given with flags or environment variables, and talks
+// to the Cloud Controller API directly.
+//
+// Usage: cf-bgd [--api URL] [--token TOKEN] [--org ORG] [--space SPACE] APP_NAME... | --all | --app APP=MANIFEST... [deploy flags]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/bluegreen"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/internal/standalone"
+)
+
+var Version string
+
+func main() {
+	log.SetFlags(0)
+
+	flagConfig := standalone.Config{}
+	f := flag.NewFlagSet("cf-bgd", flag.ExitOnError)
+	f.StringVar(&flagConfig.ApiEndpoint, "api", "", "Cloud Controller API endpoint, overriding CF_API and the cf CLI's target")
+	f.StringVar(&flagConfig.AccessToken, "token", "", "access token, overriding CF_TOKEN and the cf CLI's login")
+	f.StringVar(&flagConfig.OrgName, "org", "", "org to deploy to, overriding CF_ORG and the cf CLI's target")
+	f.StringVar(&flagConfig.SpaceName, "space", "", "space to deploy to, overriding CF_SPACE and the cf CLI's target")
+	f.BoolVar(&flagConfig.SkipSSLValidation, "skip-ssl-validation", false, "do not verify the API's certificate")
+	cfHome := f.String("cf-home", cfHomeDir(), "directory holding the cf CLI's .cf/config.json")
+	version := f.Bool("version", false, "print the version and exit")
+	f.Usage = func() {
+		fmt.Fprintln(f.Output(), "Usage: cf-bgd [--api URL] [--token TOKEN] [--org ORG] [--space SPACE] APP_NAME... | --all | --app APP=MANIFEST... [deploy flags]")
+		f.PrintDefaults()
+	}
+	ownArgs, deployArgs := splitArgs(f, os.Args[1:])
+	f.Parse(ownArgs)
+
+	if *version {
+		fmt.Println(Version)
+		return
+	}
+	if len(deployArgs) == 0 {
+		f.Usage()
+		os.Exit(2)
+	}
+
+	opts, err := bluegreen.ParseArgs(append([]string{"blue-green-deploy"}, deployArgs...))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	config := standalone.Config{}
+	configPath := filepath.Join(*cfHome, ".cf", "config.json")
+	if _, err = os.Stat(configPath); err == nil {
+		if config, err = standalone.LoadCfConfig(configPath); err != nil {
+			log.Fatalf("Could not read %s - %v", configPath, err)
+		}
+	}
+	config = config.Override(standalone.ConfigFromEnvironment()).Override(flagConfig)
+
+	connection, err := standalone.NewConnection(config, os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	orchestrator := bluegreen.New(connection, os.Stdout)
+	orchestrator.AddObserver(bluegreen.ProgressObserver{Out: os.Stdout})
+	if _, err := orchestrator.Run(opts); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// splitArgs splits the flags of cf-bgd itself from the deployment's, which start with the first
+// app name or the first flag cf-bgd does not know, such as --all.
+func splitArgs(f *flag.FlagSet, args []string) ([]string, []string) {
+	for i := 0; i < len(args); i++ {
+		name := strings.TrimLeft(args[i], "-")
+		if !strings.HasPrefix(args[i], "-") || name == "" {
+			return args[:i], args[i:]
+		}
+		hasValue := strings.Contains(name, "=")
+		ownFlag := f.Lookup(strings.SplitN(name, "=", 2)[0])
+		if ownFlag == nil {
+			return args[:i], args[i:]
+		}
+		if hasValue {
+			continue
+		}
+		if boolFlag, ok := ownFlag.Value.(interface{ IsBoolFlag() bool }); !ok || !boolFlag.IsBoolFlag() {
+			i++
+		}
+	}
+	return args, nil
+}
+
+// cfHomeDir is where the cf CLI keeps its config, CF_HOME or else the home directory.
+func cfHomeDir() string {
+	if cfHome := os.Getenv("CF_HOME"); cfHome != "" {
+		return cfHome
+	}
+	home, _ := os.UserHomeDir()
+	return home
+}
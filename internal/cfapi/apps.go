@@ -0,0 +1,205 @@
+package cfapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+type App struct {
+	Guid     string   `json:"guid"`
+	Name     string   `json:"name"`
+	State    string   `json:"state"`
+	Metadata Metadata `json:"metadata"`
+}
+
+// AppByName finds an app in the targeted space.
+func (c *Client) AppByName(name string) (App, error) {
+	space, err := c.Connection.GetCurrentSpace()
+	if err != nil {
+		return App{}, err
+	}
+
+	query := url.Values{}
+	query.Set("names", name)
+	if space.Guid != "" {
+		query.Set("space_guids", space.Guid)
+	}
+
+	apps := []App{}
+	err = c.eachPage("/v3/apps?"+query.Encode(), func(resources json.RawMessage) error {
+		page := []App{}
+		err := json.Unmarshal(resources, &page)
+		apps = append(apps, page...)
+		return err
+	})
+	if err != nil {
+		return App{}, err
+	}
+	if len(apps) == 0 {
+		return App{}, &NotFoundError{Kind: "app", Name: name}
+	}
+	return apps[0], nil
+}
+
+// Instance is the state of one instance of an app's process.
+type Instance struct {
+	Index  int    `json:"index"`
+	State  string `json:"state"`
+	Uptime int    `json:"uptime"`
+}
+
+// Instances lists the instances of a process of an app, such as web.
+func (c *Client) Instances(appGuid string, processType string) ([]Instance, error) {
+	return c.instances(fmt.Sprintf("/v3/apps/%s/processes/%s/stats", appGuid, processType))
+}
+
+// ProcessInstances lists the instances of a process, such as a new process of a deployment.
+func (c *Client) ProcessInstances(processGuid string) ([]Instance, error) {
+	return c.instances(fmt.Sprintf("/v3/processes/%s/stats", processGuid))
+}
+
+func (c *Client) instances(path string) ([]Instance, error) {
+	instances := []Instance{}
+	err := c.eachPage(path, func(resources json.RawMessage) error {
+		page := []Instance{}
+		err := json.Unmarshal(resources, &page)
+		instances = append(instances, page...)
+		return err
+	})
+	return instances, err
+}
+
+// Apps lists the apps in a space.
+func (c *Client) Apps(spaceGuid string) ([]App, error) {
+	apps := []App{}
+	err := c.eachPage("/v3/apps?space_guids="+url.QueryEscape(spaceGuid), func(resources json.RawMessage) error {
+		page := []App{}
+		err := json.Unmarshal(resources, &page)
+		apps = append(apps, page...)
+		return err
+	})
+	return apps, err
+}
+
+func (c *Client) App(guid string) (App, error) {
+	app := App{}
+	err := c.Get("/v3/apps/"+guid, &app)
+	return app, err
+}
+
+func (c *Client) CreateApp(name string, spaceGuid string) (App, error) {
+	request := struct {
+		Name          string `json:"name"`
+		Relationships struct {
+			Space Relationship `json:"space"`
+		} `json:"relationships"`
+	}{Name: name}
+	request.Relationships.Space = toOne(spaceGuid)
+
+	app := App{}
+	err := c.Post("/v3/apps", request, &app)
+	return app, err
+}
+
+func (c *Client) RenameApp(guid string, name string) error {
+	return c.Patch("/v3/apps/"+guid, map[string]string{"name": name}, nil)
+}
+
+func (c *Client) DeleteApp(guid string) error {
+	return c.Do("DELETE", "/v3/apps/"+guid, nil, nil)
+}
+
+// RestartApp stops an app if it is running and starts it with its current droplet.
+func (c *Client) RestartApp(guid string) error {
+	return c.Post(fmt.Sprintf("/v3/apps/%s/actions/restart", guid), nil, nil)
+}
+
+type Droplet struct {
+	Guid  string `json:"guid"`
+	State string `json:"state"`
+}
+
+func (c *Client) CurrentDroplet(appGuid string) (Droplet, error) {
+	droplet := Droplet{}
+	err := c.Get(fmt.Sprintf("/v3/apps/%s/droplets/current", appGuid), &droplet)
+	return droplet, err
+}
+
+// CopyDroplet copies a droplet to another app. The copy belongs to that app, so it stays when
+// the app the droplet was copied from is deleted.
+func (c *Client) CopyDroplet(dropletGuid string, appGuid string) (Droplet, error) {
+	request := struct {
+		Relationships struct {
+			App Relationship `json:"app"`
+		} `json:"relationships"`
+	}{}
+	request.Relationships.App = toOne(appGuid)
+
+	droplet := Droplet{}
+	err := c.Post("/v3/droplets?source_guid="+url.QueryEscape(dropletGuid), request, &droplet)
+	return droplet, err
+}
+
+func (c *Client) SetCurrentDroplet(appGuid string, dropletGuid string) error {
+	return c.Patch(fmt.Sprintf("/v3/apps/%s/relationships/current_droplet", appGuid), toOne(dropletGuid), nil)
+}
+
+// AppRoutes lists the routes mapped to an app.
+func (c *Client) AppRoutes(appGuid string) ([]Route, error) {
+	routes := []Route{}
+	err := c.eachPage(fmt.Sprintf("/v3/apps/%s/routes", appGuid), func(resources json.RawMessage) error {
+		page := []Route{}
+		err := json.Unmarshal(resources, &page)
+		routes = append(routes, page...)
+		return err
+	})
+	return routes, err
+}
+
+type Process struct {
+	Guid       string `json:"guid"`
+	Type       string `json:"type"`
+	Instances  int    `json:"instances"`
+	MemoryInMb int64  `json:"memory_in_mb"`
+	DiskInMb   int64  `json:"disk_in_mb"`
+}
+
+func (c *Client) Process(appGuid string, processType string) (Process, error) {
+	process := Process{}
+	err := c.Get(fmt.Sprintf("/v3/apps/%s/processes/%s", appGuid, processType), &process)
+	return process, err
+}
+
+func (c *Client) ScaleProcess(appGuid string, processType string, instances int) error {
+	return c.Post(fmt.Sprintf("/v3/apps/%s/processes/%s/actions/scale", appGuid, processType),
+		map[string]int{"instances": instances}, nil)
+}
+
+// EnvironmentVariables returns the environment variables set on an app.
+func (c *Client) EnvironmentVariables(appGuid string) (map[string]interface{}, error) {
+	variables := struct {
+		Var map[string]interface{} `json:"var"`
+	}{}
+	err := c.Get(fmt.Sprintf("/v3/apps/%s/environment_variables", appGuid), &variables)
+	return variables.Var, err
+}
+
+// SetEnvironmentVariables adds environment variables to an app, keeping the ones it has. A
+// variable set to nil is removed. Running instances only see the change once they restart.
+func (c *Client) SetEnvironmentVariables(appGuid string, variables map[string]interface{}) error {
+	body := map[string]interface{}{"var": variables}
+	return c.Patch(fmt.Sprintf("/v3/apps/%s/environment_variables", appGuid), body, nil)
+}
+
+func (c *Client) SshEnabled(appGuid string) (bool, error) {
+	sshEnabled := struct {
+		Enabled bool `json:"enabled"`
+	}{}
+	err := c.Get(fmt.Sprintf("/v3/apps/%s/ssh_enabled", appGuid), &sshEnabled)
+	return sshEnabled.Enabled, err
+}
+
+func (c *Client) SetSshEnabled(appGuid string, enabled bool) error {
+	return c.Patch(fmt.Sprintf("/v3/apps/%s/features/ssh", appGuid), map[string]bool{"enabled": enabled}, nil)
+}
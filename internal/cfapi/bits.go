@@ -0,0 +1,177 @@
+package cfapi
+
+import (
+	"archive/zip"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// defaultIgnoreLines are the lines the cf CLI puts before those of every .cfignore, so that these
+// files are never uploaded.
+var defaultIgnoreLines = []string{".cfignore", "/manifest.yml", ".gitignore", ".git", ".hg", ".svn", "_darcs", ".DS_Store"}
+
+type ignorePattern struct {
+	exclude bool
+	glob    *regexp.Regexp
+}
+
+// cfIgnore decides which files of an app are uploaded the way the cf CLI reads a .cfignore. Every
+// line is a glob, where * matches within a directory and ** across directories, which matches
+// a file or directory and everything in it. A line starting with / is anchored to the app's
+// directory, any other matches at any depth. A line starting with ! uploads what earlier lines
+// ignore. The last line which matches a file decides.
+type cfIgnore []ignorePattern
+
+func newCfIgnore(text string) cfIgnore {
+	ignore := cfIgnore{}
+	for _, line := range append(defaultIgnoreLines, strings.Split(text, "\n")...) {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		exclude := true
+		if strings.HasPrefix(line, "!") {
+			line = line[1:]
+			exclude = false
+		}
+
+		pattern := path.Clean(line)
+		globs := []string{pattern, path.Join(pattern, "*"), path.Join(pattern, "**", "*")}
+		if !strings.HasPrefix(pattern, "/") {
+			globs = append(globs, path.Join("**", pattern), path.Join("**", pattern, "*"), path.Join("**", pattern, "**", "*"))
+		}
+		for _, glob := range globs {
+			ignore = append(ignore, ignorePattern{exclude: exclude, glob: globRegexp(glob)})
+		}
+	}
+	return ignore
+}
+
+// globRegexp turns a glob into a regular expression matching the whole path.
+func globRegexp(glob string) *regexp.Regexp {
+	expression := &strings.Builder{}
+	expression.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**"):
+			expression.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			expression.WriteString("[^/]*")
+		case glob[i] == '?':
+			expression.WriteString("[^/]")
+		default:
+			expression.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	expression.WriteString("$")
+	return regexp.MustCompile(expression.String())
+}
+
+// ignored tells whether a file, given by its path relative to the app's directory, is left out.
+func (c cfIgnore) ignored(relativePath string) bool {
+	ignored := false
+	for _, pattern := range c {
+		matchedPath := relativePath
+		if strings.HasPrefix(pattern.glob.String(), "^/") {
+			matchedPath = "/" + relativePath
+		}
+		if pattern.glob.MatchString(matchedPath) {
+			ignored = pattern.exclude
+		}
+	}
+	return ignored
+}
+
+// readCfIgnore reads the .cfignore of an app, if it has one.
+func readCfIgnore(appPath string) (cfIgnore, error) {
+	text, err := ioutil.ReadFile(filepath.Join(appPath, ".cfignore"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return newCfIgnore(string(text)), nil
+}
+
+// writeAppBits zips the files of an app for upload. A file, such as a jar, is taken to be an
+// archive of the app already and is uploaded as it is. Every file is looked at, even in ignored
+// directories, since a later line of the .cfignore may upload it after all.
+func writeAppBits(out io.Writer, appPath string) error {
+	info, err := os.Stat(appPath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		file, err := os.Open(appPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(out, file)
+		return err
+	}
+
+	ignore, err := readCfIgnore(appPath)
+	if err != nil {
+		return err
+	}
+
+	writer := zip.NewWriter(out)
+	err = filepath.Walk(appPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relativePath, err := filepath.Rel(appPath, path)
+		if err != nil {
+			return err
+		}
+		relativePath = filepath.ToSlash(relativePath)
+		if ignore.ignored(relativePath) {
+			return nil
+		}
+
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = relativePath
+		header.Method = zip.Deflate
+		fileWriter, err := writer.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(fileWriter, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// writeUploadForm writes the multipart form the Cloud Controller takes the bits of a package in,
+// zipping the app's files straight into it, and returns the form's content type.
+func writeUploadForm(out io.Writer, appPath string) (string, error) {
+	writer := multipart.NewWriter(out)
+	part, err := writer.CreateFormFile("bits", "application.zip")
+	if err != nil {
+		return "", err
+	}
+	if err := writeAppBits(part, appPath); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	return writer.FormDataContentType(), nil
+}
@@ -0,0 +1,66 @@
+package cfapi_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/bluemixgaragelondon/cf-blue-green-deploy/internal/cfapi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("App bits", func() {
+	// The cases follow what cf push uploads for the same .cfignore
+	DescribeTable("ignores files like cf push",
+		func(cfignore string, relativePath string, ignored bool) {
+			Expect(CfIgnored(cfignore, relativePath)).To(Equal(ignored))
+		},
+		Entry("the .cfignore itself", "", ".cfignore", true),
+		Entry("version control directories", "", ".git/objects/ab/cdef", true),
+		Entry("the manifest next to the app", "", "manifest.yml", true),
+		Entry("a manifest further down", "", "config/manifest.yml", false),
+		Entry("a directory at any depth", "node_modules", "lib/node_modules/left-pad/index.js", true),
+		Entry("an anchored directory", "/tmp", "tmp/cache", true),
+		Entry("an anchored directory further down", "/tmp", "src/tmp/cache", false),
+		Entry("a wildcard within a directory", "*.log", "logs/app.log", true),
+		Entry("a wildcard which does not cross directories", "logs/*.log", "logs/2020/app.log", false),
+		Entry("** across directories", "docs/**/*.md", "docs/api/v1/index.md", true),
+		Entry("** with nothing in between", "docs/**/*.md", "docs/index.md", false),
+		Entry("a negated line", "*.log\n!keep.log", "logs/keep.log", false),
+		Entry("a negated line before the line it would undo", "!keep.log\n*.log", "keep.log", true),
+		Entry("a negated default", "!manifest.yml", "manifest.yml", false),
+		Entry("a line starting with #", "#notes", "#notes", true),
+	)
+
+	It("zips the files which are not ignored", func() {
+		appDir, err := ioutil.TempDir("", "bits")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(appDir)
+		files := map[string]string{
+			".cfignore":          "target\n!target/app.jar\n",
+			"manifest.yml":       "applications: []",
+			"src/main.go":        "package main",
+			"target/app.jar":     "jar",
+			"target/classes/a.c": "class",
+		}
+		for name, content := range files {
+			Expect(os.MkdirAll(filepath.Dir(filepath.Join(appDir, name)), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(appDir, name), []byte(content), 0644)).To(Succeed())
+		}
+
+		bits := &bytes.Buffer{}
+		Expect(WriteAppBits(bits, appDir)).To(Succeed())
+
+		archive, err := zip.NewReader(bytes.NewReader(bits.Bytes()), int64(bits.Len()))
+		Expect(err).ToNot(HaveOccurred())
+		names := []string{}
+		for _, file := range archive.File {
+			names = append(names, file.Name)
+		}
+		Expect(names).To(ConsistOf("src/main.go", "target/app.jar"))
+	})
+})
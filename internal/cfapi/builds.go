@@ -0,0 +1,174 @@
+package cfapi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+type Package struct {
+	Guid  string `json:"guid"`
+	State string `json:"state"`
+}
+
+// CreatePackage creates an empty bits package for an app, which the app's files are then
+// uploaded to.
+func (c *Client) CreatePackage(appGuid string) (Package, error) {
+	request := struct {
+		Type          string `json:"type"`
+		Relationships struct {
+			App Relationship `json:"app"`
+		} `json:"relationships"`
+	}{Type: "bits"}
+	request.Relationships.App = toOne(appGuid)
+
+	pkg := Package{}
+	err := c.Post("/v3/packages", request, &pkg)
+	return pkg, err
+}
+
+func (c *Client) Package(guid string) (Package, error) {
+	pkg := Package{}
+	err := c.Get("/v3/packages/"+guid, &pkg)
+	return pkg, err
+}
+
+// UploadPackage uploads the files of an app, zipped like cf push does, to a new package and
+// waits for the Cloud Controller to have processed them.
+func (c *Client) UploadPackage(appGuid string, appPath string) (Package, error) {
+	formFile, err := ioutil.TempFile("", "bgd-upload")
+	if err != nil {
+		return Package{}, err
+	}
+	defer os.Remove(formFile.Name())
+	contentType, err := writeUploadForm(formFile, appPath)
+	if closeErr := formFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return Package{}, err
+	}
+
+	pkg, err := c.CreatePackage(appGuid)
+	if err != nil {
+		return pkg, err
+	}
+	if err := c.Upload(fmt.Sprintf("/v3/packages/%s/upload", pkg.Guid), contentType, formFile.Name(), nil); err != nil {
+		return pkg, err
+	}
+
+	err = Poll("processing the upload", func() (bool, error) {
+		if pkg, err = c.Package(pkg.Guid); err != nil {
+			return false, err
+		}
+		switch pkg.State {
+		case "READY":
+			return true, nil
+		case "FAILED", "EXPIRED":
+			return false, fmt.Errorf("Package %s is %s", pkg.Guid, pkg.State)
+		}
+		return false, nil
+	})
+	return pkg, err
+}
+
+// Build stages a package into a droplet. Its state goes from STAGING to STAGED or FAILED.
+type Build struct {
+	Guid    string `json:"guid"`
+	State   string `json:"state"`
+	Error   string `json:"error"`
+	Droplet *struct {
+		Guid string `json:"guid"`
+	} `json:"droplet"`
+}
+
+func (c *Client) CreateBuild(packageGuid string) (Build, error) {
+	request := map[string]interface{}{"package": map[string]string{"guid": packageGuid}}
+
+	build := Build{}
+	err := c.Post("/v3/builds", request, &build)
+	return build, err
+}
+
+func (c *Client) Build(guid string) (Build, error) {
+	build := Build{}
+	err := c.Get("/v3/builds/"+guid, &build)
+	return build, err
+}
+
+// StagePackage builds a package into a droplet and returns the droplet's guid.
+func (c *Client) StagePackage(packageGuid string) (string, error) {
+	build, err := c.CreateBuild(packageGuid)
+	if err != nil {
+		return "", fmt.Errorf("Could not stage the app: %v", err)
+	}
+
+	err = Poll("staging the app", func() (bool, error) {
+		if build, err = c.Build(build.Guid); err != nil {
+			return false, err
+		}
+		switch build.State {
+		case "STAGED":
+			return true, nil
+		case "FAILED":
+			return false, fmt.Errorf("Staging failed: %s", build.Error)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if build.Droplet == nil {
+		return "", fmt.Errorf("Staging did not create a droplet")
+	}
+	return build.Droplet.Guid, nil
+}
+
+// Job is an asynchronous Cloud Controller operation. Its state ends up COMPLETE or FAILED, and
+// the errors of a failed job are returned by Job as Errors.
+type Job struct {
+	Guid  string `json:"guid"`
+	State string `json:"state"`
+}
+
+func (c *Client) Job(guid string) (Job, error) {
+	job := Job{}
+	err := c.Get("/v3/jobs/"+guid, &job)
+	return job, err
+}
+
+// DeploymentScale is the scale of the new web instances of a deployment. Parts left at 0 are
+// taken over from the running instances.
+type DeploymentScale struct {
+	Instances  int   `json:"web_instances,omitempty"`
+	MemoryInMb int64 `json:"memory_in_mb,omitempty"`
+	DiskInMb   int64 `json:"disk_in_mb,omitempty"`
+}
+
+// CreateDropletDeployment starts a rolling deployment of a droplet of an app. The scale only
+// applies to the new instances, so the running ones are left as they are until they are
+// replaced.
+func (c *Client) CreateDropletDeployment(appGuid string, dropletGuid string, scale DeploymentScale) (Deployment, error) {
+	request := struct {
+		Droplet struct {
+			Guid string `json:"guid"`
+		} `json:"droplet"`
+		Strategy      string           `json:"strategy"`
+		Options       *DeploymentScale `json:"options,omitempty"`
+		Relationships struct {
+			App Relationship `json:"app"`
+		} `json:"relationships"`
+	}{Strategy: "rolling"}
+	request.Droplet.Guid = dropletGuid
+	if scale != (DeploymentScale{}) {
+		request.Options = &scale
+	}
+	request.Relationships.App = toOne(appGuid)
+
+	deployment := Deployment{}
+	err := c.Post("/v3/deployments", request, &deployment)
+	if err != nil {
+		return deployment, fmt.Errorf("Could not start the deployment: %v", err)
+	}
+	return deployment, nil
+}
@@ -0,0 +1,15 @@
+package cfapi_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestCfapi(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter("cfapi-junit.xml")
+	RunSpecsWithDefaultAndCustomReporters(t, "Cfapi Suite", []Reporter{junitReporter})
+}
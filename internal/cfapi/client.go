@@ -0,0 +1,272 @@
+// Package cfapi is a typed client for the Cloud Controller API. It talks to the Cloud Controller
+// through cf curl, so that it uses the target and credentials of the cf CLI the plugin runs in.
+package cfapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+type Client struct {
+	Connection plugin.CliConnection
+
+	// Retry sends a request with the given method, and may send it again when it fails. Without
+	// it, every request is sent once.
+	Retry func(method string, request func() ([]string, error)) ([]string, error)
+}
+
+func NewClient(connection plugin.CliConnection) *Client {
+	return &Client{Connection: connection}
+}
+
+// Error is a single error of a Cloud Controller v3 error document.
+type Error struct {
+	Code   int    `json:"code"`
+	Title  string `json:"title"`
+	Detail string `json:"detail"`
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Title, e.Detail)
+}
+
+// Errors is a Cloud Controller v3 error document.
+type Errors []Error
+
+func (e Errors) Error() string {
+	messages := []string{}
+	for _, ccError := range e {
+		messages = append(messages, ccError.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// IsNotFound reports whether err is the error document the Cloud Controller returns for an
+// endpoint it does not have, such as the v3 API on a Cloud Controller which predates it.
+func IsNotFound(err error) bool {
+	switch ccErr := err.(type) {
+	case Errors:
+		for _, ccError := range ccErr {
+			if ccError.Title == "CF-NotFound" || ccError.Code == 10000 {
+				return true
+			}
+		}
+	case *CcError:
+		return ccErr.ErrorCode == "CF-NotFound" || ccErr.Code == 10000
+	}
+	return false
+}
+
+// NotFoundError is returned by the lookups by name, such as AppByName, which find nothing.
+type NotFoundError struct {
+	Kind string
+	Name string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("Could not find %s %s", e.Kind, e.Name)
+}
+
+// CcError is an error document returned by the Cloud Controller v2 API in place of the
+// requested resource.
+type CcError struct {
+	Code        int    `json:"code"`
+	Description string `json:"description"`
+	ErrorCode   string `json:"error_code"`
+}
+
+func (e *CcError) Error() string {
+	return fmt.Sprintf("%s: %s (code %d)", e.ErrorCode, e.Description, e.Code)
+}
+
+// Get decodes the response to a GET request into result.
+func (c *Client) Get(path string, result interface{}) error {
+	return c.Do("GET", path, nil, result)
+}
+
+func (c *Client) Post(path string, body interface{}, result interface{}) error {
+	return c.Do("POST", path, body, result)
+}
+
+func (c *Client) Patch(path string, body interface{}, result interface{}) error {
+	return c.Do("PATCH", path, body, result)
+}
+
+// Do sends a request through cf curl. The body is encoded as JSON and the response decoded into
+// result unless they are nil. Since cf curl succeeds for Cloud Controller error documents, these
+// are returned as Errors or *CcError.
+func (c *Client) Do(method string, path string, body interface{}, result interface{}) error {
+	args := []string{"curl", path}
+	if method != "GET" {
+		args = append(args, "-X", method)
+	}
+	if body != nil {
+		encodedBody, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		args = append(args, "-d", string(encodedBody))
+	}
+
+	return c.curl(method, args, result)
+}
+
+// Upload posts the file at bodyPath, such as a multipart form, with the given content type.
+// cf curl reads the body from the file itself, so any content can be sent.
+func (c *Client) Upload(path string, contentType string, bodyPath string, result interface{}) error {
+	return c.curl("POST", []string{"curl", path, "-X", "POST", "-H", "Content-Type: " + contentType, "-d", "@" + bodyPath}, result)
+}
+
+func (c *Client) curl(method string, args []string, result interface{}) error {
+	request := func() ([]string, error) {
+		output, err := c.Connection.CliCommandWithoutTerminalOutput(args...)
+		if err != nil {
+			return output, err
+		}
+		return output, responseError([]byte(strings.Join(output, "\n")))
+	}
+
+	var output []string
+	var err error
+	if c.Retry != nil {
+		output, err = c.Retry(method, request)
+	} else {
+		output, err = request()
+	}
+	if err != nil || result == nil {
+		return err
+	}
+	return json.Unmarshal([]byte(strings.Join(output, "\n")), result)
+}
+
+// responseError is the error of a response which is a Cloud Controller error document, or which
+// is not JSON at all, such as the error page of a router in front of the Cloud Controller.
+func responseError(response []byte) error {
+	trimmed := bytes.TrimSpace(response)
+	if len(trimmed) > 0 && !json.Valid(trimmed) {
+		return fmt.Errorf("Unexpected response from the Cloud Controller: %s", trimmed)
+	}
+	return DecodeError(response)
+}
+
+// DecodeError returns the error of a Cloud Controller error document, or nil for any other
+// response.
+func DecodeError(response []byte) error {
+	document := struct {
+		CcError
+		Errors Errors `json:"errors"`
+	}{}
+	if err := json.Unmarshal(response, &document); err != nil {
+		// Not an object, so not an error document either
+		return nil
+	}
+	if len(document.Errors) > 0 {
+		return document.Errors
+	}
+	if document.ErrorCode != "" {
+		ccError := document.CcError
+		return &ccError
+	}
+	return nil
+}
+
+// eachPage requests a v3 listing and every following page, handing the resources of each page
+// to appendPage.
+func (c *Client) eachPage(path string, appendPage func(resources json.RawMessage) error) error {
+	for nextPath := path; nextPath != ""; {
+		page := struct {
+			Pagination struct {
+				Next *struct {
+					Href string `json:"href"`
+				} `json:"next"`
+			} `json:"pagination"`
+			Resources json.RawMessage `json:"resources"`
+		}{}
+		if err := c.Get(nextPath, &page); err != nil {
+			return err
+		}
+		if len(page.Resources) > 0 {
+			if err := appendPage(page.Resources); err != nil {
+				return err
+			}
+		}
+
+		nextPath = ""
+		if page.Pagination.Next != nil {
+			var err error
+			if nextPath, err = requestPath(page.Pagination.Next.Href); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// eachV2Page is eachPage for the v2 API, which links to the next page with next_url.
+func (c *Client) eachV2Page(path string, appendPage func(resources json.RawMessage) error) error {
+	for nextPath := path; nextPath != ""; {
+		page := struct {
+			NextUrl   string          `json:"next_url"`
+			Resources json.RawMessage `json:"resources"`
+		}{}
+		if err := c.Get(nextPath, &page); err != nil {
+			return err
+		}
+		if len(page.Resources) > 0 {
+			if err := appendPage(page.Resources); err != nil {
+				return err
+			}
+		}
+		nextPath = page.NextUrl
+	}
+	return nil
+}
+
+// requestPath turns the absolute pagination links of the v3 API into paths for cf curl.
+func requestPath(href string) (string, error) {
+	link, err := url.Parse(href)
+	if err != nil {
+		return "", err
+	}
+	return link.RequestURI(), nil
+}
+
+// Relationship links a resource to another one by guid.
+type Relationship struct {
+	Data *struct {
+		Guid string `json:"guid"`
+	} `json:"data"`
+}
+
+func toOne(guid string) Relationship {
+	relationship := Relationship{}
+	relationship.Data = &struct {
+		Guid string `json:"guid"`
+	}{Guid: guid}
+	return relationship
+}
+
+// Metadata holds the labels and annotations of a resource.
+type Metadata struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// SetLabels adds labels to the resource at path, such as /v3/apps/:guid. Labels set to an
+// empty value are removed.
+func (c *Client) SetLabels(path string, labels map[string]string) error {
+	values := map[string]interface{}{}
+	for key, value := range labels {
+		if value == "" {
+			values[key] = nil
+		} else {
+			values[key] = value
+		}
+	}
+	return c.Patch(path, map[string]interface{}{"metadata": map[string]interface{}{"labels": values}}, nil)
+}
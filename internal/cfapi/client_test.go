@@ -0,0 +1,170 @@
+package cfapi_test
+
+import (
+	"errors"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin/models"
+	"code.cloudfoundry.org/cli/plugin/pluginfakes"
+	. "github.com/bluemixgaragelondon/cf-blue-green-deploy/internal/cfapi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		connection *pluginfakes.FakeCliConnection
+		client     *Client
+		requests   []string
+		responses  map[string]string
+	)
+
+	BeforeEach(func() {
+		requests = []string{}
+		responses = map[string]string{}
+		connection = &pluginfakes.FakeCliConnection{}
+		connection.CliCommandWithoutTerminalOutputStub = func(args ...string) ([]string, error) {
+			requests = append(requests, strings.Join(args, " "))
+			return strings.Split(responses[args[1]], "\n"), nil
+		}
+		client = NewClient(connection)
+	})
+
+	Describe("Do", func() {
+		It("sends the method and JSON body through cf curl", func() {
+			responses["/v3/things"] = `{"name": "created"}`
+
+			result := struct{ Name string }{}
+			Expect(client.Post("/v3/things", map[string]string{"name": "new"}, &result)).To(Succeed())
+
+			Expect(requests).To(Equal([]string{`curl /v3/things -X POST -d {"name":"new"}`}))
+			Expect(result.Name).To(Equal("created"))
+		})
+
+		It("returns the errors of a v3 error document", func() {
+			responses["/v3/things"] = `{"errors": [
+				{"code": 10010, "title": "CF-ResourceNotFound", "detail": "Thing not found"},
+				{"code": 10008, "title": "CF-UnprocessableEntity", "detail": "Bad thing"}
+			]}`
+
+			err := client.Get("/v3/things", nil)
+			Expect(err).To(MatchError("CF-ResourceNotFound: Thing not found; CF-UnprocessableEntity: Bad thing"))
+			Expect(err.(Errors)[0].Code).To(Equal(10010))
+		})
+
+		It("returns a v2 error document as a CcError", func() {
+			responses["/v2/things"] = `{"code": 10002, "description": "Authentication error", "error_code": "CF-NotAuthenticated"}`
+
+			err := client.Get("/v2/things", nil)
+			Expect(err).To(Equal(&CcError{Code: 10002, Description: "Authentication error", ErrorCode: "CF-NotAuthenticated"}))
+		})
+
+		It("returns the errors of cf curl itself", func() {
+			connection.CliCommandWithoutTerminalOutputReturns(nil, errors.New("not logged in"))
+			connection.CliCommandWithoutTerminalOutputStub = nil
+
+			Expect(client.Get("/v3/things", nil)).To(MatchError("not logged in"))
+		})
+
+		It("returns a response which is not JSON, such as a router error page, as an error", func() {
+			responses["/v3/things"] = "502 Bad Gateway: Registered endpoint failed to handle the request."
+
+			Expect(client.Get("/v3/things", nil)).To(MatchError("Unexpected response from the Cloud Controller: 502 Bad Gateway: Registered endpoint failed to handle the request."))
+		})
+
+		It("sends requests through Retry when it is set", func() {
+			responses["/v3/things"] = `{"name": "thing"}`
+			attempts := 0
+			client.Retry = func(method string, request func() ([]string, error)) ([]string, error) {
+				Expect(method).To(Equal("GET"))
+				for {
+					attempts++
+					if output, err := request(); err == nil || attempts == 2 {
+						return output, err
+					}
+				}
+			}
+
+			Expect(client.Get("/v3/things", nil)).To(Succeed())
+			Expect(attempts).To(Equal(1))
+		})
+
+		It("tells a missing endpoint apart from a missing resource", func() {
+			responses["/v3/things"] = `{"errors": [{"code": 10000, "title": "CF-NotFound", "detail": "Unknown request"}]}`
+			responses["/v3/things/guid"] = `{"errors": [{"code": 10010, "title": "CF-ResourceNotFound", "detail": "Thing not found"}]}`
+
+			Expect(IsNotFound(client.Get("/v3/things", nil))).To(BeTrue())
+			Expect(IsNotFound(client.Get("/v3/things/guid", nil))).To(BeFalse())
+			Expect(IsNotFound(errors.New("not logged in"))).To(BeFalse())
+		})
+	})
+
+	Describe("Domains", func() {
+		It("follows the pagination links", func() {
+			responses["/v3/organizations/org-guid/domains"] = `{
+				"pagination": {"next": {"href": "https://api.example.com/v3/organizations/org-guid/domains?page=2"}},
+				"resources": [{"guid": "shared-guid", "name": "example.com", "relationships": {"organization": {"data": null}}}]
+			}`
+			responses["/v3/organizations/org-guid/domains?page=2"] = `{
+				"pagination": {"next": null},
+				"resources": [{"guid": "private-guid", "name": "mine.com", "relationships": {"organization": {"data": {"guid": "org-guid"}}}}]
+			}`
+
+			domains, err := client.Domains("org-guid")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(domains).To(HaveLen(2))
+			Expect(domains[0].Shared()).To(BeTrue())
+			Expect(domains[1].Name).To(Equal("mine.com"))
+			Expect(domains[1].Shared()).To(BeFalse())
+		})
+
+		It("follows next_url through v2 listings", func() {
+			responses["/v2/shared_domains"] = `{"next_url": "/v2/shared_domains?page=2", "resources": [{"entity": {"name": "first.com"}}]}`
+			responses["/v2/shared_domains?page=2"] = `{"next_url": null, "resources": [{"entity": {"name": "second.com"}}]}`
+
+			Expect(client.V2DomainNames("/v2/shared_domains")).To(Equal([]string{"first.com", "second.com"}))
+		})
+	})
+
+	Describe("FindRoute", func() {
+		BeforeEach(func() {
+			responses["/v3/domains?names=example.com"] = `{"resources": [{"guid": "domain-guid", "name": "example.com"}]}`
+			responses["/v3/routes?domain_guids=domain-guid&hosts=www&paths=%2Fapi"] = `{"resources": [{"guid": "route-guid", "host": "www", "path": "/api"}]}`
+		})
+
+		It("finds the route by domain, host and path", func() {
+			route, err := client.FindRoute("example.com", "www", "api", 0)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(route.Guid).To(Equal("route-guid"))
+		})
+
+		It("reports a missing route", func() {
+			responses["/v3/routes?domain_guids=domain-guid"] = `{"resources": [{"guid": "route-guid", "host": "www", "path": "/api"}]}`
+
+			_, err := client.FindRoute("example.com", "", "", 0)
+			Expect(err).To(MatchError("Could not find route example.com"))
+			Expect(err).To(BeAssignableToTypeOf(&NotFoundError{}))
+		})
+	})
+
+	Describe("AppByName", func() {
+		It("looks the app up in the targeted space", func() {
+			connection.GetCurrentSpaceReturns(plugin_models.Space{SpaceFields: plugin_models.SpaceFields{Guid: "space-guid"}}, nil)
+			responses["/v3/apps?names=my-app&space_guids=space-guid"] = `{"resources": [{"guid": "app-guid", "name": "my-app"}]}`
+
+			app, err := client.AppByName("my-app")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(app.Guid).To(Equal("app-guid"))
+		})
+	})
+
+	Describe("SetLabels", func() {
+		It("patches the metadata and removes empty labels", func() {
+			Expect(client.SetLabels("/v3/apps/app-guid", map[string]string{"stage": ""})).To(Succeed())
+			Expect(requests).To(Equal([]string{`curl /v3/apps/app-guid -X PATCH -d {"metadata":{"labels":{"stage":null}}}`}))
+		})
+	})
+})
@@ -0,0 +1,77 @@
+package cfapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+type Deployment struct {
+	Guid   string `json:"guid"`
+	Status struct {
+		Value  string `json:"value"`
+		Reason string `json:"reason"`
+	} `json:"status"`
+
+	// NewProcesses are the processes the deployment starts the new instances in.
+	NewProcesses []struct {
+		Guid string `json:"guid"`
+		Type string `json:"type"`
+	} `json:"new_processes"`
+}
+
+func (c *Client) Deployment(guid string) (Deployment, error) {
+	deployment := Deployment{}
+	err := c.Get("/v3/deployments/"+guid, &deployment)
+	return deployment, err
+}
+
+// CreateDeployment starts a rolling deployment of a revision of an app.
+func (c *Client) CreateDeployment(appGuid string, revisionGuid string) (Deployment, error) {
+	request := struct {
+		Relationships struct {
+			App Relationship `json:"app"`
+		} `json:"relationships"`
+		Revision struct {
+			Guid string `json:"guid"`
+		} `json:"revision"`
+	}{}
+	request.Relationships.App = toOne(appGuid)
+	request.Revision.Guid = revisionGuid
+
+	deployment := Deployment{}
+	err := c.Post("/v3/deployments", request, &deployment)
+	return deployment, err
+}
+
+func (c *Client) CancelDeployment(guid string) error {
+	return c.Post(fmt.Sprintf("/v3/deployments/%s/actions/cancel", guid), nil, nil)
+}
+
+type Revision struct {
+	Guid        string   `json:"guid"`
+	Version     int      `json:"version"`
+	Description string   `json:"description"`
+	CreatedAt   string   `json:"created_at"`
+	Deployable  bool     `json:"deployable"`
+	Metadata    Metadata `json:"metadata"`
+	Droplet     struct {
+		Guid string `json:"guid"`
+	} `json:"droplet"`
+}
+
+// Revisions lists the revisions of an app, newest first.
+func (c *Client) Revisions(appGuid string) ([]Revision, error) {
+	query := url.Values{}
+	query.Set("order_by", "-created_at")
+	query.Set("per_page", "5000")
+
+	revisions := []Revision{}
+	err := c.eachPage(fmt.Sprintf("/v3/apps/%s/revisions?%s", appGuid, query.Encode()), func(resources json.RawMessage) error {
+		page := []Revision{}
+		err := json.Unmarshal(resources, &page)
+		revisions = append(revisions, page...)
+		return err
+	})
+	return revisions, err
+}
@@ -0,0 +1,89 @@
+package cfapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+type Domain struct {
+	Guid        string `json:"guid"`
+	Name        string `json:"name"`
+	Internal    bool   `json:"internal"`
+	RouterGroup *struct {
+		Guid string `json:"guid"`
+	} `json:"router_group"`
+	Relationships struct {
+		Organization Relationship `json:"organization"`
+	} `json:"relationships"`
+}
+
+// Shared reports whether the domain is shared by all orgs rather than owned by one.
+func (d Domain) Shared() bool {
+	return d.Relationships.Organization.Data == nil
+}
+
+// Domains lists the domains available to an org, or all domains visible to the user when
+// orgGuid is empty.
+func (c *Client) Domains(orgGuid string) ([]Domain, error) {
+	path := "/v3/domains"
+	if orgGuid != "" {
+		path = fmt.Sprintf("/v3/organizations/%s/domains", orgGuid)
+	}
+	return c.listDomains(path)
+}
+
+func (c *Client) DomainByName(name string) (Domain, error) {
+	domains, err := c.listDomains("/v3/domains?names=" + url.QueryEscape(name))
+	if err != nil {
+		return Domain{}, err
+	}
+	if len(domains) == 0 {
+		return Domain{}, &NotFoundError{Kind: "domain", Name: name}
+	}
+	return domains[0], nil
+}
+
+func (c *Client) Domain(guid string) (Domain, error) {
+	domain := Domain{}
+	err := c.Get("/v3/domains/"+guid, &domain)
+	return domain, err
+}
+
+// DefaultDomain is the domain cf push uses for apps of the org which have no routes.
+func (c *Client) DefaultDomain(orgGuid string) (Domain, error) {
+	domain := Domain{}
+	err := c.Get(fmt.Sprintf("/v3/organizations/%s/domains/default", orgGuid), &domain)
+	return domain, err
+}
+
+func (c *Client) listDomains(path string) ([]Domain, error) {
+	domains := []Domain{}
+	err := c.eachPage(path, func(resources json.RawMessage) error {
+		page := []Domain{}
+		err := json.Unmarshal(resources, &page)
+		domains = append(domains, page...)
+		return err
+	})
+	return domains, err
+}
+
+// V2DomainNames lists the names of the domains in a v2 listing such as /v2/shared_domains.
+func (c *Client) V2DomainNames(path string) ([]string, error) {
+	names := []string{}
+	err := c.eachV2Page(path, func(resources json.RawMessage) error {
+		page := []struct {
+			Entity struct {
+				Name string `json:"name"`
+			} `json:"entity"`
+		}{}
+		if err := json.Unmarshal(resources, &page); err != nil {
+			return err
+		}
+		for _, resource := range page {
+			names = append(names, resource.Entity.Name)
+		}
+		return nil
+	})
+	return names, err
+}
@@ -0,0 +1,9 @@
+package cfapi
+
+// CfIgnored tells whether a .cfignore holding the given text leaves out a file of the app.
+func CfIgnored(cfignore string, relativePath string) bool {
+	return newCfIgnore(cfignore).ignored(relativePath)
+}
+
+// WriteAppBits lets the tests look at the zip of an app's files.
+var WriteAppBits = writeAppBits
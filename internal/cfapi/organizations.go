@@ -0,0 +1,45 @@
+package cfapi
+
+import (
+	"net/url"
+)
+
+type Organization struct {
+	Guid string `json:"guid"`
+	Name string `json:"name"`
+}
+
+type Space struct {
+	Guid string `json:"guid"`
+	Name string `json:"name"`
+}
+
+func (c *Client) OrganizationByName(name string) (Organization, error) {
+	organizations := struct {
+		Resources []Organization `json:"resources"`
+	}{}
+	if err := c.Get("/v3/organizations?names="+url.QueryEscape(name), &organizations); err != nil {
+		return Organization{}, err
+	}
+	if len(organizations.Resources) == 0 {
+		return Organization{}, &NotFoundError{Kind: "org", Name: name}
+	}
+	return organizations.Resources[0], nil
+}
+
+func (c *Client) SpaceByName(orgGuid string, name string) (Space, error) {
+	query := url.Values{}
+	query.Set("names", name)
+	query.Set("organization_guids", orgGuid)
+
+	spaces := struct {
+		Resources []Space `json:"resources"`
+	}{}
+	if err := c.Get("/v3/spaces?"+query.Encode(), &spaces); err != nil {
+		return Space{}, err
+	}
+	if len(spaces.Resources) == 0 {
+		return Space{}, &NotFoundError{Kind: "space", Name: name}
+	}
+	return spaces.Resources[0], nil
+}
@@ -0,0 +1,29 @@
+package cfapi
+
+import (
+	"fmt"
+	"time"
+)
+
+// PollInterval is how often the state of asynchronous operations, such as staging an app, is
+// checked.
+var PollInterval = 2 * time.Second
+
+// PollTimeout is how long an asynchronous operation may take before it is given up on.
+var PollTimeout = 15 * time.Minute
+
+// Poll checks whether an asynchronous operation is done every PollInterval, until it is, fails,
+// or takes longer than PollTimeout.
+func Poll(operation string, done func() (bool, error)) error {
+	deadline := time.Now().Add(PollTimeout)
+	for {
+		finished, err := done()
+		if err != nil || finished {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Timed out %s after %v", operation, PollTimeout)
+		}
+		time.Sleep(PollInterval)
+	}
+}
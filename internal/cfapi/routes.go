@@ -0,0 +1,136 @@
+package cfapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+type Route struct {
+	Guid          string `json:"guid"`
+	Host          string `json:"host"`
+	Path          string `json:"path"`
+	Port          int    `json:"port"`
+	Relationships struct {
+		Domain Relationship `json:"domain"`
+	} `json:"relationships"`
+}
+
+// FindRoute looks up a route by its domain, host, path and port. Empty parts only match routes
+// without them.
+func (c *Client) FindRoute(domainName string, host string, path string, port int) (Route, error) {
+	domain, err := c.DomainByName(domainName)
+	if err != nil {
+		return Route{}, err
+	}
+
+	query := url.Values{}
+	query.Set("domain_guids", domain.Guid)
+	if host != "" {
+		query.Set("hosts", host)
+	}
+	if path != "" {
+		query.Set("paths", "/"+strings.TrimPrefix(path, "/"))
+	}
+	if port != 0 {
+		query.Set("ports", fmt.Sprintf("%d", port))
+	}
+
+	routes := []Route{}
+	err = c.eachPage("/v3/routes?"+query.Encode(), func(resources json.RawMessage) error {
+		page := []Route{}
+		err := json.Unmarshal(resources, &page)
+		routes = append(routes, page...)
+		return err
+	})
+	if err != nil {
+		return Route{}, err
+	}
+
+	for _, route := range routes {
+		// The filters are only narrowing for the parts the route has, so check the rest here
+		if route.Host == host && strings.TrimPrefix(route.Path, "/") == strings.TrimPrefix(path, "/") {
+			return route, nil
+		}
+	}
+
+	name := domainName
+	if host != "" {
+		name = host + "." + domainName
+	}
+	if path != "" {
+		name = name + "/" + strings.TrimPrefix(path, "/")
+	}
+	return Route{}, &NotFoundError{Kind: "route", Name: name}
+}
+
+// Destination is an app a route sends traffic to. Weight is only set for weighted routes.
+type Destination struct {
+	Guid string `json:"guid,omitempty"`
+	App  struct {
+		Guid    string `json:"guid"`
+		Process struct {
+			Type string `json:"type"`
+		} `json:"process"`
+	} `json:"app"`
+	Weight   *int   `json:"weight,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+}
+
+func NewDestination(appGuid string, processType string) Destination {
+	destination := Destination{}
+	destination.App.Guid = appGuid
+	destination.App.Process.Type = processType
+	return destination
+}
+
+// ReplaceRouteDestinations makes the route send its traffic to exactly the given destinations.
+func (c *Client) ReplaceRouteDestinations(routeGuid string, destinations []Destination) error {
+	body := map[string]interface{}{"destinations": destinations}
+	return c.Patch(fmt.Sprintf("/v3/routes/%s/destinations", routeGuid), body, nil)
+}
+
+// RouteDestinations lists the apps a route sends its traffic to.
+func (c *Client) RouteDestinations(routeGuid string) ([]Destination, error) {
+	destinations := struct {
+		Destinations []Destination `json:"destinations"`
+	}{}
+	err := c.Get(fmt.Sprintf("/v3/routes/%s/destinations", routeGuid), &destinations)
+	return destinations.Destinations, err
+}
+
+// InsertRouteDestinations adds destinations to a route, keeping the ones it already has.
+func (c *Client) InsertRouteDestinations(routeGuid string, destinations []Destination) error {
+	body := map[string]interface{}{"destinations": destinations}
+	return c.Post(fmt.Sprintf("/v3/routes/%s/destinations", routeGuid), body, nil)
+}
+
+func (c *Client) RemoveRouteDestination(routeGuid string, destinationGuid string) error {
+	return c.Do("DELETE", fmt.Sprintf("/v3/routes/%s/destinations/%s", routeGuid, destinationGuid), nil, nil)
+}
+
+func (c *Client) CreateRoute(spaceGuid string, domainGuid string, host string, path string) (Route, error) {
+	request := struct {
+		Host          string `json:"host,omitempty"`
+		Path          string `json:"path,omitempty"`
+		Relationships struct {
+			Space  Relationship `json:"space"`
+			Domain Relationship `json:"domain"`
+		} `json:"relationships"`
+	}{Host: host}
+	if path != "" {
+		request.Path = "/" + strings.TrimPrefix(path, "/")
+	}
+	request.Relationships.Space = toOne(spaceGuid)
+	request.Relationships.Domain = toOne(domainGuid)
+
+	route := Route{}
+	err := c.Post("/v3/routes", request, &route)
+	return route, err
+}
+
+func (c *Client) DeleteRoute(guid string) error {
+	return c.Do("DELETE", "/v3/routes/"+guid, nil, nil)
+}
@@ -0,0 +1,374 @@
+package standalone
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/internal/cfapi"
+)
+
+// commandFlags are the flags of the supported cf commands which take a value.
+var commandFlags = map[string]bool{
+	"-X": true, "-d": true, "-H": true, "-n": true, "-i": true, "-m": true, "-k": true,
+	"-f": true, "-p": true, "--path": true, "--strategy": true, "--vars-file": true, "--var": true,
+}
+
+// parseCommand splits the arguments of a cf command into its positional arguments and flags.
+// Flags without a value are set to the empty string; flags given more than once keep every value.
+func parseCommand(args []string) ([]string, map[string][]string, error) {
+	positional := []string{}
+	flags := map[string][]string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			positional = append(positional, arg)
+			continue
+		}
+		if !commandFlags[arg] || (arg == "-f" && !takesManifest(args[0])) {
+			flags[arg] = append(flags[arg], "")
+			continue
+		}
+		if i+1 == len(args) {
+			return nil, nil, fmt.Errorf("Flag %s needs a value", arg)
+		}
+		flags[arg] = append(flags[arg], args[i+1])
+		i++
+	}
+	return positional, flags, nil
+}
+
+// takesManifest tells the commands where -f names a manifest from the ones where it forces.
+func takesManifest(command string) bool {
+	return command == "push"
+}
+
+func flag(flags map[string][]string, name string) string {
+	if values := flags[name]; len(values) > 0 {
+		return values[len(values)-1]
+	}
+	return ""
+}
+
+// runCommand runs a cf command, writing what it reports to out and returning it as lines.
+func (c *Connection) runCommand(out io.Writer, args []string) ([]string, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("No command given")
+	}
+	positional, flags, err := parseCommand(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if positional[0] == "curl" {
+		return c.curl(positional, flags)
+	}
+
+	output := &commandOutput{out: out}
+	switch command, operands := positional[0], positional[1:]; {
+	case command == "push" && len(operands) == 1:
+		err = c.push(output, operands[0], flags)
+	case command == "map-route" && len(operands) == 2:
+		err = c.mapRoute(output, operands[0], operands[1], flag(flags, "-n"), flag(flags, "--path"))
+	case command == "unmap-route" && len(operands) == 2:
+		err = c.unmapRoute(output, operands[0], operands[1], flag(flags, "-n"), flag(flags, "--path"))
+	case command == "delete-route" && len(operands) == 1:
+		err = c.deleteRoute(output, operands[0], flag(flags, "-n"), flag(flags, "--path"))
+	case command == "rename" && len(operands) == 2:
+		err = c.rename(output, operands[0], operands[1])
+	case command == "scale" && len(operands) == 1:
+		err = c.scale(output, operands[0], flag(flags, "-i"))
+	case command == "ssh-enabled" && len(operands) == 1:
+		err = c.sshEnabled(output, operands[0])
+	case command == "enable-ssh" && len(operands) == 1:
+		err = c.setSshEnabled(output, operands[0], true)
+	case command == "disable-ssh" && len(operands) == 1:
+		err = c.setSshEnabled(output, operands[0], false)
+	case command == "delete" && len(operands) == 1:
+		_, deleteRoutes := flags["-r"]
+		err = c.deleteApp(output, operands[0], deleteRoutes)
+	default:
+		err = fmt.Errorf("cf %s is not supported by cf-bgd", strings.Join(args, " "))
+	}
+
+	if err != nil {
+		output.Printf("FAILED\n%v", err)
+	}
+	return output.lines, err
+}
+
+// commandOutput writes what a command reports and keeps it to be returned as its output.
+type commandOutput struct {
+	out   io.Writer
+	lines []string
+}
+
+func (o *commandOutput) Printf(format string, args ...interface{}) {
+	text := fmt.Sprintf(format, args...)
+	fmt.Fprintln(o.out, text)
+	o.lines = append(o.lines, strings.Split(text, "\n")...)
+}
+
+// curl sends a request like cf curl does, returning the response body as the output, even for
+// Cloud Controller error documents.
+func (c *Connection) curl(positional []string, flags map[string][]string) ([]string, error) {
+	if len(positional) != 2 {
+		return nil, fmt.Errorf("cf curl needs exactly one path")
+	}
+
+	method := flag(flags, "-X")
+	body := flag(flags, "-d")
+	if method == "" {
+		method = "GET"
+		if body != "" {
+			method = "POST"
+		}
+	}
+	header := http.Header{}
+	for _, value := range flags["-H"] {
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) == 2 {
+			header.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+		}
+	}
+
+	var requestBody []byte
+	if strings.HasPrefix(body, "@") {
+		// Like cf curl, -d @FILE sends the contents of the file
+		var err error
+		if requestBody, err = ioutil.ReadFile(strings.TrimPrefix(body, "@")); err != nil {
+			return nil, err
+		}
+	} else if body != "" {
+		requestBody = []byte(body)
+	}
+	res, err := c.do(method, positional[1], header, requestBody)
+	if err != nil {
+		return nil, err
+	}
+	return []string{string(res.Body)}, nil
+}
+
+// resourceNotFound is the code of the CF-ResourceNotFound error the Cloud Controller answers a
+// request for a resource it does not have with.
+const resourceNotFound = 10010
+
+func routeName(domain string, host string, path string) string {
+	name := domain
+	if host != "" {
+		name = host + "." + domain
+	}
+	if path != "" {
+		name = name + "/" + strings.TrimPrefix(path, "/")
+	}
+	return name
+}
+
+// isNotFound tells the lookups which failed because there is nothing to find, whether a lookup
+// by name found nothing or the Cloud Controller does not have the resource, from the ones which
+// failed to ask.
+func isNotFound(err error) bool {
+	var notFound *cfapi.NotFoundError
+	if errors.As(err, &notFound) {
+		return true
+	}
+	var ccErrors cfapi.Errors
+	if errors.As(err, &ccErrors) {
+		for _, ccError := range ccErrors {
+			if ccError.Code == resourceNotFound {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *Connection) mapRoute(output *commandOutput, appName string, domain string, host string, path string) error {
+	output.Printf("Mapping route %s to app %s in org %s / space %s...", routeName(domain, host, path), appName, c.Config.OrgName, c.Config.SpaceName)
+
+	app, err := c.client.AppByName(appName)
+	if err != nil {
+		return err
+	}
+
+	route, err := c.client.FindRoute(domain, host, path, 0)
+	if isNotFound(err) {
+		var routeDomain cfapi.Domain
+		if routeDomain, err = c.client.DomainByName(domain); err != nil {
+			return err
+		}
+		route, err = c.client.CreateRoute(c.Config.SpaceGuid, routeDomain.Guid, host, path)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := c.client.InsertRouteDestinations(route.Guid, []cfapi.Destination{cfapi.NewDestination(app.Guid, "web")}); err != nil {
+		return err
+	}
+	output.Printf("OK")
+	return nil
+}
+
+func (c *Connection) unmapRoute(output *commandOutput, appName string, domain string, host string, path string) error {
+	output.Printf("Removing route %s from app %s in org %s / space %s...", routeName(domain, host, path), appName, c.Config.OrgName, c.Config.SpaceName)
+
+	app, err := c.client.AppByName(appName)
+	if err != nil {
+		return err
+	}
+	route, err := c.client.FindRoute(domain, host, path, 0)
+	if err != nil {
+		return err
+	}
+
+	destinations, err := c.client.RouteDestinations(route.Guid)
+	if err != nil {
+		return err
+	}
+	for _, destination := range destinations {
+		if destination.App.Guid != app.Guid {
+			continue
+		}
+		if err := c.client.RemoveRouteDestination(route.Guid, destination.Guid); err != nil {
+			return err
+		}
+	}
+	output.Printf("OK")
+	return nil
+}
+
+func (c *Connection) deleteRoute(output *commandOutput, domain string, host string, path string) error {
+	name := routeName(domain, host, path)
+	output.Printf("Deleting route %s...", name)
+
+	route, err := c.client.FindRoute(domain, host, path, 0)
+	if isNotFound(err) {
+		output.Printf("Unable to delete, route '%s' does not exist.\nOK", name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := c.client.DeleteRoute(route.Guid); err != nil {
+		return err
+	}
+	output.Printf("OK")
+	return nil
+}
+
+func (c *Connection) rename(output *commandOutput, appName string, newName string) error {
+	output.Printf("Renaming app %s to %s in org %s / space %s...", appName, newName, c.Config.OrgName, c.Config.SpaceName)
+
+	app, err := c.client.AppByName(appName)
+	if err != nil {
+		return err
+	}
+	if err := c.client.RenameApp(app.Guid, newName); err != nil {
+		return err
+	}
+	output.Printf("OK")
+	return nil
+}
+
+func (c *Connection) scale(output *commandOutput, appName string, instances string) error {
+	instanceCount, err := strconv.Atoi(instances)
+	if err != nil {
+		return fmt.Errorf("cf-bgd can only scale the instances of an app, not %q", instances)
+	}
+	output.Printf("Scaling app %s in org %s / space %s to %d instances...", appName, c.Config.OrgName, c.Config.SpaceName, instanceCount)
+
+	app, err := c.client.AppByName(appName)
+	if err != nil {
+		return err
+	}
+	if err := c.client.ScaleProcess(app.Guid, "web", instanceCount); err != nil {
+		return err
+	}
+	output.Printf("OK")
+	return nil
+}
+
+func (c *Connection) sshEnabled(output *commandOutput, appName string) error {
+	app, err := c.client.AppByName(appName)
+	if err != nil {
+		return err
+	}
+	enabled, err := c.client.SshEnabled(app.Guid)
+	if err != nil {
+		return err
+	}
+
+	if enabled {
+		output.Printf("ssh support is enabled for '%s'", appName)
+	} else {
+		output.Printf("ssh support is disabled for '%s'", appName)
+	}
+	return nil
+}
+
+func (c *Connection) setSshEnabled(output *commandOutput, appName string, enabled bool) error {
+	action := "Disabling"
+	if enabled {
+		action = "Enabling"
+	}
+	output.Printf("%s ssh support for app %s...", action, appName)
+
+	app, err := c.client.AppByName(appName)
+	if err != nil {
+		return err
+	}
+	if err := c.client.SetSshEnabled(app.Guid, enabled); err != nil {
+		return err
+	}
+	output.Printf("OK")
+	return nil
+}
+
+// deleteApp deletes an app and, if deleteRoutes is set, its routes. It waits for the app to be
+// gone, since the deployment goes on to give its name to another app.
+func (c *Connection) deleteApp(output *commandOutput, appName string, deleteRoutes bool) error {
+	output.Printf("Deleting app %s in org %s / space %s...", appName, c.Config.OrgName, c.Config.SpaceName)
+
+	app, err := c.client.AppByName(appName)
+	if isNotFound(err) {
+		output.Printf("App %s does not exist.\nOK", appName)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if deleteRoutes {
+		routes, err := c.client.AppRoutes(app.Guid)
+		if err != nil {
+			return err
+		}
+		for _, route := range routes {
+			if err := c.client.DeleteRoute(route.Guid); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := c.client.DeleteApp(app.Guid); err != nil {
+		return err
+	}
+	err = cfapi.Poll(fmt.Sprintf("deleting app %s", appName), func() (bool, error) {
+		_, err := c.client.AppByName(appName)
+		if isNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+	if err != nil {
+		return err
+	}
+	output.Printf("OK")
+	return nil
+}
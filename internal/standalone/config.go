@@ -0,0 +1,104 @@
+package standalone
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Config is what a Connection needs to talk to a Cloud Controller: where it is, how to
+// authenticate, and which org and space to work in.
+type Config struct {
+	ApiEndpoint       string
+	AccessToken       string
+	RefreshToken      string
+	UaaEndpoint       string
+	SkipSSLValidation bool
+
+	OrgGuid   string
+	OrgName   string
+	SpaceGuid string
+	SpaceName string
+}
+
+// LoadCfConfig reads the config.json the cf CLI keeps the target and tokens of a logged in
+// user in, usually ~/.cf/config.json.
+func LoadCfConfig(path string) (Config, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	cfConfig := struct {
+		Target       string
+		AccessToken  string
+		RefreshToken string
+		UaaEndpoint  string
+		SSLDisabled  bool
+
+		OrganizationFields struct {
+			GUID string
+			Name string
+		}
+		SpaceFields struct {
+			GUID string
+			Name string
+		}
+	}{}
+	if err := json.Unmarshal(contents, &cfConfig); err != nil {
+		return Config{}, err
+	}
+
+	return Config{
+		ApiEndpoint:       cfConfig.Target,
+		AccessToken:       cfConfig.AccessToken,
+		RefreshToken:      cfConfig.RefreshToken,
+		UaaEndpoint:       cfConfig.UaaEndpoint,
+		SkipSSLValidation: cfConfig.SSLDisabled,
+		OrgGuid:           cfConfig.OrganizationFields.GUID,
+		OrgName:           cfConfig.OrganizationFields.Name,
+		SpaceGuid:         cfConfig.SpaceFields.GUID,
+		SpaceName:         cfConfig.SpaceFields.Name,
+	}, nil
+}
+
+// Override replaces the settings which are set in other. Choosing an org or space by name
+// drops the guid of the one chosen before. Choosing another API endpoint drops everything which
+// belongs to the one chosen before, so that its tokens are never sent to another foundation.
+func (c Config) Override(other Config) Config {
+	if other.ApiEndpoint != "" && strings.TrimSuffix(other.ApiEndpoint, "/") != strings.TrimSuffix(c.ApiEndpoint, "/") {
+		c = Config{ApiEndpoint: other.ApiEndpoint}
+	}
+	if other.AccessToken != "" {
+		c.AccessToken = other.AccessToken
+		c.RefreshToken = other.RefreshToken
+	}
+	if other.UaaEndpoint != "" {
+		c.UaaEndpoint = other.UaaEndpoint
+	}
+	if other.SkipSSLValidation {
+		c.SkipSSLValidation = true
+	}
+	if other.OrgName != "" || other.OrgGuid != "" {
+		c.OrgName, c.OrgGuid = other.OrgName, other.OrgGuid
+		c.SpaceName, c.SpaceGuid = "", ""
+	}
+	if other.SpaceName != "" || other.SpaceGuid != "" {
+		c.SpaceName, c.SpaceGuid = other.SpaceName, other.SpaceGuid
+	}
+	return c
+}
+
+// ConfigFromEnvironment reads CF_API, CF_TOKEN, CF_ORG, CF_SPACE and CF_SKIP_SSL_VALIDATION.
+func ConfigFromEnvironment() Config {
+	skipSSLValidation, _ := strconv.ParseBool(os.Getenv("CF_SKIP_SSL_VALIDATION"))
+	return Config{
+		ApiEndpoint:       os.Getenv("CF_API"),
+		AccessToken:       os.Getenv("CF_TOKEN"),
+		SkipSSLValidation: skipSSLValidation,
+		OrgName:           os.Getenv("CF_ORG"),
+		SpaceName:         os.Getenv("CF_SPACE"),
+	}
+}
@@ -0,0 +1,100 @@
+package standalone_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/bluemixgaragelondon/cf-blue-green-deploy/internal/standalone"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	Describe("LoadCfConfig", func() {
+		It("reads the target and tokens of the cf CLI", func() {
+			dir, err := ioutil.TempDir("", "cf-home")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, "config.json")
+			Expect(ioutil.WriteFile(path, []byte(`{
+				"Target": "https://api.example.com",
+				"AccessToken": "bearer access",
+				"RefreshToken": "refresh",
+				"UaaEndpoint": "https://uaa.example.com",
+				"SSLDisabled": true,
+				"OrganizationFields": {"GUID": "org-guid", "Name": "org"},
+				"SpaceFields": {"GUID": "space-guid", "Name": "space"}
+			}`), 0600)).To(Succeed())
+
+			Expect(LoadCfConfig(path)).To(Equal(Config{
+				ApiEndpoint:       "https://api.example.com",
+				AccessToken:       "bearer access",
+				RefreshToken:      "refresh",
+				UaaEndpoint:       "https://uaa.example.com",
+				SkipSSLValidation: true,
+				OrgGuid:           "org-guid",
+				OrgName:           "org",
+				SpaceGuid:         "space-guid",
+				SpaceName:         "space",
+			}))
+		})
+
+		It("fails for a missing file", func() {
+			_, err := LoadCfConfig("/does/not/exist/config.json")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Override", func() {
+		base := Config{
+			ApiEndpoint:  "https://api.example.com",
+			AccessToken:  "bearer access",
+			RefreshToken: "refresh",
+			OrgGuid:      "org-guid",
+			OrgName:      "org",
+			SpaceGuid:    "space-guid",
+			SpaceName:    "space",
+		}
+
+		It("keeps the settings which are not overridden", func() {
+			Expect(base.Override(Config{})).To(Equal(base))
+		})
+
+		It("drops the refresh token of a replaced access token", func() {
+			config := base.Override(Config{AccessToken: "other"})
+			Expect(config.AccessToken).To(Equal("other"))
+			Expect(config.RefreshToken).To(BeEmpty())
+		})
+
+		It("drops the space when another org is chosen", func() {
+			config := base.Override(Config{OrgName: "other-org"})
+			Expect(config.OrgName).To(Equal("other-org"))
+			Expect(config.OrgGuid).To(BeEmpty())
+			Expect(config.SpaceName).To(BeEmpty())
+			Expect(config.SpaceGuid).To(BeEmpty())
+		})
+
+		It("looks up a space chosen by name in the same org", func() {
+			config := base.Override(Config{SpaceName: "other-space"})
+			Expect(config.OrgGuid).To(Equal("org-guid"))
+			Expect(config.SpaceName).To(Equal("other-space"))
+			Expect(config.SpaceGuid).To(BeEmpty())
+		})
+
+		It("drops the tokens and target of another API endpoint", func() {
+			config := base.Override(Config{ApiEndpoint: "https://api.other.example.com"})
+			Expect(config).To(Equal(Config{ApiEndpoint: "https://api.other.example.com"}))
+		})
+
+		It("keeps what is chosen together with another API endpoint", func() {
+			config := base.Override(Config{ApiEndpoint: "https://api.other.example.com", AccessToken: "other", SpaceName: "other-space"})
+			Expect(config).To(Equal(Config{ApiEndpoint: "https://api.other.example.com", AccessToken: "other", SpaceName: "other-space"}))
+		})
+
+		It("keeps the tokens when the same API endpoint is chosen again", func() {
+			Expect(base.Override(Config{ApiEndpoint: "https://api.example.com/"}).AccessToken).To(Equal("bearer access"))
+		})
+	})
+})
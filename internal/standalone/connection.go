@@ -0,0 +1,400 @@
+// Package standalone lets the deployment run outside of the cf CLI. Its Connection implements
+// the plugin.CliConnection the deployment is written against by talking to the Cloud
+// Controller API directly, with the target and credentials of a Config.
+package standalone
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin"
+	"code.cloudfoundry.org/cli/plugin/models"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/internal/cfapi"
+)
+
+// Connection is a plugin.CliConnection without the cf CLI. It supports the API requests and cf
+// commands the deployment makes and fails the others. Several apps can be deployed with it at
+// once.
+type Connection struct {
+	Config Config
+	Out    io.Writer
+
+	httpClient  *http.Client
+	client      *cfapi.Client
+	domainNames map[string]string
+
+	// mutex guards the tokens and the domain names, refreshing makes one refresh wait for another
+	mutex      sync.Mutex
+	refreshing sync.Mutex
+}
+
+// NewConnection checks that config has a target and credentials, and looks up the guids of the
+// org and space chosen by name.
+func NewConnection(config Config, out io.Writer) (*Connection, error) {
+	if config.ApiEndpoint == "" {
+		return nil, errors.New("No API endpoint set. Log in with cf login, or set one with --api or CF_API.")
+	}
+	if config.AccessToken == "" {
+		return nil, errors.New("Not logged in. Log in with cf login, or pass a token with --token or CF_TOKEN.")
+	}
+	config.ApiEndpoint = strings.TrimSuffix(config.ApiEndpoint, "/")
+	if !strings.HasPrefix(strings.ToLower(config.AccessToken), "bearer ") {
+		config.AccessToken = "bearer " + config.AccessToken
+	}
+
+	c := &Connection{
+		Config: config,
+		Out:    out,
+		httpClient: &http.Client{
+			Timeout: 5 * time.Minute,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: config.SkipSSLValidation},
+			},
+		},
+		domainNames: map[string]string{},
+	}
+	c.client = cfapi.NewClient(c)
+
+	if c.Config.OrgGuid == "" && c.Config.OrgName != "" {
+		org, err := c.client.OrganizationByName(c.Config.OrgName)
+		if err != nil {
+			return nil, err
+		}
+		c.Config.OrgGuid = org.Guid
+	}
+	if c.Config.SpaceGuid == "" && c.Config.SpaceName != "" {
+		if c.Config.OrgGuid == "" {
+			return nil, fmt.Errorf("No org targeted to find space %s in.", c.Config.SpaceName)
+		}
+		space, err := c.client.SpaceByName(c.Config.OrgGuid, c.Config.SpaceName)
+		if err != nil {
+			return nil, err
+		}
+		c.Config.SpaceGuid = space.Guid
+	}
+	return c, nil
+}
+
+// response is what is left of an HTTP response once it has been read.
+type response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// do sends a request to the Cloud Controller. When the access token has expired and there is a
+// refresh token, it gets a new access token and sends the request again.
+func (c *Connection) do(method string, path string, header http.Header, body []byte) (response, error) {
+	accessToken, refreshToken := c.tokens()
+	res, err := c.send(method, path, header, body)
+	if err == nil && res.StatusCode == http.StatusUnauthorized && refreshToken != "" {
+		if err := c.refreshToken(accessToken); err != nil {
+			return res, fmt.Errorf("Could not refresh the access token: %v", err)
+		}
+		res, err = c.send(method, path, header, body)
+	}
+	if err != nil {
+		return res, err
+	}
+
+	// Error documents are passed on like cf curl does, but only the Cloud Controller sends those
+	if res.StatusCode >= 400 && !json.Valid(res.Body) {
+		return res, fmt.Errorf("%s %s failed with status code %d: %s", method, path, res.StatusCode, http.StatusText(res.StatusCode))
+	}
+	return res, nil
+}
+
+func (c *Connection) send(method string, path string, header http.Header, body []byte) (response, error) {
+	target := path
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		target = c.Config.ApiEndpoint + "/" + strings.TrimPrefix(path, "/")
+	}
+
+	request, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return response{}, err
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	if request.Header.Get("Content-Type") == "" && len(body) > 0 {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	accessToken, _ := c.tokens()
+	request.Header.Set("Authorization", accessToken)
+
+	res, err := c.httpClient.Do(request)
+	if err != nil {
+		return response{}, err
+	}
+	defer res.Body.Close()
+
+	responseBody, err := ioutil.ReadAll(res.Body)
+	return response{StatusCode: res.StatusCode, Header: res.Header, Body: responseBody}, err
+}
+
+func (c *Connection) tokens() (string, string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.Config.AccessToken, c.Config.RefreshToken
+}
+
+// refreshToken swaps the refresh token for a new access token at the UAA, which is found
+// through the links of the API root when the config does not name it. The expired token is
+// only refreshed once, when several requests find it has expired.
+func (c *Connection) refreshToken(expired string) error {
+	c.refreshing.Lock()
+	defer c.refreshing.Unlock()
+	if accessToken, _ := c.tokens(); accessToken != expired {
+		return nil
+	}
+
+	if c.Config.UaaEndpoint == "" {
+		root := struct {
+			Links struct {
+				Uaa struct {
+					Href string `json:"href"`
+				} `json:"uaa"`
+			} `json:"links"`
+		}{}
+		res, err := c.send("GET", "/", nil, nil)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(res.Body, &root); err != nil {
+			return err
+		}
+		c.Config.UaaEndpoint = root.Links.Uaa.Href
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	_, refreshToken := c.tokens()
+	form.Set("refresh_token", refreshToken)
+	request, err := http.NewRequest("POST", strings.TrimSuffix(c.Config.UaaEndpoint, "/")+"/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	request.SetBasicAuth("cf", "")
+
+	res, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("the UAA responded with %s", res.Status)
+	}
+
+	token := struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.Config.AccessToken = "bearer " + token.AccessToken
+	if token.RefreshToken != "" {
+		c.Config.RefreshToken = token.RefreshToken
+	}
+	return nil
+}
+
+// CliCommandWithoutTerminalOutput runs the cf commands the deployment uses, printing nothing.
+func (c *Connection) CliCommandWithoutTerminalOutput(args ...string) ([]string, error) {
+	return c.runCommand(ioutil.Discard, args)
+}
+
+// CliCommand runs the cf commands the deployment uses.
+func (c *Connection) CliCommand(args ...string) ([]string, error) {
+	return c.runCommand(c.Out, args)
+}
+
+// WithOutput returns the connection with the cf commands writing to out instead, so that apps
+// deployed at once can keep their output apart.
+func (c *Connection) WithOutput(out io.Writer) plugin.CliConnection {
+	return &outputConnection{Connection: c, out: out}
+}
+
+type outputConnection struct {
+	*Connection
+	out io.Writer
+}
+
+func (c *outputConnection) CliCommand(args ...string) ([]string, error) {
+	return c.runCommand(c.out, args)
+}
+
+func (c *Connection) GetCurrentOrg() (plugin_models.Organization, error) {
+	org := plugin_models.Organization{}
+	org.Guid = c.Config.OrgGuid
+	org.Name = c.Config.OrgName
+	return org, nil
+}
+
+func (c *Connection) GetCurrentSpace() (plugin_models.Space, error) {
+	space := plugin_models.Space{}
+	space.Guid = c.Config.SpaceGuid
+	space.Name = c.Config.SpaceName
+	return space, nil
+}
+
+// GetApp describes an app of the targeted space with its web process and routes.
+func (c *Connection) GetApp(name string) (plugin_models.GetAppModel, error) {
+	app, err := c.client.AppByName(name)
+	if err != nil {
+		return plugin_models.GetAppModel{}, err
+	}
+
+	process, err := c.client.Process(app.Guid, "web")
+	if err != nil {
+		return plugin_models.GetAppModel{}, err
+	}
+	routes, err := c.client.AppRoutes(app.Guid)
+	if err != nil {
+		return plugin_models.GetAppModel{}, err
+	}
+
+	appModel := plugin_models.GetAppModel{
+		Guid:          app.Guid,
+		Name:          app.Name,
+		State:         app.State,
+		SpaceGuid:     c.Config.SpaceGuid,
+		InstanceCount: process.Instances,
+		Memory:        process.MemoryInMb,
+		DiskQuota:     process.DiskInMb,
+	}
+	for _, route := range routes {
+		summary := plugin_models.GetApp_RouteSummary{Guid: route.Guid, Host: route.Host, Path: route.Path, Port: route.Port}
+		if route.Relationships.Domain.Data != nil {
+			summary.Domain.Guid = route.Relationships.Domain.Data.Guid
+			if summary.Domain.Name, err = c.domainName(summary.Domain.Guid); err != nil {
+				return plugin_models.GetAppModel{}, err
+			}
+		}
+		appModel.Routes = append(appModel.Routes, summary)
+	}
+	return appModel, nil
+}
+
+// GetApps lists the apps of the targeted space, without their routes.
+func (c *Connection) GetApps() ([]plugin_models.GetAppsModel, error) {
+	if c.Config.SpaceGuid == "" {
+		return nil, errors.New("No space targeted")
+	}
+	apps, err := c.client.Apps(c.Config.SpaceGuid)
+	if err != nil {
+		return nil, err
+	}
+
+	appModels := []plugin_models.GetAppsModel{}
+	for _, app := range apps {
+		appModels = append(appModels, plugin_models.GetAppsModel{Guid: app.Guid, Name: app.Name, State: app.State})
+	}
+	return appModels, nil
+}
+
+// domainName looks up the name of a domain, remembering it since apps share few domains.
+func (c *Connection) domainName(guid string) (string, error) {
+	c.mutex.Lock()
+	name, ok := c.domainNames[guid]
+	c.mutex.Unlock()
+	if ok {
+		return name, nil
+	}
+	domain, err := c.client.Domain(guid)
+	if err != nil {
+		return "", err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.domainNames[guid] = domain.Name
+	return domain.Name, nil
+}
+
+func (c *Connection) IsLoggedIn() (bool, error) {
+	accessToken, _ := c.tokens()
+	return accessToken != "", nil
+}
+
+func (c *Connection) IsSSLDisabled() (bool, error) {
+	return c.Config.SkipSSLValidation, nil
+}
+
+func (c *Connection) HasOrganization() (bool, error) {
+	return c.Config.OrgGuid != "", nil
+}
+
+func (c *Connection) HasSpace() (bool, error) {
+	return c.Config.SpaceGuid != "", nil
+}
+
+func (c *Connection) ApiEndpoint() (string, error) {
+	return c.Config.ApiEndpoint, nil
+}
+
+func (c *Connection) HasAPIEndpoint() (bool, error) {
+	return c.Config.ApiEndpoint != "", nil
+}
+
+func (c *Connection) AccessToken() (string, error) {
+	accessToken, _ := c.tokens()
+	return accessToken, nil
+}
+
+var errNotSupported = errors.New("not supported by cf-bgd")
+
+func (c *Connection) Username() (string, error)            { return "", errNotSupported }
+func (c *Connection) UserGuid() (string, error)            { return "", errNotSupported }
+func (c *Connection) UserEmail() (string, error)           { return "", errNotSupported }
+func (c *Connection) ApiVersion() (string, error)          { return "", errNotSupported }
+func (c *Connection) LoggregatorEndpoint() (string, error) { return "", errNotSupported }
+func (c *Connection) DopplerEndpoint() (string, error)     { return "", errNotSupported }
+
+func (c *Connection) GetOrgs() ([]plugin_models.GetOrgs_Model, error) {
+	return nil, errNotSupported
+}
+
+func (c *Connection) GetSpaces() ([]plugin_models.GetSpaces_Model, error) {
+	return nil, errNotSupported
+}
+
+func (c *Connection) GetOrgUsers(string, ...string) ([]plugin_models.GetOrgUsers_Model, error) {
+	return nil, errNotSupported
+}
+
+func (c *Connection) GetSpaceUsers(string, string) ([]plugin_models.GetSpaceUsers_Model, error) {
+	return nil, errNotSupported
+}
+
+func (c *Connection) GetServices() ([]plugin_models.GetServices_Model, error) {
+	return nil, errNotSupported
+}
+
+func (c *Connection) GetService(string) (plugin_models.GetService_Model, error) {
+	return plugin_models.GetService_Model{}, errNotSupported
+}
+
+func (c *Connection) GetOrg(string) (plugin_models.GetOrg_Model, error) {
+	return plugin_models.GetOrg_Model{}, errNotSupported
+}
+
+func (c *Connection) GetSpace(string) (plugin_models.GetSpace_Model, error) {
+	return plugin_models.GetSpace_Model{}, errNotSupported
+}
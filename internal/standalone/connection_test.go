@@ -0,0 +1,424 @@
+package standalone_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin/models"
+	. "github.com/bluemixgaragelondon/cf-blue-green-deploy/internal/standalone"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeCloudController answers requests by method and path with canned JSON, recording them.
+type fakeCloudController struct {
+	responses map[string]func(r *http.Request, body []byte) (int, string)
+	requests  []string
+	bodies    map[string][]byte
+	headers   map[string]http.Header
+	tokens    []string
+}
+
+func (f *fakeCloudController) respond(route string, status int, body string) {
+	f.responses[route] = func(*http.Request, []byte) (int, string) { return status, body }
+}
+
+func (f *fakeCloudController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	route := r.Method + " " + r.URL.RequestURI()
+	f.requests = append(f.requests, route)
+	f.bodies[route] = body
+	f.headers[route] = r.Header
+	f.tokens = append(f.tokens, r.Header.Get("Authorization"))
+
+	respond, ok := f.responses[route]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"errors": [{"code": 10000, "title": "CF-NotFound", "detail": "Unknown request %s"}]}`, route)
+		return
+	}
+	status, response := respond(r, body)
+	w.WriteHeader(status)
+	fmt.Fprint(w, response)
+}
+
+var _ = Describe("Connection", func() {
+	var (
+		cc         *fakeCloudController
+		server     *httptest.Server
+		config     Config
+		out        *bytes.Buffer
+		connection *Connection
+	)
+
+	BeforeEach(func() {
+		cc = &fakeCloudController{responses: map[string]func(*http.Request, []byte) (int, string){}, bodies: map[string][]byte{}, headers: map[string]http.Header{}}
+		server = httptest.NewServer(cc)
+		config = Config{
+			ApiEndpoint: server.URL,
+			AccessToken: "access",
+			OrgGuid:     "org-guid",
+			OrgName:     "org",
+			SpaceGuid:   "space-guid",
+			SpaceName:   "space",
+		}
+		out = &bytes.Buffer{}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		connection, err = NewConnection(config, out)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Describe("NewConnection", func() {
+		It("needs an API endpoint", func() {
+			_, err := NewConnection(Config{AccessToken: "access"}, out)
+			Expect(err).To(MatchError(ContainSubstring("No API endpoint set")))
+		})
+
+		It("needs an access token", func() {
+			_, err := NewConnection(Config{ApiEndpoint: server.URL}, out)
+			Expect(err).To(MatchError(ContainSubstring("Not logged in")))
+		})
+
+		It("looks up the org and space chosen by name", func() {
+			cc.respond("GET /v3/organizations?names=other-org", 200, `{"resources": [{"guid": "other-org-guid", "name": "other-org"}]}`)
+			cc.respond("GET /v3/spaces?names=other-space&organization_guids=other-org-guid", 200, `{"resources": [{"guid": "other-space-guid", "name": "other-space"}]}`)
+
+			connection, err := NewConnection(Config{ApiEndpoint: server.URL, AccessToken: "access", OrgName: "other-org", SpaceName: "other-space"}, out)
+			Expect(err).NotTo(HaveOccurred())
+
+			space, _ := connection.GetCurrentSpace()
+			Expect(space.Guid).To(Equal("other-space-guid"))
+		})
+
+		It("fails for an org which does not exist", func() {
+			cc.respond("GET /v3/organizations?names=missing", 200, `{"resources": []}`)
+
+			_, err := NewConnection(Config{ApiEndpoint: server.URL, AccessToken: "access", OrgName: "missing"}, out)
+			Expect(err).To(MatchError("Could not find org missing"))
+		})
+	})
+
+	Describe("cf curl", func() {
+		It("sends the request with the access token", func() {
+			cc.respond("PATCH /v3/apps/app-guid", 200, `{"guid": "app-guid"}`)
+
+			output, err := connection.CliCommandWithoutTerminalOutput("curl", "/v3/apps/app-guid", "-X", "PATCH", "-d", `{"name":"new"}`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).To(Equal([]string{`{"guid": "app-guid"}`}))
+			Expect(string(cc.bodies["PATCH /v3/apps/app-guid"])).To(Equal(`{"name":"new"}`))
+			Expect(cc.tokens).To(Equal([]string{"bearer access"}))
+		})
+
+		It("returns error documents as output, like cf curl", func() {
+			cc.respond("GET /v3/apps/app-guid", 404, `{"errors": [{"code": 10010, "title": "CF-ResourceNotFound", "detail": "App not found"}]}`)
+
+			output, err := connection.CliCommandWithoutTerminalOutput("curl", "/v3/apps/app-guid")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output[0]).To(ContainSubstring("CF-ResourceNotFound"))
+		})
+
+		It("fails for errors which are not from the Cloud Controller", func() {
+			cc.respond("GET /v3/apps", 502, `<html>Bad Gateway</html>`)
+
+			_, err := connection.CliCommandWithoutTerminalOutput("curl", "/v3/apps")
+			Expect(err).To(MatchError("GET /v3/apps failed with status code 502: Bad Gateway"))
+		})
+
+		Context("when the access token has expired", func() {
+			BeforeEach(func() {
+				config.RefreshToken = "refresh"
+				config.UaaEndpoint = server.URL + "/uaa"
+				cc.responses["GET /v3/apps"] = func(r *http.Request, _ []byte) (int, string) {
+					if r.Header.Get("Authorization") != "bearer fresh" {
+						return 401, `{"errors": [{"code": 1000, "title": "CF-InvalidAuthToken", "detail": "Invalid Auth Token"}]}`
+					}
+					return 200, `{"resources": []}`
+				}
+				cc.responses["POST /uaa/oauth/token"] = func(r *http.Request, body []byte) (int, string) {
+					if string(body) != "grant_type=refresh_token&refresh_token=refresh" {
+						return 401, `{}`
+					}
+					return 200, `{"access_token": "fresh", "refresh_token": "next"}`
+				}
+			})
+
+			It("refreshes it and sends the request again", func() {
+				output, err := connection.CliCommandWithoutTerminalOutput("curl", "/v3/apps")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(output).To(Equal([]string{`{"resources": []}`}))
+				Expect(cc.requests).To(Equal([]string{"GET /v3/apps", "POST /uaa/oauth/token", "GET /v3/apps"}))
+				Expect(connection.AccessToken()).To(Equal("bearer fresh"))
+			})
+		})
+	})
+
+	Describe("GetApp", func() {
+		It("describes the app with its web process and routes", func() {
+			cc.respond("GET /v3/apps?names=app&space_guids=space-guid", 200, `{"resources": [{"guid": "app-guid", "name": "app", "state": "STARTED"}]}`)
+			cc.respond("GET /v3/apps/app-guid/processes/web", 200, `{"guid": "app-guid", "type": "web", "instances": 3, "memory_in_mb": 256, "disk_in_mb": 1024}`)
+			cc.respond("GET /v3/apps/app-guid/routes", 200, `{"resources": [
+				{"guid": "route-guid", "host": "app", "path": "", "relationships": {"domain": {"data": {"guid": "domain-guid"}}}}
+			]}`)
+			cc.respond("GET /v3/domains/domain-guid", 200, `{"guid": "domain-guid", "name": "example.com"}`)
+
+			app, err := connection.GetApp("app")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(app).To(Equal(plugin_models.GetAppModel{
+				Guid:          "app-guid",
+				Name:          "app",
+				State:         "STARTED",
+				SpaceGuid:     "space-guid",
+				InstanceCount: 3,
+				Memory:        256,
+				DiskQuota:     1024,
+				Routes: []plugin_models.GetApp_RouteSummary{
+					{Guid: "route-guid", Host: "app", Domain: plugin_models.GetApp_DomainFields{Guid: "domain-guid", Name: "example.com"}},
+				},
+			}))
+		})
+
+		It("fails for an app which does not exist", func() {
+			cc.respond("GET /v3/apps?names=app&space_guids=space-guid", 200, `{"resources": []}`)
+
+			_, err := connection.GetApp("app")
+			Expect(err).To(MatchError("Could not find app app"))
+		})
+	})
+
+	Describe("cf commands", func() {
+		BeforeEach(func() {
+			cc.respond("GET /v3/apps?names=app&space_guids=space-guid", 200, `{"resources": [{"guid": "app-guid", "name": "app"}]}`)
+			cc.respond("GET /v3/domains?names=example.com", 200, `{"resources": [{"guid": "domain-guid", "name": "example.com"}]}`)
+		})
+
+		It("maps a route, creating it if it does not exist", func() {
+			cc.respond("GET /v3/routes?domain_guids=domain-guid&hosts=app", 200, `{"resources": []}`)
+			cc.respond("POST /v3/routes", 201, `{"guid": "route-guid", "host": "app"}`)
+			cc.respond("POST /v3/routes/route-guid/destinations", 200, `{"destinations": []}`)
+
+			output, err := connection.CliCommand("map-route", "app", "example.com", "-n", "app")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).To(ContainElement("OK"))
+			Expect(string(cc.bodies["POST /v3/routes/route-guid/destinations"])).To(Equal(`{"destinations":[{"app":{"guid":"app-guid","process":{"type":"web"}}}]}`))
+			Expect(out.String()).To(ContainSubstring("Mapping route app.example.com to app app in org org / space space..."))
+		})
+
+		It("unmaps a route by removing the app's destinations", func() {
+			cc.respond("GET /v3/routes?domain_guids=domain-guid&hosts=app", 200, `{"resources": [{"guid": "route-guid", "host": "app"}]}`)
+			cc.respond("GET /v3/routes/route-guid/destinations", 200, `{"destinations": [
+				{"guid": "other-destination", "app": {"guid": "other-app-guid"}},
+				{"guid": "app-destination", "app": {"guid": "app-guid"}}
+			]}`)
+			cc.respond("DELETE /v3/routes/route-guid/destinations/app-destination", 204, ``)
+
+			_, err := connection.CliCommand("unmap-route", "app", "example.com", "-n", "app")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cc.requests).To(ContainElement("DELETE /v3/routes/route-guid/destinations/app-destination"))
+			Expect(cc.requests).NotTo(ContainElement("DELETE /v3/routes/route-guid/destinations/other-destination"))
+		})
+
+		It("succeeds deleting a route which does not exist", func() {
+			cc.respond("GET /v3/routes?domain_guids=domain-guid&hosts=app", 200, `{"resources": []}`)
+
+			_, err := connection.CliCommand("delete-route", "example.com", "-n", "app", "-f")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.String()).To(ContainSubstring("route 'app.example.com' does not exist"))
+		})
+
+		It("takes a resource the Cloud Controller does not have for one which does not exist", func() {
+			cc.respond("GET /v3/routes?domain_guids=domain-guid&hosts=app", 404, `{"errors": [{"code": 10010, "title": "CF-ResourceNotFound", "detail": "Domain not found"}]}`)
+
+			_, err := connection.CliCommand("delete-route", "example.com", "-n", "app", "-f")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.String()).To(ContainSubstring("route 'app.example.com' does not exist"))
+		})
+
+		It("fails for lookups which fail for other reasons", func() {
+			cc.respond("GET /v3/routes?domain_guids=domain-guid&hosts=app", 503, `{"errors": [{"code": 10001, "title": "CF-ServiceUnavailable", "detail": "Could not find a route service"}]}`)
+
+			_, err := connection.CliCommand("delete-route", "example.com", "-n", "app", "-f")
+			Expect(err).To(MatchError(ContainSubstring("CF-ServiceUnavailable")))
+		})
+
+		It("reports whether ssh is enabled like cf ssh-enabled", func() {
+			cc.respond("GET /v3/apps/app-guid/ssh_enabled", 200, `{"enabled": true, "reason": ""}`)
+
+			output, err := connection.CliCommand("ssh-enabled", "app")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).To(Equal([]string{"ssh support is enabled for 'app'"}))
+		})
+
+		It("deletes an app with its routes and waits for it to be gone", func() {
+			*PollInterval = time.Millisecond
+			lookups := 0
+			cc.responses["GET /v3/apps?names=app&space_guids=space-guid"] = func(*http.Request, []byte) (int, string) {
+				lookups++
+				if lookups > 2 {
+					return 200, `{"resources": []}`
+				}
+				return 200, `{"resources": [{"guid": "app-guid", "name": "app"}]}`
+			}
+			cc.respond("GET /v3/apps/app-guid/routes", 200, `{"resources": [{"guid": "route-guid", "host": "app"}]}`)
+			cc.respond("DELETE /v3/routes/route-guid", 202, ``)
+			cc.respond("DELETE /v3/apps/app-guid", 202, ``)
+
+			_, err := connection.CliCommand("delete", "app", "-f", "-r")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cc.requests).To(ContainElement("DELETE /v3/routes/route-guid"))
+			Expect(lookups).To(Equal(3))
+		})
+
+		It("writes the output of the commands elsewhere for a connection with its own output", func() {
+			cc.respond("GET /v3/apps/app-guid/ssh_enabled", 200, `{"enabled": true, "reason": ""}`)
+			appOut := &bytes.Buffer{}
+
+			_, err := connection.WithOutput(appOut).CliCommand("ssh-enabled", "app")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(appOut.String()).To(Equal("ssh support is enabled for 'app'\n"))
+			Expect(out.String()).To(BeEmpty())
+		})
+
+		It("fails for commands it does not support", func() {
+			_, err := connection.CliCommand("bind-service", "app", "db")
+			Expect(err).To(MatchError("cf bind-service app db is not supported by cf-bgd"))
+		})
+	})
+
+	Describe("cf push", func() {
+		var appDir string
+
+		BeforeEach(func() {
+			*PollInterval = time.Millisecond
+
+			var err error
+			appDir, err = ioutil.TempDir("", "app")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.MkdirAll(filepath.Join(appDir, "public"), 0755)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(appDir, "tmp"), 0755)).To(Succeed())
+			files := map[string]string{
+				"manifest.yml": "applications:\n- name: app\n  memory: 64M\n  routes:\n  - route: app.example.com\n  env:\n    GREETING: hello\n",
+				".cfignore":    "tmp/\n*.log\n",
+				"index.html":   "<h1>hello</h1>",
+				"debug.log":    "",
+				"public/a.css": "",
+				"tmp/cache":    "",
+			}
+			for name, contents := range files {
+				Expect(ioutil.WriteFile(filepath.Join(appDir, name), []byte(contents), 0644)).To(Succeed())
+			}
+
+			cc.respond("GET /v3/apps?names=app-new&space_guids=space-guid", 200, `{"resources": []}`)
+			cc.respond("POST /v3/apps", 201, `{"guid": "app-guid", "name": "app-new"}`)
+			cc.responses["POST /v3/spaces/space-guid/actions/apply_manifest"] = func(*http.Request, []byte) (int, string) {
+				return 202, ``
+			}
+			cc.respond("POST /v3/packages", 201, `{"guid": "package-guid", "state": "AWAITING_UPLOAD"}`)
+			cc.respond("POST /v3/packages/package-guid/upload", 200, `{"guid": "package-guid", "state": "PROCESSING_UPLOAD"}`)
+			cc.respond("GET /v3/packages/package-guid", 200, `{"guid": "package-guid", "state": "READY"}`)
+			cc.respond("POST /v3/builds", 201, `{"guid": "build-guid", "state": "STAGING"}`)
+			cc.respond("GET /v3/builds/build-guid", 200, `{"guid": "build-guid", "state": "STAGED", "droplet": {"guid": "droplet-guid"}}`)
+			cc.respond("PATCH /v3/apps/app-guid/relationships/current_droplet", 200, `{}`)
+			cc.respond("POST /v3/apps/app-guid/actions/restart", 200, `{}`)
+			cc.respond("GET /v3/apps/app-guid/processes/web/stats", 200, `{"resources": [{"index": 0, "state": "RUNNING"}]}`)
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(appDir)
+		})
+
+		It("applies the manifest with the route from the flags, uploads the files and starts the app", func() {
+			_, err := connection.CliCommand("push", "app-new", "-n", "app-new", "-d", "example.com", "-i", "2", "-f", filepath.Join(appDir, "manifest.yml"))
+			Expect(err).NotTo(HaveOccurred())
+
+			manifest := string(cc.bodies["POST /v3/spaces/space-guid/actions/apply_manifest"])
+			Expect(manifest).To(ContainSubstring("name: app-new"))
+			Expect(manifest).To(ContainSubstring("route: app-new.example.com"))
+			Expect(manifest).NotTo(ContainSubstring("route: app.example.com"))
+			Expect(manifest).To(ContainSubstring("instances: 2"))
+			Expect(manifest).To(ContainSubstring("GREETING: hello"))
+
+			upload := "POST /v3/packages/package-guid/upload"
+			Expect(zippedFiles(cc.headers[upload].Get("Content-Type"), cc.bodies[upload])).To(Equal([]string{"index.html", "public/a.css"}))
+
+			Expect(cc.requests).To(ContainElement("PATCH /v3/apps/app-guid/relationships/current_droplet"))
+			Expect(cc.requests).To(ContainElement("POST /v3/apps/app-guid/actions/restart"))
+		})
+
+		It("fills in the variables of the manifest from --vars-file and --var", func() {
+			manifestPath := filepath.Join(appDir, "vars-manifest.yml")
+			varsPath := filepath.Join(appDir, "vars.yml")
+			Expect(ioutil.WriteFile(manifestPath, []byte("applications:\n- name: app\n  memory: ((memory))\n  env:\n    GREETING: ((greeting)), world\n"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(varsPath, []byte("memory: 64M\ngreeting: hi\n"), 0644)).To(Succeed())
+
+			_, err := connection.CliCommand("push", "app-new", "-n", "app-new", "-d", "example.com", "-f", manifestPath, "--vars-file", varsPath, "--var", "greeting=hello")
+			Expect(err).NotTo(HaveOccurred())
+
+			manifest := string(cc.bodies["POST /v3/spaces/space-guid/actions/apply_manifest"])
+			Expect(manifest).To(ContainSubstring("memory: 64M"))
+			Expect(manifest).To(ContainSubstring("GREETING: hello, world"))
+		})
+
+		It("fails when staging fails", func() {
+			cc.respond("GET /v3/builds/build-guid", 200, `{"guid": "build-guid", "state": "FAILED", "error": "NoAppDetectedError"}`)
+
+			_, err := connection.CliCommand("push", "app-new", "-p", appDir)
+			Expect(err).To(MatchError("Staging failed: NoAppDetectedError"))
+			Expect(cc.requests).NotTo(ContainElement("POST /v3/apps/app-guid/actions/restart"))
+		})
+
+		It("fails when an instance crashes", func() {
+			cc.respond("GET /v3/apps/app-guid/processes/web/stats", 200, `{"resources": [{"index": 0, "state": "CRASHED"}]}`)
+
+			_, err := connection.CliCommand("push", "app-new", "-p", appDir)
+			Expect(err).To(MatchError("Instance 0 of app app-new crashed"))
+		})
+
+		It("deploys the droplet with a rolling deployment", func() {
+			cc.respond("GET /v3/apps?names=app-new&space_guids=space-guid", 200, `{"resources": [{"guid": "app-guid", "name": "app-new"}]}`)
+			cc.respond("POST /v3/deployments", 201, `{"guid": "deployment-guid", "status": {"value": "ACTIVE"}}`)
+
+			_, err := connection.CliCommand("push", "app-new", "-p", appDir, "--strategy", "rolling", "--no-wait")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cc.requests).NotTo(ContainElement("POST /v3/apps"))
+			Expect(cc.requests).NotTo(ContainElement("POST /v3/apps/app-guid/actions/restart"))
+			Expect(string(cc.bodies["POST /v3/deployments"])).To(ContainSubstring(`"droplet":{"guid":"droplet-guid"}`))
+		})
+	})
+})
+
+// zippedFiles lists the files of the zip uploaded in a multipart form.
+func zippedFiles(contentType string, form []byte) []string {
+	_, params, err := mime.ParseMediaType(contentType)
+	Expect(err).NotTo(HaveOccurred())
+	part, err := multipart.NewReader(bytes.NewReader(form), params["boundary"]).NextPart()
+	Expect(err).NotTo(HaveOccurred())
+	Expect(part.FormName()).To(Equal("bits"))
+	contents, err := ioutil.ReadAll(part)
+	Expect(err).NotTo(HaveOccurred())
+
+	archive, err := zip.NewReader(bytes.NewReader(contents), int64(len(contents)))
+	Expect(err).NotTo(HaveOccurred())
+	names := []string{}
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,6 @@
+package standalone
+
+import "github.com/bluemixgaragelondon/cf-blue-green-deploy/internal/cfapi"
+
+// PollInterval lets the tests poll without waiting.
+var PollInterval = &cfapi.PollInterval
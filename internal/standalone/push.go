@@ -0,0 +1,221 @@
+package standalone
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/internal/cfapi"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
+	"gopkg.in/yaml.v2"
+)
+
+// routeProperties are the manifest properties which choose the routes of an app, and which a
+// route given with -n and -d replaces.
+var routeProperties = []string{"routes", "host", "hosts", "domain", "domains", "no-hostname", "random-route", "no-route"}
+
+// push does what cf push does with the flags the deployment uses: it applies the app's
+// properties from the manifest, uploads and stages its files and starts the new droplet, either
+// by restarting the app or with a rolling deployment.
+func (c *Connection) push(output *commandOutput, appName string, flags map[string][]string) error {
+	if c.Config.SpaceGuid == "" {
+		return fmt.Errorf("No space targeted")
+	}
+	output.Printf("Pushing app %s to org %s / space %s...", appName, c.Config.OrgName, c.Config.SpaceName)
+
+	appData, appPath, err := pushedAppData(appName, flags)
+	if err != nil {
+		return err
+	}
+
+	app, err := c.client.AppByName(appName)
+	if isNotFound(err) {
+		app, err = c.client.CreateApp(appName, c.Config.SpaceGuid)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := c.applyManifest(appData); err != nil {
+		return fmt.Errorf("Could not apply the manifest: %v", err)
+	}
+
+	output.Printf("Uploading files from %s...", appPath)
+	pkg, err := c.client.UploadPackage(app.Guid, appPath)
+	if err != nil {
+		return fmt.Errorf("Could not upload the app: %v", err)
+	}
+
+	output.Printf("Staging app...")
+	dropletGuid, err := c.client.StagePackage(pkg.Guid)
+	if err != nil {
+		return err
+	}
+
+	if flag(flags, "--strategy") == "rolling" {
+		return c.deployDroplet(output, app.Guid, dropletGuid, flags)
+	}
+	return c.startDroplet(output, app, dropletGuid)
+}
+
+// pushedAppData is the manifest entry of the app with the flags applied to it, and the path of
+// its files.
+func pushedAppData(appName string, flags map[string][]string) (map[string]interface{}, string, error) {
+	appData := map[string]interface{}{}
+	appPath := "."
+	if manifestPath := flag(flags, "-f"); manifestPath != "" {
+		vars := map[string]string{}
+		for _, assignment := range flags["--var"] {
+			name, value, err := manifest.ParseVar(assignment)
+			if err != nil {
+				return nil, "", err
+			}
+			vars[name] = value
+		}
+		m, err := manifest.FileManifestReader{ManifestPath: manifestPath, VarsFiles: flags["--vars-file"], Vars: vars}.Read()
+		if err != nil {
+			return nil, "", err
+		}
+		manifestApp, err := m.AppData(appName)
+		if err != nil {
+			return nil, "", err
+		}
+		for key, value := range manifestApp {
+			appData[key] = value
+		}
+		if appPath, err = m.AppPath(appName); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if flagPath := flag(flags, "-p"); flagPath != "" {
+		appPath = flagPath
+	}
+	delete(appData, "path")
+	appData["name"] = appName
+
+	if host, domain := flag(flags, "-n"), flag(flags, "-d"); host != "" || domain != "" {
+		if domain == "" {
+			return nil, "", fmt.Errorf("A route given with -n also needs a domain given with -d")
+		}
+		for _, property := range routeProperties {
+			delete(appData, property)
+		}
+		appData["routes"] = []interface{}{map[string]interface{}{"route": routeName(domain, host, "")}}
+	}
+
+	if instances := flag(flags, "-i"); instances != "" {
+		instanceCount, err := strconv.Atoi(instances)
+		if err != nil {
+			return nil, "", fmt.Errorf("Invalid instance count %q", instances)
+		}
+		appData["instances"] = instanceCount
+	}
+	if memory := flag(flags, "-m"); memory != "" {
+		appData["memory"] = memory
+	}
+	if diskQuota := flag(flags, "-k"); diskQuota != "" {
+		appData["disk_quota"] = diskQuota
+	}
+	return appData, appPath, nil
+}
+
+// applyManifest applies the properties of an app, such as its routes, scale, environment and
+// services, and waits for the Cloud Controller to have done so.
+func (c *Connection) applyManifest(appData map[string]interface{}) error {
+	document, err := yaml.Marshal(map[string]interface{}{"applications": []interface{}{appData}})
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-yaml")
+	res, err := c.do("POST", fmt.Sprintf("/v3/spaces/%s/actions/apply_manifest", c.Config.SpaceGuid), header, document)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode >= 400 {
+		return errorDocument(res)
+	}
+
+	jobUrl := res.Header.Get("Location")
+	if jobUrl == "" {
+		return nil
+	}
+	jobGuid := path.Base(jobUrl)
+	return cfapi.Poll("applying the manifest", func() (bool, error) {
+		job, err := c.client.Job(jobGuid)
+		return job.State == "COMPLETE", err
+	})
+}
+
+// startDroplet makes the droplet the app's current one and restarts the app, waiting for all
+// its instances to be running.
+func (c *Connection) startDroplet(output *commandOutput, app cfapi.App, dropletGuid string) error {
+	if err := c.client.SetCurrentDroplet(app.Guid, dropletGuid); err != nil {
+		return err
+	}
+	output.Printf("Starting app %s...", app.Name)
+	if err := c.client.RestartApp(app.Guid); err != nil {
+		return err
+	}
+
+	err := cfapi.Poll(fmt.Sprintf("starting app %s", app.Name), func() (bool, error) {
+		instances, err := c.client.Instances(app.Guid, "web")
+		if err != nil {
+			return false, err
+		}
+		running := 0
+		for _, instance := range instances {
+			switch instance.State {
+			case "RUNNING":
+				running++
+			case "CRASHED":
+				return false, fmt.Errorf("Instance %d of app %s crashed", instance.Index, app.Name)
+			}
+		}
+		return running == len(instances), nil
+	})
+	if err != nil {
+		return err
+	}
+	output.Printf("OK")
+	return nil
+}
+
+// deployDroplet starts a rolling deployment of the droplet, waiting for it to finish unless
+// --no-wait was given.
+func (c *Connection) deployDroplet(output *commandOutput, appGuid string, dropletGuid string, flags map[string][]string) error {
+	output.Printf("Starting a rolling deployment...")
+	deployment, err := c.client.CreateDropletDeployment(appGuid, dropletGuid, cfapi.DeploymentScale{})
+	if err != nil {
+		return err
+	}
+	if _, noWait := flags["--no-wait"]; noWait {
+		output.Printf("OK")
+		return nil
+	}
+
+	err = cfapi.Poll("waiting for the deployment", func() (bool, error) {
+		deployment, err = c.client.Deployment(deployment.Guid)
+		return deployment.Status.Value == "FINALIZED", err
+	})
+	if err != nil {
+		return err
+	}
+	if deployment.Status.Reason != "DEPLOYED" {
+		return fmt.Errorf("Deployment %s finished as %s", deployment.Guid, strings.ToLower(deployment.Status.Reason))
+	}
+	output.Printf("OK")
+	return nil
+}
+
+// errorDocument is the error of a response which failed with a Cloud Controller error document.
+func errorDocument(res response) error {
+	if err := cfapi.DecodeError(res.Body); err != nil {
+		return err
+	}
+	return fmt.Errorf("Request failed with status code %d", res.StatusCode)
+}
@@ -0,0 +1,15 @@
+package standalone_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestStandalone(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter("standalone-junit.xml")
+	RunSpecsWithDefaultAndCustomReporters(t, "Standalone Suite", []Reporter{junitReporter})
+}
@@ -1,22 +1,20 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"os"
-	"strings"
 
 	"code.cloudfoundry.org/cli/plugin"
-	"code.cloudfoundry.org/cli/plugin/models"
-	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/bluegreen"
 )
 
 var PluginVersion string
 
+// CfPlugin adapts the bluegreen package to the cf CLI's plugin interface.
 type CfPlugin struct {
-	Connection plugin.CliConnection
-	Deployer   BlueGreenDeployer
+	// Version is the MAJOR.MINOR.BUILD version reported to the cf CLI
+	Version string
 }
 
 func (p *CfPlugin) Run(cliConnection plugin.CliConnection, args []string) {
@@ -24,165 +22,46 @@ func (p *CfPlugin) Run(cliConnection plugin.CliConnection, args []string) {
 		return
 	}
 
-	argsStruct := NewArgs(args)
-
-	p.Connection = cliConnection
-
-	cfDomains := manifest.CfDomains{}
-	var err error
-
-	cfDomains.SharedDomains, err = p.SharedDomains()
-	if err != nil {
-		log.Fatalf("Failed to get shared domains: %v", err)
-	}
-
-	if len(cfDomains.SharedDomains) < 1 {
-		log.Fatalf("Failed to get default shared domain (no shared domains defined)")
-	} else {
-		cfDomains.DefaultDomain = cfDomains.SharedDomains[0]
+	if len(args) > 0 && args[0] == "bgd-validate" {
+		validate(cliConnection, args)
+		return
 	}
 
-	cfDomains.PrivateDomains, err = p.PrivateDomains()
+	opts, err := bluegreen.ParseArgs(args)
 	if err != nil {
-		log.Fatalf("Failed to get private domains: %v", err)
-	}
-
-	p.Deployer.Setup(cliConnection)
-
-	if argsStruct.AppName == "" {
-		log.Fatal("App name was empty, must be provided.")
-	}
-
-	reader := manifest.FileManifestReader{argsStruct.ManifestPath}
-	if !p.Deploy(cfDomains, &reader, argsStruct) {
-		log.Fatal("Smoke tests failed")
-	}
-}
-
-func (p *CfPlugin) Deploy(cfDomains manifest.CfDomains, manifestReader manifest.ManifestReader, args Args) bool {
-	appName := args.AppName
-
-	p.Deployer.DeleteAllAppsExceptLiveApp(appName)
-	liveAppName, liveAppRoutes := p.Deployer.LiveApp(appName)
-
-	manifestScaleParameters := p.GetScaleFromManifest(appName, cfDomains, manifestReader)
-
-	// TODO We're overloading 'new' here for both the staging app and the 'finished' app, which is confusing
-	newAppRoutes := p.GetNewAppRoutes(args.AppName, cfDomains, manifestReader, liveAppRoutes)
-	newAppName := appName + "-new"
-
-	// Add route so that we can run the smoke tests
-	tempRouteDomain := newAppRoutes[0].Domain
-	tempRoute := plugin_models.GetApp_RouteSummary{Host: newAppName, Domain: tempRouteDomain}
-
-	// If deploy is unsuccessful, p.ErrorFunc will be called which exits.
-	p.Deployer.PushNewApp(newAppName, tempRoute, args.ManifestPath, manifestScaleParameters)
-
-	if liveAppName != "" {
-		p.Deployer.SetSshAccess(newAppName, p.Deployer.CheckSshEnablement(appName))
-	}
-	promoteNewApp := true
-	smokeTestScript := args.SmokeTestPath
-	if smokeTestScript != "" {
-		promoteNewApp = p.Deployer.RunSmokeTests(smokeTestScript, FQDN(tempRoute))
+		log.Fatal(err)
 	}
 
-	p.Deployer.UnmapRoutesFromApp(newAppName, tempRoute)
-	p.Deployer.DeleteRoutes(tempRoute)
-
-	if promoteNewApp {
-		// If there is a live app, we want to disassociate the routes with the old version of the app
-		// and instead update the routes to use the new version.
-		if liveAppName != "" {
-			p.Deployer.MapRoutesToApp(newAppName, newAppRoutes...)
-			p.Deployer.RenameApp(liveAppName, appName+"-old")
-			p.Deployer.RenameApp(newAppName, appName)
-			p.Deployer.UnmapRoutesFromApp(appName+"-old", liveAppRoutes...)
-		} else {
-			// If there is no live app, we only need to add our new routes.
-			p.Deployer.MapRoutesToApp(newAppName, newAppRoutes...)
-			p.Deployer.RenameApp(newAppName, appName)
-		}
-		if args.DeleteOldApps {
-			p.Deployer.DeleteAllAppsExceptLiveAndFailedApp(appName)
-		}
-		return true
-	} else {
-		// We don't want to promote. Instead mark it as failed.
-		p.Deployer.RenameApp(newAppName, appName+"-failed")
-		return false
+	orchestrator := bluegreen.New(cliConnection, os.Stdout)
+	orchestrator.AddObserver(bluegreen.ProgressObserver{Out: os.Stdout})
+	if _, err := orchestrator.Run(opts); err != nil {
+		log.Fatal(err)
 	}
 }
 
-func (p *CfPlugin) GetNewAppRoutes(appName string, cfDomains manifest.CfDomains, manifestReader manifest.ManifestReader, liveAppRoutes []plugin_models.GetApp_RouteSummary) []plugin_models.GetApp_RouteSummary {
-	newAppRoutes := []plugin_models.GetApp_RouteSummary{}
-
-	parsedManifest, err := manifestReader.Read()
+// validate prints the problems of the manifests, and exits with an error when there are any.
+func validate(cliConnection plugin.CliConnection, args []string) {
+	opts, err := bluegreen.ParseValidateArgs(args)
 	if err != nil {
-		// This error should be handled properly
-		fmt.Println(err)
+		log.Fatal(err)
 	}
 
-	if parsedManifest != nil {
-		if appParams := parsedManifest.GetAppParams(appName, cfDomains); appParams != nil && appParams.Routes != nil {
-			newAppRoutes = appParams.Routes
-		}
-	}
-
-	uniqueRoutes := p.UnionRouteLists(newAppRoutes, liveAppRoutes)
-
-	if len(uniqueRoutes) == 0 {
-		uniqueRoutes = append(uniqueRoutes, plugin_models.GetApp_RouteSummary{Host: appName, Domain: plugin_models.GetApp_DomainFields{Name: cfDomains.DefaultDomain}})
-	}
-	return uniqueRoutes
-}
-
-func (p *CfPlugin) GetScaleFromManifest(appName string, cfDomains manifest.CfDomains,
-	manifestReader manifest.ManifestReader) (scaleParameters ScaleParameters) {
-	parsedManifest, err := manifestReader.Read()
+	problems, err := bluegreen.New(cliConnection, os.Stdout).Validate(opts)
 	if err != nil {
-		// TODO: Handle this error nicely
-		fmt.Println(err)
+		log.Fatal(err)
 	}
-	if parsedManifest != nil {
-		manifestScaleParameters := parsedManifest.GetAppParams(appName, cfDomains)
-		if manifestScaleParameters != nil {
-			scaleParameters = ScaleParameters{
-				Memory:        manifestScaleParameters.Memory,
-				InstanceCount: manifestScaleParameters.InstanceCount,
-				DiskQuota:     manifestScaleParameters.DiskQuota,
-			}
-		}
-	}
-	return
-}
-
-
-func (p *CfPlugin) contains(list []plugin_models.GetApp_RouteSummary, value plugin_models.GetApp_RouteSummary) bool {
-	for _, v := range list {
-		if (v == value) {
-			return true;
-		}
+	for _, problem := range problems {
+		fmt.Println(problem)
 	}
-	return false;
-}
-
-func (p *CfPlugin) UnionRouteLists(listA []plugin_models.GetApp_RouteSummary, listB []plugin_models.GetApp_RouteSummary) []plugin_models.GetApp_RouteSummary {
-	duplicateList := append(listA, listB...)
-
-	uniqueRoutes := []plugin_models.GetApp_RouteSummary{}
-	for _, route := range duplicateList {
-		if (! p.contains(uniqueRoutes, route)) {
-			uniqueRoutes = append(uniqueRoutes, route)
-		}
+	if len(problems) > 0 {
+		log.Fatalf("Found %d problems in the manifest", len(problems))
 	}
-
-	return uniqueRoutes
+	fmt.Println("The manifest is valid")
 }
 
 func (p *CfPlugin) GetMetadata() plugin.PluginMetadata {
 	var major, minor, build int
-	fmt.Sscanf(PluginVersion, "%d.%d.%d", &major, &minor, &build)
+	fmt.Sscanf(p.Version, "%d.%d.%d", &major, &minor, &build)
 
 	return plugin.PluginMetadata{
 		Name: "blue-green-deploy",
@@ -197,13 +76,46 @@ func (p *CfPlugin) GetMetadata() plugin.PluginMetadata {
 				Alias:    "bgd",
 				HelpText: "Zero-downtime deploys with smoke tests",
 				UsageDetails: plugin.Usage{
-					// TODO for manifests with multiple apps, a different smoke test is needed. The approach below would not work.
-					// Perhaps we could name the smoke test in the manifest?
-					Usage: "blue-green-deploy APP_NAME [--smoke-test TEST_SCRIPT] [-f MANIFEST_FILE] [--delete-old-apps]",
+					Usage: "blue-green-deploy [APP_NAME]... [--app APP=MANIFEST]... | --all [--atomic] [--parallel N] [--smoke-test TEST_SCRIPT] [-f MANIFEST_FILE [-f OVERLAY_FILE | --overlay OVERLAY_FILE]... [--vars-file VARS_FILE]... [--var NAME=VALUE]... | --no-manifest] [--delete-old-apps] [--prune-routes] [--exclude-route HOST.DOMAIN[/PATH]]... [--wave DOMAIN[,DOMAIN]]... [--wave-pause DURATION] [--strategy canary|instance-canary [--canary-steps 10,50,100] [--canary-pause DURATION]] [--strategy rolling [--rolling-timeout DURATION]]\n   blue-green-deploy APP_NAME --rollback [--revision REVISION_GUID]\n\n   Both accept [--retries N] [--retry-delay DURATION] [--event-log FILE]",
 					Options: map[string]string{
-						"smoke-test": "The test script to run.",
-						"f":          "Path to manifest",
+						"smoke-test":      "The test script to run, for apps which do not name their own with x-bgd-smoke-test in the manifest",
+						"f":               "Path to manifest, and to overlays merged into it when repeated",
 						"delete-old-apps": "Delete old app instance(s)",
+						"prune-routes":    "Do not map live routes which are missing from the manifest to the new app",
+						"exclude-route":   "Leave this route mapped to the old app (can be repeated)",
+						"wave":            "Promote routes on these domains or routes together, one wave after another, each verified with the smoke test if there is one (can be repeated)",
+						"wave-pause":      "Time to wait between waves, e.g. 30s",
+						"strategy":        "How to move traffic to the new app: blue-green (default), canary, instance-canary or rolling",
+						"canary-steps":    "Percentages of traffic (canary) or instances (instance-canary) to move to the new app in turn",
+						"canary-pause":    "Time to wait between canary steps, e.g. 5m",
+						"rolling-timeout": "Time to wait for a rolling deployment to finish before cancelling it (default 15m)",
+						"rollback":        "Roll the app back to the previous revision deployed by this plugin",
+						"revision":        "Guid of the revision to roll back to",
+						"retries":         "How often to retry cf commands which fail with a transient error (default 3)",
+						"retry-delay":     "Time to wait before the first retry, doubling for every further retry (default 2s)",
+						"event-log":       "Write the events of the deployment to FILE as newline-delimited JSON",
+						"all":             "Deploy every app of the manifest, one after another",
+						"atomic":          "Promote several apps together once all of them passed their smoke tests, or none of them",
+						"parallel":        "How many apps which do not depend on each other to deploy at once, each pushed by a cf process of its own (default 1)",
+						"app":             "Deploy this app with its own manifest, given as APP=MANIFEST (can be repeated)",
+						"overlay":         "Merge this manifest into the one given with -f, like a further -f (can be repeated)",
+						"vars-file":       "Fill in the ((variables)) of the manifest from this YAML file (can be repeated)",
+						"var":             "Fill in a ((variable)) of the manifest, given as NAME=VALUE (can be repeated)",
+						"no-manifest":     "Deploy without a manifest, keeping the routes and scale of the live app",
+					},
+				},
+			},
+			{
+				Name:     "bgd-validate",
+				HelpText: "Check a manifest for the problems which would stop a blue-green deploy",
+				UsageDetails: plugin.Usage{
+					Usage: "bgd-validate [-f MANIFEST_FILE [-f OVERLAY_FILE | --overlay OVERLAY_FILE]...] [--app APP=MANIFEST]... [--vars-file VARS_FILE]... [--var NAME=VALUE]...",
+					Options: map[string]string{
+						"f":         "Path to manifest, and to overlays merged into it when repeated",
+						"overlay":   "Merge this manifest into the one given with -f, like a further -f (can be repeated)",
+						"app":       "Check the manifest of this app, given as APP=MANIFEST (can be repeated)",
+						"vars-file": "Fill in the ((variables)) of the manifest from this YAML file (can be repeated)",
+						"var":       "Fill in a ((variable)) of the manifest, given as NAME=VALUE (can be repeated)",
 					},
 				},
 			},
@@ -211,59 +123,11 @@ func (p *CfPlugin) GetMetadata() plugin.PluginMetadata {
 	}
 }
 
-func (p *CfPlugin) PrivateDomains() (domains []string, apiErr error) {
-	path := "/v2/private_domains"
-	return p.listCfDomains(path)
-}
-
-func (p *CfPlugin) SharedDomains() (domains []string, apiErr error) {
-	path := "/v2/shared_domains"
-	return p.listCfDomains(path)
-}
-
-func (p *CfPlugin) listCfDomains(cfPath string) (domains []string, err error) {
-	var res []string
-	if res, err = p.Connection.CliCommandWithoutTerminalOutput("curl", cfPath); err != nil {
-		return
-	}
-
-	response := struct {
-		Resources []struct {
-			Entity struct {
-				Name string
-			}
-		}
-	}{}
-
-	var jsonString string
-	jsonString = strings.Join(res, "\n")
-
-	if err = json.Unmarshal([]byte(jsonString), &response); err != nil {
-		return
-	}
-
-	for i, _ := range response.Resources {
-		domains = append(domains, response.Resources[i].Entity.Name)
-	}
-	return
-}
-
-func FQDN(r plugin_models.GetApp_RouteSummary) string {
-	return fmt.Sprintf("%v.%v", r.Host, r.Domain.Name)
-}
-
 func main() {
 
 	log.SetFlags(0)
 
-	p := CfPlugin{
-		Deployer: &BlueGreenDeploy{
-			ErrorFunc: func(message string, err error) {
-				log.Fatalf("%v - %v", message, err)
-			},
-			Out: os.Stdout,
-		},
-	}
+	p := CfPlugin{Version: PluginVersion}
 
 	// TODO issue #24 - (Rufus) - not sure if I'm using the plugin correctly, but if I build (go build) and run without arguments
 	// I expected to see available arguments but instead the code panics.
@@ -8,6 +8,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"code.cloudfoundry.org/cli/cf/formatters"
 	"code.cloudfoundry.org/cli/plugin/models"
@@ -23,6 +24,37 @@ type CfDomains struct {
 	DefaultDomain  string
 	SharedDomains  []string
 	PrivateDomains []string
+
+	// Domains holds what is known about each domain beyond its name. It is empty when the
+	// domains were read from an API which does not report it.
+	Domains []Domain
+}
+
+// Domain describes a domain which routes can be created on.
+type Domain struct {
+	Name     string
+	Internal bool
+	Shared   bool
+
+	// RouterGroup is the guid of the router group of TCP domains, and empty for HTTP domains
+	RouterGroup string
+}
+
+// Domain looks up what is known about the domain with the given name.
+func (d CfDomains) Domain(name string) (Domain, bool) {
+	for _, domain := range d.Domains {
+		if domain.Name == name {
+			return domain, true
+		}
+	}
+	return Domain{}, false
+}
+
+// IsExternalHttp reports whether routes on the domain are reachable over HTTP from outside the
+// foundation. Domains nothing is known about are assumed to be.
+func (d CfDomains) IsExternalHttp(name string) bool {
+	domain, ok := d.Domain(name)
+	return !ok || (!domain.Internal && domain.RouterGroup == "")
 }
 
 func (m Manifest) Applications(cfDomains CfDomains) ([]plugin_models.GetAppModel, error) {
@@ -50,16 +82,75 @@ func (m Manifest) Applications(cfDomains CfDomains) ([]plugin_models.GetAppModel
 	}
 
 	if len(mapToAppErrs) > 0 {
-		message := ""
-		for i := range mapToAppErrs {
-			message = message + fmt.Sprintf("%s\n", mapToAppErrs[i].Error())
-		}
-		return []plugin_models.GetAppModel{}, errors.New(message)
+		return []plugin_models.GetAppModel{}, joinErrors(mapToAppErrs)
 	}
 
 	return apps, nil
 }
 
+// AppData returns the properties of an app in the manifest, including the global properties, as
+// they were written. An app without a name, or the only app of the manifest, is taken to be
+// appName, as cf push does with a manifest for a single app.
+func (m Manifest) AppData(appName string) (map[string]interface{}, error) {
+	rawData, err := expandProperties(m.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	appMaps, err := m.getAppMaps(rawData.(map[string]interface{}))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, appMap := range appMaps {
+		if name, ok := appMap["name"]; !ok || len(appMaps) == 1 || name == appName {
+			return appMap, nil
+		}
+	}
+	return nil, fmt.Errorf("Could not find app %s in the manifest", appName)
+}
+
+// AppPath is the path of the files of an app as cf push finds them: the path property of the
+// app, relative to the manifest, or else the directory of the manifest.
+func (m Manifest) AppPath(appName string) (string, error) {
+	appData, err := m.AppData(appName)
+	if err != nil {
+		return "", err
+	}
+	manifestDir := filepath.Dir(m.Path)
+	appPath, ok := appData["path"].(string)
+	if !ok {
+		return manifestDir, nil
+	}
+	if !filepath.IsAbs(appPath) {
+		appPath = filepath.Join(manifestDir, appPath)
+	}
+	return appPath, nil
+}
+
+// AppNames lists the names of the apps of the manifest, in the order it declares them.
+func (m Manifest) AppNames() ([]string, error) {
+	rawData, err := expandProperties(m.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	appMaps, err := m.getAppMaps(rawData.(map[string]interface{}))
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for i, appMap := range appMaps {
+		name, ok := appMap["name"].(string)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("Application %d of the manifest has no name", i+1)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
 func cloneWithExclude(data map[string]interface{}, excludedKey string) map[string]interface{} {
 	otherMap := make(map[string]interface{})
 	for key, value := range data {
@@ -89,12 +180,11 @@ func (m Manifest) getAppMaps(data map[string]interface{}) ([]map[string]interfac
 		for _, appData := range appMaps {
 			appDataAsMap, err := Mappify(appData)
 			if err != nil {
-				errs = append(errs, fmt.Errorf("Expected application to be a list of key/value pairs\nError occurred in manifest near:\n'{{.YmlSnippet}}'. Error was %v",
-					map[string]interface{}{"YmlSnippet": appData}, err))
+				errs = append(errs, fmt.Errorf("Expected application to be a list of key/value pairs\nError occurred in manifest near:\n'%v'. Error was %v", appData, err))
 				continue
 			}
 
-			appMap, err := DeepMerge(globalProperties, appDataAsMap)
+			appMap, err := ManifestMerger.Merge(globalProperties, appDataAsMap)
 			if err != nil {
 				return nil, err
 			}
@@ -107,11 +197,7 @@ func (m Manifest) getAppMaps(data map[string]interface{}) ([]map[string]interfac
 	}
 
 	if len(errs) > 0 {
-		message := ""
-		for i := range errs {
-			message = message + fmt.Sprintf("%s\n", errs[i].Error())
-		}
-		return []map[string]interface{}{}, errors.New(message)
+		return []map[string]interface{}{}, joinErrors(errs)
 	}
 
 	return apps, nil
@@ -131,8 +217,7 @@ func expandProperties(input interface{}) (interface{}, error) {
 				// TODO we need a test for a manifest with ${random-word}
 				output = strings.Replace(input, "${random-word}", strings.ToLower(randomdata.SillyName()), -1)
 			} else {
-				err := fmt.Errorf("Property '{{.PropertyName}}' found in manifest. This feature is no longer supported. Please remove it and try again.",
-					map[string]interface{}{"PropertyName": match[0]})
+				err := fmt.Errorf("Property '%s' found in manifest. This feature is no longer supported. Please remove it and try again.", match[0])
 				errs = append(errs, err)
 			}
 		} else {
@@ -179,11 +264,7 @@ func expandProperties(input interface{}) (interface{}, error) {
 	}
 
 	if len(errs) > 0 {
-		message := ""
-		for _, err := range errs {
-			message = message + fmt.Sprintf("%s\n", err.Error())
-		}
-		return nil, errors.New(message)
+		return nil, joinErrors(errs)
 	}
 
 	return output, nil
@@ -237,15 +318,20 @@ func mapToAppParams(basePath string, yamlMap map[string]interface{}, cfDomains C
 	}
 
 	if len(errs) > 0 {
-		message := ""
-		for _, err := range errs {
-			message = message + fmt.Sprintf("%s\n", err.Error())
-		}
-		return plugin_models.GetAppModel{}, errors.New(message)
+		return plugin_models.GetAppModel{}, joinErrors(errs)
 	}
 	return appParams, nil
 }
 
+// joinErrors is an error with the message of every one of errs, each on a line of its own.
+func joinErrors(errs []error) error {
+	message := ""
+	for _, err := range errs {
+		message = message + fmt.Sprintf("%s\n", err.Error())
+	}
+	return errors.New(message)
+}
+
 func removeDuplicatedValue(ary []string) []string {
 	if ary == nil {
 		return nil
@@ -273,16 +359,12 @@ func checkForNulls(yamlMap map[string]interface{}) error {
 			break
 		}
 		if value == nil {
-			errs = append(errs, fmt.Errorf("{{.PropertyName}} should not be null", map[string]interface{}{"PropertyName": key}))
+			errs = append(errs, fmt.Errorf("%s should not be null", key))
 		}
 	}
 
 	if len(errs) > 0 {
-		message := ""
-		for i := range errs {
-			message = message + fmt.Sprintf("%s\n", errs[i].Error())
-		}
-		return errors.New(message)
+		return joinErrors(errs)
 	}
 
 	return nil
@@ -295,7 +377,7 @@ func stringVal(yamlMap map[string]interface{}, key string, errs *[]error) *strin
 	}
 	result, ok := val.(string)
 	if !ok {
-		*errs = append(*errs, fmt.Errorf("{{.PropertyName}} must be a string value", map[string]interface{}{"PropertyName": key}))
+		*errs = append(*errs, fmt.Errorf("%s must be a string value", key))
 		return nil
 	}
 	return &result
@@ -310,12 +392,7 @@ func bytesVal(yamlMap map[string]interface{}, key string, errs *[]error) *int64
 	stringVal := coerceToString(yamlVal)
 	value, err := formatters.ToMegabytes(stringVal)
 	if err != nil {
-		*errs = append(*errs, fmt.Errorf("Invalid value for '{{.PropertyName}}': {{.StringVal}}\n{{.Error}}",
-			map[string]interface{}{
-				"PropertyName": key,
-				"Error":        err.Error(),
-				"StringVal":    stringVal,
-			}))
+		*errs = append(*errs, fmt.Errorf("Invalid value for '%s': %s, %v", key, stringVal, err))
 		return nil
 	}
 	return &value
@@ -329,7 +406,9 @@ func intVal(yamlMap map[string]interface{}, key string, errs *[]error) *int {
 
 	switch val := yamlMap[key].(type) {
 	case string:
-		intVal, err = strconv.Atoi(val)
+		if intVal, err = strconv.Atoi(val); err != nil {
+			err = fmt.Errorf("Invalid value for '%s': %s, expected a whole number", key, val)
+		}
 	case int:
 		intVal = val
 	case int64:
@@ -337,8 +416,7 @@ func intVal(yamlMap map[string]interface{}, key string, errs *[]error) *int {
 	case nil:
 		return nil
 	default:
-		err = fmt.Errorf("Expected {{.PropertyName}} to be a number, but it was a {{.PropertyType}}.",
-			map[string]interface{}{"PropertyName": key, "PropertyType": val})
+		err = fmt.Errorf("Expected %s to be a number, but it was a %s.", key, yamlType(val))
 	}
 
 	if err != nil {
@@ -349,6 +427,35 @@ func intVal(yamlMap map[string]interface{}, key string, errs *[]error) *int {
 	return &intVal
 }
 
+func durationVal(yamlMap map[string]interface{}, key string, errs *[]error) *time.Duration {
+	yamlVal := yamlMap[key]
+	if yamlVal == nil {
+		return nil
+	}
+
+	value, err := time.ParseDuration(coerceToString(yamlVal))
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("Invalid value for '%s': %v, expected a duration such as 30s", key, yamlVal))
+		return nil
+	}
+	return &value
+}
+
+// yamlType names the type of a value of a manifest the way YAML does.
+func yamlType(value interface{}) string {
+	switch value.(type) {
+	case bool:
+		return "boolean"
+	case float64:
+		return "decimal number"
+	case []interface{}:
+		return "list"
+	case map[interface{}]interface{}, map[string]interface{}:
+		return "map"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
 func coerceToString(value interface{}) string {
 	return fmt.Sprintf("%v", value)
 }
@@ -361,7 +468,7 @@ func sliceOrNil(yamlMap map[string]interface{}, key string, errs *[]error) []str
 	var err error
 	stringSlice := []string{}
 
-	sliceErr := fmt.Errorf("Expected {{.PropertyName}} to be a list of strings.", map[string]interface{}{"PropertyName": key})
+	sliceErr := fmt.Errorf("Expected %s to be a list of strings.", key)
 
 	switch input := yamlMap[key].(type) {
 	case []interface{}:
@@ -404,13 +511,17 @@ func RoutesFromManifest(defaultDomain string, Hosts []string, Domains []string)
 }
 
 func parseRoutes(cfDomains CfDomains, input map[string]interface{}, errs *[]error) []plugin_models.GetApp_RouteSummary {
-	if _, ok := input["routes"]; !ok {
+	return parseRouteList(cfDomains, input, "routes", errs)
+}
+
+func parseRouteList(cfDomains CfDomains, input map[string]interface{}, key string, errs *[]error) []plugin_models.GetApp_RouteSummary {
+	if _, ok := input[key]; !ok {
 		return nil
 	}
 
-	genericRoutes, ok := input["routes"].([]interface{})
+	genericRoutes, ok := input[key].([]interface{})
 	if !ok {
-		*errs = append(*errs, fmt.Errorf("'routes' should be a list"))
+		*errs = append(*errs, fmt.Errorf("'%s' should be a list", key))
 		return nil
 	}
 
@@ -418,37 +529,55 @@ func parseRoutes(cfDomains CfDomains, input map[string]interface{}, errs *[]erro
 	for _, genericRoute := range genericRoutes {
 		route, ok := genericRoute.(map[interface{}]interface{})
 		if !ok {
-			*errs = append(*errs, fmt.Errorf("each route in 'routes' must have a 'route' property"))
+			*errs = append(*errs, fmt.Errorf("each route in '%s' must have a 'route' property", key))
 			continue
 		}
 
 		if routeVal, exist := route["route"]; exist {
-			routeWithoutPath, path := findPath(routeVal.(string))
-
-			routeWithoutPathAndPort, port, err := findPort(routeWithoutPath)
+			parsedRoute, err := ParseRoute(cfDomains, routeVal.(string))
 			if err != nil {
 				*errs = append(*errs, err)
 			}
-			hostname, domain, err := findDomain(cfDomains, routeWithoutPathAndPort)
-			if err != nil {
-				*errs = append(*errs, err)
-			}
-			manifestRoutes = append(manifestRoutes, plugin_models.GetApp_RouteSummary{
-
-				// HTTP routes include a domain, an optional hostname, and an optional context path
-				Host:   hostname,
-				Domain: domain,
-				Path:   path,
-				Port:   port,
-			})
+			manifestRoutes = append(manifestRoutes, parsedRoute)
 		} else {
-			*errs = append(*errs, fmt.Errorf("each route in 'routes' must have a 'route' property"))
+			*errs = append(*errs, fmt.Errorf("each route in '%s' must have a 'route' property", key))
 		}
 	}
 
 	return manifestRoutes
 }
 
+// ParseRoute splits a route written as HOST.DOMAIN[:PORT][/PATH] into its parts, using the known
+// domains to tell where the host ends and the domain starts.
+func ParseRoute(cfDomains CfDomains, routeName string) (plugin_models.GetApp_RouteSummary, error) {
+	var errs []error
+
+	routeWithoutPath, path := findPath(routeName)
+
+	routeWithoutPathAndPort, port, err := findPort(routeWithoutPath)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	hostname, domain, err := findDomain(cfDomains, routeWithoutPathAndPort)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	route := plugin_models.GetApp_RouteSummary{
+
+		// HTTP routes include a domain, an optional hostname, and an optional context path
+		Host:   hostname,
+		Domain: domain,
+		Path:   path,
+		Port:   port,
+	}
+
+	if len(errs) > 0 {
+		return route, errors.New(strings.TrimSuffix(joinErrors(errs).Error(), "\n"))
+	}
+	return route, nil
+}
+
 func findPath(routeName string) (string, string) {
 	routeSlice := strings.Split(routeName, "/")
 	return routeSlice[0], strings.Join(routeSlice[1:], "/")
@@ -511,12 +640,21 @@ func decomposeRoute(allowedDomains []string, routeName string) (string, *plugin_
 }
 
 func (manifest *Manifest) GetAppParams(appName string, cfDomains CfDomains) *plugin_models.GetAppModel {
-	var err error
-	apps, err := manifest.Applications(cfDomains)
+	appParams, err := manifest.AppParams(appName, cfDomains)
 	if err != nil {
 		fmt.Println(err)
 		return nil
 	}
+	return appParams
+}
+
+// AppParams are the properties of the app in the manifest, or nil when the manifest does not
+// have the app. It fails when the applications of the manifest cannot be parsed.
+func (manifest *Manifest) AppParams(appName string, cfDomains CfDomains) (*plugin_models.GetAppModel, error) {
+	apps, err := manifest.Applications(cfDomains)
+	if err != nil {
+		return nil, err
+	}
 
 	for index, app := range apps {
 		if isHostOrDomainEmpty(app) {
@@ -525,10 +663,10 @@ func (manifest *Manifest) GetAppParams(appName string, cfDomains CfDomains) *plu
 		if app.Name != "" && app.Name != appName {
 			continue
 		}
-		return &apps[index]
+		return &apps[index], nil
 	}
 
-	return nil
+	return nil, nil
 }
 
 func isHostOrDomainEmpty(app plugin_models.GetAppModel) bool {
@@ -539,3 +677,83 @@ func isHostOrDomainEmpty(app plugin_models.GetAppModel) bool {
 	}
 	return true
 }
+
+// PluginParams holds the settings of an application which only this plugin understands.
+// They are declared in the manifest with an "x-bgd-" prefix, which cf push ignores.
+type PluginParams struct {
+	ExcludedRoutes []plugin_models.GetApp_RouteSummary
+
+	// SmokeTest is the script the app is smoke tested with, found relative to the manifest. The
+	// other settings are zero unless the manifest sets them.
+	SmokeTest         string
+	SmokeTestTimeout  time.Duration
+	SmokeTestAttempts int
+	SmokeTestInterval time.Duration
+
+	// DependsOn are the apps which have to be promoted before this one.
+	DependsOn []string
+}
+
+func (manifest *Manifest) GetPluginParams(appName string, cfDomains CfDomains) (*PluginParams, error) {
+	rawData, err := expandProperties(manifest.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	appMaps, err := manifest.getAppMaps(rawData.(map[string]interface{}))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, appMap := range appMaps {
+		var errs []error
+		if name := stringVal(appMap, "name", &errs); name != nil && *name != appName {
+			continue
+		}
+
+		pluginParams := mapToPluginParams(filepath.Dir(manifest.Path), appMap, cfDomains, &errs)
+		if len(errs) > 0 {
+			return nil, joinErrors(errs)
+		}
+		return &pluginParams, nil
+	}
+
+	return &PluginParams{}, nil
+}
+
+func mapToPluginParams(basePath string, yamlMap map[string]interface{}, cfDomains CfDomains, errs *[]error) PluginParams {
+	pluginParams := PluginParams{
+		ExcludedRoutes: parseRouteList(cfDomains, yamlMap, "x-bgd-exclude-routes", errs),
+	}
+
+	if smokeTest := stringVal(yamlMap, "x-bgd-smoke-test", errs); smokeTest != nil {
+		pluginParams.SmokeTest = scriptPath(basePath, *smokeTest)
+	}
+	if timeout := durationVal(yamlMap, "x-bgd-smoke-test-timeout", errs); timeout != nil {
+		pluginParams.SmokeTestTimeout = *timeout
+	}
+	if attempts := intVal(yamlMap, "x-bgd-smoke-test-attempts", errs); attempts != nil {
+		if *attempts < 1 {
+			*errs = append(*errs, fmt.Errorf("x-bgd-smoke-test-attempts must be at least 1"))
+		}
+		pluginParams.SmokeTestAttempts = *attempts
+	}
+	if interval := durationVal(yamlMap, "x-bgd-smoke-test-interval", errs); interval != nil {
+		pluginParams.SmokeTestInterval = *interval
+	}
+	pluginParams.DependsOn = sliceOrNil(yamlMap, "x-bgd-depends-on", errs)
+	return pluginParams
+}
+
+// scriptPath finds a script named in the manifest relative to the manifest, in a way which runs
+// it rather than one of the same name on the PATH.
+func scriptPath(basePath string, script string) string {
+	if filepath.IsAbs(script) {
+		return script
+	}
+	script = filepath.Join(basePath, script)
+	if !strings.ContainsRune(script, filepath.Separator) {
+		script = "." + string(filepath.Separator) + script
+	}
+	return script
+}
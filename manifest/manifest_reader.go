@@ -15,8 +15,14 @@ type ManifestReader interface {
 	Read() (*Manifest, error)
 }
 
+// FileManifestReader reads a manifest from a file, or from the manifest.yml of a directory, and
+// fills in its ((variables)) from VarsFiles and Vars like cf push does. The Overlays are merged
+// into the manifest in turn, see MergeOverlay.
 type FileManifestReader struct {
 	ManifestPath string
+	Overlays     []string
+	VarsFiles    []string
+	Vars         map[string]string
 }
 
 func (manifestReader FileManifestReader) Read() (*Manifest, error) {
@@ -45,17 +51,129 @@ func (manifestReader *FileManifestReader) readManifest(inputPath string) (*Manif
 
 	m.Path = manifestPath
 
-	mapp, err := manifestReader.readAllYAMLFiles(manifestPath)
+	mapp, err := manifestReader.readMerged(manifestPath)
 
 	if err != nil {
 		return m, err
 	}
 
-	m.Data = mapp
+	values, err := readVars(manifestReader.VarsFiles, manifestReader.Vars)
+	if err != nil {
+		return m, err
+	}
+	data, err := interpolate(mapp, values)
+	if err != nil {
+		return m, err
+	}
+
+	m.Data = data.(map[string]interface{})
 
 	return m, nil
 }
 
+// readMerged reads the manifest with the manifests it inherits from and its overlays.
+func (manifestReader *FileManifestReader) readMerged(manifestPath string) (map[string]interface{}, error) {
+	mapp, err := manifestReader.readAllYAMLFiles(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, overlayPath := range manifestReader.Overlays {
+		overlay, err := manifestReader.readAllYAMLFiles(overlayPath)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read overlay %s: %v", overlayPath, err)
+		}
+		if mapp, err = MergeOverlay(mapp, overlay); err != nil {
+			return nil, fmt.Errorf("Could not merge overlay %s: %v", overlayPath, err)
+		}
+	}
+	return mapp, nil
+}
+
+// Generate writes the manifest merged with its overlays to a new file in the temporary directory,
+// for cf push to read, and returns its path. Written next to the manifest, it would be uploaded
+// with the app's files. The ((variables)) are left for cf push to fill in, and the paths of the
+// apps are made absolute, since cf push reads them relative to the merged manifest. The caller
+// removes the file.
+func (manifestReader FileManifestReader) Generate() (string, error) {
+	inputPath := manifestReader.ManifestPath
+	if inputPath == "" {
+		inputPath = "./"
+	}
+	manifestPath, err := manifestReader.interpetManifestPath(inputPath)
+	if err != nil {
+		return "", fmt.Errorf("Error finding manifest: %v", err)
+	}
+
+	mapp, err := manifestReader.readMerged(manifestPath)
+	if err != nil {
+		return "", err
+	}
+	// The manifests it inherits from are merged in already
+	delete(mapp, "inherit")
+	if err := absoluteAppPaths(mapp, filepath.Dir(manifestPath)); err != nil {
+		return "", err
+	}
+
+	contents, err := yaml.Marshal(mapp)
+	if err != nil {
+		return "", err
+	}
+	file, err := ioutil.TempFile("", "bgd-manifest-*.yml")
+	if err != nil {
+		return "", fmt.Errorf("Could not write the merged manifest: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append([]byte("---\n"), contents...)); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("Could not write the merged manifest: %v", err)
+	}
+	return file.Name(), nil
+}
+
+// absoluteAppPaths makes the paths of the apps of a manifest absolute, and gives the apps without
+// a path the directory of the manifest, which cf push uploads for them. Docker apps have no files
+// to upload.
+func absoluteAppPaths(mapp map[string]interface{}, manifestDir string) error {
+	manifestDir, err := filepath.Abs(manifestDir)
+	if err != nil {
+		return err
+	}
+	absolute := func(path interface{}) interface{} {
+		if relativePath, ok := path.(string); ok && !filepath.IsAbs(relativePath) {
+			return filepath.Join(manifestDir, relativePath)
+		}
+		return path
+	}
+
+	_, globalPath := mapp["path"]
+	if globalPath {
+		mapp["path"] = absolute(mapp["path"])
+	}
+	apps, _ := mapp["applications"].([]interface{})
+	for _, app := range apps {
+		var path, docker interface{}
+		var hasPath bool
+		setPath := func(interface{}) {}
+		switch appMap := app.(type) {
+		case map[interface{}]interface{}:
+			path, hasPath = appMap["path"]
+			docker = appMap["docker"]
+			setPath = func(path interface{}) { appMap["path"] = path }
+		case map[string]interface{}:
+			path, hasPath = appMap["path"]
+			docker = appMap["docker"]
+			setPath = func(path interface{}) { appMap["path"] = path }
+		}
+		if hasPath {
+			setPath(absolute(path))
+		} else if docker == nil && !globalPath {
+			setPath(manifestDir)
+		}
+	}
+	return nil
+}
+
 func (manifestReader *FileManifestReader) readAllYAMLFiles(path string) (mergedMap map[string]interface{}, err error) {
 	file, err := os.Open(filepath.Clean(path))
 
@@ -89,7 +207,7 @@ func (manifestReader *FileManifestReader) readAllYAMLFiles(path string) (mergedM
 		return
 	}
 
-	mergedMap, err = DeepMerge(inheritedMap, mapp)
+	mergedMap, err = ManifestMerger.Merge(inheritedMap, mapp)
 	if err != nil {
 		return
 	}
@@ -1,6 +1,10 @@
 package manifest_test
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
 	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -71,4 +75,145 @@ var _ = Describe("Manifest reader", func() {
 			Expect(manifest.Data["domain"]).To(Equal("shared-domain.example.com"))
 		})
 	})
+
+	Context("When a manifest overrides the lists of the manifest it inherits from", func() {
+
+		It("merges the applications and routes instead of repeating them", func() {
+			reader := &manifest.FileManifestReader{ManifestPath: "../fixtures/manifestwithinheritedroutes.yml"}
+			m, err := reader.Read()
+			Expect(err).To(BeNil())
+			Expect(m.AppNames()).To(Equal([]string{"routed-app"}))
+
+			app, err := m.AppData("routed-app")
+			Expect(err).To(BeNil())
+			Expect(app["instances"]).To(Equal(2))
+			Expect(app["routes"]).To(HaveLen(2))
+			Expect(app["buildpacks"]).To(Equal([]interface{}{"go_buildpack"}))
+		})
+	})
+
+	Context("When a manifest has variables", func() {
+
+		It("fills them in from the vars files, keeping the type of whole values", func() {
+			reader := &manifest.FileManifestReader{ManifestPath: "../fixtures/manifestwithvars.yml", VarsFiles: []string{"../fixtures/vars.yml"}}
+			m, err := reader.Read()
+			Expect(err).To(BeNil())
+
+			app, err := m.AppData("vars-app")
+			Expect(err).To(BeNil())
+			Expect(app["instances"]).To(Equal(3))
+			Expect(app["routes"]).To(Equal([]interface{}{map[interface{}]interface{}{"route": "www.example.com"}}))
+		})
+
+		It("lets later vars files and vars given one by one override earlier ones", func() {
+			reader := &manifest.FileManifestReader{
+				ManifestPath: "../fixtures/manifestwithvars.yml",
+				VarsFiles:    []string{"../fixtures/vars.yml", "../fixtures/other-vars.yml"},
+				Vars:         map[string]string{"instances": "5"},
+			}
+			m, err := reader.Read()
+			Expect(err).To(BeNil())
+
+			app, err := m.AppData("vars-app")
+			Expect(err).To(BeNil())
+			Expect(app["instances"]).To(Equal("5"))
+			Expect(app["routes"]).To(Equal([]interface{}{map[interface{}]interface{}{"route": "api.example.com"}}))
+		})
+
+		It("names the variables without a value", func() {
+			reader := &manifest.FileManifestReader{ManifestPath: "../fixtures/manifestwithvars.yml", Vars: map[string]string{"host": "www"}}
+			_, err := reader.Read()
+			Expect(err).To(MatchError("Expected to find variables: app-name, domain.name, instances"))
+		})
+	})
+})
+
+var _ = Describe("Manifest reader with overlays", func() {
+	reader := &manifest.FileManifestReader{
+		ManifestPath: "../fixtures/layered/manifest.yml",
+		Overlays:     []string{"../fixtures/layered/prod.yml", "../fixtures/layered/eu.yml"},
+		Vars:         map[string]string{"host": "www"},
+	}
+
+	It("merges the overlays into the manifest in turn", func() {
+		m, err := reader.Read()
+		Expect(err).To(BeNil())
+
+		web, err := m.AppData("web")
+		Expect(err).To(BeNil())
+		Expect(web["instances"]).To(Equal(4))
+		Expect(web["memory"]).To(Equal("256M"))
+		Expect(web["routes"]).To(Equal([]interface{}{map[interface{}]interface{}{"route": "www.example.com"}}))
+		Expect(web["env"]).To(Equal(map[string]interface{}{"LOG_LEVEL": "info", "GREETING": "hello", "REGION": "eu"}))
+
+		worker, err := m.AppData("worker")
+		Expect(err).To(BeNil())
+		Expect(worker["no-route"]).To(Equal(true))
+	})
+
+	It("generates the merged manifest outside the app's files, leaving the variables to cf push", func() {
+		mergedPath, err := reader.Generate()
+		Expect(err).To(BeNil())
+		defer os.Remove(mergedPath)
+
+		Expect(filepath.Dir(mergedPath)).To(Equal(filepath.Clean(os.TempDir())))
+		contents, err := ioutil.ReadFile(mergedPath)
+		Expect(err).To(BeNil())
+		Expect(string(contents)).To(ContainSubstring("route: ((host)).example.com"))
+
+		m, err := (&manifest.FileManifestReader{ManifestPath: mergedPath, Vars: map[string]string{"host": "www"}}).Read()
+		Expect(err).To(BeNil())
+		Expect(m.AppNames()).To(Equal([]string{"web", "worker"}))
+
+		// The apps are still pushed from the directory of the manifest
+		layeredDir, err := filepath.Abs("../fixtures/layered")
+		Expect(err).To(BeNil())
+		Expect(m.AppPath("web")).To(Equal(layeredDir))
+		Expect(m.AppPath("worker")).To(Equal(layeredDir))
+	})
+
+	It("makes the paths of the apps of the merged manifest absolute", func() {
+		dir, err := ioutil.TempDir("", "bgd-generate")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		Expect(ioutil.WriteFile(filepath.Join(dir, "manifest.yml"), []byte(`---
+applications:
+- name: web
+  path: dist
+- name: image
+  docker:
+    image: nginx
+`), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "overlay.yml"), []byte("---\napplications:\n- name: web\n  instances: 2\n"), 0644)).To(Succeed())
+
+		mergedPath, err := (&manifest.FileManifestReader{ManifestPath: filepath.Join(dir, "manifest.yml"), Overlays: []string{filepath.Join(dir, "overlay.yml")}}).Generate()
+		Expect(err).To(BeNil())
+		defer os.Remove(mergedPath)
+
+		m, err := (&manifest.FileManifestReader{ManifestPath: mergedPath}).Read()
+		Expect(err).To(BeNil())
+		Expect(m.AppPath("web")).To(Equal(filepath.Join(dir, "dist")))
+		image, err := m.AppData("image")
+		Expect(err).To(BeNil())
+		Expect(image).NotTo(HaveKey("path"))
+	})
+
+	It("names an overlay which cannot be read", func() {
+		_, err := (&manifest.FileManifestReader{ManifestPath: "../fixtures/layered/manifest.yml", Overlays: []string{"../fixtures/layered/missing.yml"}}).Read()
+		Expect(err).To(MatchError(ContainSubstring("Could not read overlay ../fixtures/layered/missing.yml")))
+	})
+})
+
+var _ = Describe("ParseVar", func() {
+	It("splits a variable given as NAME=VALUE", func() {
+		name, value, err := manifest.ParseVar("route=www.example.com/path?a=b")
+		Expect(err).To(BeNil())
+		Expect(name).To(Equal("route"))
+		Expect(value).To(Equal("www.example.com/path?a=b"))
+	})
+
+	It("needs a name and a value", func() {
+		_, _, err := manifest.ParseVar("route")
+		Expect(err).To(MatchError(`"route" is not of the form NAME=VALUE`))
+	})
 })
@@ -1,6 +1,8 @@
 package manifest
 
 import (
+	"time"
+
 	"code.cloudfoundry.org/cli/plugin/models"
 	"github.com/cloudfoundry-incubator/candiedyaml"
 	. "github.com/onsi/ginkgo"
@@ -158,6 +160,31 @@ var _ = Describe("Manifest", func() {
 		})
 	})
 
+	Context("the AppNames function", func() {
+		It("lists the apps in the order of the manifest", func() {
+			m := &Manifest{Data: map[string]interface{}{
+				"applications": []interface{}{
+					map[interface{}]interface{}{"name": "frontend"},
+					map[interface{}]interface{}{"name": "api"},
+				},
+			}}
+
+			Expect(m.AppNames()).To(Equal([]string{"frontend", "api"}))
+		})
+
+		It("returns an error for an app without a name", func() {
+			m := &Manifest{Data: map[string]interface{}{
+				"applications": []interface{}{
+					map[interface{}]interface{}{"name": "frontend"},
+					map[interface{}]interface{}{"host": "api"},
+				},
+			}}
+
+			_, err := m.AppNames()
+			Expect(err).To(MatchError("Application 2 of the manifest has no name"))
+		})
+	})
+
 })
 
 var _ = Describe("CloneWithExclude", func() {
@@ -449,3 +476,131 @@ func manifestFromYamlString(yamlString string) *Manifest {
 	candiedyaml.Unmarshal([]byte(yamlString), &yamlMap)
 	return &Manifest{Data: yamlMap}
 }
+
+var _ = Describe("Plugin params", func() {
+	cfDomains := CfDomains{DefaultDomain: "example.com", SharedDomains: []string{"example.com"}}
+
+	Context("when the app declares excluded routes", func() {
+		It("parses them like routes", func() {
+			m := &Manifest{Data: map[string]interface{}{
+				"applications": []interface{}{
+					map[interface{}]interface{}{
+						"name": "other",
+					},
+					map[interface{}]interface{}{
+						"name": "foo",
+						"x-bgd-exclude-routes": []interface{}{
+							map[interface{}]interface{}{"route": "admin.example.com/console"},
+						},
+					},
+				},
+			}}
+
+			params, err := m.GetPluginParams("foo", cfDomains)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(params.ExcludedRoutes).To(Equal([]plugin_models.GetApp_RouteSummary{
+				{Host: "admin", Domain: plugin_models.GetApp_DomainFields{Name: "example.com"}, Path: "console"},
+			}))
+		})
+	})
+
+	Context("when the excluded routes do not match a domain", func() {
+		It("returns an error", func() {
+			m := &Manifest{Data: map[string]interface{}{
+				"name": "foo",
+				"x-bgd-exclude-routes": []interface{}{
+					map[interface{}]interface{}{"route": "admin.example.org"},
+				},
+			}}
+
+			_, err := m.GetPluginParams("foo", cfDomains)
+			Expect(err).To(MatchError(ContainSubstring("did not match any existing domains")))
+		})
+	})
+
+	Context("when the app declares a smoke test", func() {
+		It("finds the script relative to the manifest", func() {
+			m := &Manifest{Path: "deploy/manifest.yml", Data: map[string]interface{}{
+				"applications": []interface{}{
+					map[interface{}]interface{}{
+						"name":                      "foo",
+						"x-bgd-smoke-test":          "./smoke/api.sh",
+						"x-bgd-smoke-test-timeout":  "2m",
+						"x-bgd-smoke-test-attempts": 3,
+						"x-bgd-smoke-test-interval": "10s",
+					},
+				},
+			}}
+
+			params, err := m.GetPluginParams("foo", cfDomains)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(params.SmokeTest).To(Equal("deploy/smoke/api.sh"))
+			Expect(params.SmokeTestTimeout).To(Equal(2 * time.Minute))
+			Expect(params.SmokeTestAttempts).To(Equal(3))
+			Expect(params.SmokeTestInterval).To(Equal(10 * time.Second))
+		})
+
+		It("runs a script next to a manifest in the working directory from there", func() {
+			m := &Manifest{Path: "manifest.yml", Data: map[string]interface{}{"name": "foo", "x-bgd-smoke-test": "smoke.sh"}}
+
+			params, err := m.GetPluginParams("foo", cfDomains)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(params.SmokeTest).To(Equal("./smoke.sh"))
+		})
+
+		It("returns an error for an invalid timeout", func() {
+			m := &Manifest{Data: map[string]interface{}{"name": "foo", "x-bgd-smoke-test-timeout": "soon"}}
+
+			_, err := m.GetPluginParams("foo", cfDomains)
+			Expect(err).To(MatchError(ContainSubstring("Invalid value for 'x-bgd-smoke-test-timeout': soon")))
+		})
+	})
+
+	Context("when the app depends on other apps", func() {
+		It("lists them", func() {
+			m := &Manifest{Data: map[string]interface{}{
+				"name":             "frontend",
+				"x-bgd-depends-on": []interface{}{"api", "auth"},
+			}}
+
+			params, err := m.GetPluginParams("frontend", cfDomains)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(params.DependsOn).To(Equal([]string{"api", "auth"}))
+		})
+
+		It("returns an error when they are not a list", func() {
+			m := &Manifest{Data: map[string]interface{}{"name": "frontend", "x-bgd-depends-on": "api"}}
+
+			_, err := m.GetPluginParams("frontend", cfDomains)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("when the app is not in the manifest", func() {
+		It("returns empty params", func() {
+			m := &Manifest{Data: map[string]interface{}{"name": "other"}}
+
+			params, err := m.GetPluginParams("foo", cfDomains)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(params.ExcludedRoutes).To(BeEmpty())
+		})
+	})
+})
+
+var _ = Describe("CfDomains", func() {
+	cfDomains := CfDomains{Domains: []Domain{
+		{Name: "example.com", Shared: true},
+		{Name: "apps.internal", Internal: true, Shared: true},
+		{Name: "tcp.example.com", Shared: true, RouterGroup: "default-tcp"},
+	}}
+
+	It("knows which domains are reachable over HTTP from outside", func() {
+		Expect(cfDomains.IsExternalHttp("example.com")).To(BeTrue())
+		Expect(cfDomains.IsExternalHttp("apps.internal")).To(BeFalse())
+		Expect(cfDomains.IsExternalHttp("tcp.example.com")).To(BeFalse())
+	})
+
+	It("assumes unknown domains are reachable", func() {
+		Expect(cfDomains.IsExternalHttp("unknown.com")).To(BeTrue())
+	})
+})
@@ -6,45 +6,254 @@ import (
 	"reflect"
 )
 
+// DeepMerge merges maps into a new one, in order: maps are merged key by key, lists are appended
+// and other values are replaced. It is the AppendingMerger, see ManifestMerger for manifests.
 func DeepMerge(maps ...map[string]interface{}) (map[string]interface{}, error) {
-	mergedmap := make(map[string]interface{})
-	return Reduce(maps, mergedmap, mergeReducer)
+	return AppendingMerger.Merge(maps...)
 }
 
-func mergeReducer(key string, val interface{}, reduced map[string]interface{}) (map[string]interface{}, error) {
+// ReplaceKey lists the keys of a map whose values replace the ones of the maps it is merged
+// into, rather than being merged with them, such as x-bgd-replace: [routes].
+const ReplaceKey = "x-bgd-replace"
 
+// ListMerge says how a list is merged into the list of the same key: appended to it, replacing
+// it, or with MergeListsBy, merging the items with the same value of a key.
+type ListMerge struct {
+	Replace bool
+	Key     string
+}
+
+var (
+	AppendLists  = ListMerge{}
+	ReplaceLists = ListMerge{Replace: true}
+)
+
+// MergeListsBy merges the items of two lists with the same value of key, such as routes with the
+// same route. Items which are not maps, such as services given by name, are their own key. The
+// other items of the second list are added after the ones of the first.
+func MergeListsBy(key string) ListMerge {
+	return ListMerge{Key: key}
+}
+
+// Merger merges maps deeply. Maps are merged key by key, lists with the ListMerge of their key in
+// Lists or else with Default, and other values are replaced.
+type Merger struct {
+	Lists   map[string]ListMerge
+	Default ListMerge
+}
+
+var (
+	// AppendingMerger appends lists to each other.
+	AppendingMerger = Merger{Default: AppendLists}
+
+	// ManifestMerger merges the lists of a manifest by what identifies their items: applications
+	// and services by name, routes by route and processes by type. Other lists, such as
+	// buildpacks, are replaced.
+	ManifestMerger = Merger{
+		Lists: map[string]ListMerge{
+			"applications": MergeListsBy("name"),
+			"routes":       MergeListsBy("route"),
+			"services":     MergeListsBy("name"),
+			"sidecars":     MergeListsBy("name"),
+			"processes":    MergeListsBy("type"),
+		},
+		Default: ReplaceLists,
+	}
+)
+
+// Merge merges maps into a new one, in order.
+func (m Merger) Merge(maps ...map[string]interface{}) (map[string]interface{}, error) {
+	merged := make(map[string]interface{})
+	for _, child := range maps {
+		replaced := replacedKeys(child)
+		for key, value := range child {
+			parent, exists := merged[key]
+			if !exists || replaced[key] {
+				merged[key] = value
+				continue
+			}
+			var err error
+			if merged[key], err = m.mergeValue(key, parent, value); err != nil {
+				return nil, err
+			}
+		}
+		if len(replaced) > 0 {
+			delete(merged, ReplaceKey)
+		}
+	}
+	return merged, nil
+}
+
+func (m Merger) mergeValue(key string, parent interface{}, child interface{}) (interface{}, error) {
 	switch {
+	case IsMappable(child):
+		childMap, err := Mappify(child)
+		if err != nil {
+			return nil, err
+		}
+		parentMap, err := Mappify(parent)
+		if err != nil {
+			return nil, err
+		}
+		return m.Merge(parentMap, childMap)
 
-	case containsKey(reduced, key) == false:
-		reduced[key] = val
-		return reduced, nil
+	case IsSliceable(child):
+		parentList, parentOk := parent.([]interface{})
+		childList, childOk := child.([]interface{})
+		if !parentOk || !childOk {
+			return child, nil
+		}
+		return m.mergeLists(key, parentList, childList)
+
+	default:
+		return child, nil
+	}
+}
+
+func (m Merger) mergeLists(key string, parent []interface{}, child []interface{}) ([]interface{}, error) {
+	strategy, ok := m.Lists[key]
+	if !ok {
+		strategy = m.Default
+	}
+	switch {
+	case strategy.Replace:
+		return child, nil
+	case strategy.Key == "":
+		return append(append([]interface{}{}, parent...), child...), nil
+	}
+
+	merged := append([]interface{}{}, parent...)
+	for _, item := range child {
+		index := indexOfItem(merged, strategy.Key, item)
+		if index < 0 {
+			merged = append(merged, item)
+			continue
+		}
+		if !IsMappable(merged[index]) || !IsMappable(item) {
+			merged[index] = item
+			continue
+		}
 
-	case IsMappable(val):
-		mVal, err := Mappify(val)
+		parentItem, err := Mappify(merged[index])
 		if err != nil {
 			return nil, err
 		}
-		mReduced, err := Mappify(reduced[key].(interface{}))
+		childItem, err := Mappify(item)
 		if err != nil {
 			return nil, err
 		}
+		if merged[index], err = m.Merge(parentItem, childItem); err != nil {
+			return nil, err
+		}
+	}
+	return merged, nil
+}
+
+// replacedKeys are the keys a map lists under ReplaceKey.
+func replacedKeys(m map[string]interface{}) map[string]bool {
+	replaced := map[string]bool{}
+	switch keys := m[ReplaceKey].(type) {
+	case string:
+		replaced[keys] = true
+	case []interface{}:
+		for _, key := range keys {
+			replaced[fmt.Sprint(key)] = true
+		}
+	}
+	return replaced
+}
+
+// itemKey is the value of key of an item of a list, or the item itself when it is not a map.
+func itemKey(item interface{}, key string) (interface{}, bool) {
+	if IsMappable(item) {
+		itemMap, err := Mappify(item)
+		if err != nil {
+			return nil, false
+		}
+		value, ok := itemMap[key]
+		return value, ok
+	}
+	if item == nil || IsSliceable(item) {
+		return nil, false
+	}
+	return item, true
+}
+
+func indexOfItem(items []interface{}, key string, item interface{}) int {
+	value, ok := itemKey(item, key)
+	if !ok {
+		return -1
+	}
+	for i, existing := range items {
+		if existingValue, ok := itemKey(existing, key); ok && reflect.DeepEqual(existingValue, value) {
+			return i
+		}
+	}
+	return -1
+}
 
-		maps := []map[string]interface{}{mReduced, mVal}
-		mergedmap, err := Reduce(maps, make(map[string]interface{}), mergeReducer)
+// MergeOverlay merges an overlay, such as the settings of one environment, into a manifest with
+// the ManifestMerger. Applications are matched by name, and an overlay application without a name applies
+// to the only application of the manifest. Applications only the overlay has are added.
+func MergeOverlay(base map[string]interface{}, overlay map[string]interface{}) (map[string]interface{}, error) {
+	merged, err := ManifestMerger.Merge(withoutApplications(base), withoutApplications(overlay))
+	if err != nil {
+		return nil, err
+	}
+
+	apps, _ := base["applications"].([]interface{})
+	apps = append([]interface{}{}, apps...)
+	overlayApps, _ := overlay["applications"].([]interface{})
+	for i, overlayApp := range overlayApps {
+		overlayAppMap, err := Mappify(overlayApp)
 		if err != nil {
 			return nil, err
 		}
-		reduced[key] = mergedmap
-		return reduced, nil
 
-	case IsSliceable(val):
-		reduced[key] = append(reduced[key].([]interface{}), val.([]interface{})...)
-		return reduced, nil
+		index := -1
+		if name, ok := overlayAppMap["name"]; ok {
+			index = indexOfApplication(apps, name)
+		} else if len(apps) == 1 {
+			index = 0
+		} else {
+			return nil, fmt.Errorf("Application %d of the overlay has no name", i+1)
+		}
+		if index < 0 {
+			apps = append(apps, overlayAppMap)
+			continue
+		}
 
-	default:
-		reduced[key] = val
-		return reduced, nil
+		app, err := Mappify(apps[index])
+		if err != nil {
+			return nil, err
+		}
+		if apps[index], err = ManifestMerger.Merge(app, overlayAppMap); err != nil {
+			return nil, err
+		}
+	}
+	if len(apps) > 0 {
+		merged["applications"] = apps
+	}
+	return merged, nil
+}
+
+func withoutApplications(manifest map[string]interface{}) map[string]interface{} {
+	rest := map[string]interface{}{}
+	for key, value := range manifest {
+		if key != "applications" {
+			rest[key] = value
+		}
+	}
+	return rest
+}
+
+func indexOfApplication(apps []interface{}, name interface{}) int {
+	for i, app := range apps {
+		if appMap, err := Mappify(app); err == nil && appMap["name"] == name {
+			return i
+		}
 	}
+	return -1
 }
 
 func containsKey(thing map[string]interface{}, key string) bool {
@@ -119,3 +119,115 @@ var _ = Describe("Map converter and merger", func() {
 	})
 
 })
+
+var _ = Describe("Merger", func() {
+	parent := map[string]interface{}{
+		"routes": []interface{}{
+			map[interface{}]interface{}{"route": "www.example.com"},
+			map[interface{}]interface{}{"route": "api.example.com"},
+		},
+		"services":   []interface{}{"db", map[interface{}]interface{}{"name": "cache", "parameters": map[interface{}]interface{}{"size": "small"}}},
+		"buildpacks": []interface{}{"java_buildpack"},
+		"env":        map[interface{}]interface{}{"LOG_LEVEL": "debug", "REGION": "eu"},
+	}
+
+	It("appends lists with DeepMerge, like it always did", func() {
+		merged, err := manifest.DeepMerge(parent, map[string]interface{}{"buildpacks": []interface{}{"java_buildpack"}})
+		Expect(err).To(BeNil())
+		Expect(merged["buildpacks"]).To(Equal([]interface{}{"java_buildpack", "java_buildpack"}))
+	})
+
+	It("merges routes by route and services by name, adding the new ones", func() {
+		merged, err := manifest.ManifestMerger.Merge(parent, map[string]interface{}{
+			"routes": []interface{}{
+				map[interface{}]interface{}{"route": "api.example.com", "protocol": "http2"},
+				map[interface{}]interface{}{"route": "admin.example.com"},
+			},
+			"services": []interface{}{map[interface{}]interface{}{"name": "db"}, map[interface{}]interface{}{"name": "cache", "parameters": map[interface{}]interface{}{"size": "large"}}},
+		})
+		Expect(err).To(BeNil())
+
+		Expect(merged["routes"]).To(Equal([]interface{}{
+			map[interface{}]interface{}{"route": "www.example.com"},
+			map[string]interface{}{"route": "api.example.com", "protocol": "http2"},
+			map[interface{}]interface{}{"route": "admin.example.com"},
+		}))
+		Expect(merged["services"]).To(Equal([]interface{}{
+			map[interface{}]interface{}{"name": "db"},
+			map[string]interface{}{"name": "cache", "parameters": map[string]interface{}{"size": "large"}},
+		}))
+	})
+
+	It("merges env by key and replaces other lists", func() {
+		merged, err := manifest.ManifestMerger.Merge(parent, map[string]interface{}{
+			"buildpacks": []interface{}{"go_buildpack"},
+			"env":        map[interface{}]interface{}{"LOG_LEVEL": "info"},
+		})
+		Expect(err).To(BeNil())
+
+		Expect(merged["buildpacks"]).To(Equal([]interface{}{"go_buildpack"}))
+		Expect(merged["env"]).To(Equal(map[string]interface{}{"LOG_LEVEL": "info", "REGION": "eu"}))
+	})
+
+	It("replaces the values of the keys the child marks to be replaced", func() {
+		merged, err := manifest.ManifestMerger.Merge(parent, map[string]interface{}{
+			"x-bgd-replace": []interface{}{"routes", "env"},
+			"routes":        []interface{}{map[interface{}]interface{}{"route": "admin.example.com"}},
+			"env":           map[interface{}]interface{}{"LOG_LEVEL": "info"},
+		})
+		Expect(err).To(BeNil())
+
+		Expect(merged["routes"]).To(Equal([]interface{}{map[interface{}]interface{}{"route": "admin.example.com"}}))
+		Expect(merged["env"]).To(Equal(map[interface{}]interface{}{"LOG_LEVEL": "info"}))
+		Expect(merged).NotTo(HaveKey("x-bgd-replace"))
+	})
+
+	It("takes the strategy for each key from its configuration", func() {
+		merger := manifest.Merger{Lists: map[string]manifest.ListMerge{"buildpacks": manifest.AppendLists}, Default: manifest.ReplaceLists}
+		merged, err := merger.Merge(parent, map[string]interface{}{
+			"buildpacks": []interface{}{"nodejs_buildpack"},
+			"routes":     []interface{}{map[interface{}]interface{}{"route": "admin.example.com"}},
+		})
+		Expect(err).To(BeNil())
+
+		Expect(merged["buildpacks"]).To(Equal([]interface{}{"java_buildpack", "nodejs_buildpack"}))
+		Expect(merged["routes"]).To(Equal([]interface{}{map[interface{}]interface{}{"route": "admin.example.com"}}))
+	})
+})
+
+var _ = Describe("MergeOverlay", func() {
+	base := map[string]interface{}{
+		"applications": []interface{}{
+			map[interface{}]interface{}{"name": "web", "instances": 1},
+			map[interface{}]interface{}{"name": "worker"},
+		},
+	}
+
+	It("merges the applications of the overlay into the ones of the same name", func() {
+		merged, err := manifest.MergeOverlay(base, map[string]interface{}{
+			"applications": []interface{}{
+				map[interface{}]interface{}{"name": "web", "instances": 3},
+				map[interface{}]interface{}{"name": "admin"},
+			},
+		})
+		Expect(err).To(BeNil())
+		Expect(merged["applications"]).To(Equal([]interface{}{
+			map[string]interface{}{"name": "web", "instances": 3},
+			map[interface{}]interface{}{"name": "worker"},
+			map[string]interface{}{"name": "admin"},
+		}))
+	})
+
+	It("applies an application without a name to the only application of the manifest", func() {
+		merged, err := manifest.MergeOverlay(
+			map[string]interface{}{"applications": []interface{}{map[interface{}]interface{}{"name": "web"}}},
+			map[string]interface{}{"applications": []interface{}{map[interface{}]interface{}{"instances": 2}}})
+		Expect(err).To(BeNil())
+		Expect(merged["applications"]).To(Equal([]interface{}{map[string]interface{}{"name": "web", "instances": 2}}))
+	})
+
+	It("fails for an application without a name when the manifest has several", func() {
+		_, err := manifest.MergeOverlay(base, map[string]interface{}{"applications": []interface{}{map[interface{}]interface{}{"instances": 2}}})
+		Expect(err).To(MatchError("Application 1 of the overlay has no name"))
+	})
+})
@@ -0,0 +1,575 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is something wrong with a manifest, at a line of one of its files.
+type Problem struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+}
+
+// ValidationError is the error of a manifest with problems, listing every one of them.
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := []string{"The manifest is not valid:"}
+	for _, problem := range e.Problems {
+		lines = append(lines, problem.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Validate checks every application of the manifest, merged with the manifests it inherits from
+// and its overlays, against the rules the deployment applies to routes, domains, memory and
+// instances, and reports each problem at the line it was written on. It only fails when the
+// manifest cannot be found or read.
+func (manifestReader FileManifestReader) Validate(cfDomains CfDomains) ([]Problem, error) {
+	inputPath := manifestReader.ManifestPath
+	if inputPath == "" {
+		inputPath = "./"
+	}
+	manifestPath, err := manifestReader.interpetManifestPath(inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error finding manifest: %v", err)
+	}
+
+	files, problems, err := parseSources(manifestPath, manifestReader.Overlays)
+	if err != nil || len(problems) > 0 {
+		return problems, err
+	}
+	mapp, lines, err := mergeSources(files)
+	if err != nil {
+		return nil, err
+	}
+	values, err := readVars(manifestReader.VarsFiles, manifestReader.Vars)
+	if err != nil {
+		return nil, err
+	}
+	data, err := interpolate(mapp, values)
+	var missingVars *MissingVarsError
+	if errors.As(err, &missingVars) {
+		return lines.variables(files, missingVars.Names), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := Manifest{Path: manifestPath, Data: data.(map[string]interface{})}
+	appMaps, err := m.getAppMaps(m.Data)
+	if err != nil {
+		return []Problem{lines.at("", "applications", "", err.Error())}, nil
+	}
+	// The applications are told apart by what their names are before interpolation, which is
+	// what the lines of their keys were kept under
+	rawAppMaps, err := m.getAppMaps(mapp)
+	if err != nil {
+		return nil, err
+	}
+	for i, appMap := range appMaps {
+		appName, _ := appMap["name"].(string)
+		for _, problem := range validateApp(filepath.Dir(m.Path), appMap, cfDomains) {
+			message := problem.message
+			if appName != "" {
+				message = fmt.Sprintf("%s: %s", appName, message)
+			}
+			item := ""
+			if problem.item != noItem {
+				item = rawItemName(rawAppMaps[i][problem.key], problem.item)
+			}
+			problems = append(problems, lines.at(appID(rawAppMaps[i], i), problem.key, item, message))
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].File != problems[j].File {
+			return problems[i].File < problems[j].File
+		}
+		return problems[i].Line < problems[j].Line
+	})
+	return problems, nil
+}
+
+// appProblem is a problem of an application, with the key, and the index of the item of a list
+// such as a route, it was found at.
+type appProblem struct {
+	key     string
+	item    int
+	message string
+}
+
+// noItem is the item of a problem with a key as a whole.
+const noItem = -1
+
+func validateApp(basePath string, app map[string]interface{}, cfDomains CfDomains) []appProblem {
+	problems := []appProblem{}
+	report := func(key string, item int, errs []error) {
+		for _, err := range errs {
+			problems = append(problems, appProblem{key: key, item: item, message: strings.TrimSpace(err.Error())})
+		}
+	}
+	check := func(key string, validate func(errs *[]error)) {
+		var errs []error
+		validate(&errs)
+		report(key, noItem, errs)
+	}
+
+	keys := []string{}
+	for key := range app {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if app[key] == nil && key != "command" && key != "buildpack" {
+			report(key, noItem, []error{fmt.Errorf("%s should not be null", key)})
+		} else if _, err := expandProperties(app[key]); err != nil {
+			report(key, noItem, []error{err})
+		} else if strings.HasPrefix(key, "x-bgd-") && key != "x-bgd-exclude-routes" {
+			check(key, func(errs *[]error) {
+				mapToPluginParams(basePath, map[string]interface{}{key: app[key]}, cfDomains, errs)
+			})
+		}
+	}
+
+	check("name", func(errs *[]error) { stringVal(app, "name", errs) })
+	check("host", func(errs *[]error) { stringVal(app, "host", errs) })
+	check("hosts", func(errs *[]error) { sliceOrNil(app, "hosts", errs) })
+
+	var domainErrs []error
+	if domain := stringVal(app, "domain", &domainErrs); domain != nil {
+		report("domain", 0, unknownDomains(cfDomains, []string{*domain}))
+	}
+	report("domain", noItem, domainErrs)
+	domainErrs = nil
+	domains := sliceOrNil(app, "domains", &domainErrs)
+	report("domains", noItem, domainErrs)
+	for i, domain := range domains {
+		report("domains", i, unknownDomains(cfDomains, []string{domain}))
+	}
+
+	check("memory", func(errs *[]error) { bytesVal(app, "memory", errs) })
+	check("disk_quota", func(errs *[]error) { bytesVal(app, "disk_quota", errs) })
+	check("instances", func(errs *[]error) { intVal(app, "instances", errs) })
+
+	for _, key := range []string{"routes", "x-bgd-exclude-routes"} {
+		problems = append(problems, validateRoutes(app, key, cfDomains)...)
+	}
+	_, hasRoutes := app["routes"]
+	for _, key := range []string{"host", "hosts", "domain", "domains"} {
+		if _, ok := app[key]; ok && hasRoutes {
+			report("routes", noItem, []error{errors.New("Cannot have both a routes and a host or domain")})
+			break
+		}
+	}
+	return problems
+}
+
+func validateRoutes(app map[string]interface{}, key string, cfDomains CfDomains) []appProblem {
+	if _, ok := app[key]; !ok {
+		return nil
+	}
+	routes, ok := app[key].([]interface{})
+	if !ok {
+		return []appProblem{{key: key, item: noItem, message: fmt.Sprintf("'%s' should be a list", key)}}
+	}
+
+	problems := []appProblem{}
+	for i, route := range routes {
+		routeMap, err := Mappify(route)
+		routeName, ok := routeMap["route"].(string)
+		if err != nil || !ok {
+			problems = append(problems, appProblem{key: key, item: noItem, message: fmt.Sprintf("each route in '%s' must have a 'route' property", key)})
+			continue
+		}
+		if !knowsDomains(cfDomains) {
+			continue
+		}
+		if _, err := ParseRoute(cfDomains, routeName); err != nil {
+			for _, message := range strings.Split(err.Error(), "\n") {
+				problems = append(problems, appProblem{key: key, item: i, message: message})
+			}
+		}
+	}
+	return problems
+}
+
+// unknownDomains fails for the domains which do not exist, when the domains are known.
+func unknownDomains(cfDomains CfDomains, domains []string) []error {
+	if !knowsDomains(cfDomains) {
+		return nil
+	}
+	errs := []error{}
+	for _, domain := range domains {
+		if !containsString(cfDomains.SharedDomains, domain) && !containsString(cfDomains.PrivateDomains, domain) {
+			errs = append(errs, fmt.Errorf("The domain %s does not exist", domain))
+		}
+	}
+	return errs
+}
+
+func knowsDomains(cfDomains CfDomains) bool {
+	return len(cfDomains.SharedDomains) > 0 || len(cfDomains.PrivateDomains) > 0
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// source is a file of a manifest, parsed with the line of every value.
+type source struct {
+	path string
+	root *yaml.Node
+}
+
+// parseSources parses the manifest and its overlays, each after the manifests it inherits from,
+// in the order they are merged in. Files which are not valid YAML are problems.
+func parseSources(manifestPath string, overlays []string) ([][]source, []Problem, error) {
+	files := [][]source{}
+	problems := []Problem{}
+	for _, path := range append([]string{manifestPath}, overlays...) {
+		fileSources, fileProblems, err := parseSource(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, fileSources)
+		problems = append(problems, fileProblems...)
+	}
+	return files, problems, nil
+}
+
+var yamlErrorRegex = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+func parseSource(path string) ([]source, []Problem, error) {
+	contents, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	root := &yaml.Node{}
+	if err := yaml.Unmarshal(contents, root); err != nil {
+		problem := Problem{File: path, Line: 1, Message: err.Error()}
+		if match := yamlErrorRegex.FindStringSubmatch(err.Error()); match != nil {
+			problem.Line, _ = strconv.Atoi(match[1])
+			problem.Message = match[2]
+		}
+		return nil, []Problem{problem}, nil
+	}
+	if len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode || len(root.Content) == 0 {
+		line := root.Line
+		if line == 0 {
+			line = 1
+		}
+		return nil, []Problem{{File: path, Line: line, Message: "Invalid manifest. Expected a map"}}, nil
+	}
+
+	inherited := []source{}
+	problems := []Problem{}
+	if inherit := mappingValue(root, "inherit"); inherit != nil {
+		if inherit.Kind != yaml.ScalarNode {
+			return nil, nil, fmt.Errorf("invalid inherit path in manifest")
+		}
+		inheritedPath := inherit.Value
+		if !filepath.IsAbs(inheritedPath) {
+			inheritedPath = filepath.Join(filepath.Dir(path), inheritedPath)
+		}
+		if inherited, problems, err = parseSource(inheritedPath); err != nil {
+			return nil, nil, err
+		}
+	}
+	return append(inherited, source{path: path, root: root}), problems, nil
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// mergeSources merges the parsed files the way the manifest is read: every file after the ones
+// it inherits from, and then every overlay. It returns the merged manifest, before its variables
+// are interpolated, with the lines its keys were written on.
+func mergeSources(files [][]source) (map[string]interface{}, positions, error) {
+	lines := positions{lines: map[string]position{}, first: position{file: files[0][0].path, line: 1}}
+	var mapp map[string]interface{}
+	for i, fileSources := range files {
+		var fileMap map[string]interface{}
+		for _, src := range fileSources {
+			srcMap, err := nodeMap(src.root)
+			if err != nil {
+				return nil, positions{}, err
+			}
+			if fileMap == nil {
+				fileMap = srcMap
+			} else if fileMap, err = ManifestMerger.Merge(fileMap, srcMap); err != nil {
+				return nil, positions{}, err
+			}
+			// An application of an overlay without a name applies to the only one of the manifest
+			onlyApp := ""
+			if apps, _ := mapp["applications"].([]interface{}); i > 0 && len(apps) == 1 {
+				onlyApp = appID(apps[0], 0)
+			}
+			lines.add(src, onlyApp)
+		}
+
+		if i == 0 {
+			mapp = fileMap
+			continue
+		}
+		var err error
+		if mapp, err = MergeOverlay(mapp, fileMap); err != nil {
+			return nil, positions{}, fmt.Errorf("Could not merge overlay %s: %v", fileSources[len(fileSources)-1].path, err)
+		}
+	}
+	return mapp, lines, nil
+}
+
+// nodeMap is the map of a parsed file as yaml.v2, which reads the manifest, has it: the keys of
+// nested maps are interface{}, and yes, no, on and off are booleans.
+func nodeMap(root *yaml.Node) (map[string]interface{}, error) {
+	value, err := nodeValue(root)
+	if err != nil {
+		return nil, err
+	}
+	mapp := map[string]interface{}{}
+	for key, value := range value.(map[interface{}]interface{}) {
+		mapp[fmt.Sprint(key)] = value
+	}
+	return mapp, nil
+}
+
+// yaml11Booleans are the plain scalars YAML 1.1 reads as booleans, other than true and false.
+var yaml11Booleans = map[string]bool{
+	"y": true, "Y": true, "yes": true, "Yes": true, "YES": true, "on": true, "On": true, "ON": true,
+	"n": false, "N": false, "no": false, "No": false, "NO": false, "off": false, "Off": false, "OFF": false,
+}
+
+func nodeValue(node *yaml.Node) (interface{}, error) {
+	switch node.Kind {
+	case yaml.AliasNode:
+		return nodeValue(node.Alias)
+	case yaml.SequenceNode:
+		items := []interface{}{}
+		for _, item := range node.Content {
+			value, err := nodeValue(item)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+		}
+		return items, nil
+	case yaml.MappingNode:
+		mapp := map[interface{}]interface{}{}
+		merged := []*yaml.Node{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Tag == "!!merge" {
+				merged = append(merged, node.Content[i+1])
+				continue
+			}
+			key, err := nodeValue(node.Content[i])
+			if err != nil {
+				return nil, err
+			}
+			if mapp[key], err = nodeValue(node.Content[i+1]); err != nil {
+				return nil, err
+			}
+		}
+		// The keys of the map win over the ones merged in with <<
+		for _, mergedNode := range merged {
+			value, err := nodeValue(mergedNode)
+			if err != nil {
+				return nil, err
+			}
+			mergedMaps, ok := value.([]interface{})
+			if !ok {
+				mergedMaps = []interface{}{value}
+			}
+			for _, mergedMap := range mergedMaps {
+				mergedMap, _ := mergedMap.(map[interface{}]interface{})
+				for key, value := range mergedMap {
+					if _, ok := mapp[key]; !ok {
+						mapp[key] = value
+					}
+				}
+			}
+		}
+		return mapp, nil
+	case yaml.ScalarNode:
+		if boolean, ok := yaml11Booleans[node.Value]; ok && node.Style == 0 && node.Tag == "!!str" {
+			return boolean, nil
+		}
+	}
+	var value interface{}
+	err := node.Decode(&value)
+	return value, err
+}
+
+// appID tells an application apart by its name before interpolation, since the names of two
+// applications may have the same value, or by its place in the manifest when it has no name.
+func appID(app interface{}, index int) string {
+	if appMap, err := Mappify(app); err == nil {
+		if name, ok := appMap["name"]; ok {
+			return fmt.Sprint(name)
+		}
+	}
+	return fmt.Sprintf("#%d", index)
+}
+
+// rawItemName is what identifies the item at index of the value of a key, as it was written:
+// the route of a route, or the value itself when it is not a list.
+func rawItemName(value interface{}, index int) string {
+	items, ok := value.([]interface{})
+	if !ok {
+		return fmt.Sprint(value)
+	}
+	if index >= len(items) {
+		return ""
+	}
+	for _, key := range []string{"route", "name", "type"} {
+		if name, ok := itemKey(items[index], key); ok {
+			return fmt.Sprint(name)
+		}
+	}
+	return fmt.Sprint(items[index])
+}
+
+type position struct {
+	file string
+	line int
+}
+
+// positions are the lines the keys of the applications, and the items of their lists, were
+// written on. Where several files set the same key, the one merged in last is kept.
+type positions struct {
+	lines map[string]position
+	first position
+}
+
+func positionKey(appID string, key string, item string) string {
+	return strings.Join([]string{appID, key, item}, "\x00")
+}
+
+// add keeps the lines of a file. An application without a name is the only application of the
+// manifest when one is given, or the one at its place otherwise.
+func (p positions) add(src source, onlyApp string) {
+	root := src.root
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if key.Value != "applications" || value.Kind != yaml.SequenceNode {
+			p.addKey(src.path, "", key, value)
+			continue
+		}
+		p.lines[positionKey("", key.Value, "")] = position{file: src.path, line: key.Line}
+		for j, appNode := range value.Content {
+			id := fmt.Sprintf("#%d", j)
+			if name := mappingValue(appNode, "name"); name != nil {
+				id = name.Value
+			} else if onlyApp != "" {
+				id = onlyApp
+			}
+			p.lines[positionKey(id, "", "")] = position{file: src.path, line: appNode.Line}
+			for k := 0; k+1 < len(appNode.Content); k += 2 {
+				p.addKey(src.path, id, appNode.Content[k], appNode.Content[k+1])
+			}
+		}
+	}
+}
+
+func (p positions) addKey(file string, appID string, key *yaml.Node, value *yaml.Node) {
+	p.lines[positionKey(appID, key.Value, "")] = position{file: file, line: key.Line}
+	if value.Kind != yaml.SequenceNode {
+		if value.Kind == yaml.ScalarNode {
+			p.lines[positionKey(appID, key.Value, itemName(value))] = position{file: file, line: value.Line}
+		}
+		return
+	}
+	for _, item := range value.Content {
+		p.lines[positionKey(appID, key.Value, itemName(item))] = position{file: file, line: item.Line}
+	}
+}
+
+// itemName is what identifies an item of a list, such as the route of a route, as it is written.
+func itemName(item *yaml.Node) string {
+	if item.Kind == yaml.MappingNode {
+		for _, key := range []string{"route", "name", "type"} {
+			if value := mappingValue(item, key); value != nil {
+				return value.Value
+			}
+		}
+	}
+	return item.Value
+}
+
+// at is the problem with the message at the line of the most specific of the item, the key of
+// the application, the key shared by all applications, or the application itself.
+func (p positions) at(appID string, key string, item string, message string) Problem {
+	candidates := []string{
+		positionKey(appID, key, item),
+		positionKey(appID, key, ""),
+		positionKey("", key, item),
+		positionKey("", key, ""),
+		positionKey(appID, "", ""),
+	}
+	for _, candidate := range candidates {
+		if position, ok := p.lines[candidate]; ok {
+			return Problem{File: position.file, Line: position.line, Message: message}
+		}
+	}
+	return Problem{File: p.first.file, Line: p.first.line, Message: message}
+}
+
+// variables reports the variables without a value at every line they are used on.
+func (p positions) variables(files [][]source, names []string) []Problem {
+	missing := map[string]bool{}
+	for _, name := range names {
+		missing[name] = true
+	}
+
+	problems := []Problem{}
+	var visit func(path string, node *yaml.Node)
+	visit = func(path string, node *yaml.Node) {
+		if node.Kind == yaml.ScalarNode {
+			for _, match := range variableRegex.FindAllStringSubmatch(node.Value, -1) {
+				if missing[match[1]] {
+					problems = append(problems, Problem{File: path, Line: node.Line, Message: fmt.Sprintf("Variable %s has no value", match[1])})
+				}
+			}
+		}
+		for _, child := range node.Content {
+			visit(path, child)
+		}
+	}
+	for _, fileSources := range files {
+		for _, src := range fileSources {
+			visit(src.path, src.root)
+		}
+	}
+	return problems
+}
@@ -0,0 +1,109 @@
+package manifest_test
+
+import (
+	"github.com/bluemixgaragelondon/cf-blue-green-deploy/manifest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Validate", func() {
+	cfDomains := manifest.CfDomains{DefaultDomain: "example.com", SharedDomains: []string{"example.com"}}
+
+	It("reports every problem of every application at its line", func() {
+		reader := manifest.FileManifestReader{ManifestPath: "../fixtures/invalid/manifest.yml"}
+		problems, err := reader.Validate(cfDomains)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stringsOf(problems)).To(Equal([]string{
+			"../fixtures/invalid/manifest.yml:5: web: Invalid value for 'memory': lots, Byte quantity must be an integer with a unit of measurement like M, MB, G, or GB",
+			"../fixtures/invalid/manifest.yml:6: web: Invalid value for 'instances': two, expected a whole number",
+			"../fixtures/invalid/manifest.yml:9: web: The route web.nowhere.com did not match any existing domains",
+			"../fixtures/invalid/manifest.yml:11: worker: The domain nowhere.com does not exist",
+			"../fixtures/invalid/manifest.yml:13: worker: Cannot have both a routes and a host or domain",
+			"../fixtures/invalid/manifest.yml:15: worker: x-bgd-smoke-test-attempts must be at least 1",
+		}))
+	})
+
+	It("reports the problems of an overlay in the overlay", func() {
+		reader := manifest.FileManifestReader{
+			ManifestPath: "../fixtures/invalid/manifest.yml",
+			Overlays:     []string{"../fixtures/invalid/overlay.yml"},
+		}
+		problems, err := reader.Validate(cfDomains)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stringsOf(problems)).To(ContainElement(
+			"../fixtures/invalid/overlay.yml:4: web: Invalid value for 'disk_quota': big, Byte quantity must be an integer with a unit of measurement like M, MB, G, or GB",
+		))
+	})
+
+	It("reports YAML which cannot be parsed", func() {
+		reader := manifest.FileManifestReader{ManifestPath: "../fixtures/invalid/broken.yml"}
+		problems, err := reader.Validate(cfDomains)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(problems).To(HaveLen(1))
+		Expect(problems[0].File).To(Equal("../fixtures/invalid/broken.yml"))
+		Expect(problems[0].Line).To(BeNumerically(">", 1))
+	})
+
+	It("reports the variables without a value where they are used", func() {
+		reader := manifest.FileManifestReader{ManifestPath: "../fixtures/invalid/vars.yml", Vars: map[string]string{"host": "www"}}
+		problems, err := reader.Validate(cfDomains)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stringsOf(problems)).To(Equal([]string{
+			"../fixtures/invalid/vars.yml:6: Variable domain has no value",
+		}))
+	})
+
+	It("tells apart applications whose names have the same value", func() {
+		reader := manifest.FileManifestReader{
+			ManifestPath: "../fixtures/invalid/samename.yml",
+			Vars:         map[string]string{"first": "web", "second": "web", "domain": "nowhere.com"},
+		}
+		problems, err := reader.Validate(cfDomains)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stringsOf(problems)).To(Equal([]string{
+			"../fixtures/invalid/samename.yml:4: web: Invalid value for 'memory': lots, Byte quantity must be an integer with a unit of measurement like M, MB, G, or GB",
+			"../fixtures/invalid/samename.yml:6: web: The route web.nowhere.com did not match any existing domains",
+			"../fixtures/invalid/samename.yml:8: web: Invalid value for 'instances': two, expected a whole number",
+			"../fixtures/invalid/samename.yml:10: web: The route web.nowhere.com did not match any existing domains",
+		}))
+	})
+
+	It("reports the problems of an application without a name at its own lines", func() {
+		reader := manifest.FileManifestReader{ManifestPath: "../fixtures/invalid/nameless.yml"}
+		problems, err := reader.Validate(cfDomains)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stringsOf(problems)).To(Equal([]string{
+			"../fixtures/invalid/nameless.yml:4: Invalid value for 'instances': two, expected a whole number",
+			"../fixtures/invalid/nameless.yml:5: Invalid value for 'memory': lots, Byte quantity must be an integer with a unit of measurement like M, MB, G, or GB",
+		}))
+	})
+
+	It("finds no problems in a valid manifest", func() {
+		reader := manifest.FileManifestReader{ManifestPath: "../fixtures/manifestwithinheritedroutes.yml"}
+		problems, err := reader.Validate(cfDomains)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(problems).To(BeEmpty())
+	})
+
+	It("fails when there is no manifest", func() {
+		reader := manifest.FileManifestReader{ManifestPath: "../doesnotexist"}
+		_, err := reader.Validate(cfDomains)
+
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+func stringsOf(problems []manifest.Problem) []string {
+	lines := []string{}
+	for _, problem := range problems {
+		lines = append(lines, problem.String())
+	}
+	return lines
+}
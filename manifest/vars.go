@@ -0,0 +1,144 @@
+package manifest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// variableRegex matches the ((variables)) of a manifest, which cf push fills in from --vars-file
+// and --var. A name with dots looks up a value nested in the variables.
+var variableRegex = regexp.MustCompile(`\(\(([-\w./]+)\)\)`)
+
+// ParseVar splits a variable given as NAME=VALUE, like the --var flag of cf push takes it.
+func ParseVar(assignment string) (string, string, error) {
+	parts := strings.SplitN(assignment, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", fmt.Errorf("%q is not of the form NAME=VALUE", assignment)
+	}
+	return parts[0], parts[1], nil
+}
+
+// readVars reads the variables of the vars files, where a later file overrides an earlier one,
+// and adds the variables given one by one, which override them all.
+func readVars(varsFiles []string, vars map[string]string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	for _, varsFile := range varsFiles {
+		contents, err := ioutil.ReadFile(filepath.Clean(varsFile))
+		if err != nil {
+			return nil, fmt.Errorf("Could not read the vars file: %v", err)
+		}
+		fileValues := map[string]interface{}{}
+		if err := yaml.Unmarshal(contents, &fileValues); err != nil {
+			return nil, fmt.Errorf("Could not parse the vars file %s: %v", varsFile, err)
+		}
+		for name, value := range fileValues {
+			values[name] = value
+		}
+	}
+	for name, value := range vars {
+		values[name] = value
+	}
+	return values, nil
+}
+
+// interpolate fills in the variables of the values of a manifest. A value which is just a
+// variable takes the variable's value as it is, such as a number or a list, while a variable
+// within a longer string is filled in as text. Variables without a value fail, naming all of
+// them.
+func interpolate(input interface{}, values map[string]interface{}) (interface{}, error) {
+	missing := map[string]bool{}
+	output := interpolateValue(input, values, missing)
+	if len(missing) > 0 {
+		names := []string{}
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, &MissingVarsError{Names: names}
+	}
+	return output, nil
+}
+
+// MissingVarsError is the error of a manifest with variables which have no value.
+type MissingVarsError struct {
+	Names []string
+}
+
+func (e *MissingVarsError) Error() string {
+	return fmt.Sprintf("Expected to find variables: %s", strings.Join(e.Names, ", "))
+}
+
+func interpolateValue(input interface{}, values map[string]interface{}, missing map[string]bool) interface{} {
+	switch input := input.(type) {
+	case string:
+		if match := variableRegex.FindStringSubmatch(input); match != nil && match[0] == input {
+			value, ok := lookupVar(values, match[1])
+			if !ok {
+				missing[match[1]] = true
+			}
+			return value
+		}
+		return variableRegex.ReplaceAllStringFunc(input, func(variable string) string {
+			name := variableRegex.FindStringSubmatch(variable)[1]
+			value, ok := lookupVar(values, name)
+			if !ok {
+				missing[name] = true
+				return variable
+			}
+			return fmt.Sprint(value)
+		})
+	case []interface{}:
+		output := make([]interface{}, len(input))
+		for index, item := range input {
+			output[index] = interpolateValue(item, values, missing)
+		}
+		return output
+	case map[interface{}]interface{}:
+		output := make(map[interface{}]interface{})
+		for key, value := range input {
+			output[key] = interpolateValue(value, values, missing)
+		}
+		return output
+	case map[string]interface{}:
+		output := make(map[string]interface{})
+		for key, value := range input {
+			output[key] = interpolateValue(value, values, missing)
+		}
+		return output
+	default:
+		return input
+	}
+}
+
+// lookupVar finds the value of a variable, following the dots of its name into nested values.
+func lookupVar(values map[string]interface{}, name string) (interface{}, bool) {
+	if value, ok := values[name]; ok {
+		return value, true
+	}
+	var value interface{} = values
+	for _, key := range strings.Split(name, ".") {
+		switch nested := value.(type) {
+		case map[string]interface{}:
+			found, ok := nested[key]
+			if !ok {
+				return nil, false
+			}
+			value = found
+		case map[interface{}]interface{}:
+			found, ok := nested[key]
+			if !ok {
+				return nil, false
+			}
+			value = found
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}